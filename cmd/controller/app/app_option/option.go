@@ -10,6 +10,7 @@ import (
 type Options struct {
 	Global   *option.GlobalManagerOption
 	Ctrl     *option.ControllersManagerOption
+	Server   *option.ServerOption
 	Provider *config.Config
 }
 
@@ -18,6 +19,7 @@ func NewOptions() *Options {
 	return &Options{
 		Global:   option.DefaultGlobalManagetOption(),
 		Ctrl:     option.DefaultControllersManagerOption(),
+		Server:   option.DefaultServerOption(),
 		Provider: config.NewDefaultConfig(),
 	}
 }
@@ -26,5 +28,6 @@ func NewOptions() *Options {
 func (o *Options) AddFlags(fs *pflag.FlagSet) {
 	o.Global.AddFlags(fs)
 	o.Ctrl.AddFlags(fs)
+	o.Server.AddFlags(fs)
 	o.Provider.AddFlags(fs)
 }
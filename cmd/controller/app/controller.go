@@ -49,8 +49,10 @@ func NewControllerCmd(opt *app_option.Options) *cobra.Command {
 				LeaderElectionNamespace:    opt.Global.LeaderElectionNamespace,
 				LeaderElectionID:           "kok-operator",
 				SyncPeriod:                 &opt.Global.ResyncPeriod,
-				MetricsBindAddress:         "0", // disable metrics with manager, use our observe
-				HealthProbeBindAddress:     "0", // disable health probe with manager, use our observe
+				MetricsBindAddress:         opt.Ctrl.MetricsBindAddress,
+				HealthProbeBindAddress:     opt.Ctrl.HealthProbeBindAddress,
+				Port:                       opt.Ctrl.WebhookPort,
+				CertDir:                    opt.Ctrl.WebhookCertDir,
 			})
 			if err != nil {
 				klog.Fatalf("unable to new manager err: %v", err)
@@ -58,7 +60,7 @@ func NewControllerCmd(opt *app_option.Options) *cobra.Command {
 
 			// Setup all Controllers
 			ctrlrt.Log.Info("Setting up controller")
-			if err := controllers.AddToManager(mgr, opt.Ctrl, opt.Provider); err != nil {
+			if err := controllers.AddToManager(mgr, opt.Ctrl, opt.Server, opt.Provider); err != nil {
 				klog.Fatalf("unable to register controllers to the manager err: %v", err)
 			}
 
@@ -71,6 +73,7 @@ func NewControllerCmd(opt *app_option.Options) *cobra.Command {
 	}
 
 	opt.Ctrl.AddFlags(cmd.Flags())
+	opt.Server.AddFlags(cmd.Flags())
 	opt.Provider.AddFlags(cmd.Flags())
 	return cmd
 }
@@ -0,0 +1,40 @@
+#!/usr/bin/env bash
+
+# gen-artifact-manifest.sh writes the manifest.yaml of the artifacts in the layout of the operator
+# image, /k8s-<version>/bin and /k8s/bin under root, with the sha256 of each file.
+# usage: gen-artifact-manifest.sh [root] > manifest.yaml
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+ROOT=${1:-/}
+ARCH=${ARCH:-amd64}
+
+entry() {
+  local name=$1 version=$2 path=$3
+  [ -f "${ROOT%/}/${path}" ] || return 0
+  echo "- name: ${name}"
+  [ -z "${version}" ] || echo "  version: ${version}"
+  echo "  arch: ${ARCH}"
+  echo "  path: ${path}"
+  echo "  sha256: $(sha256sum "${ROOT%/}/${path}" | cut -d' ' -f1)"
+}
+
+echo "artifacts:"
+for dir in "${ROOT%/}"/k8s-v*/bin; do
+  [ -d "${dir}" ] || continue
+  version=$(basename "$(dirname "${dir}")")
+  version=${version#k8s-}
+  for name in kubectl kubeadm kubelet etcdctl; do
+    entry "${name}" "${version}" "k8s-${version}/bin/${name}"
+  done
+done
+
+for name in k9s cni.tgz crictl runc containerd.tar.gz docker.tgz cri-dockerd nvidia-container-toolkit.tar.gz; do
+  entry "${name}" "" "k8s/bin/${name}"
+done
+for file in "${ROOT%/}"/k8s/bin/NVIDIA-Linux-*.run; do
+  [ -f "${file}" ] || continue
+  entry nvidia-driver "" "k8s/bin/$(basename "${file}")"
+done
@@ -1,26 +1,79 @@
 package etcd
 
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/k8sutil"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
+	"github.com/wtxue/kok-operator/pkg/util/template"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
 const (
 	etcdTemplate = `
 ---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    component: etcd
+    {{ .ClusterNameLabel }}: {{ .ClusterName }}
+spec:
+  ports:
+    - port: 2379
+      name: client
+    - port: 2380
+      name: peer
+  clusterIP: None
+  selector:
+    component: etcd
+    {{ .ClusterNameLabel }}: {{ .ClusterName }}
+  publishNotReadyAddresses: true
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    component: etcd
+    {{ .ClusterNameLabel }}: {{ .ClusterName }}
+spec:
+  minAvailable: {{ .MinAvailable }}
+  selector:
+    matchLabels:
+      component: etcd
+      {{ .ClusterNameLabel }}: {{ .ClusterName }}
+---
 apiVersion: apps/v1
 kind: StatefulSet
 metadata:
-  name: etcd
+  name: {{ .Name }}
   namespace: {{ .Namespace }}
   labels:
-    app: etcd
+    component: etcd
+    {{ .ClusterNameLabel }}: {{ .ClusterName }}
 spec:
-  serviceName: etcd
-  replicas: 3
+  serviceName: {{ .Name }}
+  replicas: {{ .Replicas }}
+  podManagementPolicy: Parallel
   selector:
     matchLabels:
-      app: etcd
+      component: etcd
+      {{ .ClusterNameLabel }}: {{ .ClusterName }}
   template:
     metadata:
-      name: etcd
       labels:
-        app: etcd
+        component: etcd
+        {{ .ClusterNameLabel }}: {{ .ClusterName }}
     spec:
       affinity:
         podAntiAffinity:
@@ -28,165 +81,272 @@ spec:
           - podAffinityTerm:
               labelSelector:
                 matchLabels:
-                  app: etcd
-              namespaces:
-              - {{ .Namespace }}
+                  component: etcd
+                  {{ .ClusterNameLabel }}: {{ .ClusterName }}
               topologyKey: kubernetes.io/hostname
             weight: 1
       containers:
         - name: etcd
-          image: {{ default "registry.aliyuncs.com/google_containers/etcd:3.4.3-0" .ImageName }}
+          image: {{ .ImageName }}
+          imagePullPolicy: IfNotPresent
           ports:
             - containerPort: 2379
               name: client
             - containerPort: 2380
               name: peer
+            - containerPort: 2381
+              name: metrics
           env:
-            - name: INITIAL_CLUSTER_SIZE
-              value: "3"
-            - name: CLUSTER_NAMESPACE
+            - name: POD_NAME
               valueFrom:
                 fieldRef:
-                  fieldPath: metadata.namespace
+                  fieldPath: metadata.name
+          readinessProbe:
+            httpGet:
+              path: /health
+              port: metrics
+            initialDelaySeconds: 5
+            periodSeconds: 10
+            timeoutSeconds: 15
+            failureThreshold: 3
+          livenessProbe:
+            httpGet:
+              path: /health
+              port: metrics
+            initialDelaySeconds: 60
+            periodSeconds: 10
+            timeoutSeconds: 15
+            failureThreshold: 8
           resources:
             requests:
               cpu: 100m
               memory: 512Mi
           volumeMounts:
             - name: datadir
-              mountPath: /var/run/etcd
+              mountPath: /var/lib/etcd
+            - name: certs
+              mountPath: /etc/etcd/pki
+              readOnly: true
+            - name: cluster
+              mountPath: /etc/etcd/cluster
+              readOnly: true
           command:
             - /bin/sh
             - -c
             - |
-              PEERS="etcd-0=http://etcd-0.etcd:2380,etcd-1=http://etcd-1.etcd:2380,etcd-2=http://etcd-2.etcd:2380"
-              exec etcd --name ${HOSTNAME} \
-                --listen-peer-urls http://0.0.0.0:2380 \
-                --listen-client-urls http://0.0.0.0:2379 \
-                --advertise-client-urls http://${HOSTNAME}.etcd:2379 \
-                --initial-advertise-peer-urls http://${HOSTNAME}:2380 \
-                --initial-cluster-token etcd-cluster-1 \
-                --initial-cluster ${PEERS} \
-                --initial-cluster-state new \
-                --data-dir /var/run/etcd/default.etcd
+              PEER_URL=https://${POD_NAME}.{{ .Name }}.{{ .Namespace }}.svc:2380
+              CLIENT_URL=https://${POD_NAME}.{{ .Name }}.{{ .Namespace }}.svc:2379
+              INITIAL_ARGS=""
+              # the initial cluster is only used by the member without data
+              if [ ! -d /var/lib/etcd/member ]; then
+                INITIAL_ARGS="--initial-cluster=$(cat /etc/etcd/cluster/initial-cluster) --initial-cluster-state=$(cat /etc/etcd/cluster/initial-cluster-state) --initial-cluster-token={{ .Name }}"
+              fi
+              exec etcd --name=${POD_NAME} \
+                --data-dir=/var/lib/etcd \
+                --listen-client-urls=https://0.0.0.0:2379 \
+                --advertise-client-urls=${CLIENT_URL} \
+                --listen-peer-urls=https://0.0.0.0:2380 \
+                --initial-advertise-peer-urls=${PEER_URL} \
+                --listen-metrics-urls=http://0.0.0.0:2381 \
+                --client-cert-auth=true \
+                --trusted-ca-file=/etc/etcd/pki/{{ .CACertKey }} \
+                --cert-file=/etc/etcd/pki/{{ .ServerCertKey }} \
+                --key-file=/etc/etcd/pki/{{ .ServerKeyKey }} \
+                --peer-client-cert-auth=true \
+                --peer-trusted-ca-file=/etc/etcd/pki/{{ .CACertKey }} \
+                --peer-cert-file=/etc/etcd/pki/{{ .ServerCertKey }} \
+                --peer-key-file=/etc/etcd/pki/{{ .ServerKeyKey }} \
+                --snapshot-count=10000 \
+                ${INITIAL_ARGS}
+      volumes:
+        - name: certs
+          secret:
+            secretName: {{ .CertsSecret }}
+            defaultMode: 0400
+        - name: cluster
+          configMap:
+            name: {{ .ClusterConfigMap }}
   volumeClaimTemplates:
     - metadata:
         name: datadir
+        labels:
+          component: etcd
+          {{ .ClusterNameLabel }}: {{ .ClusterName }}
       spec:
-        storageClassName: local-storage
+        {{- if .StorageClassName }}
+        storageClassName: {{ .StorageClassName }}
+        {{- end }}
         accessModes:
           - "ReadWriteOnce"
         resources:
           requests:
-            storage: 10Gi
----
-apiVersion: v1
-kind: Service
-metadata:
-  name: etcd
-  namespace: {{ .Namespace }}
-  labels:
-    app: etcd
-spec:
-  ports:
-    - port: 2380
-      name: etcd-server
-    - port: 2379
-      name: etcd-client
-  clusterIP: None
-  selector:
-    app: etcd
-  publishNotReadyAddresses: true
----
-apiVersion: policy/v1beta1
-kind: PodDisruptionBudget
-metadata:
-  name: etcd-pdb
-  namespace: {{ .Namespace }}
-  labels:
-    pdb: etcd
-spec:
-  minAvailable: 2
-  selector:
-    matchLabels:
-      app: etcd
----
-apiVersion: storage.k8s.io/v1
-kind: StorageClass
-metadata:
-  labels:
-    createdBy: controller
-  name: local-storage
-provisioner: kubernetes.io/no-provisioner
-reclaimPolicy: Delete
-volumeBindingMode: WaitForFirstConsumer
----
-apiVersion: v1
-kind: PersistentVolume
-metadata:
-  name: {{ .ClusterName }}-etcd0-lpv
-spec:
-  accessModes:
-    - ReadWriteOnce
-  capacity:
-    storage: 10Gi
-  local:
-    path: {{ default "/web/default-cluster" .EtcdHostPath }}
-  nodeAffinity:
-    required:
-      nodeSelectorTerms:
-        - matchExpressions:
-            - key: kubernetes.io/hostname
-              operator: In
-              values:
-                - {{ .EtcdHostName0 }}
-  persistentVolumeReclaimPolicy: Retain
-  storageClassName: local-storage
-  volumeMode: Filesystem
----
-apiVersion: v1
-kind: PersistentVolume
-metadata:
-  name: {{ .ClusterName }}-etcd1-lpv
-spec:
-  accessModes:
-    - ReadWriteOnce
-  capacity:
-    storage: 10Gi
-  local:
-    path: {{ default "/web/default-cluster" .EtcdHostPath }}
-  nodeAffinity:
-    required:
-      nodeSelectorTerms:
-        - matchExpressions:
-            - key: kubernetes.io/hostname
-              operator: In
-              values:
-                - {{ .EtcdHostName1 }}
-  persistentVolumeReclaimPolicy: Retain
-  storageClassName: local-storage
-  volumeMode: Filesystem
----
-apiVersion: v1
-kind: PersistentVolume
-metadata:
-  name: {{ .ClusterName }}-etcd2-lpv
-spec:
-  accessModes:
-    - ReadWriteOnce
-  capacity:
-    storage: 10Gi
-  local:
-    path: {{ default "/web/default-cluster" .EtcdHostPath }}
-  nodeAffinity:
-    required:
-      nodeSelectorTerms:
-        - matchExpressions:
-            - key: kubernetes.io/hostname
-              operator: In
-              values:
-                - {{ .EtcdHostName2 }}
-  persistentVolumeReclaimPolicy: Retain
-  storageClassName: local-storage
-  volumeMode: Filesystem`
+            storage: {{ .StorageSize }}
+`
 )
+
+const (
+	// DefaultReplicas is the number of etcd members if not set in spec.
+	DefaultReplicas = 3
+	// DefaultStorageSize is the size of data volume of each member if not set in spec.
+	DefaultStorageSize = "2Gi"
+
+	// keys of the certs secret mounted by etcd and kube-apiserver
+	CACertKey              = "ca.crt"
+	ServerCertKey          = "server.crt"
+	ServerKeyKey           = "server.key"
+	APIServerClientCertKey = "apiserver-etcd-client.crt"
+	APIServerClientKeyKey  = "apiserver-etcd-client.key"
+
+	// keys of the initial cluster configmap
+	InitialClusterKey      = "initial-cluster"
+	InitialClusterStateKey = "initial-cluster-state"
+
+	clientPort = 2379
+	peerPort   = 2380
+)
+
+type Option struct {
+	Name             string
+	Namespace        string
+	ClusterName      string
+	ClusterNameLabel string
+	Replicas         int32
+	MinAvailable     int32
+	ImageName        string
+	StorageClassName string
+	StorageSize      string
+	CertsSecret      string
+	ClusterConfigMap string
+	CACertKey        string
+	ServerCertKey    string
+	ServerKeyKey     string
+}
+
+// Name returns the name of etcd StatefulSet and its headless service.
+func Name(cluster *devopsv1.Cluster) string {
+	return constants.GenComponentName(cluster.Name, "etcd")
+}
+
+// CertsSecretName returns the name of secret which holds the etcd ca, server and client certs.
+func CertsSecretName(cluster *devopsv1.Cluster) string {
+	return constants.GenComponentName(cluster.Name, "etcd-certs")
+}
+
+// ClusterConfigMapName returns the name of configmap which holds the initial cluster of new members.
+func ClusterConfigMapName(cluster *devopsv1.Cluster) string {
+	return constants.GenComponentName(cluster.Name, "etcd-cluster")
+}
+
+// IsManaged returns true if the etcd of cluster is deployed as StatefulSet by the operator,
+// it's opted in by spec.etcd.local.managed.
+func IsManaged(cluster *devopsv1.Cluster) bool {
+	return cluster.Spec.Etcd != nil && cluster.Spec.Etcd.Local != nil && cluster.Spec.Etcd.Local.Managed
+}
+
+// Replicas returns the desired number of etcd members.
+func Replicas(cluster *devopsv1.Cluster) int32 {
+	if cluster.Spec.Etcd != nil && cluster.Spec.Etcd.Local != nil && cluster.Spec.Etcd.Local.Replicas != nil {
+		return *cluster.Spec.Etcd.Local.Replicas
+	}
+	return DefaultReplicas
+}
+
+// MemberName returns the name of member with the ordinal, it's the name of pod too.
+func MemberName(cluster *devopsv1.Cluster, ordinal int32) string {
+	return fmt.Sprintf("%s-%d", Name(cluster), ordinal)
+}
+
+func memberHost(cluster *devopsv1.Cluster, ordinal int32) string {
+	return fmt.Sprintf("%s.%s.%s.svc", MemberName(cluster, ordinal), Name(cluster), cluster.Namespace)
+}
+
+// PeerURL returns the peer url of member with the ordinal.
+func PeerURL(cluster *devopsv1.Cluster, ordinal int32) string {
+	return fmt.Sprintf("https://%s:%d", memberHost(cluster, ordinal), peerPort)
+}
+
+// ClientEndpoints returns the client urls of the first replicas members.
+func ClientEndpoints(cluster *devopsv1.Cluster, replicas int32) []string {
+	endpoints := make([]string, 0, replicas)
+	for i := int32(0); i < replicas; i++ {
+		endpoints = append(endpoints, fmt.Sprintf("https://%s:%d", memberHost(cluster, i), clientPort))
+	}
+	return endpoints
+}
+
+// InitialCluster returns the --initial-cluster of the first replicas members.
+func InitialCluster(cluster *devopsv1.Cluster, replicas int32) string {
+	members := make([]string, 0, replicas)
+	for i := int32(0); i < replicas; i++ {
+		members = append(members, fmt.Sprintf("%s=%s", MemberName(cluster, i), PeerURL(cluster, i)))
+	}
+	return strings.Join(members, ",")
+}
+
+// ServerCertSANs returns the dns names of all members covered by the server cert.
+func ServerCertSANs(cluster *devopsv1.Cluster) []string {
+	svc := Name(cluster)
+	sans := []string{
+		"localhost",
+		fmt.Sprintf("*.%s", svc),
+		fmt.Sprintf("*.%s.%s", svc, cluster.Namespace),
+		fmt.Sprintf("*.%s.%s.svc", svc, cluster.Namespace),
+	}
+	if cluster.Spec.Etcd != nil && cluster.Spec.Etcd.Local != nil {
+		sans = append(sans, cluster.Spec.Etcd.Local.ServerCertSANs...)
+	}
+	return sans
+}
+
+// ImageName returns the etcd image supported by the kubernetes version of cluster.
+func ImageName(cfg *config.Config, cluster *devopsv1.Cluster) string {
+	tag := pkiutil.DefaultEtcdVersion
+	if v, _, err := pkiutil.EtcdSupportedVersion(pkiutil.SupportedEtcdVersion, cluster.Spec.Version); err == nil {
+		tag = v.String()
+	}
+	return fmt.Sprintf("%s/etcd:%s", cfg.CustomRegistry, tag)
+}
+
+// BuildEtcdAddon builds the headless service, pdb and StatefulSet of etcd with the replicas,
+// all of them are owned by the cluster.
+func BuildEtcdAddon(cfg *config.Config, ctx *common.ClusterContext, replicas int32) ([]client.Object, error) {
+	opt := &Option{
+		Name:             Name(ctx.Cluster),
+		Namespace:        ctx.Cluster.Namespace,
+		ClusterName:      ctx.Cluster.Name,
+		ClusterNameLabel: constants.ClusterNameLabel,
+		Replicas:         replicas,
+		MinAvailable:     replicas/2 + 1,
+		ImageName:        ImageName(cfg, ctx.Cluster),
+		StorageSize:      DefaultStorageSize,
+		CertsSecret:      CertsSecretName(ctx.Cluster),
+		ClusterConfigMap: ClusterConfigMapName(ctx.Cluster),
+		CACertKey:        CACertKey,
+		ServerCertKey:    ServerCertKey,
+		ServerKeyKey:     ServerKeyKey,
+	}
+	if local := ctx.Cluster.Spec.Etcd.Local; local != nil {
+		if local.StorageClassName != nil {
+			opt.StorageClassName = *local.StorageClassName
+		}
+		if local.StorageSize != nil {
+			opt.StorageSize = local.StorageSize.String()
+		}
+	}
+
+	data, err := template.ParseString(etcdTemplate, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	objs, err := k8sutil.LoadObjs(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	owner := k8sutil.ObjectMeta(opt.Name, nil, ctx.Cluster).OwnerReferences
+	for _, obj := range objs {
+		obj.SetOwnerReferences(owner)
+	}
+	return objs, nil
+}
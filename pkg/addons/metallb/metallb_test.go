@@ -0,0 +1,53 @@
+package metallb
+
+import (
+	"strings"
+	"testing"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	"github.com/wtxue/kok-operator/pkg/util/pointer"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestBuildMetalLBAddon(t *testing.T) {
+	cluster := &devopsv1.Cluster{}
+	cluster.Spec.Features.PublicLB = pointer.ToBool(true)
+	cluster.Spec.Features.LoadBalancer = &devopsv1.LoadBalancer{
+		Mode: devopsv1.LoadBalancerBGP,
+		AddressPools: []devopsv1.AddressPool{
+			{Name: "public", Addresses: []string{"192.168.10.0/24", "192.168.9.1-192.168.9.5"}, AutoAssign: pointer.ToBool(false)},
+		},
+		BGPPeers: []devopsv1.BGPPeer{{PeerAddress: "10.0.0.1", PeerASN: 64501, MyASN: 64500}},
+	}
+
+	objs, err := BuildMetalLBAddon(config.NewDefaultConfig(), &common.ClusterContext{Cluster: cluster})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := objs[0].(*corev1.Namespace); !ok || objs[0].GetName() != Namespace {
+		t.Errorf("expect namespace first, got %T %s", objs[0], objs[0].GetName())
+	}
+
+	var found int
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *corev1.ConfigMap:
+			found++
+			cfg := o.Data["config"]
+			for _, s := range []string{"peer-address: 10.0.0.1", "peer-asn: 64501", "protocol: bgp", "auto-assign: false", "- 192.168.9.1-192.168.9.5"} {
+				if !strings.Contains(cfg, s) {
+					t.Errorf("expect %q in config:\n%s", s, cfg)
+				}
+			}
+		case *appsv1.DaemonSet, *appsv1.Deployment:
+			found++
+		}
+	}
+	if found != 3 {
+		t.Errorf("expect config, speaker and controller, found %d", found)
+	}
+}
@@ -41,6 +41,9 @@ type CredentialInfo struct {
 	KubeData        map[string]string `json:"kubeData,omitempty"`
 	ManifestsData   map[string]string `json:"manifestsData,omitempty"`
 	CertsBinaryData map[string][]byte `json:"certsBinaryData,omitempty"`
+	// PendingCAData holds the new CAs during CA rotation, keyed by the path like CertsBinaryData.
+	// +optional
+	PendingCAData map[string][]byte `json:"pendingCAData,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -1,6 +1,7 @@
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -30,15 +31,42 @@ const (
 	GPUVirtual GPUType = "Virtual"
 )
 
-// OSType defines the operating of system.
+// OSType defines the operating of system, it's the ID of /etc/os-release in lower case.
 type OSType string
 
 const (
-	CentosType OSType = "centos"
-	DebianType OSType = "debian"
-	UbuntuType OSType = "ubuntu"
+	CentosType    OSType = "centos"
+	DebianType    OSType = "debian"
+	UbuntuType    OSType = "ubuntu"
+	RHELType      OSType = "rhel"
+	RockyType     OSType = "rocky"
+	AlmaType      OSType = "almalinux"
+	OpenEulerType OSType = "openeuler"
+	KylinType     OSType = "kylin"
+	AnolisType    OSType = "anolis"
 )
 
+// PackageRepo is a repo file of the package manager on the hosts.
+type PackageRepo struct {
+	// OSType is the os of the hosts the repo is written to, empty means all the hosts.
+	// +optional
+	OSType OSType `json:"osType,omitempty"`
+	// Name is the file name of the repo without suffix, it's written to /etc/yum.repos.d/<name>.repo
+	// or /etc/apt/sources.list.d/<name>.list.
+	Name string `json:"name"`
+	// Content is the content of the repo file.
+	Content string `json:"content"`
+}
+
+// PackageRepos configures the package repos of the hosts, the existing repos are left untouched by default.
+type PackageRepos struct {
+	// Replace backs up and removes the existing repos before the repos are written.
+	// +optional
+	Replace bool `json:"replace,omitempty"`
+	// +optional
+	Repos []PackageRepo `json:"repos,omitempty"`
+}
+
 // CRIType defines the runtime of Container.
 type CRIType string
 
@@ -55,6 +83,10 @@ const (
 	ClusterRunning ClusterPhase = "Running"
 	// ClusterInitializing is the initialize phase.
 	ClusterInitializing ClusterPhase = "Initializing"
+	// ClusterUpgrading means the cluster is rolling to the version of spec.
+	ClusterUpgrading ClusterPhase = "Upgrading"
+	// ClusterRestoring means etcd is restoring from a snapshot.
+	ClusterRestoring ClusterPhase = "Restoring"
 	// ClusterFailed is the failed phase.
 	ClusterFailed ClusterPhase = "Failed"
 	// ClusterTerminating means the cluster is undergoing graceful termination.
@@ -157,6 +189,45 @@ type ClusterCondition struct {
 	// Human-readable message indicating details about last transition.
 	// +optional
 	Message string `json:"message,omitempty"`
+	// Attempts is the number of times the handler of this condition has run.
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+	// Duration is the total time the handler of this condition has run.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// NodeConditionType defines the type of condition recorded for one node of the cluster.
+type NodeConditionType string
+
+const (
+	// NodeConditionUpgrade records the progress of a node during the cluster upgrade.
+	NodeConditionUpgrade NodeConditionType = "Upgrade"
+	// NodeConditionDelete records the cleanup of a node during the cluster deletion.
+	NodeConditionDelete NodeConditionType = "Delete"
+)
+
+// NodeCondition contains details for the current condition of one node of this cluster.
+type NodeCondition struct {
+	// Node is the name of the node, which is the ip of machine.
+	Node string `json:"node"`
+	// Type is the type of the condition.
+	Type NodeConditionType `json:"type"`
+	// Status is the status of the condition.
+	// Can be True, False, Unknown.
+	Status ConditionStatus `json:"status"`
+	// Last time we probed the condition.
+	// +optional
+	LastProbeTime metav1.Time `json:"lastProbeTime,omitempty"`
+	// Last time the condition transitioned from one status to another.
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+	// Unique, one-word, CamelCase reason for the condition's last transition.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// Human-readable message indicating details about last transition.
+	// +optional
+	Message string `json:"message,omitempty"`
 }
 
 // UpgradeStrategy used to control the upgrade process.
@@ -198,6 +269,139 @@ type LocalEtcd struct {
 	ServerCertSANs []string `json:"serverCertSANs,omitempty"`
 	// PeerCertSANs sets extra Subject Alternative Names for the etcd peer signing cert.
 	PeerCertSANs []string `json:"peerCertSANs,omitempty"`
+
+	// Backup configures snapshots of the local etcd.
+	// +optional
+	Backup *EtcdBackup `json:"backup,omitempty"`
+
+	// Managed deploys the TLS etcd StatefulSet of the managed cluster by the operator, the existing
+	// etcd-N.etcd endpoints are used if it's false.
+	// +optional
+	Managed bool `json:"managed,omitempty"`
+	// Replicas is the number of members of the etcd StatefulSet deployed by the managed provider, default 3.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// StorageClassName of the data volumes of the etcd StatefulSet, the default storage class is used if empty.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// StorageSize of the data volume of each member, default 2Gi.
+	// +optional
+	StorageSize *resource.Quantity `json:"storageSize,omitempty"`
+}
+
+// EtcdBackup describes how to take snapshots of the local etcd.
+type EtcdBackup struct {
+	// Schedule is a cron expression with 5 fields, e.g. "0 */6 * * *".
+	// Empty means no periodic backup, on-demand backup by annotation is still available.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+	// Retention is the number of snapshots to keep. Defaults to 7.
+	// +optional
+	Retention int32 `json:"retention,omitempty"`
+	// Destination is where the snapshots are stored, defaults to the local directory of masters.
+	// +optional
+	Destination BackupDestination `json:"destination,omitempty"`
+}
+
+// BackupDestination describes where the snapshots are stored, Local and S3 are mutually exclusive.
+type BackupDestination struct {
+	// +optional
+	Local *LocalBackupDestination `json:"local,omitempty"`
+	// +optional
+	S3 *S3BackupDestination `json:"s3,omitempty"`
+}
+
+// LocalBackupDestination keeps the snapshot on the master which takes it.
+type LocalBackupDestination struct {
+	// Path is the directory of snapshots. Defaults to "/var/lib/etcd-backup".
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// S3BackupDestination uploads the snapshot to a S3 compatible object storage.
+type S3BackupDestination struct {
+	// Endpoint is the url of the object storage, e.g. https://s3.amazonaws.com or http://minio:9000
+	Endpoint string `json:"endpoint"`
+	// Region defaults to us-east-1.
+	// +optional
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+	// Prefix of the object key.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+	// InsecureSkipVerify skips the tls verification of the endpoint.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// CredentialsSecret is the name of secret in the cluster namespace,
+	// which holds the keys "accessKeyID" and "secretAccessKey".
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// EtcdBackupRecord records a snapshot of etcd.
+type EtcdBackupRecord struct {
+	// Name is the file name of the snapshot.
+	Name string `json:"name"`
+	// Node is the master which takes the snapshot.
+	Node string `json:"node"`
+	// Location is the path on the node, or the url of the object storage.
+	Location string `json:"location"`
+	// Size of the snapshot in bytes.
+	Size int64 `json:"size"`
+	// Revision of etcd when the snapshot is taken.
+	Revision int64       `json:"revision"`
+	Time     metav1.Time `json:"time"`
+}
+
+// CertificatePolicy describes when the leaf certs and the client certs of kubeconfigs are renewed.
+type CertificatePolicy struct {
+	// LeafLifetime is the validity of the leaf certs issued, default 8760h.
+	// +optional
+	LeafLifetime *metav1.Duration `json:"leafLifetime,omitempty"`
+	// RenewBefore renews the leaf certs when any of them expires within it, default 720h.
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+	// Schedule is a cron expression of checking the expiry, the renewal restarts the control plane
+	// and kubelet, default "0 3 * * *".
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// CertRotationPhase is the step of the certs rotation in progress.
+type CertRotationPhase string
+
+const (
+	// CertRotationRenewing re-issues the leaf certs with the current CAs.
+	CertRotationRenewing CertRotationPhase = "Renewing"
+	// CertRotationDistributingCA generates the new CAs and distributes them along with the old ones.
+	CertRotationDistributingCA CertRotationPhase = "DistributingCA"
+	// CertRotationReissuing re-issues the leaf certs with the new CAs.
+	CertRotationReissuing CertRotationPhase = "Reissuing"
+	// CertRotationRetiringCA removes the old CAs from the nodes.
+	CertRotationRetiringCA CertRotationPhase = "RetiringCA"
+)
+
+// CertificatesStatus records the expiry of the certs and the rotation in progress.
+type CertificatesStatus struct {
+	// Expiry of the certs in the credential, including the client certs of kubeconfigs.
+	// +optional
+	Expiry []CertificateExpiry `json:"expiry,omitempty"`
+	// Rotation is the step of rotation in progress, empty means no rotation.
+	// +optional
+	Rotation CertRotationPhase `json:"rotation,omitempty"`
+	// LastCheckTime is the last time of the scheduled check.
+	// +optional
+	LastCheckTime *metav1.Time `json:"lastCheckTime,omitempty"`
+	// LastRotateTime is the last time a rotation step is done.
+	// +optional
+	LastRotateTime *metav1.Time `json:"lastRotateTime,omitempty"`
+}
+
+// CertificateExpiry records the expiry of a cert.
+type CertificateExpiry struct {
+	// Name is the path of cert or kubeconfig.
+	Name     string      `json:"name"`
+	NotAfter metav1.Time `json:"notAfter"`
 }
 
 type HA struct {
@@ -227,6 +431,9 @@ type ClusterFeature struct {
 	PublicLB *bool `json:"publicLB,omitempty"`
 	// +optional
 	InternalLB *bool `json:"internalLB,omitempty" `
+	// LoadBalancer is the metallb config deployed when publicLB or internalLB is enabled.
+	// +optional
+	LoadBalancer *LoadBalancer `json:"loadBalancer,omitempty"`
 	// +optional
 	GPUType *GPUType `json:"gpuType,omitempty"`
 	// +optional
@@ -296,6 +503,48 @@ type Upgrade struct {
 	Strategy UpgradeStrategy `json:"strategy,omitempty"`
 }
 
+// LoadBalancerMode is the way metallb announces the addresses of LoadBalancer services.
+type LoadBalancerMode string
+
+const (
+	// LoadBalancerL2 answers arp/ndp requests of the addresses on the nodes
+	LoadBalancerL2 LoadBalancerMode = "l2"
+	// LoadBalancerBGP advertises the addresses to the bgp peers
+	LoadBalancerBGP LoadBalancerMode = "bgp"
+)
+
+// LoadBalancer is the metallb config of baremetal cluster.
+type LoadBalancer struct {
+	// Mode is l2 or bgp, default value is l2.
+	// +kubebuilder:validation:Enum=l2;bgp
+	// +optional
+	Mode LoadBalancerMode `json:"mode,omitempty"`
+	// AddressPools are assigned to the LoadBalancer services.
+	AddressPools []AddressPool `json:"addressPools"`
+	// BGPPeers are the routers to peer with in bgp mode.
+	// +optional
+	BGPPeers []BGPPeer `json:"bgpPeers,omitempty"`
+}
+
+// AddressPool is a set of addresses assigned by metallb.
+type AddressPool struct {
+	Name string `json:"name"`
+	// Addresses are cidrs or ranges like 192.168.1.100-192.168.1.200.
+	Addresses []string `json:"addresses"`
+	// AutoAssign false means the pool is only used by the services requesting it, default value is true.
+	// +optional
+	AutoAssign *bool `json:"autoAssign,omitempty"`
+}
+
+// BGPPeer is a bgp router metallb peers with.
+type BGPPeer struct {
+	PeerAddress string `json:"peerAddress"`
+	PeerASN     uint32 `json:"peerASN"`
+	MyASN       uint32 `json:"myASN"`
+	// +optional
+	PeerPort int32 `json:"peerPort,omitempty"`
+}
+
 // Mirror contains the config related to the registry mirror
 type Mirror struct {
 	// Endpoints are endpoints for a namespace. CRI plugin will try the endpoints
@@ -318,12 +567,16 @@ type ClusterSpec struct {
 	Finalizers []FinalizerName `json:"finalizers,omitempty"`
 	TenantID   string          `json:"tenantID"`
 	// +optional
-	DisplayName string      `json:"displayName,omitempty"`
-	ClusterType string      `json:"clusterType,omitempty"`
-	OSType      OSType      `json:"osType,omitempty"`
-	CRIType     CRIType     `json:"criType,omitempty"`
-	NetworkType NetworkType `json:"networkType,omitempty"`
-	Version     string      `json:"version,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	ClusterType string `json:"clusterType,omitempty"`
+	// OSType is used for the hosts without /etc/os-release, the os of the others is detected.
+	// +optional
+	OSType OSType `json:"osType,omitempty"`
+	// +optional
+	PackageRepos *PackageRepos `json:"packageRepos,omitempty"`
+	CRIType      CRIType       `json:"criType,omitempty"`
+	NetworkType  NetworkType   `json:"networkType,omitempty"`
+	Version      string        `json:"version,omitempty"`
 	// +optional
 	NetworkDevice string `json:"networkDevice,omitempty"`
 	// +optional
@@ -341,6 +594,9 @@ type ClusterSpec struct {
 	Properties ClusterProperty `json:"properties,omitempty"`
 	// +optional
 	Machines []*ClusterMachine `json:"machines,omitempty"`
+	// SSH holds the options to connect the machines of cluster.
+	// +optional
+	SSH *SSHOptions `json:"ssh,omitempty"`
 	// +optional
 	Registry *Registry `json:"registry,omitempty"`
 	// +optional
@@ -358,11 +614,94 @@ type ClusterSpec struct {
 	Upgrade Upgrade `json:"upgrade,omitempty"`
 	// +optional
 	NetworkArgs map[string]string `json:"networkArgs,omitempty"`
+	// HelmReleases are installed or upgraded into the cluster after it's running,
+	// the releases removed from the list are uninstalled.
+	// +optional
+	HelmReleases []HelmRelease `json:"helmReleases,omitempty"`
+	// Certificates configures the rotation of the certs, the certs are renewed by the operator
+	// only when it's set.
+	// +optional
+	Certificates *CertificatePolicy `json:"certificates,omitempty"`
+	// Preflight tunes the preflight checks of the masters, and the machines without their policy.
+	// +optional
+	Preflight *PreflightPolicy `json:"preflight,omitempty"`
 	// +optional
 	// Pause
 	Pause bool `json:"pause,omitempty"`
 }
 
+// HelmRelease describes a helm chart released into the cluster.
+type HelmRelease struct {
+	// Name of the release, it's unique in the cluster.
+	Name string `json:"name"`
+	// Namespace of the release, defaults to kube-system, it's created if not exists.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// RepoName is the name of the helm repo, e.g. bitnami
+	RepoName string `json:"repoName"`
+	// RepoURL is the url of the helm repo, the repo is added when it is missing.
+	// +optional
+	RepoURL string `json:"repoURL,omitempty"`
+	// Chart is the chart name in the repo.
+	Chart string `json:"chart"`
+	// Version of the chart, the latest version is used if empty.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Values is yaml of the chart values.
+	// +optional
+	Values string `json:"values,omitempty"`
+}
+
+// HelmReleaseStatus records the release applied to the cluster.
+type HelmReleaseStatus struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Chart is the chart of release in the form of repo/chart.
+	// +optional
+	Chart string `json:"chart,omitempty"`
+	// Version of the chart released.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Revision of the release.
+	// +optional
+	Revision int32 `json:"revision,omitempty"`
+	// Status of the release, such as deployed and failed.
+	// +optional
+	Status string `json:"status,omitempty"`
+	// A human readable message indicating details about the last failure.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// ObservedGeneration is the generation of cluster applied.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// SpecHash is the hash of the release spec applied, the release is applied again when it's changed.
+	// +optional
+	SpecHash string `json:"specHash,omitempty"`
+	// Last time the release was applied.
+	// +optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// SSHOptions is the options to connect machines by ssh.
+type SSHOptions struct {
+	// DialTimeoutSeconds is the timeout of each dial, default 1
+	// +optional
+	DialTimeoutSeconds int32 `json:"dialTimeoutSeconds,omitempty"`
+	// Retry is the retry times when dial failed, default 0
+	// +optional
+	Retry int32 `json:"retry,omitempty"`
+	// RetryIntervalSeconds is the interval between retries, default 5
+	// +optional
+	RetryIntervalSeconds int32 `json:"retryIntervalSeconds,omitempty"`
+	// KnownHosts is the content of known_hosts file, the host keys of machines are checked with it,
+	// the machine with hostKeyFingerprint is checked with the fingerprint.
+	// +optional
+	KnownHosts string `json:"knownHosts,omitempty"`
+	// CredentialsRef is the secret of ssh credentials shared by the machines without credentials
+	// +optional
+	CredentialsRef *corev1.LocalObjectReference `json:"credentialsRef,omitempty"`
+}
+
 // ClusterStatus represents information about the status of a cluster.
 type ClusterStatus struct {
 	// +optional
@@ -375,6 +714,9 @@ type ClusterStatus struct {
 	// +patchMergeKey=type
 	// +patchStrategy=merge
 	Conditions []ClusterCondition `json:"conditions,omitempty"`
+	// NodeConditions records the progress of each node during a rolling operation, such as upgrade.
+	// +optional
+	NodeConditions []NodeCondition `json:"nodeConditions,omitempty"`
 	// A human readable message indicating details about why the cluster is in this condition.
 	// +optional
 	Message string `json:"message,omitempty"`
@@ -410,6 +752,96 @@ type ClusterStatus struct {
 	NodeCIDRMaskSizeIPv4 int32 `json:"nodeCIDRMaskSizeIPv4,omitempty"`
 	// +optional
 	NodeCIDRMaskSizeIPv6 int32 `json:"nodeCIDRMaskSizeIPv6,omitempty"`
+	// EtcdBackups records the snapshots kept, the latest is the last one.
+	// +optional
+	EtcdBackups []EtcdBackupRecord `json:"etcdBackups,omitempty"`
+	// HelmReleases records the releases of spec.helmReleases.
+	// +optional
+	HelmReleases []HelmReleaseStatus `json:"helmReleases,omitempty"`
+	// Certificates records the expiry of certs and the progress of rotation.
+	// +optional
+	Certificates *CertificatesStatus `json:"certificates,omitempty"`
+	// MachineInfos records the system info of masters detected over ssh.
+	// +optional
+	MachineInfos []ClusterMachineInfo `json:"machineInfos,omitempty"`
+	// Preflight is the report of the preflight checks of the hosts.
+	// +optional
+	Preflight *PreflightReport `json:"preflight,omitempty"`
+}
+
+// PreflightIgnoreAll in ignoreErrors ignores the errors of all the checks.
+const PreflightIgnoreAll = "all"
+
+// PreflightPolicy enables, disables or tunes the preflight checks by name, the names are the ones
+// in the preflight report, such as NumCPU, Memory and Port-6443.
+type PreflightPolicy struct {
+	// IgnoreErrors are the checks whose errors are reported as warnings, "all" ignores all of them.
+	// +optional
+	IgnoreErrors []string `json:"ignoreErrors,omitempty"`
+	// Disable are the checks not run.
+	// +optional
+	Disable []string `json:"disable,omitempty"`
+	// Enable are the optional checks run, such as KernelModule-iptable_nat and Port-2380.
+	// +optional
+	Enable []string `json:"enable,omitempty"`
+	// MinCPU defaults to 1.
+	// +optional
+	MinCPU *int32 `json:"minCPU,omitempty"`
+	// MinMemory defaults to 1700Mi on master and 1Gi on node.
+	// +optional
+	MinMemory *resource.Quantity `json:"minMemory,omitempty"`
+	// MinDisk is the min available disk of /var/lib, defaults to 10Gi.
+	// +optional
+	MinDisk *resource.Quantity `json:"minDisk,omitempty"`
+	// MinKernelVersion is the min kernel version as major.minor, defaults to 4.10.
+	// +optional
+	MinKernelVersion string `json:"minKernelVersion,omitempty"`
+	// MaxClockSkew is the max clock offset of a host to the others, defaults to 5s.
+	// +optional
+	MaxClockSkew *metav1.Duration `json:"maxClockSkew,omitempty"`
+}
+
+// PreflightCheckStatus is the result of a preflight check.
+type PreflightCheckStatus string
+
+const (
+	PreflightPassed  PreflightCheckStatus = "Passed"
+	PreflightWarning PreflightCheckStatus = "Warning"
+	PreflightFailed  PreflightCheckStatus = "Failed"
+)
+
+// PreflightCheckResult is the result of a check on a host.
+type PreflightCheckResult struct {
+	Name   string               `json:"name"`
+	Status PreflightCheckStatus `json:"status"`
+	// +optional
+	Messages []string `json:"messages,omitempty"`
+}
+
+// HostPreflightResult is the results of the checks on a host.
+type HostPreflightResult struct {
+	IP string `json:"ip"`
+	// Role is master or node.
+	Role   string               `json:"role"`
+	Status PreflightCheckStatus `json:"status"`
+	// +optional
+	Checks []PreflightCheckResult `json:"checks,omitempty"`
+}
+
+// PreflightReport is the results of the preflight checks of the masters and the machines of the cluster.
+type PreflightReport struct {
+	Time metav1.Time `json:"time"`
+	// Status is the worst status of the hosts.
+	Status PreflightCheckStatus `json:"status"`
+	// +optional
+	Hosts []HostPreflightResult `json:"hosts,omitempty"`
+}
+
+// ClusterMachineInfo is the system info of a master.
+type ClusterMachineInfo struct {
+	// IP is the ip of master in spec.machines.
+	IP                string `json:"ip"`
+	MachineSystemInfo `json:",inline"`
 }
 
 // +genclient
@@ -30,10 +30,17 @@ type ClusterMachine struct {
 	IP       string `json:"ip"`
 	Port     int32  `json:"port"`
 	Username string `json:"username"`
+	// CredentialsRef is the secret of ssh credentials in the namespace of cluster,
+	// the keys are password, ssh-privatekey and passphrase.
+	// +optional
+	CredentialsRef *corev1.LocalObjectReference `json:"credentialsRef,omitempty"`
+	// Deprecated: use CredentialsRef instead.
 	// +optional
 	Password string `json:"password,omitempty"`
+	// Deprecated: use CredentialsRef instead.
 	// +optional
 	PrivateKey []byte `json:"privateKey,omitempty"`
+	// Deprecated: use CredentialsRef instead.
 	// +optional
 	PassPhrase []byte `json:"passPhrase,omitempty"`
 	// +optional
@@ -41,6 +48,66 @@ type ClusterMachine struct {
 	// If specified, the node's taints.
 	// +optional
 	Taints []corev1.Taint `json:"taints,omitempty"`
+	// HostKeyFingerprint pins the SHA256 fingerprint of the host key, such as SHA256:xxx
+	// +optional
+	HostKeyFingerprint string `json:"hostKeyFingerprint,omitempty"`
+	// ProxyJump is the bastion host to reach the machine
+	// +optional
+	ProxyJump *ProxyJump `json:"proxyJump,omitempty"`
+	// Arch is the expected cpu architecture, amd64 or arm64, the detected one is used if empty
+	// +optional
+	Arch string `json:"arch,omitempty"`
+	// SSHOptions is filled from the cluster spec when the cluster is loaded
+	SSHOptions *SSHOptions `json:"-"`
+	// Secrets resolves the credentialsRef when connecting
+	Secrets *SecretSource `json:"-"`
+}
+
+// SecretGetter gets the secret by name in the namespace of cluster.
+// +kubebuilder:object:generate=false
+type SecretGetter func(name string) (*corev1.Secret, error)
+
+// SecretSource holds the getter of secrets, it's shared by the copies of machine.
+// +kubebuilder:object:generate=false
+type SecretSource struct {
+	Get SecretGetter
+}
+
+func (in *SecretSource) DeepCopyInto(out *SecretSource) {
+	*out = *in
+}
+
+func (in *SecretSource) DeepCopy() *SecretSource {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// SSHPassPhraseKey is the key of passphrase of private key in the credentials secret.
+const SSHPassPhraseKey = "passphrase"
+
+// ProxyJump is a jump host like ssh -J
+type ProxyJump struct {
+	IP       string `json:"ip"`
+	Port     int32  `json:"port"`
+	Username string `json:"username"`
+	// CredentialsRef is the secret of ssh credentials like ClusterMachine
+	// +optional
+	CredentialsRef *corev1.LocalObjectReference `json:"credentialsRef,omitempty"`
+	// Deprecated: use CredentialsRef instead.
+	// +optional
+	Password string `json:"password,omitempty"`
+	// Deprecated: use CredentialsRef instead.
+	// +optional
+	PrivateKey []byte `json:"privateKey,omitempty"`
+	// Deprecated: use CredentialsRef instead.
+	// +optional
+	PassPhrase []byte `json:"passPhrase,omitempty"`
+	// +optional
+	HostKeyFingerprint string `json:"hostKeyFingerprint,omitempty"`
 }
 
 func (in *Cluster) SetCondition(newCondition ClusterCondition) {
@@ -57,6 +124,10 @@ func (in *Cluster) SetCondition(newCondition ClusterCondition) {
 			if newCondition.LastTransitionTime.IsZero() {
 				newCondition.LastTransitionTime = condition.LastTransitionTime
 			}
+			if newCondition.Attempts == 0 {
+				newCondition.Attempts = condition.Attempts
+				newCondition.Duration = condition.Duration
+			}
 			condition = newCondition
 		}
 		conditions = append(conditions, condition)
@@ -72,20 +143,220 @@ func (in *Cluster) SetCondition(newCondition ClusterCondition) {
 	in.Status.Conditions = conditions
 }
 
+// RecordAttempt counts a run of the handler of the condition, the condition is added if not found.
+func (in *Cluster) RecordAttempt(conditionType string, d time.Duration) {
+	for i := range in.Status.Conditions {
+		if in.Status.Conditions[i].Type == conditionType {
+			in.Status.Conditions[i].Attempts++
+			in.Status.Conditions[i].Duration = addDuration(in.Status.Conditions[i].Duration, d)
+			return
+		}
+	}
+
+	in.SetCondition(ClusterCondition{
+		Type:     conditionType,
+		Status:   ConditionUnknown,
+		Attempts: 1,
+		Duration: addDuration(nil, d),
+	})
+}
+
+// LoadBalancerEnabled returns true when publicLB or internalLB is enabled.
+func (in *ClusterFeature) LoadBalancerEnabled() bool {
+	return (in.PublicLB != nil && *in.PublicLB) || (in.InternalLB != nil && *in.InternalLB)
+}
+
+// FailedCondition returns the first failed condition, nil if none.
+func (in *Cluster) FailedCondition() *ClusterCondition {
+	for i := range in.Status.Conditions {
+		if in.Status.Conditions[i].Status == ConditionFalse {
+			return &in.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// GetCondition returns the condition of the type, nil if not found.
+func (in *Cluster) GetCondition(conditionType string) *ClusterCondition {
+	for i := range in.Status.Conditions {
+		if in.Status.Conditions[i].Type == conditionType {
+			return &in.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// RemoveCondition removes the condition of the type.
+func (in *Cluster) RemoveCondition(conditionType string) {
+	var conditions []ClusterCondition
+	for _, condition := range in.Status.Conditions {
+		if condition.Type != conditionType {
+			conditions = append(conditions, condition)
+		}
+	}
+
+	in.Status.Conditions = conditions
+}
+
+func (in *Cluster) SetNodeCondition(newCondition NodeCondition) {
+	var conditions []NodeCondition
+
+	exist := false
+
+	if newCondition.LastProbeTime.IsZero() {
+		newCondition.LastProbeTime = metav1.Now()
+	}
+	for _, condition := range in.Status.NodeConditions {
+		if condition.Node == newCondition.Node && condition.Type == newCondition.Type {
+			exist = true
+			if newCondition.LastTransitionTime.IsZero() {
+				newCondition.LastTransitionTime = condition.LastTransitionTime
+			}
+			condition = newCondition
+		}
+		conditions = append(conditions, condition)
+	}
+
+	if !exist {
+		if newCondition.LastTransitionTime.IsZero() {
+			newCondition.LastTransitionTime = metav1.Now()
+		}
+		conditions = append(conditions, newCondition)
+	}
+
+	in.Status.NodeConditions = conditions
+}
+
+func (in *Cluster) GetNodeCondition(node string, conditionType NodeConditionType) *NodeCondition {
+	for i := range in.Status.NodeConditions {
+		if in.Status.NodeConditions[i].Node == node && in.Status.NodeConditions[i].Type == conditionType {
+			return &in.Status.NodeConditions[i]
+		}
+	}
+
+	return nil
+}
+
+// SetMachineInfo records the system info of the master.
+func (in *Cluster) SetMachineInfo(ip string, info MachineSystemInfo) {
+	for i := range in.Status.MachineInfos {
+		if in.Status.MachineInfos[i].IP == ip {
+			in.Status.MachineInfos[i].MachineSystemInfo = info
+			return
+		}
+	}
+
+	in.Status.MachineInfos = append(in.Status.MachineInfos, ClusterMachineInfo{IP: ip, MachineSystemInfo: info})
+}
+
+// GetMachineInfo returns the system info of the master, nil if it's not detected.
+func (in *Cluster) GetMachineInfo(ip string) *MachineSystemInfo {
+	for i := range in.Status.MachineInfos {
+		if in.Status.MachineInfos[i].IP == ip {
+			return &in.Status.MachineInfos[i].MachineSystemInfo
+		}
+	}
+
+	return nil
+}
+
 func (in *ClusterMachine) SSH() (*ssh.SSH, error) {
 	sshConfig := &ssh.Config{
-		User:        in.Username,
-		Host:        in.IP,
-		Port:        int(in.Port),
-		Password:    in.Password,
-		PrivateKey:  in.PrivateKey,
-		PassPhrase:  in.PassPhrase,
-		DialTimeOut: time.Second,
-		Retry:       0,
+		User:               in.Username,
+		Host:               in.IP,
+		Port:               int(in.Port),
+		Password:           in.Password,
+		PrivateKey:         in.PrivateKey,
+		PassPhrase:         in.PassPhrase,
+		DialTimeOut:        time.Second,
+		Retry:              0,
+		HostKeyFingerprint: in.HostKeyFingerprint,
+	}
+	if opts := in.SSHOptions; opts != nil {
+		if opts.DialTimeoutSeconds > 0 {
+			sshConfig.DialTimeOut = time.Duration(opts.DialTimeoutSeconds) * time.Second
+		}
+		if opts.RetryIntervalSeconds > 0 {
+			sshConfig.RetryInterval = time.Duration(opts.RetryIntervalSeconds) * time.Second
+		}
+		sshConfig.Retry = int(opts.Retry)
+		sshConfig.KnownHosts = []byte(opts.KnownHosts)
+	}
+
+	// the shared secret of cluster is used only if the machine has no credentials
+	ref := in.CredentialsRef
+	if ref == nil && in.Password == "" && len(in.PrivateKey) == 0 && in.SSHOptions != nil {
+		ref = in.SSHOptions.CredentialsRef
+	}
+	if ref != nil {
+		f, err := in.credentialsFunc(ref)
+		if err != nil {
+			return nil, err
+		}
+		sshConfig.CredentialsFunc = f
+	}
+
+	if p := in.ProxyJump; p != nil {
+		sshConfig.Proxy = &ssh.Config{
+			User:               p.Username,
+			Host:               p.IP,
+			Port:               int(p.Port),
+			Password:           p.Password,
+			PrivateKey:         p.PrivateKey,
+			PassPhrase:         p.PassPhrase,
+			DialTimeOut:        sshConfig.DialTimeOut,
+			Retry:              sshConfig.Retry,
+			RetryInterval:      sshConfig.RetryInterval,
+			HostKeyFingerprint: p.HostKeyFingerprint,
+			KnownHosts:         sshConfig.KnownHosts,
+		}
+		if p.CredentialsRef != nil {
+			f, err := in.credentialsFunc(p.CredentialsRef)
+			if err != nil {
+				return nil, err
+			}
+			sshConfig.Proxy.CredentialsFunc = f
+		}
 	}
 	return ssh.New(sshConfig)
 }
 
+// credentialsFunc reads the credentials from the secret when connecting.
+func (in *ClusterMachine) credentialsFunc(ref *corev1.LocalObjectReference) (func() (*ssh.Credentials, error), error) {
+	if in.Secrets == nil || in.Secrets.Get == nil {
+		return nil, fmt.Errorf("credentialsRef %s of %s can't be resolved without secret getter", ref.Name, in.IP)
+	}
+
+	getter := in.Secrets.Get
+	return func() (*ssh.Credentials, error) {
+		secret, err := getter(ref.Name)
+		if err != nil {
+			return nil, fmt.Errorf("get credentials secret %s: %v", ref.Name, err)
+		}
+
+		return &ssh.Credentials{
+			Password:   string(secret.Data[corev1.BasicAuthPasswordKey]),
+			PrivateKey: secret.Data[corev1.SSHAuthPrivateKey],
+			PassPhrase: secret.Data[SSHPassPhraseKey],
+		}, nil
+	}, nil
+}
+
+// SetSSHOptions sets the ssh options of cluster and the getter to resolve credentialsRef
+func (in *ClusterMachine) SetSSHOptions(opts *SSHOptions, getter SecretGetter) {
+	in.SSHOptions = opts
+	in.Secrets = &SecretSource{Get: getter}
+}
+
+// SetSSHOptions passes the ssh options of cluster to its masters
+func (in *Cluster) SetSSHOptions(getter SecretGetter) {
+	for _, m := range in.Spec.Machines {
+		m.SetSSHOptions(in.Spec.SSH, getter)
+	}
+}
+
 func (in *Cluster) Address(addrType AddressType) *ClusterAddress {
 	for _, one := range in.Status.Addresses {
 		if one.Type == addrType {
@@ -121,6 +392,51 @@ func (in *Cluster) RemoveAddress(addrType AddressType) {
 	in.Status.Addresses = addrs
 }
 
+// RemovedMasters returns the hosts of real addresses which are not the masters of spec any more.
+func (in *Cluster) RemovedMasters() []string {
+	var hosts []string
+	for _, one := range in.Status.Addresses {
+		if one.Type != AddressReal {
+			continue
+		}
+
+		found := false
+		for _, m := range in.Spec.Machines {
+			if m.IP == one.Host {
+				found = true
+				break
+			}
+		}
+		if !found {
+			hosts = append(hosts, one.Host)
+		}
+	}
+
+	return hosts
+}
+
+// MastersChanged returns true if the masters of spec are different from the real addresses.
+func (in *Cluster) MastersChanged() bool {
+	if len(in.RemovedMasters()) > 0 {
+		return true
+	}
+
+	for _, m := range in.Spec.Machines {
+		found := false
+		for _, one := range in.Status.Addresses {
+			if one.Type == AddressReal && one.Host == m.IP {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (in *Cluster) Host() (string, error) {
 	addrs := make(map[AddressType][]ClusterAddress)
 	for _, one := range in.Status.Addresses {
@@ -159,6 +475,10 @@ func (in *Machine) SetCondition(newCondition MachineCondition) {
 			if newCondition.LastTransitionTime.IsZero() {
 				newCondition.LastTransitionTime = condition.LastTransitionTime
 			}
+			if newCondition.Attempts == 0 {
+				newCondition.Attempts = condition.Attempts
+				newCondition.Duration = condition.Duration
+			}
 			condition = newCondition
 		}
 		conditions = append(conditions, condition)
@@ -174,16 +494,42 @@ func (in *Machine) SetCondition(newCondition MachineCondition) {
 	in.Status.Conditions = conditions
 }
 
+// RecordAttempt counts a run of the handler of the condition, the condition is added if not found.
+func (in *Machine) RecordAttempt(conditionType string, d time.Duration) {
+	for i := range in.Status.Conditions {
+		if in.Status.Conditions[i].Type == conditionType {
+			in.Status.Conditions[i].Attempts++
+			in.Status.Conditions[i].Duration = addDuration(in.Status.Conditions[i].Duration, d)
+			return
+		}
+	}
+
+	in.SetCondition(MachineCondition{
+		Type:     conditionType,
+		Status:   ConditionUnknown,
+		Attempts: 1,
+		Duration: addDuration(nil, d),
+	})
+}
+
+// FailedCondition returns the first failed condition, nil if none.
+func (in *Machine) FailedCondition() *MachineCondition {
+	for i := range in.Status.Conditions {
+		if in.Status.Conditions[i].Status == ConditionFalse {
+			return &in.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
 func (in *MachineSpec) SSH() (*ssh.SSH, error) {
-	sshConfig := &ssh.Config{
-		User:        in.Machine.Username,
-		Host:        in.Machine.IP,
-		Port:        int(in.Machine.Port),
-		Password:    in.Machine.Password,
-		PrivateKey:  in.Machine.PrivateKey,
-		PassPhrase:  in.Machine.PassPhrase,
-		DialTimeOut: time.Second,
-		Retry:       0,
+	return in.Machine.SSH()
+}
+
+func addDuration(total *metav1.Duration, d time.Duration) *metav1.Duration {
+	if total == nil {
+		total = &metav1.Duration{}
 	}
-	return ssh.New(sshConfig)
+	return &metav1.Duration{Duration: (total.Duration + d).Round(time.Millisecond)}
 }
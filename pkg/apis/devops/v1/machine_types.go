@@ -85,6 +85,12 @@ type MachineCondition struct {
 	// Human-readable message indicating details about last transition.
 	// +optional
 	Message string `json:"message,omitempty"`
+	// Attempts is the number of times the handler of this condition has run.
+	// +optional
+	Attempts int32 `json:"attempts,omitempty"`
+	// Duration is the total time the handler of this condition has run.
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
 }
 
 type MachineFeature struct {
@@ -103,7 +109,10 @@ type MachineSpec struct {
 	Machine          *ClusterMachine   `json:"machine,omitempty"`
 	Feature          *MachineFeature   `json:"feature,omitempty"`
 	KubeletExtraArgs map[string]string `json:"kubeletExtraArgs,omitempty"`
-	Pause            bool              `json:"pause,omitempty"`
+	// Preflight tunes the preflight checks of the machine instead of the policy of cluster.
+	// +optional
+	Preflight *PreflightPolicy `json:"preflight,omitempty"`
+	Pause     bool             `json:"pause,omitempty"`
 }
 
 // MachineStatus represents information about the status of an machine.
@@ -1,6 +1,8 @@
 package apis
 
 import (
+	"time"
+
 	kubeadmv1beta3 "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm/v1beta3"
 )
 
@@ -9,4 +11,6 @@ type WarpperConfiguration struct {
 	*kubeadmv1beta3.InitConfiguration    `json:"-"`
 	*kubeadmv1beta3.ClusterConfiguration `json:"-"`
 	IPs                                  []string
+	// NotAfter overrides the expiration of the leaf certs, nil means the default validity.
+	NotAfter *time.Time `json:"-"`
 }
@@ -4,15 +4,97 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// AddonType defines the type of addon.
+type AddonType string
+
+const (
+	AddonCoreDNS       AddonType = "coredns"
+	AddonKubeProxy     AddonType = "kubeproxy"
+	AddonFlannel       AddonType = "flannel"
+	AddonMetricsServer AddonType = "metricsserver"
+	AddonMetalLB       AddonType = "metallb"
+	AddonKubeVip       AddonType = "kubevip"
+	// AddonHelm installs any helm chart described by spec.chart
+	AddonHelm AddonType = "helm"
+)
+
+// AddonPhase defines the phase of addon.
+type AddonPhase string
+
+const (
+	// AddonPending means the target cluster is not ready yet
+	AddonPending AddonPhase = "Pending"
+	// AddonInstalling is the first installation phase
+	AddonInstalling AddonPhase = "Installing"
+	// AddonUpgrading means the spec changed and is applying
+	AddonUpgrading AddonPhase = "Upgrading"
+	// AddonRunning is the normal running phase
+	AddonRunning AddonPhase = "Running"
+	// AddonFailed means the last apply failed, it will be retried
+	AddonFailed AddonPhase = "Failed"
+	// AddonTerminating means the addon is uninstalling
+	AddonTerminating AddonPhase = "Terminating"
+)
+
+// HelmChart describes the chart of a helm addon.
+type HelmChart struct {
+	// RepoName is the name of the helm repo, e.g. bitnami
+	RepoName string `json:"repoName"`
+	// RepoURL is the url of the helm repo, the repo is added when it is missing
+	// +optional
+	RepoURL string `json:"repoURL,omitempty"`
+	// Name is the chart name in the repo
+	Name string `json:"name"`
+	// ReleaseName defaults to the name of Addons
+	// +optional
+	ReleaseName string `json:"releaseName,omitempty"`
+	// Namespace of the release, defaults to kube-system
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
 // AddonsSpec is a description of Addons.
 type AddonsSpec struct {
-	Foo string `json:"foo,omitempty"`
+	// ClusterName is the target cluster in the same namespace.
+	ClusterName string `json:"clusterName"`
+	// +kubebuilder:validation:Enum=coredns;kubeproxy;flannel;metricsserver;metallb;kubevip;helm
+	Type AddonType `json:"type"`
+	// Version is the image tag of builtin addons, or the chart version of helm addons.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Chart is required by helm addon, metallb uses the official chart by default.
+	// +optional
+	Chart *HelmChart `json:"chart,omitempty"`
+	// Values is yaml of the chart values for helm addons,
+	// builtin addons support overriding "image" and "replicas".
+	// +optional
+	Values string `json:"values,omitempty"`
+	// +optional
+	Pause bool `json:"pause,omitempty"`
 }
 
 // AddonsStatus represents information about the status of an Addons.
 type AddonsStatus struct {
-	Phase string `json:"phase,omitempty"`
-	Foo   string `json:"foo,omitempty"`
+	// +optional
+	Phase AddonPhase `json:"phase,omitempty"`
+	// Version is the version applied to the cluster.
+	// +optional
+	Version string `json:"version,omitempty"`
+	// Healthy is true when all workloads of the addon are ready.
+	// +optional
+	Healthy bool `json:"healthy,omitempty"`
+	// ObservedGeneration is the generation of the spec applied.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// The reason for the last failure.
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// A human readable message indicating details about the last failure.
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Last time the addon was applied.
+	// +optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
 }
 
 // +genclient
@@ -23,6 +105,10 @@ type AddonsStatus struct {
 // Addons is the Schema for the Addon API
 // +k8s:openapi-gen=true
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="CLUSTER",type="string",JSONPath=".spec.clusterName",description="The target cluster."
+// +kubebuilder:printcolumn:name="TYPE",type="string",JSONPath=".spec.type",description="The addon type."
+// +kubebuilder:printcolumn:name="VERSION",type="string",JSONPath=".status.version",description="The applied version."
+// +kubebuilder:printcolumn:name="HEALTHY",type="boolean",JSONPath=".status.healthy",description="The addon health."
 // +kubebuilder:printcolumn:name="PHASE",type="string",JSONPath=".status.phase",description="The Addons phase."
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp",description="CreationTimestamp is a timestamp representing the server time when this object was created. "
 type Addons struct {
@@ -9,6 +9,7 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	"github.com/wtxue/kok-operator/pkg/k8sclient"
+	"github.com/wtxue/kok-operator/pkg/observe"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/cluster"
@@ -93,6 +94,12 @@ func (c *Cluster) initK8SClients() error {
 }
 
 func (c *Cluster) healthCheck() bool {
+	healthy := c.doHealthCheck()
+	observe.SetClusterHealth(c.Name, healthy)
+	return healthy
+}
+
+func (c *Cluster) doHealthCheck() bool {
 	body, err := c.KubeCli.Discovery().RESTClient().Get().AbsPath("/healthz").Do(context.TODO()).Raw()
 	if err != nil {
 		runtime.HandleError(errors.Wrapf(err, "Failed to do cluster health check for cluster %q", c.Name))
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/wtxue/kok-operator/pkg/observe"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/cluster"
@@ -125,6 +126,7 @@ func (m *ClusterManager) Delete(name string) error {
 	}
 
 	m.clusters[index].Stop()
+	observe.DeleteClusterHealth(name)
 	clusters := m.clusters
 	clusters = append(clusters[:index], clusters[index+1:]...)
 	m.clusters = clusters
@@ -19,6 +19,7 @@ const (
 	KubeAPIServerPodManifestFile         = KubeletPodManifestDir + "kube-apiserver.yaml"
 	KubeControllerManagerPodManifestFile = KubeletPodManifestDir + "kube-controller-manager.yaml"
 	KubeSchedulerPodManifestFile         = KubeletPodManifestDir + "kube-scheduler.yaml"
+	KubeVipPodManifestFile               = KubeletPodManifestDir + "kube-vip.yaml"
 
 	DstTmpDir  = "/tmp/k8s/"
 	DstBinDir  = "/usr/local/bin/"
@@ -26,8 +27,15 @@ const (
 	CNIDataDir = "/var/lib/cni/"
 	CNIConfDIr = "/etc/cni"
 
+	// ContainerdSocket is the CRI socket of containerd
+	ContainerdSocket = "unix:///run/containerd/containerd.sock"
+	// CRIDockerdSocket is the CRI socket of cri-dockerd which shims docker engine
+	CRIDockerdSocket = "unix:///var/run/cri-dockerd.sock"
+
 	CertificatesDir = KubernetesDir + "pki/"
 	EtcdDataDir     = "/var/lib/etcd"
+	// EtcdBackupDir is the default directory of etcd snapshots on masters
+	EtcdBackupDir = "/var/lib/etcd-backup"
 
 	TokenFile = KubernetesDir + "known_tokens.csv"
 
@@ -71,11 +79,17 @@ const (
 	// LabelNodeRoleMaster specifies that a node is a control-plane
 	// This is a duplicate definition of the constant in pkg/controller/service/service_controller.go
 	LabelNodeRoleMaster = "node-role.kubernetes.io/master"
+	// LabelNodeNeedUpgrade marks the worker node to upgrade when the cluster upgrade mode is Manual
+	LabelNodeNeedUpgrade = "platform.k8s.io/need-upgrade"
 
 	DNSIPIndex = 10
 
 	// RenewCertsTimeThreshold control how long time left to renew certs
 	RenewCertsTimeThreshold = 30 * 24 * time.Hour
+	// CertLeafLifetime is the default validity of leaf certs when the certificates policy is set
+	CertLeafLifetime = 365 * 24 * time.Hour
+	// CertCheckSchedule is the default schedule of checking the expiry of certs
+	CertCheckSchedule = "0 3 * * *"
 
 	FlannelDirFile    = KubernetesDir + "flannel.yaml"
 	CustomDir         = "/opt/k8s/"
@@ -3,6 +3,7 @@ package constants
 const (
 	FinalizersCluster = "finalizers.fake.io/cluster"
 	FinalizersMachine = "finalizers.fake.io/machine"
+	FinalizersAddons  = "finalizers.fake.io/addons"
 )
 
 func ContainsString(slice []string, s string) bool {
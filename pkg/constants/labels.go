@@ -8,6 +8,7 @@ const (
 	ComponentNameCrd = "crds"
 	CreatedByLabel   = "fake.io/created-by"
 	CreatedBy        = "operator"
+	ClusterNameLabel = "fake.io/cluster-name"
 
 	KubeApiServer         = "kube-apiserver"
 	KubeKubeScheduler     = "kube-scheduler"
@@ -20,15 +21,44 @@ const (
 const (
 	ClusterUpdateStep    = "fake.io/update.step"
 	ClusterRestoreStep   = "fake.io/restore.step"
+	ClusterEtcdBackup    = "fake.io/etcd.backup"
+	ClusterEtcdRestore   = "fake.io/etcd.restore"
 	ClusterApiserverType = "fake.io/apiserver.type"
 	ClusterApiserverVip  = "fake.io/apiserver.vip"
 	ClusterDebugLocalDir = "fake.io/debug.localdir"
+	// ClusterOrphanHosts "true" on cluster or machine means deleting the cr without cleaning the hosts
+	ClusterOrphanHosts = "fake.io/orphan.hosts"
+	// ClusterResume on failed cluster or machine retries the failed create step with fresh attempts
+	ClusterResume = "fake.io/resume"
+	// ClusterRenewCerts on running cluster renews the leaf certs once
+	ClusterRenewCerts = "fake.io/certs.renew"
+	// ClusterRotateCA on running cluster rotates the CAs and re-issues the leaf certs
+	ClusterRotateCA = "fake.io/ca.rotate"
+	// ClusterPreflightOnly on initializing cluster runs the preflight checks of the hosts into status
+	// without creating the cluster, the hosts are not changed
+	ClusterPreflightOnly = "fake.io/preflight.only"
 )
 
 var CtrlLabels = map[string]string{
 	"createBy": "controller",
 }
 
+// ClusterLabels returns the labels of objects created by controller for the cluster
+func ClusterLabels(clusterName string) map[string]string {
+	labels := make(map[string]string, len(CtrlLabels)+1)
+	for k, v := range CtrlLabels {
+		labels[k] = v
+	}
+	labels[ClusterNameLabel] = clusterName
+
+	return labels
+}
+
+// IsOrphanHosts returns true when the hosts should not be cleaned on deletion
+func IsOrphanHosts(annotation map[string]string) bool {
+	return GetMapKey(annotation, ClusterOrphanHosts) == "true"
+}
+
 func GetMapKey(annotation map[string]string, key string) string {
 	if k, ok := annotation[key]; ok {
 		return k
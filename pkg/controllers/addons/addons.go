@@ -2,12 +2,20 @@ package addons
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
 	workloadv1 "github.com/wtxue/kok-operator/pkg/apis/workload/v1"
+	"github.com/wtxue/kok-operator/pkg/clustermanager"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/gmanager"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -17,6 +25,14 @@ import (
 
 const (
 	controllerName = "addons"
+
+	// requeue interval when the cluster is not ready or the addon failed
+	retryInterval = 30 * time.Second
+	// resync interval to refresh the health of running addons
+	healthInterval = 1 * time.Minute
+
+	reasonClusterNotReady = "ClusterNotReady"
+	reasonFailedApply     = "FailedApply"
 )
 
 // addonsReconciler reconciles a addons object
@@ -25,6 +41,7 @@ type addonsReconciler struct {
 	Log    logr.Logger
 	Mgr    manager.Manager
 	Scheme *runtime.Scheme
+	*gmanager.GManager
 }
 
 type addonsContext struct {
@@ -32,14 +49,20 @@ type addonsContext struct {
 	Req    reconcile.Request
 	Addons *workloadv1.Addons
 	logr.Logger
+
+	// Cluster is the target cluster context, and nil when the cluster is not found
+	Cluster *common.ClusterContext
+	// Remote is the client cache of the target cluster
+	Remote *clustermanager.Cluster
 }
 
 func Add(mgr manager.Manager, pMgr *gmanager.GManager) error {
 	r := &addonsReconciler{
-		Client: mgr.GetClient(),
-		Mgr:    mgr,
-		Log:    logf.Log.WithName(controllerName),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Mgr:      mgr,
+		Log:      logf.Log.WithName(controllerName),
+		Scheme:   mgr.GetScheme(),
+		GManager: pMgr,
 	}
 
 	return ctrl.NewControllerManagedBy(mgr).
@@ -47,6 +70,9 @@ func Add(mgr manager.Manager, pMgr *gmanager.GManager) error {
 		Complete(r)
 }
 
+// +kubebuilder:rbac:groups=workload.fake.io,resources=addons,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=workload.fake.io,resources=addons/status,verbs=get;update;patch
+
 func (r *addonsReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	logger := r.Log.WithValues(controllerName, req.NamespacedName.String())
 
@@ -54,7 +80,7 @@ func (r *addonsReconciler) Reconcile(ctx context.Context, req reconcile.Request)
 	err := r.Client.Get(ctx, req.NamespacedName, addons)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			logger.Error(err, "not find addons")
+			logger.Info("not find addons")
 			return reconcile.Result{}, nil
 		}
 
@@ -62,10 +88,185 @@ func (r *addonsReconciler) Reconcile(ctx context.Context, req reconcile.Request)
 		return reconcile.Result{}, err
 	}
 
-	return r.reconcile(&addonsContext{Ctx: ctx, Req: req, Addons: addons, Logger: logger})
+	logger = logger.WithValues("cluster", addons.Spec.ClusterName, "type", addons.Spec.Type)
+	actx := &addonsContext{Ctx: ctx, Req: req, Addons: addons, Logger: logger}
+	if !addons.ObjectMeta.DeletionTimestamp.IsZero() {
+		return r.cleanAddons(actx)
+	}
+
+	if !constants.ContainsString(addons.ObjectMeta.Finalizers, constants.FinalizersAddons) {
+		logger.Info("set", "finalizers", constants.FinalizersAddons)
+		addons.ObjectMeta.Finalizers = append(addons.ObjectMeta.Finalizers, constants.FinalizersAddons)
+		err := r.Client.Update(ctx, addons)
+		if err != nil {
+			logger.Error(err, "failed to set finalizers")
+			return reconcile.Result{}, err
+		}
+
+		return reconcile.Result{}, nil
+	}
+
+	if addons.Spec.Pause {
+		logger.Info("addons is Pause")
+		return reconcile.Result{}, nil
+	}
+
+	err = r.fillClusterContext(actx)
+	if err != nil {
+		logger.Info("target cluster is not ready", "err", err.Error())
+		if addons.Status.Phase == "" || addons.Status.Message != err.Error() {
+			if addons.Status.Phase == "" {
+				addons.Status.Phase = workloadv1.AddonPending
+			}
+			addons.Status.Reason = reasonClusterNotReady
+			addons.Status.Message = err.Error()
+			if err := r.Client.Status().Update(ctx, addons); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		return reconcile.Result{RequeueAfter: retryInterval}, nil
+	}
+
+	return r.reconcile(actx)
+}
+
+// fillClusterContext get the target cluster, which must be running and has been added to the cluster manager
+func (r *addonsReconciler) fillClusterContext(ctx *addonsContext) error {
+	key := types.NamespacedName{Name: ctx.Addons.Spec.ClusterName, Namespace: ctx.Addons.Namespace}
+	cluster := &devopsv1.Cluster{}
+	err := r.Client.Get(ctx.Ctx, key, cluster)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("cluster %s not found", key.Name)
+		}
+		return err
+	}
+	cluster.SetSSHOptions(common.SecretGetter(ctx.Ctx, r.Client, cluster.Namespace))
+
+	credential := &devopsv1.ClusterCredential{}
+	err = r.Client.Get(ctx.Ctx, key, credential)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("cluster credential %s not found", key.Name)
+		}
+		return err
+	}
+
+	ctx.Cluster = &common.ClusterContext{
+		Ctx:            ctx.Ctx,
+		Key:            key,
+		Cluster:        cluster,
+		Credential:     credential,
+		Client:         r.Client,
+		ClusterManager: r.ClusterManager,
+		Logger:         ctx.Logger,
+	}
+
+	if cluster.Status.Phase != devopsv1.ClusterRunning && cluster.Status.Phase != devopsv1.ClusterUpgrading {
+		return fmt.Errorf("cluster %s is %s", key.Name, cluster.Status.Phase)
+	}
+
+	ctx.Remote, err = r.ClusterManager.Get(cluster.Name)
+	if err != nil {
+		return err
+	}
+
+	return nil
 }
 
 func (r *addonsReconciler) reconcile(ctx *addonsContext) (reconcile.Result, error) {
+	addons := ctx.Addons
+	switch addons.Status.Phase {
+	case "", workloadv1.AddonPending:
+		addons.Status.Phase = workloadv1.AddonInstalling
+		addons.Status.Reason = ""
+		addons.Status.Message = ""
+		return reconcile.Result{}, r.Client.Status().Update(ctx.Ctx, addons)
+	case workloadv1.AddonRunning:
+		if addons.Status.ObservedGeneration != addons.Generation {
+			ctx.Info("spec changed, start upgrade", "generation", addons.Generation)
+			addons.Status.Phase = workloadv1.AddonUpgrading
+			return reconcile.Result{}, r.Client.Status().Update(ctx.Ctx, addons)
+		}
+
+		healthy := r.checkHealth(ctx)
+		if healthy != addons.Status.Healthy {
+			ctx.Info("health changed", "healthy", healthy)
+			addons.Status.Healthy = healthy
+			if err := r.Client.Status().Update(ctx.Ctx, addons); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		return reconcile.Result{RequeueAfter: healthInterval}, nil
+	}
+
+	// Installing, Upgrading, Failed
+	version, err := r.apply(ctx)
+	if err != nil {
+		ctx.Error(err, "failed to apply addon", "phase", addons.Status.Phase)
+		addons.Status.Phase = workloadv1.AddonFailed
+		addons.Status.Reason = reasonFailedApply
+		addons.Status.Message = err.Error()
+		addons.Status.Healthy = false
+		if err := r.Client.Status().Update(ctx.Ctx, addons); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{RequeueAfter: retryInterval}, nil
+	}
+
+	ctx.Info("apply addon successfully", "version", version)
+	addons.Status.Phase = workloadv1.AddonRunning
+	addons.Status.Version = version
+	addons.Status.ObservedGeneration = addons.Generation
+	addons.Status.Reason = ""
+	addons.Status.Message = ""
+	addons.Status.LastUpdateTime = metav1.Now()
+	addons.Status.Healthy = r.checkHealth(ctx)
+	if err := r.Client.Status().Update(ctx.Ctx, addons); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{RequeueAfter: healthInterval}, nil
+}
+
+func (r *addonsReconciler) cleanAddons(ctx *addonsContext) (reconcile.Result, error) {
+	addons := ctx.Addons
+	if !constants.ContainsString(addons.ObjectMeta.Finalizers, constants.FinalizersAddons) {
+		return reconcile.Result{}, nil
+	}
+
+	if addons.Status.Phase != workloadv1.AddonTerminating {
+		addons.Status.Phase = workloadv1.AddonTerminating
+		return reconcile.Result{}, r.Client.Status().Update(ctx.Ctx, addons)
+	}
+
+	err := r.fillClusterContext(ctx)
+	if addons.Status.ObservedGeneration == 0 {
+		ctx.Info("addon never applied, skip uninstall")
+	} else if err == nil {
+		err = r.uninstall(ctx)
+		if err != nil {
+			ctx.Error(err, "failed to uninstall addon")
+			addons.Status.Reason = reasonFailedApply
+			addons.Status.Message = err.Error()
+			r.Client.Status().Update(ctx.Ctx, addons)
+			return reconcile.Result{RequeueAfter: retryInterval}, nil
+		}
+	} else if ctx.Cluster != nil && ctx.Cluster.Cluster.DeletionTimestamp.IsZero() {
+		// the cluster exists but not ready, wait for it to uninstall
+		ctx.Info("wait cluster ready to uninstall", "err", err.Error())
+		return reconcile.Result{RequeueAfter: retryInterval}, nil
+	} else {
+		ctx.Info("cluster is gone, skip uninstall", "err", err.Error())
+	}
+
+	addons.ObjectMeta.Finalizers = constants.RemoveString(addons.ObjectMeta.Finalizers, constants.FinalizersAddons)
+	err = r.Client.Update(ctx.Ctx, addons)
+	if err != nil {
+		ctx.Error(err, "failed to remove finalizers")
+		return reconcile.Result{}, err
+	}
 
+	ctx.Info("uninstall addon successfully")
 	return reconcile.Result{}, nil
 }
@@ -0,0 +1,449 @@
+package addons
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/wtxue/kok-operator/pkg/addons/coredns"
+	"github.com/wtxue/kok-operator/pkg/addons/flannel"
+	"github.com/wtxue/kok-operator/pkg/addons/kubeproxy"
+	"github.com/wtxue/kok-operator/pkg/addons/kubevip"
+	"github.com/wtxue/kok-operator/pkg/addons/metricsserver"
+	workloadv1 "github.com/wtxue/kok-operator/pkg/apis/workload/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	helmv3 "github.com/wtxue/kok-operator/pkg/helm/v3"
+	"github.com/wtxue/kok-operator/pkg/k8sutil"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultAddonNamespace = "kube-system"
+)
+
+// builtinBuilders build the objects of builtin addons which are applied by k8sutil.Reconcile
+var builtinBuilders = map[workloadv1.AddonType]func(cfg *config.Config, ctx *common.ClusterContext) ([]client.Object, error){
+	workloadv1.AddonCoreDNS:       coredns.BuildCoreDNSAddon,
+	workloadv1.AddonKubeProxy:     buildKubeproxyAddon,
+	workloadv1.AddonFlannel:       flannel.BuildFlannelAddon,
+	workloadv1.AddonMetricsServer: metricsserver.BuildMetricsServerAddon,
+}
+
+// defaultCharts are the charts of addons installed by helm without spec.chart
+var defaultCharts = map[workloadv1.AddonType]*workloadv1.HelmChart{
+	workloadv1.AddonMetalLB: {
+		RepoName:  "metallb",
+		RepoURL:   "https://metallb.github.io/metallb",
+		Name:      "metallb",
+		Namespace: "metallb-system",
+	},
+}
+
+// builtinValues are the values supported by builtin addons
+type builtinValues struct {
+	Image    string `json:"image,omitempty"`
+	Replicas *int32 `json:"replicas,omitempty"`
+}
+
+func buildKubeproxyAddon(cfg *config.Config, ctx *common.ClusterContext) ([]client.Object, error) {
+	if len(ctx.Cluster.Spec.PublicAlternativeNames) == 0 {
+		return nil, fmt.Errorf("kubeproxy addon requires spec.publicAlternativeNames of cluster")
+	}
+
+	return kubeproxy.BuildKubeproxyAddon(cfg, ctx)
+}
+
+// apply install or upgrade the addon, returns the applied version
+func (r *addonsReconciler) apply(ctx *addonsContext) (string, error) {
+	addonType := ctx.Addons.Spec.Type
+	if _, ok := builtinBuilders[addonType]; ok {
+		objs, err := r.buildObjects(ctx)
+		if err != nil {
+			return "", err
+		}
+
+		for _, obj := range objs {
+			err = k8sutil.Reconcile(ctx.Logger, ctx.Remote.GetClient(), obj, k8sutil.DesiredStatePresent)
+			if err != nil {
+				return "", errors.Wrapf(err, "reconcile %s", addonType)
+			}
+		}
+
+		return workloadVersion(objs), nil
+	}
+
+	switch addonType {
+	case workloadv1.AddonKubeVip:
+		return r.applyKubeVip(ctx)
+	case workloadv1.AddonMetalLB:
+		// the namespace metallb-system is managed by the cluster when the feature is enabled
+		if ctx.Cluster.Cluster.Spec.Features.LoadBalancerEnabled() {
+			return "", fmt.Errorf("metallb addon conflicts with publicLB and internalLB of cluster %s, remove one of them", ctx.Cluster.Cluster.Name)
+		}
+		fallthrough
+	case workloadv1.AddonHelm:
+		rls, err := r.applyChart(ctx)
+		if err != nil {
+			return "", err
+		}
+		return rls.Version, nil
+	default:
+		return "", fmt.Errorf("unknown addon type: %s", addonType)
+	}
+}
+
+// uninstall remove all resources of the addon from the cluster
+func (r *addonsReconciler) uninstall(ctx *addonsContext) error {
+	addonType := ctx.Addons.Spec.Type
+	if _, ok := builtinBuilders[addonType]; ok {
+		objs, err := r.buildObjects(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range objs {
+			err = k8sutil.Reconcile(ctx.Logger, ctx.Remote.GetClient(), obj, k8sutil.DesiredStateAbsent)
+			if err != nil {
+				return errors.Wrapf(err, "remove %s", addonType)
+			}
+		}
+
+		return nil
+	}
+
+	switch addonType {
+	case workloadv1.AddonKubeVip:
+		return r.uninstallKubeVip(ctx)
+	case workloadv1.AddonHelm, workloadv1.AddonMetalLB:
+		return r.uninstallChart(ctx)
+	default:
+		return fmt.Errorf("unknown addon type: %s", addonType)
+	}
+}
+
+// checkHealth returns true when all workloads of the addon are ready
+func (r *addonsReconciler) checkHealth(ctx *addonsContext) bool {
+	switch ctx.Addons.Spec.Type {
+	case workloadv1.AddonKubeVip:
+		return r.checkKubeVipHealth(ctx)
+	case workloadv1.AddonHelm, workloadv1.AddonMetalLB:
+		return r.checkChartHealth(ctx)
+	}
+
+	objs, err := r.buildObjects(ctx)
+	if err != nil {
+		ctx.Error(err, "failed to build addon")
+		return false
+	}
+
+	cli := ctx.Remote.GetClient()
+	for _, obj := range objs {
+		key := client.ObjectKeyFromObject(obj)
+		switch obj.(type) {
+		case *appsv1.Deployment:
+			deploy := &appsv1.Deployment{}
+			if err := cli.Get(ctx.Ctx, key, deploy); err != nil {
+				ctx.Info("failed to get deployment", "key", key, "err", err.Error())
+				return false
+			}
+			if deploy.Spec.Replicas != nil && deploy.Status.ReadyReplicas < *deploy.Spec.Replicas {
+				return false
+			}
+		case *appsv1.DaemonSet:
+			ds := &appsv1.DaemonSet{}
+			if err := cli.Get(ctx.Ctx, key, ds); err != nil {
+				ctx.Info("failed to get daemonset", "key", key, "err", err.Error())
+				return false
+			}
+			if ds.Status.NumberReady < ds.Status.DesiredNumberScheduled {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// buildObjects build the objects of builtin addon with the spec version and values
+func (r *addonsReconciler) buildObjects(ctx *addonsContext) ([]client.Object, error) {
+	build := builtinBuilders[ctx.Addons.Spec.Type]
+	objs, err := build(r.GManager.Config, ctx.Cluster)
+	if err != nil {
+		return nil, errors.Wrapf(err, "build %s", ctx.Addons.Spec.Type)
+	}
+
+	values := &builtinValues{}
+	if ctx.Addons.Spec.Values != "" {
+		err = yaml.Unmarshal([]byte(ctx.Addons.Spec.Values), values)
+		if err != nil {
+			return nil, errors.Wrap(err, "unmarshal values")
+		}
+	}
+
+	for _, obj := range objs {
+		var podSpec *corev1.PodSpec
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			podSpec = &o.Spec.Template.Spec
+			if values.Replicas != nil {
+				o.Spec.Replicas = values.Replicas
+			}
+		case *appsv1.DaemonSet:
+			podSpec = &o.Spec.Template.Spec
+		default:
+			continue
+		}
+
+		if len(podSpec.Containers) == 0 {
+			continue
+		}
+		if values.Image != "" {
+			podSpec.Containers[0].Image = values.Image
+		} else if ctx.Addons.Spec.Version != "" {
+			podSpec.Containers[0].Image = replaceImageTag(podSpec.Containers[0].Image, ctx.Addons.Spec.Version)
+		}
+	}
+
+	return objs, nil
+}
+
+// workloadVersion returns the image tag of the first workload
+func workloadVersion(objs []client.Object) string {
+	for _, obj := range objs {
+		var podSpec *corev1.PodSpec
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			podSpec = &o.Spec.Template.Spec
+		case *appsv1.DaemonSet:
+			podSpec = &o.Spec.Template.Spec
+		default:
+			continue
+		}
+
+		if len(podSpec.Containers) > 0 {
+			return imageTag(podSpec.Containers[0].Image)
+		}
+	}
+
+	return ""
+}
+
+func splitImage(image string) (string, string) {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return image, ""
+	}
+
+	return image[:i], image[i+1:]
+}
+
+func imageTag(image string) string {
+	_, tag := splitImage(image)
+	return tag
+}
+
+func replaceImageTag(image, tag string) string {
+	name, _ := splitImage(image)
+	return name + ":" + tag
+}
+
+func (r *addonsReconciler) applyKubeVip(ctx *addonsContext) (string, error) {
+	if len(ctx.Cluster.Cluster.Spec.Machines) == 0 {
+		return "", fmt.Errorf("kubevip addon requires master machines of cluster")
+	}
+
+	manifest := kubevip.BuildKubeVipStaticPod(ctx.Cluster)["kube-vip.yaml"]
+	if manifest == "" {
+		return "", fmt.Errorf("failed to build kube-vip static pod")
+	}
+
+	pod := &corev1.Pod{}
+	err := yaml.Unmarshal([]byte(manifest), pod)
+	if err != nil {
+		return "", errors.Wrap(err, "unmarshal kube-vip static pod")
+	}
+
+	values := &builtinValues{}
+	if ctx.Addons.Spec.Values != "" {
+		err = yaml.Unmarshal([]byte(ctx.Addons.Spec.Values), values)
+		if err != nil {
+			return "", errors.Wrap(err, "unmarshal values")
+		}
+	}
+
+	if values.Image != "" {
+		pod.Spec.Containers[0].Image = values.Image
+	} else if ctx.Addons.Spec.Version != "" {
+		pod.Spec.Containers[0].Image = replaceImageTag(pod.Spec.Containers[0].Image, ctx.Addons.Spec.Version)
+	}
+
+	data, err := yaml.Marshal(pod)
+	if err != nil {
+		return "", err
+	}
+
+	for _, machine := range ctx.Cluster.Cluster.Spec.Machines {
+		sh, err := machine.SSH()
+		if err != nil {
+			return "", err
+		}
+
+		ctx.Info("write kube-vip static pod", "node", machine.IP)
+		err = sh.WriteFile(bytes.NewReader(data), constants.KubeVipPodManifestFile)
+		if err != nil {
+			return "", errors.Wrapf(err, "node: %s write kube-vip static pod", machine.IP)
+		}
+	}
+
+	return imageTag(pod.Spec.Containers[0].Image), nil
+}
+
+func (r *addonsReconciler) uninstallKubeVip(ctx *addonsContext) error {
+	for _, machine := range ctx.Cluster.Cluster.Spec.Machines {
+		sh, err := machine.SSH()
+		if err != nil {
+			return err
+		}
+
+		_, err = sh.CombinedOutput(fmt.Sprintf("rm -f %s", constants.KubeVipPodManifestFile))
+		if err != nil {
+			return errors.Wrapf(err, "node: %s remove kube-vip static pod", machine.IP)
+		}
+	}
+
+	return nil
+}
+
+func (r *addonsReconciler) checkKubeVipHealth(ctx *addonsContext) bool {
+	for _, machine := range ctx.Cluster.Cluster.Spec.Machines {
+		// mirror pod of static pod is named with the node name suffix
+		key := types.NamespacedName{Namespace: defaultAddonNamespace, Name: fmt.Sprintf("kube-vip-%s", machine.IP)}
+		pod := &corev1.Pod{}
+		if err := ctx.Remote.GetClient().Get(ctx.Ctx, key, pod); err != nil {
+			ctx.Info("failed to get pod", "key", key, "err", err.Error())
+			return false
+		}
+
+		if pod.Status.Phase != corev1.PodRunning {
+			return false
+		}
+	}
+
+	return true
+}
+
+// chart returns the chart of helm addon, the release name defaults to the name of Addons
+func chart(addons *workloadv1.Addons) (*workloadv1.HelmChart, error) {
+	c := addons.Spec.Chart
+	if c == nil {
+		c = defaultCharts[addons.Spec.Type]
+	}
+	if c == nil || c.RepoName == "" || c.Name == "" {
+		return nil, fmt.Errorf("spec.chart with repoName and name is required by %s addon", addons.Spec.Type)
+	}
+
+	c = c.DeepCopy()
+	if c.ReleaseName == "" {
+		c.ReleaseName = addons.Name
+	}
+	if c.Namespace == "" {
+		c.Namespace = defaultAddonNamespace
+	}
+
+	return c, nil
+}
+
+// getHelmEnv returns the helm env of the target cluster and the chart reference, the repo of chart
+// owned by the target cluster is added if it's missing
+func (r *addonsReconciler) getHelmEnv(ctx *addonsContext, c *workloadv1.HelmChart) (*helmv3.HelmEnv, string, error) {
+	repoName, err := helmv3.EnsureClusterRepo(r.HelmEnv.Cli, ctx.Addons.Namespace, ctx.Addons.Spec.ClusterName, &repo.Entry{Name: c.RepoName, URL: c.RepoURL})
+	if err != nil {
+		return nil, "", err
+	}
+
+	env, err := helmv3.NewHelmEnv(r.HelmEnv, ctx.Remote.RawKubeconfig, c.Namespace, ctx.Remote.KubeCli)
+	if err != nil {
+		return nil, "", err
+	}
+	return env, path.Join(repoName, c.Name), nil
+}
+
+func (r *addonsReconciler) applyChart(ctx *addonsContext) (*helmv3.Release, error) {
+	c, err := chart(ctx.Addons)
+	if err != nil {
+		return nil, err
+	}
+
+	env, chartRef, err := r.getHelmEnv(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.Info("apply helm release", "release", c.ReleaseName, "chart", chartRef, "version", ctx.Addons.Spec.Version)
+	rls, err := helmv3.ApplyRelease(env, c.ReleaseName, chartRef, ctx.Addons.Spec.Version, nil,
+		c.Namespace, []byte(ctx.Addons.Spec.Values), nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "apply release %s", c.ReleaseName)
+	}
+	if rls == nil {
+		return nil, fmt.Errorf("apply release %s return empty", c.ReleaseName)
+	}
+
+	return rls, nil
+}
+
+func (r *addonsReconciler) uninstallChart(ctx *addonsContext) error {
+	c, err := chart(ctx.Addons)
+	if err != nil {
+		return err
+	}
+
+	env, _, err := r.getHelmEnv(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	err = helmv3.UninstallReleases(env, c.ReleaseName, &helmv3.Options{Namespace: c.Namespace})
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		return errors.Wrapf(err, "uninstall release %s", c.ReleaseName)
+	}
+
+	return nil
+}
+
+func (r *addonsReconciler) checkChartHealth(ctx *addonsContext) bool {
+	c, err := chart(ctx.Addons)
+	if err != nil {
+		return false
+	}
+
+	env, _, err := r.getHelmEnv(ctx, c)
+	if err != nil {
+		ctx.Error(err, "failed to get helm env")
+		return false
+	}
+
+	rlsList, err := helmv3.ListReleases(ctx.Ctx, env, &helmv3.Options{Namespace: c.Namespace, Filter: &c.ReleaseName})
+	if err != nil {
+		ctx.Error(err, "failed to list releases")
+		return false
+	}
+
+	for _, rls := range rlsList {
+		if rls.ReleaseName == c.ReleaseName && rls.ReleaseInfo != nil {
+			return rls.ReleaseInfo.Status == string(release.StatusDeployed)
+		}
+	}
+
+	return false
+}
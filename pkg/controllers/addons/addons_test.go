@@ -0,0 +1,237 @@
+package addons
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	workloadv1 "github.com/wtxue/kok-operator/pkg/apis/workload/v1"
+	"github.com/wtxue/kok-operator/pkg/clustermanager"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/gmanager"
+	"github.com/wtxue/kok-operator/pkg/k8sclient"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	"github.com/wtxue/kok-operator/pkg/util/pointer"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// fakeRemote is the target cluster served by the fake client.
+type fakeRemote struct {
+	cluster.Cluster
+	cli client.Client
+}
+
+func (f *fakeRemote) GetClient() client.Client {
+	return f.cli
+}
+
+var addonKey = types.NamespacedName{Namespace: "ns1", Name: "a1"}
+
+func newTestReconciler(t *testing.T, c *devopsv1.Cluster, addon *workloadv1.Addons) (*addonsReconciler, client.Client) {
+	t.Helper()
+
+	cli := fake.NewClientBuilder().WithScheme(k8sclient.GetScheme()).WithObjects(
+		c,
+		&devopsv1.ClusterCredential{ObjectMeta: metav1.ObjectMeta{Name: c.Name, Namespace: c.Namespace}},
+		addon,
+	).Build()
+
+	remote := fake.NewClientBuilder().WithScheme(k8sclient.GetScheme()).Build()
+	cm := &clustermanager.ClusterManager{}
+	if err := cm.Add(&clustermanager.Cluster{Name: c.Name, Cluster: &fakeRemote{cli: remote}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return &addonsReconciler{
+		Client:   cli,
+		Log:      logr.Discard(),
+		GManager: &gmanager.GManager{Config: config.NewDefaultConfig(), ClusterManager: cm},
+	}, remote
+}
+
+func testCluster(phase devopsv1.ClusterPhase) *devopsv1.Cluster {
+	return &devopsv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"},
+		Status:     devopsv1.ClusterStatus{Phase: phase},
+	}
+}
+
+func testAddon(addonType workloadv1.AddonType, phase workloadv1.AddonPhase, generation, observed int64) *workloadv1.Addons {
+	return &workloadv1.Addons{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       addonKey.Name,
+			Namespace:  addonKey.Namespace,
+			Generation: generation,
+			Finalizers: []string{constants.FinalizersAddons},
+		},
+		Spec:   workloadv1.AddonsSpec{ClusterName: "c1", Type: addonType},
+		Status: workloadv1.AddonsStatus{Phase: phase, ObservedGeneration: observed},
+	}
+}
+
+func TestReconcilePhases(t *testing.T) {
+	lbCluster := testCluster(devopsv1.ClusterRunning)
+	lbCluster.Spec.Features.PublicLB = pointer.ToBool(true)
+
+	tests := []struct {
+		name     string
+		cluster  *devopsv1.Cluster
+		addon    *workloadv1.Addons
+		phase    workloadv1.AddonPhase
+		reason   string
+		observed int64
+		requeue  bool
+		message  string
+	}{
+		{
+			name:    "new addon starts installing",
+			cluster: testCluster(devopsv1.ClusterRunning),
+			addon:   testAddon(workloadv1.AddonMetricsServer, "", 1, 0),
+			phase:   workloadv1.AddonInstalling,
+		},
+		{
+			name:    "cluster not ready",
+			cluster: testCluster(devopsv1.ClusterInitializing),
+			addon:   testAddon(workloadv1.AddonMetricsServer, "", 1, 0),
+			phase:   workloadv1.AddonPending,
+			reason:  reasonClusterNotReady,
+			requeue: true,
+		},
+		{
+			name:    "pending addon starts installing when cluster ready",
+			cluster: testCluster(devopsv1.ClusterRunning),
+			addon:   testAddon(workloadv1.AddonMetricsServer, workloadv1.AddonPending, 1, 0),
+			phase:   workloadv1.AddonInstalling,
+		},
+		{
+			name:     "installed",
+			cluster:  testCluster(devopsv1.ClusterRunning),
+			addon:    testAddon(workloadv1.AddonMetricsServer, workloadv1.AddonInstalling, 1, 0),
+			phase:    workloadv1.AddonRunning,
+			observed: 1,
+			requeue:  true,
+		},
+		{
+			name:    "install failed",
+			cluster: testCluster(devopsv1.ClusterRunning),
+			addon:   testAddon(workloadv1.AddonKubeProxy, workloadv1.AddonInstalling, 1, 0),
+			phase:   workloadv1.AddonFailed,
+			reason:  reasonFailedApply,
+			requeue: true,
+		},
+		{
+			name:     "failed addon retried",
+			cluster:  testCluster(devopsv1.ClusterRunning),
+			addon:    testAddon(workloadv1.AddonMetricsServer, workloadv1.AddonFailed, 2, 1),
+			phase:    workloadv1.AddonRunning,
+			observed: 2,
+			requeue:  true,
+		},
+		{
+			name:     "spec changed starts upgrading",
+			cluster:  testCluster(devopsv1.ClusterRunning),
+			addon:    testAddon(workloadv1.AddonMetricsServer, workloadv1.AddonRunning, 2, 1),
+			phase:    workloadv1.AddonUpgrading,
+			observed: 1,
+		},
+		{
+			name:     "upgraded",
+			cluster:  testCluster(devopsv1.ClusterRunning),
+			addon:    testAddon(workloadv1.AddonMetricsServer, workloadv1.AddonUpgrading, 2, 1),
+			phase:    workloadv1.AddonRunning,
+			observed: 2,
+			requeue:  true,
+		},
+		{
+			name:     "running addon checks health",
+			cluster:  testCluster(devopsv1.ClusterRunning),
+			addon:    testAddon(workloadv1.AddonMetricsServer, workloadv1.AddonRunning, 1, 1),
+			phase:    workloadv1.AddonRunning,
+			observed: 1,
+			requeue:  true,
+		},
+		{
+			name:    "metallb conflicts with the load balancer of cluster",
+			cluster: lbCluster,
+			addon:   testAddon(workloadv1.AddonMetalLB, workloadv1.AddonInstalling, 1, 0),
+			phase:   workloadv1.AddonFailed,
+			reason:  reasonFailedApply,
+			requeue: true,
+			message: "conflicts with publicLB and internalLB",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestReconciler(t, tt.cluster, tt.addon)
+			result, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: addonKey})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			addon := &workloadv1.Addons{}
+			if err := r.Client.Get(context.Background(), addonKey, addon); err != nil {
+				t.Fatal(err)
+			}
+			if addon.Status.Phase != tt.phase || addon.Status.Reason != tt.reason || addon.Status.ObservedGeneration != tt.observed {
+				t.Errorf("expect %s/%q observed %d, got %s/%q observed %d: %s", tt.phase, tt.reason, tt.observed,
+					addon.Status.Phase, addon.Status.Reason, addon.Status.ObservedGeneration, addon.Status.Message)
+			}
+			if !strings.Contains(addon.Status.Message, tt.message) {
+				t.Errorf("expect message %q, got %q", tt.message, addon.Status.Message)
+			}
+			if (result.RequeueAfter > 0) != tt.requeue {
+				t.Errorf("expect requeue %v, got %v", tt.requeue, result.RequeueAfter)
+			}
+		})
+	}
+}
+
+func TestReconcileUninstall(t *testing.T) {
+	r, remote := newTestReconciler(t, testCluster(devopsv1.ClusterRunning),
+		testAddon(workloadv1.AddonMetricsServer, workloadv1.AddonInstalling, 1, 0))
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: addonKey}
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+
+	deployKey := types.NamespacedName{Namespace: defaultAddonNamespace, Name: "metrics-server"}
+	if err := remote.Get(ctx, deployKey, &appsv1.Deployment{}); err != nil {
+		t.Fatalf("expect metrics-server installed: %v", err)
+	}
+
+	addon := &workloadv1.Addons{}
+	if err := r.Client.Get(ctx, addonKey, addon); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Delete(ctx, addon); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Get(ctx, addonKey, addon); err != nil || addon.Status.Phase != workloadv1.AddonTerminating {
+		t.Fatalf("expect terminating, got %s: %v", addon.Status.Phase, err)
+	}
+
+	if _, err := r.Reconcile(ctx, req); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Client.Get(ctx, addonKey, addon); !apierrors.IsNotFound(err) {
+		t.Errorf("expect the addon removed after uninstall, got %v", err)
+	}
+	if err := remote.Get(ctx, deployKey, &appsv1.Deployment{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expect metrics-server uninstalled, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/etcd"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// requeue interval when etcd backup failed
+	backupRetryInterval = 5 * time.Minute
+
+	reasonFailedBackup  = "FailedBackup"
+	reasonFailedRestore = "FailedRestore"
+)
+
+// onBackup takes an etcd snapshot when the schedule is due or the backup annotation is set,
+// it returns the duration to the next scheduled backup, zero means no schedule.
+func (r *clusterReconciler) onBackup(ctx *common.ClusterContext) time.Duration {
+	backup := etcd.GetBackup(ctx.Cluster)
+	if backup == nil {
+		return 0
+	}
+
+	var schedule cron.Schedule
+	if backup.Schedule != "" {
+		var err error
+		schedule, err = cron.ParseStandard(backup.Schedule)
+		if err != nil {
+			ctx.Error(err, "invalid etcd backup schedule", "schedule", backup.Schedule)
+			ctx.Cluster.Status.Message = err.Error()
+			ctx.Cluster.Status.Reason = reasonFailedBackup
+			return 0
+		}
+	}
+
+	onDemand := constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterEtcdBackup)
+	now := time.Now()
+	next := time.Time{}
+	if schedule != nil {
+		next = schedule.Next(lastBackupTime(ctx.Cluster))
+	}
+
+	if onDemand == "" && (next.IsZero() || next.After(now)) {
+		return untilNext(next, now)
+	}
+
+	ctx.Info("start etcd backup", "onDemand", onDemand, "schedule", backup.Schedule)
+	record, err := etcd.Backup(ctx, backup)
+	if err != nil {
+		ctx.Error(err, "failed to backup etcd")
+		ctx.Cluster.Status.Message = err.Error()
+		ctx.Cluster.Status.Reason = reasonFailedBackup
+		return backupRetryInterval
+	}
+
+	records := append(ctx.Cluster.Status.EtcdBackups, *record)
+	ctx.Cluster.Status.EtcdBackups = etcd.Prune(ctx, backup, records)
+
+	if onDemand != "" {
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			objBak := &devopsv1.Cluster{}
+			if err := r.Client.Get(ctx.Ctx, ctx.Key, objBak); err != nil {
+				return err
+			}
+			delete(objBak.Annotations, constants.ClusterEtcdBackup)
+			return r.Client.Update(ctx.Ctx, objBak)
+		})
+		if err != nil {
+			ctx.Error(err, "failed to remove annotation", "key", constants.ClusterEtcdBackup)
+		}
+	}
+
+	if schedule == nil {
+		return 0
+	}
+	return untilNext(schedule.Next(record.Time.Time), now)
+}
+
+// lastBackupTime returns the time of the latest snapshot, or the creation time of the cluster.
+func lastBackupTime(c *devopsv1.Cluster) time.Time {
+	if len(c.Status.EtcdBackups) == 0 {
+		return c.CreationTimestamp.Time
+	}
+
+	return c.Status.EtcdBackups[len(c.Status.EtcdBackups)-1].Time.Time
+}
+
+func untilNext(next, now time.Time) time.Duration {
+	if next.IsZero() {
+		return 0
+	}
+
+	if d := next.Sub(now); d > time.Second {
+		return d
+	}
+	return time.Second
+}
+
+// onRestore restores etcd from the snapshot of the restore annotation, and returns to running when
+// it's done or the annotation is removed and the apiserver is healthy. It returns the requeue duration
+// when it failed.
+func (r *clusterReconciler) onRestore(ctx *common.ClusterContext) time.Duration {
+	ref := constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterEtcdRestore)
+	if ref == "" {
+		// the masters left by a failed restore are rolled back before running
+		if etcd.GetBackup(ctx.Cluster) != nil {
+			err := etcd.AbortRestore(ctx)
+			if err != nil {
+				ctx.Error(err, "failed to abort etcd restore")
+				ctx.Cluster.Status.Message = err.Error()
+				ctx.Cluster.Status.Reason = reasonFailedRestore
+				return backupRetryInterval
+			}
+		}
+
+		ctx.Info("etcd restore annotation removed, back to running")
+		ctx.Cluster.Status.Phase = devopsv1.ClusterRunning
+		ctx.Cluster.Status.Message = ""
+		ctx.Cluster.Status.Reason = ""
+		return 0
+	}
+
+	backup := etcd.GetBackup(ctx.Cluster)
+	if backup == nil {
+		ctx.Info("cluster has no local etcd, ignoring restore", "snapshot", ref)
+		ctx.Cluster.Status.Message = "cluster has no local etcd to restore"
+		ctx.Cluster.Status.Reason = reasonFailedRestore
+		return 0
+	}
+
+	err := etcd.Restore(ctx, backup, ref)
+	if err != nil {
+		ctx.Error(err, "failed to restore etcd", "snapshot", ref)
+		ctx.Cluster.Status.Message = err.Error()
+		ctx.Cluster.Status.Reason = reasonFailedRestore
+		return backupRetryInterval
+	}
+
+	// the annotation must be removed, or the restore will run again
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		objBak := &devopsv1.Cluster{}
+		if err := r.Client.Get(ctx.Ctx, ctx.Key, objBak); err != nil {
+			return err
+		}
+		delete(objBak.Annotations, constants.ClusterEtcdRestore)
+		return r.Client.Update(ctx.Ctx, objBak)
+	})
+	if err != nil {
+		ctx.Error(err, "failed to remove annotation", "key", constants.ClusterEtcdRestore)
+		ctx.Cluster.Status.Message = fmt.Sprintf("etcd restored, but failed to remove annotation %s: %v", constants.ClusterEtcdRestore, err)
+		ctx.Cluster.Status.Reason = reasonFailedRestore
+		return 0
+	}
+
+	ctx.Cluster.Status.Phase = devopsv1.ClusterRunning
+	ctx.Cluster.Status.Message = ""
+	ctx.Cluster.Status.Reason = ""
+	return 0
+}
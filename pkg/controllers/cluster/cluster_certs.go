@@ -0,0 +1,221 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/observe/collector"
+	"github.com/wtxue/kok-operator/pkg/provider/cluster"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// requeue interval when certs rotation failed
+	certsRetryInterval = 5 * time.Minute
+
+	reasonFailedRotateCerts = "FailedRotateCerts"
+
+	// conditionTypeCAExpiring is true when any CA expires within the renew threshold
+	conditionTypeCAExpiring = "CAExpiring"
+)
+
+// onCerts records the expiry of certs into status, and runs one step of the rotation when the CA
+// rotation or renew annotation is set, or the leaf certs expire within the threshold at the scheduled
+// check. It returns the duration to requeue, zero means no schedule.
+func (r *clusterReconciler) onCerts(ctx *common.ClusterContext, p cluster.Provider) time.Duration {
+	status := ctx.Cluster.Status.Certificates
+	if status == nil {
+		status = &devopsv1.CertificatesStatus{}
+		ctx.Cluster.Status.Certificates = status
+	}
+	status.Expiry = certificateExpiry(ctx.Credential)
+
+	policy := ctx.Cluster.Spec.Certificates
+	checkCAExpiry(ctx, status.Expiry, renewBefore(policy), time.Now())
+	var schedule cron.Schedule
+	if policy != nil {
+		spec := policy.Schedule
+		if spec == "" {
+			spec = constants.CertCheckSchedule
+		}
+
+		var err error
+		schedule, err = cron.ParseStandard(spec)
+		if err != nil {
+			ctx.Error(err, "invalid certs check schedule", "schedule", spec)
+			ctx.Cluster.Status.Message = err.Error()
+			ctx.Cluster.Status.Reason = reasonFailedRotateCerts
+			return 0
+		}
+	}
+
+	now := time.Now()
+	if status.Rotation == "" {
+		switch {
+		case constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterRotateCA) != "":
+			status.Rotation = devopsv1.CertRotationDistributingCA
+		case constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterRenewCerts) != "":
+			status.Rotation = devopsv1.CertRotationRenewing
+		case schedule != nil:
+			next := schedule.Next(lastCertsCheckTime(ctx.Cluster))
+			if next.After(now) {
+				return untilNext(next, now)
+			}
+
+			status.LastCheckTime = &metav1.Time{Time: now}
+			if !needRenew(status.Expiry, renewBefore(policy), now) {
+				return untilNext(schedule.Next(now), now)
+			}
+			status.Rotation = devopsv1.CertRotationRenewing
+		default:
+			return 0
+		}
+	}
+
+	ctx.Info("start certs rotation", "step", status.Rotation)
+	err := p.OnRotateCerts(ctx)
+	if errors.Is(err, cluster.ErrCertsRotationUnsupported) {
+		ctx.V(4).Info("skip certs rotation", "reason", err.Error())
+		status.Rotation = ""
+		r.removeAnnotations(ctx, constants.ClusterRenewCerts, constants.ClusterRotateCA)
+		return 0
+	}
+	if err != nil {
+		ctx.Error(err, "failed to rotate certs", "step", status.Rotation)
+		ctx.Cluster.Status.Message = err.Error()
+		ctx.Cluster.Status.Reason = reasonFailedRotateCerts
+		return certsRetryInterval
+	}
+
+	status.LastRotateTime = &metav1.Time{Time: now}
+	status.Expiry = certificateExpiry(ctx.Credential)
+	checkCAExpiry(ctx, status.Expiry, renewBefore(policy), now)
+	if status.Rotation != devopsv1.CertRotationRenewing {
+		// reconnect the cluster with the CAs of credential
+		r.ClusterManager.Delete(ctx.Cluster.Name)
+		delete(r.ClusterStarted, ctx.Cluster.Name)
+	}
+
+	switch status.Rotation {
+	case devopsv1.CertRotationDistributingCA:
+		status.Rotation = devopsv1.CertRotationReissuing
+	case devopsv1.CertRotationReissuing:
+		status.Rotation = devopsv1.CertRotationRetiringCA
+	default:
+		status.Rotation = ""
+	}
+	if status.Rotation != "" {
+		return time.Second
+	}
+
+	ctx.Info("certs rotation successfully")
+	r.removeAnnotations(ctx, constants.ClusterRenewCerts, constants.ClusterRotateCA)
+	if schedule == nil {
+		return 0
+	}
+	return untilNext(schedule.Next(now), now)
+}
+
+func (r *clusterReconciler) removeAnnotations(ctx *common.ClusterContext, keys ...string) {
+	objBak := &devopsv1.Cluster{}
+	err := r.Client.Get(ctx.Ctx, ctx.Key, objBak)
+	if err != nil {
+		ctx.Error(err, "failed to remove annotations", "keys", keys)
+		return
+	}
+
+	found := false
+	for _, key := range keys {
+		if _, ok := objBak.Annotations[key]; ok {
+			delete(objBak.Annotations, key)
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+
+	err = r.Client.Update(ctx.Ctx, objBak)
+	if err != nil {
+		ctx.Error(err, "failed to remove annotations", "keys", keys)
+	}
+}
+
+// certificateExpiry returns the expiry of certs in the credential sorted by name.
+func certificateExpiry(cred *devopsv1.ClusterCredential) []devopsv1.CertificateExpiry {
+	if cred == nil {
+		return nil
+	}
+
+	var result []devopsv1.CertificateExpiry
+	for name, expiry := range collector.CertificateExpiry(cred) {
+		result = append(result, devopsv1.CertificateExpiry{Name: name, NotAfter: metav1.NewTime(expiry)})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// needRenew returns true if any leaf cert expires within the threshold, the CAs are only rotated
+// by the rotate CA annotation.
+func needRenew(expiry []devopsv1.CertificateExpiry, threshold time.Duration, now time.Time) bool {
+	for _, e := range expiry {
+		if !collector.IsCA(e.Name) && e.NotAfter.Sub(now) < threshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkCAExpiry sets the CAExpiring condition and warns once when any CA expires within the
+// threshold, the condition is removed after the CAs are rotated.
+func checkCAExpiry(ctx *common.ClusterContext, expiry []devopsv1.CertificateExpiry, threshold time.Duration, now time.Time) {
+	var names []string
+	for _, e := range expiry {
+		if collector.IsCA(e.Name) && e.NotAfter.Sub(now) < threshold {
+			names = append(names, e.Name)
+		}
+	}
+	if len(names) == 0 {
+		ctx.Cluster.RemoveCondition(conditionTypeCAExpiring)
+		return
+	}
+
+	message := fmt.Sprintf("CA %s expire within %s, annotate %s to rotate them", strings.Join(names, ","), threshold, constants.ClusterRotateCA)
+	if c := ctx.Cluster.GetCondition(conditionTypeCAExpiring); c == nil || c.Message != message {
+		ctx.Eventf(ctx.Cluster, corev1.EventTypeWarning, conditionTypeCAExpiring, "%s", message)
+	}
+	ctx.Cluster.SetCondition(devopsv1.ClusterCondition{
+		Type:    conditionTypeCAExpiring,
+		Status:  devopsv1.ConditionTrue,
+		Reason:  conditionTypeCAExpiring,
+		Message: message,
+	})
+}
+
+func renewBefore(policy *devopsv1.CertificatePolicy) time.Duration {
+	if policy != nil && policy.RenewBefore != nil {
+		return policy.RenewBefore.Duration
+	}
+
+	return constants.RenewCertsTimeThreshold
+}
+
+// lastCertsCheckTime returns the time of the last check, or the creation time of the cluster.
+func lastCertsCheckTime(c *devopsv1.Cluster) time.Time {
+	if c.Status.Certificates == nil || c.Status.Certificates.LastCheckTime == nil {
+		return c.CreationTimestamp.Time
+	}
+
+	return c.Status.Certificates.LastCheckTime.Time
+}
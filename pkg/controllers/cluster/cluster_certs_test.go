@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCAExpiry(t *testing.T) {
+	now := time.Now()
+	soon := metav1.NewTime(now.Add(time.Hour))
+	later := metav1.NewTime(now.Add(365 * 24 * time.Hour))
+	expiry := []devopsv1.CertificateExpiry{
+		{Name: "ca", NotAfter: soon},
+		{Name: "etcd-ca", NotAfter: later},
+		{Name: "/etc/kubernetes/pki/front-proxy-ca.crt", NotAfter: soon},
+		{Name: "/etc/kubernetes/pki/apiserver.crt", NotAfter: later},
+	}
+	if needRenew(expiry, 24*time.Hour, now) {
+		t.Errorf("expect the expiring CAs not renewed with the leaf certs")
+	}
+	if !needRenew(append(expiry, devopsv1.CertificateExpiry{Name: "client", NotAfter: soon}), 24*time.Hour, now) {
+		t.Errorf("expect the expiring leaf cert renewed")
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	ctx := &common.ClusterContext{Cluster: &devopsv1.Cluster{}, Recorder: recorder}
+	checkCAExpiry(ctx, expiry, 24*time.Hour, now)
+	checkCAExpiry(ctx, expiry, 24*time.Hour, now)
+	c := ctx.Cluster.GetCondition(conditionTypeCAExpiring)
+	if c == nil || c.Status != devopsv1.ConditionTrue || !strings.HasPrefix(c.Message, "CA ca,/etc/kubernetes/pki/front-proxy-ca.crt expire") {
+		t.Errorf("unexpected condition %+v", c)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expect warned once, got %d events", len(recorder.Events))
+	}
+
+	checkCAExpiry(ctx, expiry[1:2], 24*time.Hour, now)
+	if ctx.Cluster.GetCondition(conditionTypeCAExpiring) != nil {
+		t.Errorf("expect the condition removed after the CAs rotated")
+	}
+}
@@ -6,17 +6,25 @@ import (
 	"time"
 
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	workloadv1 "github.com/wtxue/kok-operator/pkg/apis/workload/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/gmanager"
+	helmv3 "github.com/wtxue/kok-operator/pkg/helm/v3"
+	"github.com/wtxue/kok-operator/pkg/provider/baremetal/validation"
+	"github.com/wtxue/kok-operator/pkg/provider/cluster"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/clean"
 	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
 
 	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
@@ -33,6 +41,7 @@ type clusterReconciler struct {
 	*gmanager.GManager
 	Log            logr.Logger
 	Mgr            manager.Manager
+	Recorder       record.EventRecorder
 	ClusterStarted map[string]bool
 }
 
@@ -42,6 +51,7 @@ func Add(mgr manager.Manager, pMgr *gmanager.GManager) error {
 		Mgr:            mgr,
 		GManager:       pMgr,
 		Log:            logf.Log.WithName(controllerName),
+		Recorder:       mgr.GetEventRecorderFor(controllerName),
 		ClusterStarted: make(map[string]bool),
 	}
 
@@ -55,13 +65,16 @@ func Add(mgr manager.Manager, pMgr *gmanager.GManager) error {
 	// 	return err
 	// }
 
+	// the status updates are ignored, the failed steps are requeued with backoff instead
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&devopsv1.Cluster{}).
+		For(&devopsv1.Cluster{}, builder.WithPredicates(common.SpecChangedPredicate())).
+		Owns(&appsv1.StatefulSet{}).
 		Complete(r)
 }
 
 // +kubebuilder:rbac:groups=devops.fake.io,resources=clusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=devops.fake.io,resources=clusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *clusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := r.Log.WithValues("cluster", req.Name)
@@ -81,22 +94,27 @@ func (r *clusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		logger.Error(err, "failed to get cluster")
 		return reconcile.Result{}, err
 	}
+	c.SetSSHOptions(common.SecretGetter(ctx, r.Client, c.Namespace))
+	for _, warning := range validation.ClusterWarnings(c) {
+		logger.Info("deprecated field", "warning", warning)
+	}
 
 	clusterCtx := &common.ClusterContext{
-		Ctx:     ctx,
-		Key:     req.NamespacedName,
-		Client:  r.Client,
-		Logger:  logger,
-		Cluster: c,
+		Ctx:      ctx,
+		Key:      req.NamespacedName,
+		Client:   r.Client,
+		Logger:   logger,
+		Cluster:  c,
+		Recorder: r.Recorder,
 	}
 
 	if !c.ObjectMeta.DeletionTimestamp.IsZero() {
-		err := r.cleanClusterResources(clusterCtx)
+		result, err := r.cleanClusterResources(clusterCtx)
 		if err != nil {
 			logger.Error(err, "failed to clean cluster resources")
 			return reconcile.Result{}, err
 		}
-		return reconcile.Result{}, nil
+		return result, nil
 	}
 
 	if !constants.ContainsString(c.ObjectMeta.Finalizers, constants.FinalizersCluster) {
@@ -111,7 +129,7 @@ func (r *clusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 			return reconcile.Result{}, err
 		}
 
-		return reconcile.Result{}, nil
+		return reconcile.Result{Requeue: true}, nil
 	}
 
 	if c.Spec.Pause == true {
@@ -145,8 +163,8 @@ func (r *clusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		}, nil
 	}
 
-	r.reconcile(clusterCtx)
-	return ctrl.Result{}, nil
+	result, _ := r.reconcile(clusterCtx)
+	return result, nil
 }
 
 func (r *clusterReconciler) addClusterCheck(ctx *common.ClusterContext) error {
@@ -175,7 +193,7 @@ func (r *clusterReconciler) addClusterCheck(ctx *common.ClusterContext) error {
 	return nil
 }
 
-func (r *clusterReconciler) reconcile(ctx *common.ClusterContext) error {
+func (r *clusterReconciler) reconcile(ctx *common.ClusterContext) (ctrl.Result, error) {
 	phaseRestore := constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterRestoreStep)
 	if len(phaseRestore) > 0 {
 		ctx.Info("#####  restore phase", "step", phaseRestore)
@@ -191,7 +209,7 @@ func (r *clusterReconciler) reconcile(ctx *common.ClusterContext) error {
 		ctx.Cluster.Status.Phase = devopsv1.ClusterInitializing
 		err := r.Client.Status().Update(ctx.Ctx, ctx.Cluster)
 		if err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 
 		objBak := &devopsv1.Cluster{}
@@ -199,44 +217,189 @@ func (r *clusterReconciler) reconcile(ctx *common.ClusterContext) error {
 		delete(objBak.Annotations, constants.ClusterRestoreStep)
 		err = r.Client.Update(ctx.Ctx, objBak)
 		if err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
-		return nil
+		return ctrl.Result{}, nil
+	}
+
+	if ctx.Cluster.Status.Phase == devopsv1.ClusterFailed {
+		return r.onResume(ctx)
 	}
 
 	p, err := r.CpManager.GetProvider(ctx.Cluster.Spec.ClusterType)
 	if err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
 
 	if err := common.FillClusterContext(ctx, r.ClusterManager); err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
 
+	result := ctrl.Result{}
+	phase := ctx.Cluster.Status.Phase
+
 	switch ctx.Cluster.Status.Phase {
 	case devopsv1.ClusterInitializing:
-		r.onCreate(ctx, p)
+		if isPreflightOnly(ctx.Cluster) {
+			result.RequeueAfter = r.onPreflight(ctx, p)
+			break
+		}
+		result.RequeueAfter = r.onCreate(ctx, p)
+		// the next step runs at once
+		result.Requeue = result.RequeueAfter == 0
 	case devopsv1.ClusterRunning:
-		r.onUpdate(ctx, p)
+		if ref := constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterEtcdRestore); ref != "" {
+			ctx.Info("start etcd restore", "snapshot", ref)
+			ctx.Cluster.Status.Phase = devopsv1.ClusterRestoring
+			break
+		}
+		if ctx.Cluster.Status.Version != "" && ctx.Cluster.Spec.Version != ctx.Cluster.Status.Version {
+			ctx.Info("start upgrade", "from", ctx.Cluster.Status.Version, "to", ctx.Cluster.Spec.Version)
+			ctx.Cluster.Status.Phase = devopsv1.ClusterUpgrading
+			ctx.Cluster.Status.NodeConditions = nil
+			ctx.Cluster.RemoveCondition(cluster.ConditionTypeUpgrade)
+			break
+		}
+		// the update handlers reach the cluster by the cluster manager
 		r.addClusterCheck(ctx)
+		result.RequeueAfter = r.onUpdate(ctx, p)
+		if retry := r.onBackup(ctx); retry > 0 && (result.RequeueAfter == 0 || retry < result.RequeueAfter) {
+			result.RequeueAfter = retry
+		}
+		if retry := r.onHelmReleases(ctx); retry > 0 && (result.RequeueAfter == 0 || retry < result.RequeueAfter) {
+			result.RequeueAfter = retry
+		}
+		if retry := r.onCerts(ctx, p); retry > 0 && (result.RequeueAfter == 0 || retry < result.RequeueAfter) {
+			result.RequeueAfter = retry
+		}
+	case devopsv1.ClusterUpgrading:
+		r.addClusterCheck(ctx)
+		result.RequeueAfter = r.onUpgrade(ctx, p)
+	case devopsv1.ClusterRestoring:
+		result.RequeueAfter = r.onRestore(ctx)
 	default:
 		ctx.Info("unknown cluster status", "phase", ctx.Cluster.Status.Phase)
-		return fmt.Errorf("no handler for status %q", ctx.Cluster.Status.Phase)
+		return ctrl.Result{}, fmt.Errorf("no handler for status %q", ctx.Cluster.Status.Phase)
 	}
 
-	return r.applyStatus(ctx)
+	// status updates don't trigger reconcile, requeue to run the handlers of new phase
+	if ctx.Cluster.Status.Phase != phase {
+		result.Requeue = true
+	}
+	return result, r.applyStatus(ctx)
 }
 
-func (r *clusterReconciler) cleanClusterResources(ctx *common.ClusterContext) error {
-	ms := &devopsv1.MachineList{}
+// cleanClusterResources tears down the cluster in order: addons, worker machines, master nodes,
+// and the objects of the cluster. It's requeued until every step is done, the failures are recorded
+// in status and retried with backoff. Hosts are not touched in orphan hosts mode.
+func (r *clusterReconciler) cleanClusterResources(ctx *common.ClusterContext) (ctrl.Result, error) {
+	if !constants.ContainsString(ctx.Cluster.ObjectMeta.Finalizers, constants.FinalizersCluster) {
+		return ctrl.Result{}, nil
+	}
+
+	orphan := constants.IsOrphanHosts(ctx.Cluster.Annotations)
 	listOptions := &client.ListOptions{Namespace: ctx.Key.Namespace}
-	err := r.Client.List(ctx.Ctx, ms, listOptions)
+
+	// addons are uninstalled while the cluster is still running
+	addons := &workloadv1.AddonsList{}
+	err := r.Client.List(ctx.Ctx, addons, listOptions)
 	if err != nil {
-		if apierrors.IsNotFound(err) {
-			ctx.Info("not find machineList")
-		} else {
-			ctx.Error(err, "failed list machine")
-			return err
+		ctx.Error(err, "failed list addons")
+		return ctrl.Result{}, err
+	}
+
+	remaining := 0
+	for i := range addons.Items {
+		addon := &addons.Items[i]
+		if addon.Spec.ClusterName != ctx.Cluster.Name {
+			continue
+		}
+
+		remaining++
+		if addon.DeletionTimestamp.IsZero() {
+			ctx.Info("start clean", "addons", addon.Name)
+			err = r.Client.Delete(ctx.Ctx, addon)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+	if remaining > 0 {
+		ctx.Info("wait addons deleted", "remaining", remaining)
+		return ctrl.Result{RequeueAfter: deleteWaitInterval}, nil
+	}
+
+	if ctx.Cluster.Status.Phase != devopsv1.ClusterTerminating {
+		ctx.Info("change", "status", devopsv1.ClusterTerminating, "orphanHosts", orphan)
+		ctx.Cluster.Status.Phase = devopsv1.ClusterTerminating
+		ctx.Cluster.Status.NodeConditions = nil
+		return ctrl.Result{Requeue: true}, r.Client.Status().Update(ctx.Ctx, ctx.Cluster)
+	}
+
+	// worker nodes are cleaned by the finalizer of machine
+	ms := &devopsv1.MachineList{}
+	err = r.Client.List(ctx.Ctx, ms, listOptions)
+	if err != nil {
+		ctx.Error(err, "failed list machine")
+		return ctrl.Result{}, err
+	}
+
+	remaining = 0
+	for i := range ms.Items {
+		m := &ms.Items[i]
+		if m.Spec.ClusterName != ctx.Cluster.Name {
+			continue
+		}
+
+		remaining++
+		if m.DeletionTimestamp.IsZero() {
+			ctx.Info("start clean", "machine", m.Name)
+			err = r.Client.Delete(ctx.Ctx, m)
+			if err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+
+		if m.Spec.Machine != nil {
+			if m.Status.Reason != "" {
+				setNodeDeleteCondition(ctx, m.Spec.Machine.IP, devopsv1.ConditionFalse, m.Status.Reason, m.Status.Message)
+			} else {
+				setNodeDeleteCondition(ctx, m.Spec.Machine.IP, devopsv1.ConditionUnknown, "Waiting", "waiting machine finalizer")
+			}
+		}
+	}
+	if remaining > 0 {
+		ctx.Info("wait machines deleted", "remaining", remaining)
+		if err := r.Client.Status().Update(ctx.Ctx, ctx.Cluster); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: deleteWaitInterval}, nil
+	}
+
+	if !orphan {
+		var errs []error
+		for _, m := range ctx.Cluster.Spec.Machines {
+			condition := ctx.Cluster.GetNodeCondition(m.IP, devopsv1.NodeConditionDelete)
+			if condition != nil && condition.Status == devopsv1.ConditionTrue {
+				continue
+			}
+
+			ctx.Info("start clean", "master", m.IP)
+			err = cleanMaster(ctx, m)
+			if err != nil {
+				ctx.Error(err, "failed clean master node", "node", m.IP)
+				setNodeDeleteCondition(ctx, m.IP, devopsv1.ConditionFalse, reasonFailedDelete, err.Error())
+				errs = append(errs, err)
+				continue
+			}
+			setNodeDeleteCondition(ctx, m.IP, devopsv1.ConditionTrue, "", "")
+		}
+
+		if err := r.Client.Status().Update(ctx.Ctx, ctx.Cluster); err != nil {
+			return ctrl.Result{}, err
+		}
+		if len(errs) > 0 {
+			return ctrl.Result{}, utilerrors.NewAggregate(errs)
 		}
 	}
 
@@ -247,50 +410,83 @@ func (r *clusterReconciler) cleanClusterResources(ctx *common.ClusterContext) er
 	}
 
 	credential := &devopsv1.ClusterCredential{}
-	err = r.Client.Get(ctx.Ctx, types.NamespacedName{Name: ctx.Cluster.Name, Namespace: ctx.Cluster.Namespace}, credential)
+	err = r.Client.Get(ctx.Ctx, ctx.Key, credential)
 	if err == nil {
 		ctx.Info("start clean clusterCredential")
-		r.Client.Delete(ctx.Ctx, credential)
+		err = r.Client.Delete(ctx.Ctx, credential)
+	}
+	if err != nil && !apierrors.IsNotFound(err) {
+		ctx.Error(err, "failed clean clusterCredential")
+		return ctrl.Result{}, err
 	}
 
+	// only the configmaps labeled with the cluster, the old ones are collected by owner reference
 	cms := &corev1.ConfigMapList{}
-	err = r.Client.List(ctx.Ctx, cms, listOptions)
-	if err == nil {
-		for i := range cms.Items {
-			cm := &cms.Items[i]
-			ctx.Info("start clean", "configmap", cm.Name)
-			r.Client.Delete(ctx.Ctx, cm)
+	err = r.Client.List(ctx.Ctx, cms, listOptions, client.MatchingLabels{constants.ClusterNameLabel: ctx.Cluster.Name})
+	if err != nil {
+		ctx.Error(err, "failed list configmap")
+		return ctrl.Result{}, err
+	}
+	for i := range cms.Items {
+		cm := &cms.Items[i]
+		ctx.Info("start clean", "configmap", cm.Name)
+		err = r.Client.Delete(ctx.Ctx, cm)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
 		}
-		r.Client.Delete(ctx.Ctx, credential)
 	}
 
-	// clean worker node
-	ctx.Info("start clean worker node")
-	for i := range ms.Items {
-		m := &ms.Items[i]
-		ctx.Info("start clean", "machine", m.Name)
-		r.Client.Delete(ctx.Ctx, m)
+	// the data volumes of managed etcd are not collected with the StatefulSet
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	err = r.Client.List(ctx.Ctx, pvcs, listOptions, client.MatchingLabels{constants.ClusterNameLabel: ctx.Cluster.Name})
+	if err != nil {
+		ctx.Error(err, "failed list pvc")
+		return ctrl.Result{}, err
 	}
-
-	// clean master node
-	ctx.Info("start clean master node")
-	for i := range ctx.Cluster.Spec.Machines {
-		m := ctx.Cluster.Spec.Machines[i]
-		ssh, err := m.SSH()
-		if err != nil {
-			ctx.Error(err, "failed new ssh", "node", m.IP)
-			return err
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		ctx.Info("start clean", "pvc", pvc.Name)
+		err = r.Client.Delete(ctx.Ctx, pvc)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
 		}
+	}
 
-		ctx.Info("start clean", "machine", m.IP)
-		err = clean.CleanNode(ssh)
+	if r.HelmEnv != nil {
+		err = helmv3.RemoveClusterRepos(r.HelmEnv.Cli, ctx.Cluster.Namespace, ctx.Cluster.Name)
 		if err != nil {
-			ctx.Error(err, "failed clean machine node", "node", m.IP)
-			return err
+			ctx.Error(err, "failed remove helm repos")
+			return ctrl.Result{}, err
 		}
 	}
 
-	ctx.Info("clean all manchine success, start clean cluster finalizers")
+	ctx.Info("clean all resources success, start clean cluster finalizers")
 	ctx.Cluster.ObjectMeta.Finalizers = constants.RemoveString(ctx.Cluster.ObjectMeta.Finalizers, constants.FinalizersCluster)
-	return r.Client.Update(ctx.Ctx, ctx.Cluster)
+	return ctrl.Result{}, r.Client.Update(ctx.Ctx, ctx.Cluster)
+}
+
+func cleanMaster(ctx *common.ClusterContext, m *devopsv1.ClusterMachine) error {
+	s, err := m.SSH()
+	if err != nil {
+		return err
+	}
+
+	return clean.CleanNode(s, ctx.Cluster.Spec.CRIType)
+}
+
+// setNodeDeleteCondition records the cleanup of node, True means the node is cleaned.
+func setNodeDeleteCondition(ctx *common.ClusterContext, node string, status devopsv1.ConditionStatus, reason string, message string) {
+	condition := devopsv1.NodeCondition{
+		Node:    node,
+		Type:    devopsv1.NodeConditionDelete,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	}
+
+	old := ctx.Cluster.GetNodeCondition(node, devopsv1.NodeConditionDelete)
+	if old == nil || old.Status != condition.Status {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	ctx.Cluster.SetNodeCondition(condition)
 }
@@ -0,0 +1,175 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/clustermanager"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	helmv3 "github.com/wtxue/kok-operator/pkg/helm/v3"
+	"github.com/wtxue/kok-operator/pkg/util/hash"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/repo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// requeue interval when helm release failed or the cluster is not added to cluster manager
+	helmRetryInterval = 30 * time.Second
+
+	defaultReleaseNamespace = "kube-system"
+)
+
+// onHelmReleases installs or upgrades the releases of spec when the spec is changed or the last apply
+// failed, and uninstalls the releases removed from spec. It returns the retry interval, zero means no retry.
+func (r *clusterReconciler) onHelmReleases(ctx *common.ClusterContext) time.Duration {
+	if len(ctx.Cluster.Spec.HelmReleases) == 0 && len(ctx.Cluster.Status.HelmReleases) == 0 {
+		return 0
+	}
+
+	remote, err := r.ClusterManager.Get(ctx.Cluster.Name)
+	if err != nil {
+		ctx.Info("cluster is not added to cluster manager, wait to apply helm releases", "err", err.Error())
+		return helmRetryInterval
+	}
+
+	var retry time.Duration
+	statuses := make([]devopsv1.HelmReleaseStatus, 0, len(ctx.Cluster.Spec.HelmReleases))
+	for i := range ctx.Cluster.Spec.HelmReleases {
+		rls := ctx.Cluster.Spec.HelmReleases[i]
+		if rls.Namespace == "" {
+			rls.Namespace = defaultReleaseNamespace
+		}
+
+		status := findHelmReleaseStatus(ctx.Cluster, rls.Name)
+		if status != nil && status.SpecHash == helmReleaseHash(&rls) && status.Message == "" {
+			statuses = append(statuses, *status)
+			continue
+		}
+
+		status = r.applyHelmRelease(ctx, remote, &rls)
+		if status.Message != "" {
+			retry = helmRetryInterval
+		}
+		statuses = append(statuses, *status)
+	}
+
+	for i := range ctx.Cluster.Status.HelmReleases {
+		status := ctx.Cluster.Status.HelmReleases[i]
+		if findHelmRelease(ctx.Cluster, status.Name) != nil {
+			continue
+		}
+
+		err = r.uninstallHelmRelease(ctx, remote, &status)
+		if err != nil {
+			ctx.Error(err, "failed to uninstall helm release", "release", status.Name)
+			status.Message = err.Error()
+			statuses = append(statuses, status)
+			retry = helmRetryInterval
+			continue
+		}
+		ctx.Info("uninstall helm release successfully", "release", status.Name)
+	}
+
+	if len(statuses) == 0 {
+		statuses = nil
+	}
+	ctx.Cluster.Status.HelmReleases = statuses
+	return retry
+}
+
+// helmEnv returns the helm env of the release and the chart reference in the repo owned by the cluster.
+func (r *clusterReconciler) helmEnv(ctx *common.ClusterContext, remote *clustermanager.Cluster, rls *devopsv1.HelmRelease) (*helmv3.HelmEnv, string, error) {
+	repoName, err := helmv3.EnsureClusterRepo(r.HelmEnv.Cli, ctx.Cluster.Namespace, ctx.Cluster.Name, &repo.Entry{Name: rls.RepoName, URL: rls.RepoURL})
+	if err != nil {
+		return nil, "", err
+	}
+
+	env, err := helmv3.NewHelmEnv(r.HelmEnv, remote.RawKubeconfig, rls.Namespace, remote.KubeCli)
+	if err != nil {
+		return nil, "", err
+	}
+	return env, path.Join(repoName, rls.Chart), nil
+}
+
+// helmReleaseHash returns the hash of the release spec, the release is applied again only if it's changed.
+func helmReleaseHash(rls *devopsv1.HelmRelease) string {
+	data, _ := json.Marshal(rls)
+	return hash.Sum(sha256.New(), data)[:16]
+}
+
+func (r *clusterReconciler) applyHelmRelease(ctx *common.ClusterContext, remote *clustermanager.Cluster, rls *devopsv1.HelmRelease) *devopsv1.HelmReleaseStatus {
+	status := &devopsv1.HelmReleaseStatus{
+		Name:               rls.Name,
+		Namespace:          rls.Namespace,
+		Chart:              path.Join(rls.RepoName, rls.Chart),
+		SpecHash:           helmReleaseHash(rls),
+		ObservedGeneration: ctx.Cluster.Generation,
+		LastUpdateTime:     metav1.Now(),
+	}
+
+	env, chart, err := r.helmEnv(ctx, remote, rls)
+	if err == nil {
+		ctx.Info("apply helm release", "release", rls.Name, "chart", chart, "version", rls.Version)
+		var applied *helmv3.Release
+		applied, err = helmv3.ApplyRelease(env, rls.Name, chart, rls.Version, nil, rls.Namespace, []byte(rls.Values), nil)
+		if err == nil && applied == nil {
+			err = errors.Errorf("apply release %s return empty", rls.Name)
+		}
+		if err == nil {
+			status.Version = applied.Version
+			status.Revision = applied.ReleaseVersion
+			if applied.ReleaseInfo != nil {
+				status.Status = applied.ReleaseInfo.Status
+			}
+		}
+	}
+
+	if err != nil {
+		ctx.Error(err, "failed to apply helm release", "release", rls.Name)
+		status.Status = string(release.StatusFailed)
+		status.Message = err.Error()
+		if last := findHelmReleaseStatus(ctx.Cluster, rls.Name); last != nil {
+			status.Version = last.Version
+			status.Revision = last.Revision
+		}
+	}
+
+	return status
+}
+
+func (r *clusterReconciler) uninstallHelmRelease(ctx *common.ClusterContext, remote *clustermanager.Cluster, status *devopsv1.HelmReleaseStatus) error {
+	env, err := helmv3.NewHelmEnv(r.HelmEnv, remote.RawKubeconfig, status.Namespace, remote.KubeCli)
+	if err != nil {
+		return err
+	}
+
+	err = helmv3.UninstallReleases(env, status.Name, &helmv3.Options{Namespace: status.Namespace})
+	if err != nil && !strings.Contains(err.Error(), "not found") {
+		return errors.Wrapf(err, "uninstall release %s", status.Name)
+	}
+	return nil
+}
+
+func findHelmRelease(c *devopsv1.Cluster, name string) *devopsv1.HelmRelease {
+	for i := range c.Spec.HelmReleases {
+		if c.Spec.HelmReleases[i].Name == name {
+			return &c.Spec.HelmReleases[i]
+		}
+	}
+	return nil
+}
+
+func findHelmReleaseStatus(c *devopsv1.Cluster, name string) *devopsv1.HelmReleaseStatus {
+	for i := range c.Status.HelmReleases {
+		if c.Status.HelmReleases[i].Name == name {
+			return &c.Status.HelmReleases[i]
+		}
+	}
+	return nil
+}
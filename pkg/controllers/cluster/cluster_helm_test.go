@@ -0,0 +1,21 @@
+package cluster
+
+import (
+	"testing"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+)
+
+func TestHelmReleaseHash(t *testing.T) {
+	rls := devopsv1.HelmRelease{Name: "nginx", Namespace: "web", RepoName: "bitnami", Chart: "nginx", Version: "13.2.0"}
+	hash := helmReleaseHash(&rls)
+	if other := rls; helmReleaseHash(&other) != hash {
+		t.Errorf("expect the same hash of the same spec")
+	}
+
+	changed := rls
+	changed.Values = "replicaCount: 2\n"
+	if helmReleaseHash(&changed) == hash {
+		t.Errorf("expect the hash changed with values")
+	}
+}
@@ -1,22 +1,36 @@
 package cluster
 
 import (
+	"fmt"
 	"time"
 
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/provider/cluster"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 const (
 	clusterClientRetryCount    = 5
 	clusterClientRetryInterval = 5 * time.Second
 
-	reasonFailedInit   = "FailedInit"
-	reasonFailedUpdate = "FailedUpdate"
+	// requeue interval when waiting for the addons and machines of cluster deleted
+	deleteWaitInterval = 10 * time.Second
+	// requeue interval when the update handlers failed
+	updateRetryInterval = time.Minute
+
+	reasonFailedInit    = "FailedInit"
+	reasonFailedUpdate  = "FailedUpdate"
+	reasonFailedUpgrade = "FailedUpgrade"
+	reasonFailedDelete  = "FailedDelete"
+
+	reasonExceededMaxAttempts = "ExceededMaxAttempts"
+	reasonResumed             = "Resumed"
 )
 
 func (r *clusterReconciler) applyStatus(ctx *common.ClusterContext) error {
@@ -81,34 +95,145 @@ func (r *clusterReconciler) applyStatus(ctx *common.ClusterContext) error {
 	return nil
 }
 
-func (r *clusterReconciler) onCreate(ctx *common.ClusterContext, p cluster.Provider) error {
+// onCreate runs the current create step, it returns the backoff interval to retry when the step failed.
+func (r *clusterReconciler) onCreate(ctx *common.ClusterContext, p cluster.Provider) time.Duration {
+	// the failed step isn't retried before its backoff interval, whatever triggers the reconcile
+	if condition := ctx.Cluster.FailedCondition(); condition != nil {
+		if wait := r.RetryWait(condition.Attempts, condition.LastProbeTime.Time, time.Now()); wait > 0 {
+			ctx.V(4).Info("waiting retry create", "condition", condition.Type, "attempts", condition.Attempts, "after", wait)
+			return wait
+		}
+	}
+
 	err := p.OnCreate(ctx)
+	if after, ok := cluster.IsWaiting(err); ok {
+		ctx.Cluster.Status.Message = err.Error()
+		ctx.Cluster.Status.Reason = cluster.ReasonWaitingProcess
+		return after
+	}
 	if err != nil {
 		ctx.Cluster.Status.Message = err.Error()
 		ctx.Cluster.Status.Reason = reasonFailedInit
-	} else {
-		condition := ctx.Cluster.Status.Conditions[len(ctx.Cluster.Status.Conditions)-1]
-		if condition.Status == devopsv1.ConditionFalse { // means current condition run into error
-			ctx.Cluster.Status.Message = condition.Message
-			ctx.Cluster.Status.Reason = condition.Reason
-		} else {
-			ctx.Cluster.Status.Message = ""
-			ctx.Cluster.Status.Reason = ""
+		return 0
+	}
+
+	condition := ctx.Cluster.Status.Conditions[len(ctx.Cluster.Status.Conditions)-1]
+	if condition.Status != devopsv1.ConditionFalse {
+		ctx.Cluster.Status.Message = ""
+		ctx.Cluster.Status.Reason = ""
+		return 0
+	}
+
+	// means current condition run into error
+	if r.ExceedMaxAttempts(condition.Attempts) {
+		ctx.Cluster.Status.Phase = devopsv1.ClusterFailed
+		ctx.Cluster.Status.Reason = reasonExceededMaxAttempts
+		ctx.Cluster.Status.Message = fmt.Sprintf("%s failed after %d attempts: %s", condition.Type, condition.Attempts, condition.Message)
+		ctx.Info("give up create", "condition", condition.Type, "attempts", condition.Attempts)
+		ctx.Eventf(ctx.Cluster, corev1.EventTypeWarning, reasonExceededMaxAttempts, "%s, annotate %s to resume", ctx.Cluster.Status.Message, constants.ClusterResume)
+		return 0
+	}
+
+	retry := r.RetryInterval(condition.Attempts)
+	ctx.Cluster.Status.Message = condition.Message
+	ctx.Cluster.Status.Reason = condition.Reason
+	ctx.Info("retry create", "condition", condition.Type, "attempts", condition.Attempts, "after", retry)
+	return retry
+}
+
+// onResume turns the failed cluster back to initializing when it's annotated with resume,
+// the failed step is retried with fresh attempts.
+func (r *clusterReconciler) onResume(ctx *common.ClusterContext) (ctrl.Result, error) {
+	if constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterResume) == "" {
+		ctx.V(4).Info("cluster is failed, waiting resume", "message", ctx.Cluster.Status.Message)
+		return ctrl.Result{}, nil
+	}
+
+	ctx.Info("resume failed cluster")
+	ctx.Cluster.Status.Phase = devopsv1.ClusterInitializing
+	if condition := ctx.Cluster.FailedCondition(); condition != nil {
+		condition.Attempts = 0
+		// the failed upgrade is resumed with the rollout
+		if condition.Type == cluster.ConditionTypeUpgrade {
+			ctx.Cluster.Status.Phase = devopsv1.ClusterUpgrading
 		}
 	}
+	ctx.Cluster.Status.Reason = ""
+	ctx.Cluster.Status.Message = ""
+	err := r.Client.Status().Update(ctx.Ctx, ctx.Cluster)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	ctx.Eventf(ctx.Cluster, corev1.EventTypeNormal, reasonResumed, "resumed by annotation %s", constants.ClusterResume)
 
-	return nil
+	objBak := &devopsv1.Cluster{}
+	err = r.Client.Get(ctx.Ctx, ctx.Key, objBak)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	delete(objBak.Annotations, constants.ClusterResume)
+	return ctrl.Result{}, r.Client.Update(ctx.Ctx, objBak)
 }
 
-func (r *clusterReconciler) onUpdate(ctx *common.ClusterContext, p cluster.Provider) error {
+// onUpdate runs the update handlers, it returns the interval to retry when they failed.
+func (r *clusterReconciler) onUpdate(ctx *common.ClusterContext, p cluster.Provider) time.Duration {
+	ctx.Cluster.Status.Message = ""
+	ctx.Cluster.Status.Reason = ""
 	err := p.OnUpdate(ctx)
+	if after, ok := cluster.IsWaiting(err); ok {
+		ctx.Cluster.Status.Message = err.Error()
+		ctx.Cluster.Status.Reason = cluster.ReasonWaitingProcess
+		return after
+	}
 	if err != nil {
 		ctx.Cluster.Status.Message = err.Error()
 		ctx.Cluster.Status.Reason = reasonFailedUpdate
-	} else {
-		ctx.Cluster.Status.Message = ""
-		ctx.Cluster.Status.Reason = ""
+		return updateRetryInterval
 	}
 
-	return nil
+	// the failed scale and update steps are recorded in status by provider
+	if ctx.Cluster.Status.Reason == cluster.ReasonFailedProcess {
+		return updateRetryInterval
+	}
+	return 0
+}
+
+// onUpgrade runs the upgrade handlers, the failed upgrade is retried with backoff and the cluster
+// is failed when it exceeds the max attempts. It returns the interval to retry.
+func (r *clusterReconciler) onUpgrade(ctx *common.ClusterContext, p cluster.Provider) time.Duration {
+	condition := ctx.Cluster.GetCondition(cluster.ConditionTypeUpgrade)
+	if condition != nil && condition.Status == devopsv1.ConditionFalse {
+		if wait := r.RetryWait(condition.Attempts, condition.LastProbeTime.Time, time.Now()); wait > 0 {
+			ctx.V(4).Info("waiting retry upgrade", "attempts", condition.Attempts, "after", wait)
+			return wait
+		}
+	}
+
+	err := p.OnUpgrade(ctx)
+	if err == nil {
+		return 0
+	}
+	if after, ok := cluster.IsWaiting(err); ok {
+		return after
+	}
+
+	ctx.Cluster.Status.Message = err.Error()
+	ctx.Cluster.Status.Reason = reasonFailedUpgrade
+	condition = ctx.Cluster.GetCondition(cluster.ConditionTypeUpgrade)
+	if condition == nil {
+		return r.RetryInterval(1)
+	}
+
+	if r.ExceedMaxAttempts(condition.Attempts) {
+		ctx.Cluster.Status.Phase = devopsv1.ClusterFailed
+		ctx.Cluster.Status.Reason = reasonExceededMaxAttempts
+		ctx.Cluster.Status.Message = fmt.Sprintf("upgrade to %s failed after %d attempts: %s", ctx.Cluster.Spec.Version, condition.Attempts, condition.Message)
+		ctx.Info("give up upgrade", "version", ctx.Cluster.Spec.Version, "attempts", condition.Attempts)
+		ctx.Eventf(ctx.Cluster, corev1.EventTypeWarning, reasonExceededMaxAttempts, "%s, annotate %s to resume", ctx.Cluster.Status.Message, constants.ClusterResume)
+		return 0
+	}
+
+	retry := r.RetryInterval(condition.Attempts)
+	ctx.Info("retry upgrade", "attempts", condition.Attempts, "after", retry)
+	return retry
 }
@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/gmanager"
+	"github.com/wtxue/kok-operator/pkg/provider/cluster"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var failedCalls int
+
+func EnsureAlwaysFail(ctx *common.ClusterContext) error {
+	failedCalls++
+	return errors.New("always fail")
+}
+
+func TestOnCreateBackoff(t *testing.T) {
+	r := &clusterReconciler{GManager: &gmanager.GManager{Config: config.NewDefaultConfig()}}
+	p := &cluster.DelegateProvider{CreateHandlers: []cluster.Handler{EnsureAlwaysFail}}
+	ctx := &common.ClusterContext{
+		Ctx:     context.Background(),
+		Logger:  logr.Discard(),
+		Cluster: &devopsv1.Cluster{Status: devopsv1.ClusterStatus{Phase: devopsv1.ClusterInitializing}},
+	}
+
+	failedCalls = 0
+	if retry := r.onCreate(ctx, p); retry != r.RetryInterval(1) {
+		t.Fatalf("expect retry after %s, got %s", r.RetryInterval(1), retry)
+	}
+
+	// the reconciles before the interval don't run the failed step
+	for i := 0; i < 3; i++ {
+		if retry := r.onCreate(ctx, p); retry <= 0 || retry > r.RetryInterval(1) {
+			t.Errorf("expect waiting retry, got %s", retry)
+		}
+	}
+	if failedCalls != 1 {
+		t.Fatalf("expect the step run once, got %d", failedCalls)
+	}
+
+	condition := ctx.Cluster.FailedCondition()
+	condition.LastProbeTime.Time = condition.LastProbeTime.Add(-r.RetryInterval(1))
+	if retry := r.onCreate(ctx, p); retry != r.RetryInterval(2) {
+		t.Errorf("expect retry after %s, got %s", r.RetryInterval(2), retry)
+	}
+	if failedCalls != 2 || ctx.Cluster.FailedCondition().Attempts != 2 {
+		t.Errorf("expect the step retried after the interval, got %d calls, %+v", failedCalls, ctx.Cluster.FailedCondition())
+	}
+	if ctx.Cluster.Status.Phase != devopsv1.ClusterInitializing {
+		t.Errorf("expect initializing, got %s", ctx.Cluster.Status.Phase)
+	}
+}
+
+func EnsureAlwaysWait(ctx *common.ClusterContext) error {
+	return cluster.Waitingf(30*time.Second, "waiting for etcd quorum")
+}
+
+func TestOnCreateWaiting(t *testing.T) {
+	r := &clusterReconciler{GManager: &gmanager.GManager{Config: config.NewDefaultConfig()}}
+	p := &cluster.DelegateProvider{CreateHandlers: []cluster.Handler{EnsureAlwaysWait}}
+	ctx := &common.ClusterContext{
+		Ctx:     context.Background(),
+		Logger:  logr.Discard(),
+		Cluster: &devopsv1.Cluster{Status: devopsv1.ClusterStatus{Phase: devopsv1.ClusterInitializing}},
+	}
+
+	for i := 0; i < r.MaxAttempts+1; i++ {
+		if retry := r.onCreate(ctx, p); retry != 30*time.Second {
+			t.Fatalf("expect requeue after 30s, got %s", retry)
+		}
+	}
+
+	condition := ctx.Cluster.Status.Conditions[0]
+	if condition.Status != devopsv1.ConditionUnknown || condition.Attempts != 0 {
+		t.Errorf("expect waiting condition without attempts, got %+v", condition)
+	}
+	if ctx.Cluster.Status.Phase != devopsv1.ClusterInitializing || ctx.Cluster.Status.Reason != cluster.ReasonWaitingProcess {
+		t.Errorf("expect waiting, got %s %s", ctx.Cluster.Status.Phase, ctx.Cluster.Status.Reason)
+	}
+}
+
+func TestOnUpgradeMaxAttempts(t *testing.T) {
+	cfg := config.NewDefaultConfig()
+	cfg.MaxAttempts = 2
+	r := &clusterReconciler{GManager: &gmanager.GManager{Config: cfg}}
+	p := &cluster.DelegateProvider{UpgradeHandlers: []cluster.Handler{EnsureAlwaysFail}}
+	ctx := &common.ClusterContext{
+		Ctx:    context.Background(),
+		Logger: logr.Discard(),
+		Cluster: &devopsv1.Cluster{
+			Spec:   devopsv1.ClusterSpec{Version: "1.25.3"},
+			Status: devopsv1.ClusterStatus{Phase: devopsv1.ClusterUpgrading, Version: "1.24.7"},
+		},
+	}
+
+	failedCalls = 0
+	if retry := r.onUpgrade(ctx, p); retry != r.RetryInterval(1) {
+		t.Fatalf("expect retry after %s, got %s", r.RetryInterval(1), retry)
+	}
+	if retry := r.onUpgrade(ctx, p); retry <= 0 || failedCalls != 1 {
+		t.Fatalf("expect waiting retry without running, got %s after %d calls", retry, failedCalls)
+	}
+	if ctx.Cluster.Status.Phase != devopsv1.ClusterUpgrading || ctx.Cluster.Status.Reason != reasonFailedUpgrade {
+		t.Errorf("expect upgrading with failure, got %s %s", ctx.Cluster.Status.Phase, ctx.Cluster.Status.Reason)
+	}
+
+	condition := ctx.Cluster.GetCondition(cluster.ConditionTypeUpgrade)
+	condition.LastProbeTime.Time = condition.LastProbeTime.Add(-r.RetryInterval(1))
+	if retry := r.onUpgrade(ctx, p); retry != 0 {
+		t.Errorf("expect no retry after max attempts, got %s", retry)
+	}
+	if ctx.Cluster.Status.Phase != devopsv1.ClusterFailed || ctx.Cluster.Status.Reason != reasonExceededMaxAttempts {
+		t.Errorf("expect failed, got %s %s", ctx.Cluster.Status.Phase, ctx.Cluster.Status.Reason)
+	}
+	if ctx.Cluster.Status.Version != "1.24.7" {
+		t.Errorf("expect the version not changed, got %s", ctx.Cluster.Status.Version)
+	}
+
+	// the upgrade is done with the condition removed
+	p = &cluster.DelegateProvider{}
+	ctx.Cluster.Status.Phase = devopsv1.ClusterUpgrading
+	ctx.Cluster.FailedCondition().LastProbeTime = metav1.Time{}
+	if retry := r.onUpgrade(ctx, p); retry != 0 {
+		t.Errorf("expect no retry, got %s", retry)
+	}
+	if ctx.Cluster.Status.Phase != devopsv1.ClusterRunning || ctx.Cluster.GetCondition(cluster.ConditionTypeUpgrade) != nil {
+		t.Errorf("expect running without upgrade condition, got %+v", ctx.Cluster.Status)
+	}
+}
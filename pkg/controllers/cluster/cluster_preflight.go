@@ -0,0 +1,52 @@
+package cluster
+
+import (
+	"fmt"
+	"time"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/cluster"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// interval to rerun the preflight checks of the preflight only cluster
+	preflightInterval = 5 * time.Minute
+
+	reasonPreflight       = "Preflight"
+	reasonFailedPreflight = "FailedPreflight"
+)
+
+// isPreflightOnly returns true if the cluster only runs the preflight checks instead of creating.
+func isPreflightOnly(c *devopsv1.Cluster) bool {
+	return constants.GetMapKey(c.Annotations, constants.ClusterPreflightOnly) == "true"
+}
+
+// onPreflight runs the preflight checks of the hosts into status instead of creating the cluster,
+// they're rerun every preflightInterval until the annotation is removed.
+func (r *clusterReconciler) onPreflight(ctx *common.ClusterContext, p cluster.Provider) time.Duration {
+	if report := ctx.Cluster.Status.Preflight; report != nil {
+		if next := report.Time.Add(preflightInterval); next.After(time.Now()) {
+			return untilNext(next, time.Now())
+		}
+	}
+
+	err := p.OnPreflight(ctx)
+	if err != nil {
+		ctx.Cluster.Status.Reason = reasonFailedPreflight
+		ctx.Cluster.Status.Message = err.Error()
+		return preflightInterval
+	}
+
+	report := ctx.Cluster.Status.Preflight
+	ctx.Cluster.Status.Reason = reasonPreflight
+	ctx.Cluster.Status.Message = fmt.Sprintf("preflight %s, remove annotation %s to create", report.Status, constants.ClusterPreflightOnly)
+	eventType := corev1.EventTypeNormal
+	if report.Status == devopsv1.PreflightFailed {
+		eventType = corev1.EventTypeWarning
+	}
+	ctx.Eventf(ctx.Cluster, eventType, reasonPreflight, "preflight %s on %d hosts", report.Status, len(report.Hosts))
+	return preflightInterval
+}
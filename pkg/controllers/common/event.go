@@ -0,0 +1,86 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// The reasons of the events emitted for the provider handlers.
+const (
+	EventReasonHandlerStarted   = "HandlerStarted"
+	EventReasonHandlerSucceeded = "HandlerSucceeded"
+	EventReasonHandlerFailed    = "HandlerFailed"
+	EventReasonHandlerSkipped   = "HandlerSkipped"
+
+	maxCmdLength          = 128
+	maxStderrLength       = 512
+	maxEventMessageLength = 1024
+)
+
+// Eventf emits an event of obj, it does nothing if the recorder is not set.
+func (c *ClusterContext) Eventf(obj runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if c.Recorder == nil || obj == nil {
+		return
+	}
+
+	msg := fmt.Sprintf(messageFmt, args...)
+	if len(msg) > maxEventMessageLength {
+		msg = msg[:maxEventMessageLength-3] + "..."
+	}
+	c.Recorder.Event(obj, eventtype, reason, msg)
+}
+
+// HandlerStarted emits the event before the handler runs.
+func (c *ClusterContext) HandlerStarted(obj runtime.Object, operation, handler string) {
+	c.Eventf(obj, corev1.EventTypeNormal, EventReasonHandlerStarted, "%s %s started", operation, handler)
+}
+
+// HandlerSkipped emits the event of the handler skipped by spec.
+func (c *ClusterContext) HandlerSkipped(obj runtime.Object, operation, handler string) {
+	c.Eventf(obj, corev1.EventTypeNormal, EventReasonHandlerSkipped, "%s %s skipped", operation, handler)
+}
+
+// HandlerFinished emits the event of success or failure of the handler, the failure contains
+// the host and the stderr of the failing ssh command if any.
+func (c *ClusterContext) HandlerFinished(obj runtime.Object, operation, handler string, d time.Duration, err error) {
+	d = d.Round(time.Millisecond)
+	if err == nil {
+		c.Eventf(obj, corev1.EventTypeNormal, EventReasonHandlerSucceeded, "%s %s succeeded in %s", operation, handler, d)
+		return
+	}
+
+	c.Eventf(obj, corev1.EventTypeWarning, EventReasonHandlerFailed, "%s %s failed in %s: %s", operation, handler, d, ErrorDetail(err))
+}
+
+// ErrorDetail returns the host and the trimmed stderr of the failing ssh command, or the error message.
+func ErrorDetail(err error) string {
+	var cmdErr *ssh.CommandError
+	if !errors.As(err, &cmdErr) {
+		return err.Error()
+	}
+
+	cmd := cmdErr.Cmd
+	if len(cmd) > maxCmdLength {
+		cmd = cmd[:maxCmdLength-3] + "..."
+	}
+	if cmdErr.Err != nil {
+		return fmt.Sprintf("host %s: cmd `%s`: %v", cmdErr.Host, cmd, cmdErr.Err)
+	}
+
+	return fmt.Sprintf("host %s: cmd `%s` exit %d: %s", cmdErr.Host, cmd, cmdErr.Exit, TrimStderr(cmdErr.Stderr))
+}
+
+// TrimStderr keeps the tail of stderr, where the cause of failure usually is.
+func TrimStderr(stderr string) string {
+	stderr = strings.TrimSpace(stderr)
+	if len(stderr) <= maxStderrLength {
+		return stderr
+	}
+	return "..." + stderr[len(stderr)-maxStderrLength:]
+}
@@ -0,0 +1,54 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestHandlerFinished(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	ctx := &ClusterContext{Recorder: recorder}
+	cluster := &devopsv1.Cluster{}
+
+	stderr := strings.Repeat("x", 2*maxStderrLength) + "kubeadm init failed"
+	err := fmt.Errorf("init master: %w", &ssh.CommandError{Host: "10.0.0.1", Cmd: "kubeadm init", Exit: 1, Stderr: stderr})
+	ctx.HandlerFinished(cluster, "create", "EnsureKubeadmInit", time.Second, err)
+	ctx.HandlerFinished(cluster, "create", "EnsureKubeadmInit", time.Second, nil)
+
+	event := <-recorder.Events
+	if !strings.HasPrefix(event, "Warning HandlerFailed create EnsureKubeadmInit failed in 1s: host 10.0.0.1: cmd `kubeadm init` exit 1: ...") ||
+		!strings.HasSuffix(event, "kubeadm init failed") || len(event) > maxEventMessageLength+64 {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	event = <-recorder.Events
+	if event != "Normal HandlerSucceeded create EnsureKubeadmInit succeeded in 1s" {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	if detail := ErrorDetail(errors.New("oops")); detail != "oops" {
+		t.Errorf("unexpected detail %q", detail)
+	}
+
+	// no recorder, no panic
+	(&ClusterContext{}).HandlerStarted(cluster, "create", "EnsureKubeadmInit")
+}
+
+func TestRecordAttempt(t *testing.T) {
+	cluster := &devopsv1.Cluster{}
+	cluster.RecordAttempt("EnsureKubeadmInit", time.Second)
+	cluster.RecordAttempt("EnsureKubeadmInit", 2*time.Second)
+	cluster.SetCondition(devopsv1.ClusterCondition{Type: "EnsureKubeadmInit", Status: devopsv1.ConditionTrue})
+
+	condition := cluster.Status.Conditions[0]
+	if condition.Attempts != 2 || condition.Duration == nil || condition.Duration.Duration != 3*time.Second {
+		t.Errorf("unexpected condition %+v", condition)
+	}
+}
@@ -12,11 +12,14 @@ import (
 	"github.com/wtxue/kok-operator/pkg/clustermanager"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/k8sutil"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 const (
@@ -33,6 +36,26 @@ type ClusterContext struct {
 	*clustermanager.ClusterManager
 	client.Client
 	logr.Logger
+	// Recorder emits the events of cluster and machine, no event is emitted if nil
+	Recorder record.EventRecorder
+}
+
+// SpecChangedPredicate passes the changes of spec, labels and annotations but not the status updates,
+// the deletion bumps the generation too.
+func SpecChangedPredicate() predicate.Predicate {
+	return predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{}, predicate.AnnotationChangedPredicate{})
+}
+
+// SecretGetter returns the getter of secrets in the namespace, which is used to resolve the ssh credentials.
+func SecretGetter(ctx context.Context, cli client.Client, namespace string) devopsv1.SecretGetter {
+	return func(name string) (*corev1.Secret, error) {
+		secret := &corev1.Secret{}
+		err := cli.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret)
+		if err != nil {
+			return nil, err
+		}
+		return secret, nil
+	}
 }
 
 func FillClusterContext(ctx *ClusterContext, multiMgr *clustermanager.ClusterManager) error {
@@ -41,7 +64,7 @@ func FillClusterContext(ctx *ClusterContext, multiMgr *clustermanager.ClusterMan
 	if err != nil && apierrors.IsNotFound(err) {
 		ctx.Info("not find credential, start create ...", "cluster ", ctx.Cluster.Name)
 		credential := &devopsv1.ClusterCredential{
-			ObjectMeta: k8sutil.ObjectMeta(ctx.Cluster.Name, constants.CtrlLabels, ctx.Cluster),
+			ObjectMeta: k8sutil.ObjectMeta(ctx.Cluster.Name, constants.ClusterLabels(ctx.Cluster.Name), ctx.Cluster),
 			CredentialInfo: devopsv1.CredentialInfo{
 				TenantID:    ctx.Cluster.Spec.TenantID,
 				ClusterName: ctx.Cluster.Name,
@@ -1,24 +1,40 @@
 package controllers
 
 import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
 	"github.com/wtxue/kok-operator/pkg/clustermanager"
 	"github.com/wtxue/kok-operator/pkg/controllers/addons"
 	"github.com/wtxue/kok-operator/pkg/controllers/cluster"
 	"github.com/wtxue/kok-operator/pkg/controllers/machine"
 	"github.com/wtxue/kok-operator/pkg/gmanager"
+	helmv3 "github.com/wtxue/kok-operator/pkg/helm/v3"
+	"github.com/wtxue/kok-operator/pkg/observe/collector"
 	"github.com/wtxue/kok-operator/pkg/option"
 	"github.com/wtxue/kok-operator/pkg/provider"
 	"github.com/wtxue/kok-operator/pkg/provider/config"
+	"github.com/wtxue/kok-operator/pkg/server"
+	"github.com/wtxue/kok-operator/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
+// helmOrganizationName is the directory of local helm repo env
+const helmOrganizationName = "kok-operator"
+
+// cacheSyncTimeout is the max waiting time of the readiness probe
+const cacheSyncTimeout = 3 * time.Second
+
 // AddToManagerFuncs is a list of functions to add all Controllers to the Manager
 var AddToManagerFuncs []func(manager.Manager) error
 
 var AddToManagerWithProviderFuncs []func(manager.Manager, *gmanager.GManager) error
 
 // AddToManager adds all Controllers to the Manager
-func AddToManager(mgr manager.Manager, opt *option.ControllersManagerOption, config *config.Config) error {
+func AddToManager(mgr manager.Manager, opt *option.ControllersManagerOption, serverOpt *option.ServerOption, config *config.Config) error {
 	if opt.EnableCluster {
 		AddToManagerWithProviderFuncs = append(AddToManagerWithProviderFuncs, cluster.Add)
 	}
@@ -31,6 +47,10 @@ func AddToManager(mgr manager.Manager, opt *option.ControllersManagerOption, con
 		AddToManagerWithProviderFuncs = append(AddToManagerWithProviderFuncs, addons.Add)
 	}
 
+	if opt.EnableWebhook {
+		AddToManagerWithProviderFuncs = append(AddToManagerWithProviderFuncs, webhook.Add)
+	}
+
 	pMgr, err := provider.NewProvider(config)
 	if err != nil {
 		return err
@@ -40,10 +60,16 @@ func AddToManager(mgr manager.Manager, opt *option.ControllersManagerOption, con
 		Cluster: mgr,
 	})
 
+	helmEnv, err := helmv3.InitHelmRepoEnv(helmOrganizationName, nil)
+	if err != nil {
+		return errors.Wrap(err, "init helm env")
+	}
+
 	gMgr := &gmanager.GManager{
 		ProviderManager: pMgr,
 		ClusterManager:  k8sMgr,
 		Config:          config,
+		HelmEnv:         helmEnv,
 	}
 	for _, f := range AddToManagerFuncs {
 		if err := f(mgr); err != nil {
@@ -57,6 +83,41 @@ func AddToManager(mgr manager.Manager, opt *option.ControllersManagerOption, con
 		}
 	}
 
+	if err := addObserve(mgr); err != nil {
+		return err
+	}
+
 	mgr.Add(gMgr.ClusterManager)
+	mgr.Add(helmv3.NewDefaultHelmIndexSyncer(helmEnv))
+
+	if serverOpt != nil && serverOpt.BindAddress != "" {
+		srv, err := server.New(serverOpt, mgr.GetClient(), gMgr)
+		if err != nil {
+			return errors.Wrap(err, "new operator api")
+		}
+		if err := mgr.Add(srv); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// addObserve registers the state metrics and the health probes, the operator is ready when the cache is synced.
+func addObserve(mgr manager.Manager) error {
+	if err := collector.Register(mgr.GetCache()); err != nil {
+		return errors.Wrap(err, "register metrics collector")
+	}
+
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return errors.Wrap(err, "add healthz check")
+	}
+
+	return mgr.AddReadyzCheck("cache", func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), cacheSyncTimeout)
+		defer cancel()
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return errors.New("cache is not synced")
+		}
+		return nil
+	})
+}
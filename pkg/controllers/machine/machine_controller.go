@@ -6,15 +6,21 @@ import (
 	"time"
 
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/gmanager"
+	"github.com/wtxue/kok-operator/pkg/provider/baremetal/validation"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -27,9 +33,10 @@ const (
 // machineReconciler reconciles a machine object
 type machineReconciler struct {
 	client.Client
-	Mgr    manager.Manager
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Mgr      manager.Manager
+	Scheme   *runtime.Scheme
+	Log      logr.Logger
+	Recorder record.EventRecorder
 	*gmanager.GManager
 }
 
@@ -48,6 +55,7 @@ func Add(mgr manager.Manager, pMgr *gmanager.GManager) error {
 		Mgr:      mgr,
 		Scheme:   mgr.GetScheme(),
 		Log:      logf.Log.WithName(controllerName),
+		Recorder: mgr.GetEventRecorderFor(controllerName),
 		GManager: pMgr,
 	}
 
@@ -60,8 +68,11 @@ func Add(mgr manager.Manager, pMgr *gmanager.GManager) error {
 }
 
 func (r *machineReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// every machine is a host to provision, they are reconciled in parallel. The status updates
+	// are ignored, the failed steps are requeued with backoff instead.
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&devopsv1.Machine{}).
+		For(&devopsv1.Machine{}, builder.WithPredicates(common.SpecChangedPredicate())).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.Concurrency()}).
 		Complete(r)
 }
 
@@ -97,6 +108,22 @@ func (r *machineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	}
 
 	logger = logger.WithValues("cluster", m.Spec.ClusterName)
+	if !m.ObjectMeta.DeletionTimestamp.IsZero() {
+		return r.cleanMachine(ctx, logger, m)
+	}
+
+	if !constants.ContainsString(m.ObjectMeta.Finalizers, constants.FinalizersMachine) {
+		logger.Info("set", "finalizers", constants.FinalizersMachine)
+		m.ObjectMeta.Finalizers = append(m.ObjectMeta.Finalizers, constants.FinalizersMachine)
+		err := r.Client.Update(ctx, m)
+		if err != nil {
+			logger.Error(err, "failed to set finalizers")
+			return reconcile.Result{}, err
+		}
+
+		return reconcile.Result{Requeue: true}, nil
+	}
+
 	if m.Spec.Pause == true {
 		logger.Info("machine is Pause")
 		return reconcile.Result{}, nil
@@ -108,7 +135,7 @@ func (r *machineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		return ctrl.Result{}, nil
+		return ctrl.Result{Requeue: true}, nil
 	}
 
 	cluster := &devopsv1.Cluster{}
@@ -122,6 +149,14 @@ func (r *machineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		logger.Error(err, "failed to get cluster")
 		return reconcile.Result{}, err
 	}
+	secrets := common.SecretGetter(ctx, r.Client, m.Namespace)
+	cluster.SetSSHOptions(secrets)
+	if m.Spec.Machine != nil {
+		m.Spec.Machine.SetSSHOptions(cluster.Spec.SSH, secrets)
+	}
+	for _, warning := range validation.MachineWarnings(m) {
+		logger.Info("deprecated field", "warning", warning)
+	}
 
 	if cluster.Status.Phase != devopsv1.ClusterRunning {
 		return reconcile.Result{
@@ -142,7 +177,7 @@ func (r *machineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return reconcile.Result{}, err
 	}
 
-	r.reconcile(&manchineContext{
+	result, _ := r.reconcile(&manchineContext{
 		Ctx:               ctx,
 		Key:               req.NamespacedName,
 		Logger:            logger,
@@ -150,5 +185,5 @@ func (r *machineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		Cluster:           cluster,
 		ClusterCredential: credential,
 	})
-	return ctrl.Result{}, nil
+	return result, nil
 }
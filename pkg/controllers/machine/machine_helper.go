@@ -1,11 +1,18 @@
 package machine
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/go-logr/logr"
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 const (
@@ -14,38 +21,95 @@ const (
 
 	reasonFailedInit   = "FailedInit"
 	reasonFailedUpdate = "FailedUpdate"
+	reasonFailedDelete = "FailedDelete"
+
+	reasonExceededMaxAttempts = "ExceededMaxAttempts"
+	reasonResumed             = "Resumed"
 )
 
-func (r *machineReconciler) onCreate(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
+// onCreate runs the current create step, the failed step is requeued with backoff until it
+// exceeds the max attempts, then the machine turns to failed.
+func (r *machineReconciler) onCreate(ctx *common.ClusterContext, machine *devopsv1.Machine) (ctrl.Result, error) {
+	// the failed step isn't retried before its backoff interval, whatever triggers the reconcile
+	if condition := machine.FailedCondition(); condition != nil {
+		if wait := r.RetryWait(condition.Attempts, condition.LastProbeTime.Time, time.Now()); wait > 0 {
+			ctx.V(4).Info("waiting retry create", "condition", condition.Type, "attempts", condition.Attempts, "after", wait)
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
 	p, err := r.MpManager.GetProvider(ctx.Cluster.Spec.ClusterType)
 	if err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
 
 	err = p.OnCreate(ctx, machine)
 	if err != nil {
 		machine.Status.Message = err.Error()
 		machine.Status.Reason = reasonFailedInit
+		result := ctrl.Result{}
+		if condition := machine.FailedCondition(); condition != nil {
+			result.RequeueAfter = r.retryCreate(ctx, machine, condition)
+		}
 		r.Client.Status().Update(ctx.Ctx, machine)
-		return err
-	}
-
-	condition := machine.Status.Conditions[len(machine.Status.Conditions)-1]
-	if condition.Status == devopsv1.ConditionFalse { // means current condition run into error
-		machine.Status.Message = condition.Message
-		machine.Status.Reason = condition.Reason
-		r.Client.Status().Update(ctx.Ctx, machine)
-		return fmt.Errorf("Provider.OnCreate.%s [Failed] reason: %s message: %s",
-			condition.Type, condition.Reason, condition.Message)
+		return result, err
 	}
 
 	machine.Status.Message = ""
 	machine.Status.Reason = ""
 	err = r.Client.Status().Update(ctx.Ctx, machine)
 	if err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
-	return nil
+	// status updates don't trigger reconcile, requeue to run the next step
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// retryCreate returns the backoff interval to retry the failed condition, or turns the machine
+// to failed when the condition exceeds the max attempts.
+func (r *machineReconciler) retryCreate(ctx *common.ClusterContext, machine *devopsv1.Machine, condition *devopsv1.MachineCondition) time.Duration {
+	if r.ExceedMaxAttempts(condition.Attempts) {
+		machine.Status.Phase = devopsv1.MachineFailed
+		machine.Status.Reason = reasonExceededMaxAttempts
+		machine.Status.Message = fmt.Sprintf("%s failed after %d attempts: %s", condition.Type, condition.Attempts, condition.Message)
+		ctx.Info("give up create", "condition", condition.Type, "attempts", condition.Attempts)
+		ctx.Eventf(machine, corev1.EventTypeWarning, reasonExceededMaxAttempts, "%s, annotate %s to resume", machine.Status.Message, constants.ClusterResume)
+		return 0
+	}
+
+	retry := r.RetryInterval(condition.Attempts)
+	ctx.Info("retry create", "condition", condition.Type, "attempts", condition.Attempts, "after", retry)
+	return retry
+}
+
+// onResume turns the failed machine back to initializing when it's annotated with resume,
+// the failed step is retried with fresh attempts.
+func (r *machineReconciler) onResume(ctx *common.ClusterContext, machine *devopsv1.Machine) (ctrl.Result, error) {
+	if constants.GetMapKey(machine.Annotations, constants.ClusterResume) == "" {
+		ctx.V(4).Info("machine is failed, waiting resume", "message", machine.Status.Message)
+		return ctrl.Result{}, nil
+	}
+
+	ctx.Info("resume failed machine")
+	if condition := machine.FailedCondition(); condition != nil {
+		condition.Attempts = 0
+	}
+	machine.Status.Phase = devopsv1.MachineInitializing
+	machine.Status.Reason = ""
+	machine.Status.Message = ""
+	err := r.Client.Status().Update(ctx.Ctx, machine)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	ctx.Eventf(machine, corev1.EventTypeNormal, reasonResumed, "resumed by annotation %s", constants.ClusterResume)
+
+	objBak := &devopsv1.Machine{}
+	err = r.Client.Get(ctx.Ctx, types.NamespacedName{Name: machine.Name, Namespace: machine.Namespace}, objBak)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	delete(objBak.Annotations, constants.ClusterResume)
+	return ctrl.Result{}, r.Client.Update(ctx.Ctx, objBak)
 }
 
 func (r *machineReconciler) onUpdate(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
@@ -68,7 +132,7 @@ func (r *machineReconciler) onUpdate(ctx *common.ClusterContext, machine *devops
 	return nil
 }
 
-func (r *machineReconciler) reconcile(rc *manchineContext) error {
+func (r *machineReconciler) reconcile(rc *manchineContext) (ctrl.Result, error) {
 	ctx := &common.ClusterContext{
 		Ctx:            rc.Ctx,
 		Cluster:        rc.Cluster,
@@ -76,19 +140,97 @@ func (r *machineReconciler) reconcile(rc *manchineContext) error {
 		Client:         r.Client,
 		ClusterManager: r.ClusterManager,
 		Logger:         rc.Logger,
+		Recorder:       r.Recorder,
 	}
 
 	var err error
 	switch rc.Machine.Status.Phase {
 	case devopsv1.MachineInitializing:
 		rc.Logger.Info("onCreate")
-		err = r.onCreate(ctx, rc.Machine)
+		return r.onCreate(ctx, rc.Machine)
 	case devopsv1.MachineRunning:
 		rc.Logger.Info("onUpdate")
 		err = r.onUpdate(ctx, rc.Machine)
+	case devopsv1.MachineFailed:
+		return r.onResume(ctx, rc.Machine)
 	default:
 		err = fmt.Errorf("no handler for %q", rc.Cluster.Status.Phase)
 	}
 
-	return err
+	return ctrl.Result{}, err
+}
+
+// cleanMachine run the delete handlers of provider to clean the node, and remove the finalizer.
+// The node is not touched when it's orphan or the cluster is gone, failures are retried with backoff.
+func (r *machineReconciler) cleanMachine(ctx context.Context, logger logr.Logger, m *devopsv1.Machine) (ctrl.Result, error) {
+	if !constants.ContainsString(m.ObjectMeta.Finalizers, constants.FinalizersMachine) {
+		return ctrl.Result{}, nil
+	}
+
+	if m.Status.Phase != devopsv1.MachineTerminating {
+		m.Status.Phase = devopsv1.MachineTerminating
+		return ctrl.Result{Requeue: true}, r.Client.Status().Update(ctx, m)
+	}
+
+	key := types.NamespacedName{Name: m.Spec.ClusterName, Namespace: m.Namespace}
+	cluster := &devopsv1.Cluster{}
+	err := r.Client.Get(ctx, key, cluster)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	secrets := common.SecretGetter(ctx, r.Client, m.Namespace)
+	cluster.SetSSHOptions(secrets)
+	if m.Spec.Machine != nil {
+		m.Spec.Machine.SetSSHOptions(cluster.Spec.SSH, secrets)
+	}
+
+	switch {
+	case apierrors.IsNotFound(err):
+		logger.Info("cluster is gone, skip clean node")
+	case constants.IsOrphanHosts(m.Annotations) || constants.IsOrphanHosts(cluster.Annotations):
+		logger.Info("orphan hosts, skip clean node")
+	default:
+		credential := &devopsv1.ClusterCredential{}
+		err = r.Client.Get(ctx, key, credential)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+
+		p, err := r.MpManager.GetProvider(cluster.Spec.ClusterType)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		clusterCtx := &common.ClusterContext{
+			Ctx:            ctx,
+			Key:            key,
+			Cluster:        cluster,
+			Credential:     credential,
+			Client:         r.Client,
+			ClusterManager: r.ClusterManager,
+			Logger:         logger,
+			Recorder:       r.Recorder,
+		}
+		err = p.OnDelete(clusterCtx, m)
+		if err != nil {
+			logger.Error(err, "failed to clean node")
+			m.Status.Reason = reasonFailedDelete
+			m.Status.Message = err.Error()
+			if updateErr := r.Client.Status().Update(ctx, m); updateErr != nil {
+				logger.Error(updateErr, "failed to update machine status")
+				return ctrl.Result{}, updateErr
+			}
+			return ctrl.Result{}, err
+		}
+	}
+
+	m.ObjectMeta.Finalizers = constants.RemoveString(m.ObjectMeta.Finalizers, constants.FinalizersMachine)
+	err = r.Client.Update(ctx, m)
+	if err != nil {
+		logger.Error(err, "failed to remove finalizers")
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("clean machine successfully")
+	return ctrl.Result{}, nil
 }
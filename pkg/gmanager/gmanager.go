@@ -2,6 +2,7 @@ package gmanager
 
 import (
 	"github.com/wtxue/kok-operator/pkg/clustermanager"
+	helmv3 "github.com/wtxue/kok-operator/pkg/helm/v3"
 	"github.com/wtxue/kok-operator/pkg/provider"
 	"github.com/wtxue/kok-operator/pkg/provider/config"
 )
@@ -10,4 +11,7 @@ type GManager struct {
 	*provider.ProviderManager
 	*clustermanager.ClusterManager
 	*config.Config
+
+	// HelmEnv is the local helm repo env shared by all controllers
+	HelmEnv *helmv3.HelmEnv
 }
@@ -312,7 +312,6 @@ func NewHelmEnv(helmEnv *HelmEnv, kubeConfig []byte, ns string, kubecli kubernet
 		return nil, errors.Wrapf(err, "failed to new restClientGetter")
 	}
 
-	helmEnv.RESTClientGetter = restClientGetter
 	return &HelmEnv{
 		KubeCache:        helmEnv.KubeCache,
 		Cli:              helmEnv.Cli,
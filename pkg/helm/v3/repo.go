@@ -1,12 +1,14 @@
 package v3
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -28,6 +30,9 @@ const (
 // ErrRepoNotFound describe an error if helm repository not found
 var ErrRepoNotFound = errors.New("helm repository not found!")
 
+// repoLock serializes the writes of repositories file and index cache
+var repoLock sync.Mutex
+
 // HelmIndexSyncer sync helm repo index repeatedly
 type HelmIndexSyncer struct {
 	HelmEnv *HelmEnv
@@ -404,6 +409,122 @@ func ChartsGet(helmEnv *cli.EnvSettings, repos, chart, Keyword, version string)
 	return chartVersionsSlice, nil
 }
 
+// EnsureRepo adds the repo if it's not found, url is required only when the repo is added.
+// The existing repo is updated and its index is downloaded again if the url or credentials of entry differ.
+func EnsureRepo(env *cli.EnvSettings, entry *repo.Entry) error {
+	repoLock.Lock()
+	defer repoLock.Unlock()
+
+	entries, _ := ReposGet(env)
+	for _, e := range entries {
+		if e.Name != entry.Name {
+			continue
+		}
+		if entry.URL == "" || !repoEntryChanged(e, entry) {
+			return nil
+		}
+
+		klog.Infof("update helm repo: %s, url: %s", entry.Name, entry.URL)
+		if err := reposReplace(env, entry); err != nil {
+			return errors.Wrapf(err, "update helm repo %s", entry.Name)
+		}
+		return nil
+	}
+
+	if entry.URL == "" {
+		return fmt.Errorf("helm repo %s not found and url is empty", entry.Name)
+	}
+
+	klog.Infof("add helm repo: %s, url: %s", entry.Name, entry.URL)
+	_, err := ReposAdd(env, entry)
+	if err != nil {
+		return errors.Wrapf(err, "add helm repo %s", entry.Name)
+	}
+	return nil
+}
+
+// ClusterRepoName returns the name of the repo entry owned by the cluster, so the clusters never share
+// or overwrite the entries of each other. "_" is not allowed in the names of namespace and cluster.
+func ClusterRepoName(namespace, cluster, name string) string {
+	return fmt.Sprintf("%s_%s_%s", namespace, cluster, name)
+}
+
+// EnsureClusterRepo ensures the repo entry owned by the cluster and returns its name to reference the charts,
+// the repo configured by the operator is used as is if url is empty.
+func EnsureClusterRepo(env *cli.EnvSettings, namespace, cluster string, entry *repo.Entry) (string, error) {
+	if entry.URL == "" {
+		if strings.Contains(entry.Name, "_") {
+			return "", fmt.Errorf("helm repo %s is owned by other cluster, url is required", entry.Name)
+		}
+		return entry.Name, EnsureRepo(env, entry)
+	}
+
+	owned := *entry
+	owned.Name = ClusterRepoName(namespace, cluster, entry.Name)
+	return owned.Name, EnsureRepo(env, &owned)
+}
+
+// RemoveClusterRepos removes the repo entries owned by the cluster.
+func RemoveClusterRepos(env *cli.EnvSettings, namespace, cluster string) error {
+	repoLock.Lock()
+	defer repoLock.Unlock()
+
+	entries, err := ReposGet(env)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil
+		}
+		return err
+	}
+
+	prefix := ClusterRepoName(namespace, cluster, "")
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name, prefix) {
+			continue
+		}
+		err = ReposDelete(env, e.Name)
+		if err != nil && err != ErrRepoNotFound {
+			return errors.Wrapf(err, "remove helm repo %s", e.Name)
+		}
+	}
+	return nil
+}
+
+// repoEntryChanged returns true if the url or credentials of the repo are changed.
+func repoEntryChanged(old, cur *repo.Entry) bool {
+	return old.URL != cur.URL ||
+		old.Username != cur.Username ||
+		old.Password != cur.Password ||
+		old.CertFile != cur.CertFile ||
+		old.KeyFile != cur.KeyFile ||
+		old.CAFile != cur.CAFile ||
+		old.InsecureSkipTLSverify != cur.InsecureSkipTLSverify ||
+		old.PassCredentialsAll != cur.PassCredentialsAll
+}
+
+// reposReplace downloads the index of the repo and replaces the entry of the same name.
+func reposReplace(env *cli.EnvSettings, c *repo.Entry) error {
+	repoPath := GetRepoFilePath(env)
+	f, err := repo.LoadFile(repoPath)
+	if err != nil {
+		return errors.Wrap(err, "Load ChartRepo")
+	}
+
+	r, err := NewChartRepositoryWarp(c, env)
+	if err != nil {
+		return errors.Wrap(err, "Cannot create a new ChartRepo")
+	}
+
+	if _, errIdx := r.DownloadIndexFile(); errIdx != nil {
+		return errors.Wrap(errIdx, "Repo index download failed")
+	}
+	f.Update(c)
+	if errW := f.WriteFile(repoPath, 0644); errW != nil {
+		return errors.Wrap(errW, "Cannot write helm repo profile file")
+	}
+	return nil
+}
+
 func NewDefaultHelmIndexSyncer(helmEnv *HelmEnv) *HelmIndexSyncer {
 	return &HelmIndexSyncer{
 		HelmEnv:  helmEnv,
@@ -411,9 +532,13 @@ func NewDefaultHelmIndexSyncer(helmEnv *HelmEnv) *HelmIndexSyncer {
 	}
 }
 
-func (h *HelmIndexSyncer) Start(stop <-chan struct{}) error {
-	wait.Until(func() {
+// Start implements manager.Runnable, it updates the index of all repos until the context is done.
+func (h *HelmIndexSyncer) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
 		klog.V(4).Infof("update helm repo index, time: %v", time.Now())
+		repoLock.Lock()
+		defer repoLock.Unlock()
+
 		entrys, err := ReposGet(h.HelmEnv.Cli)
 		if err != nil {
 			klog.Errorf("get all repo err: %+v", err)
@@ -424,14 +549,14 @@ func (h *HelmIndexSyncer) Start(stop <-chan struct{}) error {
 			err := ReposUpdate(h.HelmEnv.Cli, e.Name)
 			if err != nil {
 				klog.Errorf("update repo: %s err: %+v", e.Name, err)
-				return
+				continue
 			}
-
-			// chrts, err := ChartsGet(h.HelmEnv.Cli, e.Name, "", "", "")
-			// if err == nil {
-			// 	klog.Infof("charts len:%d", len(chrts))
-			// }
 		}
-	}, time.Second*time.Duration(h.Interval), stop)
+	}, time.Second*time.Duration(h.Interval))
 	return nil
 }
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, the local index is synced by all replicas.
+func (h *HelmIndexSyncer) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,118 @@
+package v3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+func newRepoServer(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/index.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("apiVersion: v1\nentries: {}\n"))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestEnsureRepo(t *testing.T) {
+	dir := t.TempDir()
+	env := &cli.EnvSettings{
+		RepositoryConfig: filepath.Join(dir, "repositories.yaml"),
+		RepositoryCache:  filepath.Join(dir, "cache"),
+	}
+	old, cur := newRepoServer(t), newRepoServer(t)
+
+	if err := EnsureRepo(env, &repo.Entry{Name: "stable"}); err == nil {
+		t.Fatal("expect error without url")
+	}
+	if err := EnsureRepo(env, &repo.Entry{Name: "stable", URL: old.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		entry *repo.Entry
+		url   string
+		user  string
+	}{
+		{name: "empty url keeps the entry", entry: &repo.Entry{Name: "stable"}, url: old.URL},
+		{name: "url changed", entry: &repo.Entry{Name: "stable", URL: cur.URL}, url: cur.URL},
+		{name: "credentials changed", entry: &repo.Entry{Name: "stable", URL: cur.URL, Username: "admin"}, url: cur.URL, user: "admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := EnsureRepo(env, tt.entry); err != nil {
+				t.Fatal(err)
+			}
+			f, err := repo.LoadFile(env.RepositoryConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := f.Get("stable")
+			if len(f.Repositories) != 1 || e.URL != tt.url || e.Username != tt.user {
+				t.Errorf("unexpected repos %+v", f.Repositories)
+			}
+		})
+	}
+
+	cur.Close()
+	if err := EnsureRepo(env, &repo.Entry{Name: "stable", URL: cur.URL + "/broken"}); err == nil {
+		t.Error("expect error if the index of the new url can't be downloaded")
+	}
+	f, _ := repo.LoadFile(env.RepositoryConfig)
+	if e := f.Get("stable"); e.URL != cur.URL {
+		t.Errorf("expect the entry kept on failure, got %+v", e)
+	}
+}
+
+func TestEnsureClusterRepo(t *testing.T) {
+	dir := t.TempDir()
+	env := &cli.EnvSettings{
+		RepositoryConfig: filepath.Join(dir, "repositories.yaml"),
+		RepositoryCache:  filepath.Join(dir, "cache"),
+	}
+	a, b := newRepoServer(t), newRepoServer(t)
+
+	nameA, err := EnsureClusterRepo(env, "tenant-a", "demo", &repo.Entry{Name: "stable", URL: a.URL, Username: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nameB, err := EnsureClusterRepo(env, "tenant-b", "demo", &repo.Entry{Name: "stable", URL: b.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nameA == nameB {
+		t.Fatalf("expect the entries of clusters isolated, got %s", nameA)
+	}
+
+	f, err := repo.LoadFile(env.RepositoryConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := f.Get(nameA); e == nil || e.URL != a.URL || e.Username != "a" {
+		t.Errorf("unexpected entry of tenant-a %+v", e)
+	}
+	if e := f.Get(nameB); e == nil || e.URL != b.URL || e.Username != "" {
+		t.Errorf("unexpected entry of tenant-b %+v", e)
+	}
+
+	if _, err := EnsureClusterRepo(env, "tenant-b", "demo", &repo.Entry{Name: nameA}); err == nil {
+		t.Errorf("expect error referencing the repo of other cluster")
+	}
+
+	if err := RemoveClusterRepos(env, "tenant-a", "demo"); err != nil {
+		t.Fatal(err)
+	}
+	f, _ = repo.LoadFile(env.RepositoryConfig)
+	if f.Has(nameA) || !f.Has(nameB) {
+		t.Errorf("expect only the repos of tenant-a removed, got %+v", f.Repositories)
+	}
+}
@@ -219,6 +219,11 @@ func GetAPIServerCertSANs(cls *devopsv1.Cluster) []string {
 		certSANs.Insert(address.Host)
 	}
 
+	// the new masters are not in the addresses until scaled
+	for _, m := range cls.Spec.Machines {
+		certSANs.Insert(m.IP)
+	}
+
 	svcName := constants.GenComponentName(cls.GetName(), constants.KubeApiServer)
 	certSANs.Insert(svcName)
 
@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	logger = logf.Log.WithName("collector")
+)
+
+const (
+	namespace   = "kok"
+	listTimeout = 10 * time.Second
+)
+
+var (
+	clusterPhaseDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "cluster_phase"),
+		"The current phase of the cluster.",
+		[]string{"namespace", "cluster", "phase"}, nil,
+	)
+	machinePhaseDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "machine_phase"),
+		"The current phase of the machine.",
+		[]string{"namespace", "machine", "cluster", "phase"}, nil,
+	)
+	certExpiryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "certificate_expiry_timestamp_seconds"),
+		"The expiry time of the certificates in the cluster credential.",
+		[]string{"namespace", "cluster", "cert"}, nil,
+	)
+)
+
+// stateCollector reads the clusters, machines and credentials from the cache when scraped,
+// so the deleted objects are never reported.
+type stateCollector struct {
+	reader client.Reader
+}
+
+// Register registers the collector of cluster phases, machine phases and
+// certificate expiry into the metrics registry of manager.
+func Register(reader client.Reader) error {
+	return metrics.Registry.Register(&stateCollector{reader: reader})
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- clusterPhaseDesc
+	ch <- machinePhaseDesc
+	ch <- certExpiryDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout)
+	defer cancel()
+
+	clusters := &devopsv1.ClusterList{}
+	if err := c.reader.List(ctx, clusters); err != nil {
+		logger.Error(err, "failed to list clusters")
+	} else {
+		for i := range clusters.Items {
+			cls := &clusters.Items[i]
+			ch <- prometheus.MustNewConstMetric(clusterPhaseDesc, prometheus.GaugeValue, 1,
+				cls.Namespace, cls.Name, string(cls.Status.Phase))
+		}
+	}
+
+	machines := &devopsv1.MachineList{}
+	if err := c.reader.List(ctx, machines); err != nil {
+		logger.Error(err, "failed to list machines")
+	} else {
+		for i := range machines.Items {
+			m := &machines.Items[i]
+			ch <- prometheus.MustNewConstMetric(machinePhaseDesc, prometheus.GaugeValue, 1,
+				m.Namespace, m.Name, m.Spec.ClusterName, string(m.Status.Phase))
+		}
+	}
+
+	credentials := &devopsv1.ClusterCredentialList{}
+	if err := c.reader.List(ctx, credentials); err != nil {
+		logger.Error(err, "failed to list cluster credentials")
+		return
+	}
+	for i := range credentials.Items {
+		cred := &credentials.Items[i]
+		for name, expiry := range CertificateExpiry(cred) {
+			ch <- prometheus.MustNewConstMetric(certExpiryDesc, prometheus.GaugeValue, float64(expiry.Unix()),
+				cred.Namespace, cred.Name, name)
+		}
+	}
+}
+
+// CertificateExpiry returns the expiry time of the certificates in the credential, including
+// the client certs of kubeconfigs.
+func CertificateExpiry(cred *devopsv1.ClusterCredential) map[string]time.Time {
+	result := make(map[string]time.Time)
+	add := func(name string, data []byte) {
+		if len(data) == 0 {
+			return
+		}
+		certs, err := certutil.ParseCertsPEM(data)
+		if err != nil || len(certs) == 0 {
+			return
+		}
+		result[name] = certs[0].NotAfter
+	}
+
+	add("ca", cred.CACert)
+	add("client", cred.ClientCert)
+	add("etcd-ca", cred.ETCDCACert)
+	add("apiserver-etcd-client", cred.ETCDAPIClientCert)
+	for name, data := range cred.CertsBinaryData {
+		if strings.HasSuffix(name, ".crt") {
+			add(name, data)
+		}
+	}
+	for name, data := range cred.KubeData {
+		cfg, err := clientcmd.Load([]byte(data))
+		if err != nil {
+			continue
+		}
+		for _, auth := range cfg.AuthInfos {
+			add(name, auth.ClientCertificateData)
+		}
+	}
+
+	return result
+}
+
+// IsCA returns true if the cert name of CertificateExpiry is a CA, which isn't renewed with the
+// leaf certs.
+func IsCA(name string) bool {
+	return name == "ca" || name == "etcd-ca" || strings.HasSuffix(path.Base(name), "ca.crt")
+}
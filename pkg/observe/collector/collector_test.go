@@ -0,0 +1,60 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/k8sclient"
+	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	certutil "k8s.io/client-go/util/cert"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCollect(t *testing.T) {
+	caCert, _, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config: certutil.Config{CommonName: "kubernetes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred := &devopsv1.ClusterCredential{
+		ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"},
+		CredentialInfo: devopsv1.CredentialInfo{
+			CACert: pkiutil.EncodeCertPEM(caCert),
+			CertsBinaryData: map[string][]byte{
+				"/etc/kubernetes/pki/ca.crt": pkiutil.EncodeCertPEM(caCert),
+				"/etc/kubernetes/pki/ca.key": []byte("ignored"),
+			},
+		},
+	}
+
+	expiry := CertificateExpiry(cred)
+	if len(expiry) != 2 {
+		t.Fatalf("expect 2 certs, got %v", expiry)
+	}
+	if !expiry["ca"].Equal(caCert.NotAfter) || expiry["ca"].Before(time.Now()) {
+		t.Errorf("unexpected expiry of ca %s", expiry["ca"])
+	}
+
+	cli := fake.NewClientBuilder().WithScheme(k8sclient.GetScheme()).WithObjects(
+		cred,
+		&devopsv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"},
+			Status:     devopsv1.ClusterStatus{Phase: devopsv1.ClusterRunning},
+		},
+		&devopsv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "ns1"},
+			Spec:       devopsv1.MachineSpec{ClusterName: "c1"},
+			Status:     devopsv1.MachineStatus{Phase: devopsv1.MachineRunning},
+		},
+	).Build()
+
+	count := testutil.CollectAndCount(&stateCollector{reader: cli})
+	if count != 4 {
+		t.Errorf("expect 4 metrics, got %d", count)
+	}
+}
@@ -0,0 +1,84 @@
+package observe
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "kok"
+
+var (
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_handler_duration_seconds",
+		Help:      "Duration of the provider handlers.",
+		Buckets:   []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"kind", "provider", "operation", "handler"})
+
+	handlerFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_handler_failures_total",
+		Help:      "Total number of the provider handler failures.",
+	}, []string{"kind", "provider", "operation", "handler"})
+
+	sshCommandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ssh_command_duration_seconds",
+		Help:      "Duration of the commands executed through ssh.",
+		Buckets:   []float64{0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300},
+	}, []string{"host"})
+
+	sshCommandErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_command_errors_total",
+		Help:      "Total number of the ssh commands failed to run or exit with non-zero code.",
+	}, []string{"host", "reason"})
+
+	clusterHealth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cluster_health",
+		Help:      "The last health check result of the cluster added to cluster manager, 1 is healthy.",
+	}, []string{"cluster"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		handlerDuration,
+		handlerFailures,
+		sshCommandDuration,
+		sshCommandErrors,
+		clusterHealth,
+	)
+}
+
+// ObserveHandler records the duration of the handler started at start, and the failure if err is not nil.
+func ObserveHandler(kind, provider, operation, handler string, start time.Time, err error) {
+	handlerDuration.WithLabelValues(kind, provider, operation, handler).Observe(time.Since(start).Seconds())
+	if err != nil {
+		handlerFailures.WithLabelValues(kind, provider, operation, handler).Inc()
+	}
+}
+
+// ObserveSSHCommand records the duration of the command started at start, reason is empty when succeeded.
+func ObserveSSHCommand(host string, start time.Time, reason string) {
+	sshCommandDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+	if reason != "" {
+		sshCommandErrors.WithLabelValues(host, reason).Inc()
+	}
+}
+
+// SetClusterHealth records the health check result of the cluster.
+func SetClusterHealth(cluster string, healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	clusterHealth.WithLabelValues(cluster).Set(v)
+}
+
+// DeleteClusterHealth removes the cluster which is deleted from cluster manager.
+func DeleteClusterHealth(cluster string) {
+	clusterHealth.DeleteLabelValues(cluster)
+}
@@ -5,18 +5,27 @@ import (
 )
 
 type ControllersManagerOption struct {
-	EnableManagerCrds bool
-	EnableCluster     bool
-	EnableMachine     bool
-	EnableAddons      bool
+	EnableManagerCrds      bool
+	EnableCluster          bool
+	EnableMachine          bool
+	EnableAddons           bool
+	MetricsBindAddress     string
+	HealthProbeBindAddress string
+	// EnableWebhook serves the admission webhooks of clusters and machines
+	EnableWebhook  bool
+	WebhookPort    int
+	WebhookCertDir string
 }
 
 func DefaultControllersManagerOption() *ControllersManagerOption {
 	return &ControllersManagerOption{
-		EnableCluster:     true,
-		EnableMachine:     true,
-		EnableAddons:      true,
-		EnableManagerCrds: true,
+		EnableCluster:          true,
+		EnableMachine:          true,
+		EnableAddons:           true,
+		EnableManagerCrds:      true,
+		MetricsBindAddress:     ":8090",
+		HealthProbeBindAddress: ":8091",
+		WebhookPort:            9443,
 	}
 }
 
@@ -25,4 +34,9 @@ func (o *ControllersManagerOption) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&o.EnableCluster, "enable-cluster", o.EnableCluster, "Enables the Cluster controller manager")
 	fs.BoolVar(&o.EnableMachine, "enable-machine", o.EnableMachine, "Enables the Machine controller manager")
 	fs.BoolVar(&o.EnableAddons, "enable-addons", o.EnableAddons, "Enables the cluster addons controller manager")
+	fs.StringVar(&o.MetricsBindAddress, "metrics-bind-address", o.MetricsBindAddress, "The address the prometheus metrics endpoint binds to, 0 means disabled")
+	fs.StringVar(&o.HealthProbeBindAddress, "health-probe-bind-address", o.HealthProbeBindAddress, "The address the /healthz and /readyz endpoints bind to, 0 means disabled")
+	fs.BoolVar(&o.EnableWebhook, "enable-webhook", o.EnableWebhook, "Enables the defaulting and validating admission webhooks of clusters and machines")
+	fs.IntVar(&o.WebhookPort, "webhook-port", o.WebhookPort, "The port the admission webhooks serve on")
+	fs.StringVar(&o.WebhookCertDir, "webhook-cert-dir", o.WebhookCertDir, "The directory of the webhook serving cert tls.crt and key tls.key")
 }
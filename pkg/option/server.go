@@ -0,0 +1,37 @@
+package option
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type ServerOption struct {
+	// BindAddress is the https address of the operator api, empty means disabled
+	BindAddress string
+	// TLSCertFile and TLSKeyFile serve https, a self-signed cert is used if empty
+	TLSCertFile string
+	TLSKeyFile  string
+	// ClientCAFile verifies the client certs
+	ClientCAFile string
+	// TokenFile is a csv file of token,user[,scope...] per line, a scope is a namespace or
+	// namespace/cluster the token can access, * means all
+	TokenFile string
+	// KubeconfigTTL is the max lifetime of the downloaded admin kubeconfig
+	KubeconfigTTL time.Duration
+}
+
+func DefaultServerOption() *ServerOption {
+	return &ServerOption{
+		KubeconfigTTL: time.Hour,
+	}
+}
+
+func (o *ServerOption) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.BindAddress, "api-bind-address", o.BindAddress, "The https address of the operator api, such as :8443, empty means disabled")
+	fs.StringVar(&o.TLSCertFile, "api-tls-cert-file", o.TLSCertFile, "The serving cert of the operator api, a self-signed cert is used if empty")
+	fs.StringVar(&o.TLSKeyFile, "api-tls-key-file", o.TLSKeyFile, "The serving key of the operator api")
+	fs.StringVar(&o.ClientCAFile, "api-client-ca-file", o.ClientCAFile, "The ca file to verify the client certs of the operator api")
+	fs.StringVar(&o.TokenFile, "api-token-file", o.TokenFile, "The bearer tokens of the operator api, a csv file of token,user[,scope...] per line, a scope is namespace, namespace/cluster or *")
+	fs.DurationVar(&o.KubeconfigTTL, "api-kubeconfig-ttl", o.KubeconfigTTL, "The max lifetime of the admin kubeconfig downloaded from the operator api")
+}
@@ -0,0 +1,85 @@
+package artifact
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+)
+
+// SupportedArches are the cpu architectures of the hosts in GOARCH naming.
+var SupportedArches = []string{"amd64", "arm64"}
+
+// unameArches maps the machine hardware name of uname to GOARCH.
+var unameArches = map[string]string{
+	"x86_64":  "amd64",
+	"amd64":   "amd64",
+	"aarch64": "arm64",
+	"arm64":   "arm64",
+}
+
+// IsSupportedArch returns true if the hosts of arch are supported.
+func IsSupportedArch(arch string) bool {
+	for _, a := range SupportedArches {
+		if a == arch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseArch returns the GOARCH of the machine hardware name reported by uname -m.
+func ParseArch(machine string) (string, error) {
+	machine = strings.TrimSpace(machine)
+	arch, ok := unameArches[machine]
+	if !ok {
+		return "", errors.Errorf("unsupported cpu arch %q, only support %v", machine, SupportedArches)
+	}
+
+	return arch, nil
+}
+
+// HostArch returns the cpu architecture of the host.
+func HostArch(s ssh.Interface) (string, error) {
+	out, err := s.CombinedOutput("uname -m")
+	if err != nil {
+		return "", errors.Wrapf(err, "node: %s detect cpu arch", s.HostIP())
+	}
+
+	arch, err := ParseArch(string(out))
+	if err != nil {
+		return "", errors.Wrapf(err, "node: %s", s.HostIP())
+	}
+	return arch, nil
+}
+
+// Required returns the names of artifacts installed on every host of the cluster.
+func Required(c *devopsv1.Cluster) []string {
+	names := []string{Kubectl, Kubeadm, Kubelet, K9s, CNI, Crictl}
+	if c.Spec.CRIType == devopsv1.DockerCRI {
+		return append(names, Docker, CRIDockerd)
+	}
+
+	return append(names, Containerd, Runc)
+}
+
+// CheckArch returns error if any required artifact of the cluster version is missing for arch.
+func (r *Repository) CheckArch(ctx context.Context, c *devopsv1.Cluster, arch string) error {
+	var missing []string
+	for _, name := range Required(c) {
+		_, err := r.Lookup(ctx, name, c.Spec.Version, arch)
+		if errors.Is(err, ErrNotFound) {
+			missing = append(missing, name)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("no artifacts %v of kubernetes %s for arch %s", missing, c.Spec.Version, arch)
+	}
+
+	return nil
+}
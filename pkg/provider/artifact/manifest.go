@@ -0,0 +1,119 @@
+package artifact
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// ManifestFile is the path of the manifest in the source.
+const ManifestFile = "manifest.yaml"
+
+// DefaultArch is the architecture of the artifacts in the operator image.
+const DefaultArch = "amd64"
+
+// the names of the artifacts installed on the hosts
+const (
+	Kubectl                = "kubectl"
+	Kubeadm                = "kubeadm"
+	Kubelet                = "kubelet"
+	Etcdctl                = "etcdctl"
+	K9s                    = "k9s"
+	CNI                    = "cni.tgz"
+	Crictl                 = "crictl"
+	Runc                   = "runc"
+	Containerd             = "containerd.tar.gz"
+	Docker                 = "docker.tgz"
+	CRIDockerd             = "cri-dockerd"
+	NvidiaDriver           = "nvidia-driver"
+	NvidiaContainerToolkit = "nvidia-container-toolkit.tar.gz"
+)
+
+// Artifact is a file of the source, Version and Arch empty means the artifact is used by all the
+// kubernetes versions and architectures. ${version} and ${arch} in Path are expanded on lookup.
+type Artifact struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	Path    string `json:"path"`
+	Sha256  string `json:"sha256,omitempty"`
+}
+
+// Manifest lists the artifacts of the source.
+type Manifest struct {
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// ParseManifest parses the manifest of the source, the sha256 is required by each artifact.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{}
+	if err := yaml.UnmarshalStrict(data, m); err != nil {
+		return nil, errors.Wrap(err, "parse manifest")
+	}
+
+	for i, a := range m.Artifacts {
+		if a.Name == "" || a.Path == "" {
+			return nil, errors.Errorf("artifacts[%d]: name and path are required", i)
+		}
+		if sum, err := hex.DecodeString(a.Sha256); err != nil || len(sum) != 32 {
+			return nil, errors.Errorf("artifacts[%d] %s: invalid sha256 %q", i, a.Name, a.Sha256)
+		}
+	}
+
+	return m, nil
+}
+
+// Lookup returns the artifact name of the kubernetes version and arch, the one of exactly version
+// or arch is preferred to the common one.
+func (m *Manifest) Lookup(name, version, arch string) (*Artifact, bool) {
+	var found *Artifact
+	score := -1
+	for i := range m.Artifacts {
+		a := &m.Artifacts[i]
+		if a.Name != name || (a.Version != "" && a.Version != version) || (a.Arch != "" && a.Arch != arch) {
+			continue
+		}
+
+		s := 0
+		if a.Version != "" {
+			s += 2
+		}
+		if a.Arch != "" {
+			s++
+		}
+		if s > score {
+			found, score = a, s
+		}
+	}
+	if found == nil {
+		return nil, false
+	}
+
+	result := *found
+	result.Version, result.Arch = version, arch
+	result.Path = strings.NewReplacer("${version}", version, "${arch}", arch).Replace(found.Path)
+	return &result, true
+}
+
+// legacyManifest is the layout of the artifacts in the operator image without manifest.yaml,
+// which has no checksum and only the binaries of DefaultArch. It's only used with skipVerify, the
+// image ships the manifest generated by hack/gen-artifact-manifest.sh.
+var legacyManifest = &Manifest{
+	Artifacts: []Artifact{
+		{Name: Kubectl, Arch: DefaultArch, Path: "k8s-${version}/bin/kubectl"},
+		{Name: Kubeadm, Arch: DefaultArch, Path: "k8s-${version}/bin/kubeadm"},
+		{Name: Kubelet, Arch: DefaultArch, Path: "k8s-${version}/bin/kubelet"},
+		{Name: Etcdctl, Arch: DefaultArch, Path: "k8s-${version}/bin/etcdctl"},
+		{Name: K9s, Arch: DefaultArch, Path: "k8s/bin/k9s"},
+		{Name: CNI, Arch: DefaultArch, Path: "k8s/bin/cni.tgz"},
+		{Name: Crictl, Arch: DefaultArch, Path: "k8s/bin/crictl"},
+		{Name: Runc, Arch: DefaultArch, Path: "k8s/bin/runc"},
+		{Name: Containerd, Arch: DefaultArch, Path: "k8s/bin/containerd.tar.gz"},
+		{Name: Docker, Arch: DefaultArch, Path: "k8s/bin/docker.tgz"},
+		{Name: CRIDockerd, Arch: DefaultArch, Path: "k8s/bin/cri-dockerd"},
+		{Name: NvidiaDriver, Arch: DefaultArch, Path: "k8s/bin/NVIDIA-Linux-x86_64-515.65.01.run"},
+		{Name: NvidiaContainerToolkit, Arch: DefaultArch, Path: "k8s/bin/nvidia-container-toolkit.tar.gz"},
+	},
+}
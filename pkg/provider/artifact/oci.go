@@ -0,0 +1,220 @@
+package artifact
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	// ociTitleAnnotation is the file name of the layer set by oras push
+	ociTitleAnnotation = "org.opencontainers.image.title"
+)
+
+type ociManifest struct {
+	Layers []struct {
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// ociSource is the oci artifact in registry, the layers are the files titled by the path.
+type ociSource struct {
+	registry   *url.URL
+	repository string
+	reference  string
+	client     *http.Client
+
+	mu     sync.Mutex
+	token  string
+	layers map[string]string
+}
+
+func newOCISource(u *url.URL) (*ociSource, error) {
+	ref := strings.TrimPrefix(u.Path, "/")
+	repository, reference := ref, "latest"
+	if i := strings.LastIndex(ref, "@"); i > 0 {
+		repository, reference = ref[:i], ref[i+1:]
+	} else if i := strings.LastIndex(ref, ":"); i > 0 {
+		repository, reference = ref[:i], ref[i+1:]
+	}
+	if repository == "" {
+		return nil, errors.Errorf("artifact source %q has no repository", u)
+	}
+
+	registry := &url.URL{Scheme: "https", Host: u.Host, User: u.User}
+	if u.Scheme == "oci+http" {
+		registry.Scheme = "http"
+	}
+	return &ociSource{
+		registry:   registry,
+		repository: repository,
+		reference:  reference,
+		client:     http.DefaultClient,
+	}, nil
+}
+
+// Open returns the blob of the layer titled path, the layers are refreshed when the manifest
+// of the source is opened.
+func (s *ociSource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	layers := s.layers
+	s.mu.Unlock()
+	if layers == nil || path == ManifestFile {
+		var err error
+		layers, err = s.fetchLayers(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	digest, ok := layers[path]
+	if !ok {
+		return nil, errors.Wrapf(os.ErrNotExist, "%s/%s:%s has no layer %s", s.registry.Host, s.repository, s.reference, path)
+	}
+
+	resp, err := s.get(ctx, fmt.Sprintf("/v2/%s/blobs/%s", s.repository, digest), "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *ociSource) fetchLayers(ctx context.Context) (map[string]string, error) {
+	resp, err := s.get(ctx, fmt.Sprintf("/v2/%s/manifests/%s", s.repository, s.reference),
+		ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	m := &ociManifest{}
+	if err := json.NewDecoder(resp.Body).Decode(m); err != nil {
+		return nil, errors.Wrapf(err, "decode manifest of %s:%s", s.repository, s.reference)
+	}
+
+	layers := make(map[string]string, len(m.Layers))
+	for _, l := range m.Layers {
+		if title := l.Annotations[ociTitleAnnotation]; title != "" {
+			layers[title] = l.Digest
+		}
+	}
+
+	s.mu.Lock()
+	s.layers = layers
+	s.mu.Unlock()
+	return layers, nil
+}
+
+// get requests the registry, the bearer token is requested with the challenge of registry when
+// it's unauthorized.
+func (s *ociSource) get(ctx context.Context, path, accept string) (*http.Response, error) {
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.registry.Scheme+"://"+s.registry.Host+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		s.mu.Lock()
+		token := s.token
+		s.mu.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if user := s.registry.User; user != nil {
+			password, _ := user.Password()
+			req.SetBasicAuth(user.Username(), password)
+		}
+		return s.client.Do(req)
+	}
+
+	resp, err := do()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := s.login(ctx, challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = do(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// login requests the token of the bearer challenge.
+func (s *ociSource) login(ctx context.Context, challenge string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return errors.Errorf("registry %s unauthorized: %q", s.registry.Host, challenge)
+	}
+
+	params := map[string]string{}
+	for _, kv := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if i := strings.Index(kv, "="); i > 0 {
+			params[strings.TrimSpace(kv[:i])] = strings.Trim(strings.TrimSpace(kv[i+1:]), `"`)
+		}
+	}
+
+	u, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return errors.Errorf("registry %s invalid challenge: %q", s.registry.Host, challenge)
+	}
+	q := u.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", fmt.Sprintf("repository:%s:pull", s.repository))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if user := s.registry.User; user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	result := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return errors.Wrapf(err, "decode token of %s", s.registry.Host)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = result.Token
+	if s.token == "" {
+		s.token = result.AccessToken
+	}
+	return nil
+}
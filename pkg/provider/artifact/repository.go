@@ -0,0 +1,225 @@
+package artifact
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/util/hash"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+)
+
+// ErrNotFound means the artifact isn't in the manifest.
+var ErrNotFound = errors.New("artifact not found")
+
+// Repository looks up the artifacts by the manifest of the source, and verifies the sha256 of
+// them before and after they are copied to the hosts.
+type Repository struct {
+	source   Source
+	cacheDir string
+	// skipVerify allows the local dir without manifest, whose artifacts have no sha256
+	skipVerify bool
+
+	mu       sync.Mutex
+	manifest *Manifest
+}
+
+var (
+	defaultMu   sync.RWMutex
+	defaultRepo = &Repository{source: dirSource("/")}
+)
+
+// New returns the repository of the source url, the remote artifacts are cached in cacheDir.
+// skipVerify allows the local dir without manifest.yaml, whose artifacts aren't verified.
+func New(sourceURL, cacheDir string, skipVerify bool) (*Repository, error) {
+	source, err := NewSource(sourceURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, local := source.(dirSource); !local && cacheDir == "" {
+		return nil, errors.Errorf("artifact source %s: cache dir is required", sourceURL)
+	}
+
+	return &Repository{source: source, cacheDir: cacheDir, skipVerify: skipVerify}, nil
+}
+
+// SetDefault sets the repository of the clusters.
+func SetDefault(r *Repository) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	defaultRepo = r
+}
+
+// ForCluster returns the repository of the cluster, it's the local dir of the debug annotation
+// if it's set.
+func ForCluster(c *devopsv1.Cluster) *Repository {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+
+	if dir := constants.GetMapKey(c.Annotations, constants.ClusterDebugLocalDir); len(dir) > 0 {
+		return &Repository{source: dirSource(dir), skipVerify: defaultRepo.skipVerify}
+	}
+	return defaultRepo
+}
+
+// Lookup returns the artifact name of the kubernetes version and arch. The manifest is reloaded
+// when it's missing, so the artifacts added to the source are found without restart.
+func (r *Repository) Lookup(ctx context.Context, name, version, arch string) (*Artifact, error) {
+	r.mu.Lock()
+	m := r.manifest
+	r.mu.Unlock()
+	if m != nil {
+		if a, ok := m.Lookup(name, version, arch); ok {
+			return a, nil
+		}
+	}
+
+	m, err := r.loadManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a, ok := m.Lookup(name, version, arch)
+	if !ok {
+		return nil, errors.Wrapf(ErrNotFound, "%s of %s/%s", name, version, arch)
+	}
+	return a, nil
+}
+
+// loadManifest loads the manifest of source, the local dir without manifest is the layout of
+// the operator image, which is only allowed by skipVerify.
+func (r *Repository) loadManifest(ctx context.Context) (*Manifest, error) {
+	m := legacyManifest
+	rc, err := r.source.Open(ctx, ManifestFile)
+	if err == nil {
+		defer rc.Close()
+		data, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return nil, errors.Wrap(err, "read artifact manifest")
+		}
+		if m, err = ParseManifest(data); err != nil {
+			return nil, err
+		}
+	} else if _, local := r.source.(dirSource); !local || !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrap(err, "open artifact manifest")
+	} else if !r.skipVerify {
+		return nil, errors.Wrap(err, "open artifact manifest, the artifacts without sha256 are only allowed by --artifact-skip-verify")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.manifest = m
+	return m, nil
+}
+
+// Fetch returns the local file of the artifact, the remote one is downloaded into the cache dir.
+// The sha256 of the file is verified, the one without sha256 fails unless skipVerify.
+func (r *Repository) Fetch(ctx context.Context, a *Artifact) (string, error) {
+	if a.Sha256 == "" && !r.skipVerify {
+		return "", errors.Errorf("artifact %s has no sha256", a.Path)
+	}
+
+	if dir, ok := r.source.(dirSource); ok {
+		filename := dir.LocalPath(a.Path)
+		if a.Sha256 == "" {
+			return filename, nil
+		}
+		return filename, hash.VerifySha256(filename, a.Sha256)
+	}
+
+	// the artifacts without sha256 are only in the local dir
+	filename := filepath.Join(r.cacheDir, strings.ToLower(a.Sha256), path.Base(a.Path))
+	if err := hash.VerifySha256(filename, a.Sha256); err == nil {
+		return filename, nil
+	}
+
+	if err := r.download(ctx, a, filename); err != nil {
+		return "", errors.Wrapf(err, "download %s", a.Path)
+	}
+	return filename, hash.VerifySha256(filename, a.Sha256)
+}
+
+func (r *Repository) download(ctx context.Context, a *Artifact, filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	rc, err := r.source.Open(ctx, a.Path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, rc)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Copy copies the artifact to dst of the host, the sha256 is verified before and after copying.
+func (r *Repository) Copy(ctx context.Context, s ssh.Interface, a *Artifact, dst string) error {
+	src, err := r.Fetch(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	err = s.CopyFile(src, dst)
+	if err != nil {
+		return errors.Wrapf(err, "node: %s copy %s", s.HostIP(), a.Name)
+	}
+
+	if a.Sha256 == "" {
+		return nil
+	}
+	return VerifyRemote(s, dst, a.Sha256)
+}
+
+// VerifyRemote returns error if the sha256 of the file on the host isn't expected.
+func VerifyRemote(s ssh.Interface, filename, expected string) error {
+	out, err := s.CombinedOutput("sha256sum " + filename)
+	if err != nil {
+		return errors.Wrapf(err, "node: %s sha256sum %s", s.HostIP(), filename)
+	}
+
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 || !strings.EqualFold(fields[0], expected) {
+		return errors.Errorf("node: %s %s sha256 mismatch: expected %s, got %q", s.HostIP(), filename, expected, out)
+	}
+	return nil
+}
+
+// Install copies the artifact name of the cluster version and the host arch to dst of the host.
+func Install(ctx *common.ClusterContext, s ssh.Interface, name, dst string) error {
+	arch, err := HostArch(s)
+	if err != nil {
+		return err
+	}
+
+	r := ForCluster(ctx.Cluster)
+	a, err := r.Lookup(ctx.Ctx, name, ctx.Cluster.Spec.Version, arch)
+	if err != nil {
+		return err
+	}
+
+	return r.Copy(ctx.Ctx, s, a, dst)
+}
@@ -0,0 +1,272 @@
+package artifact
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/util/ssh/fake"
+)
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+var testFiles = map[string]string{
+	"v1.24.4/amd64/kubelet": "kubelet v1.24.4",
+	"common/amd64/runc":     "runc",
+}
+
+var testManifest = fmt.Sprintf(`
+artifacts:
+- name: kubelet
+  version: v1.24.4
+  arch: amd64
+  path: v1.24.4/${arch}/kubelet
+  sha256: %s
+- name: runc
+  arch: amd64
+  path: common/${arch}/runc
+  sha256: %s
+`, sha256Hex(testFiles["v1.24.4/amd64/kubelet"]), sha256Hex(testFiles["common/amd64/runc"]))
+
+func TestManifestLookup(t *testing.T) {
+	sum := sha256Hex("")
+	m, err := ParseManifest([]byte(fmt.Sprintf(`
+artifacts:
+- name: kubelet
+  path: k8s-${version}/bin/${arch}/kubelet
+  sha256: %s
+- name: kubelet
+  version: v1.24.4
+  path: v1.24.4/kubelet
+  sha256: %s
+`, sum, sum)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a, ok := m.Lookup("kubelet", "v1.24.4", "amd64"); !ok || a.Path != "v1.24.4/kubelet" {
+		t.Errorf("expect the artifact of version preferred, got %+v", a)
+	}
+	if a, ok := m.Lookup("kubelet", "v1.25.0", "arm64"); !ok || a.Path != "k8s-v1.25.0/bin/arm64/kubelet" {
+		t.Errorf("expect the common artifact expanded, got %+v", a)
+	}
+	if _, ok := m.Lookup("kubeadm", "v1.24.4", "amd64"); ok {
+		t.Errorf("expect kubeadm not found")
+	}
+
+	if _, err := ParseManifest([]byte("artifacts:\n- name: kubelet\n  path: kubelet\n")); err == nil {
+		t.Errorf("expect the artifact without sha256 invalid")
+	}
+}
+
+func TestDirRepository(t *testing.T) {
+	dir := t.TempDir()
+	for p, content := range testFiles {
+		filename := filepath.Join(dir, p)
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		ioutil.WriteFile(filename, []byte(content), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(dir, ManifestFile), []byte(testManifest), 0644)
+
+	r, err := New("file://"+dir, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	a, err := r.Lookup(ctx, "kubelet", "v1.24.4", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := fake.New("10.0.0.1")
+	if err := r.Copy(ctx, s, a, "/usr/bin/kubelet"); err != nil {
+		t.Fatal(err)
+	}
+	if src, _ := s.Copied("/usr/bin/kubelet"); src != filepath.Join(dir, "v1.24.4/amd64/kubelet") {
+		t.Errorf("unexpected source %q", src)
+	}
+
+	mismatch := fake.New("10.0.0.2", fake.Result{Match: "sha256sum", Stdout: sha256Hex("other") + "  /usr/bin/kubelet"})
+	if err := r.Copy(ctx, mismatch, a, "/usr/bin/kubelet"); err == nil {
+		t.Errorf("expect the copied file mismatched")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "v1.24.4/amd64/kubelet"), []byte("tampered"), 0644)
+	if err := r.Copy(ctx, fake.New("10.0.0.3"), a, "/usr/bin/kubelet"); err == nil {
+		t.Errorf("expect the local file mismatched")
+	}
+}
+
+func TestLegacyRepository(t *testing.T) {
+	dir := t.TempDir()
+	verified, err := New(dir, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verified.Lookup(context.Background(), Kubeadm, "v1.24.4", DefaultArch); err == nil {
+		t.Errorf("expect the local dir without manifest rejected")
+	}
+	if _, err := verified.Fetch(context.Background(), &Artifact{Name: Kubeadm, Path: "k8s-v1.24.4/bin/kubeadm"}); err == nil {
+		t.Errorf("expect the artifact without sha256 rejected")
+	}
+
+	r, err := New(dir, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := r.Lookup(context.Background(), Kubeadm, "v1.24.4", DefaultArch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Path != "k8s-v1.24.4/bin/kubeadm" || a.Sha256 != "" {
+		t.Errorf("unexpected legacy artifact %+v", a)
+	}
+	if _, err := r.Fetch(context.Background(), a); err != nil {
+		t.Errorf("expect the artifact without sha256 allowed by skip verify: %v", err)
+	}
+}
+
+func TestHTTPRepository(t *testing.T) {
+	files := map[string]string{ManifestFile: testManifest}
+	for p, content := range testFiles {
+		files[p] = content
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		content, ok := files[strings.TrimPrefix(req.URL.Path, "/artifacts/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	r, err := New(server.URL+"/artifacts", t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRemoteRepository(t, r, &requests)
+}
+
+func TestOCIRepository(t *testing.T) {
+	blobs := map[string]string{}
+	var layers []string
+	add := func(title, content string) {
+		digest := "sha256:" + sha256Hex(content)
+		blobs[digest] = content
+		layers = append(layers, fmt.Sprintf(`{"digest":%q,"annotations":{%q:%q}}`, digest, ociTitleAnnotation, title))
+	}
+	add(ManifestFile, testManifest)
+	for p, content := range testFiles {
+		add(p, content)
+	}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"layers":[%s]}`, strings.Join(layers, ","))
+
+	requests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.URL.Path == "/token" {
+			if req.URL.Query().Get("scope") != "repository:kok/artifacts:pull" {
+				t.Errorf("unexpected scope %q", req.URL.RawQuery)
+			}
+			w.Write([]byte(`{"token":"t1"}`))
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer t1" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case req.URL.Path == "/v2/kok/artifacts/manifests/v1":
+			w.Write([]byte(manifest))
+		case strings.HasPrefix(req.URL.Path, "/v2/kok/artifacts/blobs/"):
+			content, ok := blobs[strings.TrimPrefix(req.URL.Path, "/v2/kok/artifacts/blobs/")]
+			if !ok {
+				http.NotFound(w, req)
+				return
+			}
+			w.Write([]byte(content))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	r, err := New("oci+http://"+strings.TrimPrefix(server.URL, "http://")+"/kok/artifacts:v1", t.TempDir(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testRemoteRepository(t, r, &requests)
+}
+
+// testRemoteRepository verifies the artifacts are downloaded once into the cache.
+func testRemoteRepository(t *testing.T, r *Repository, requests *int) {
+	ctx := context.Background()
+	a, err := r.Lookup(ctx, "runc", "v1.25.0", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := fake.New("10.0.0.1")
+	if err := r.Copy(ctx, s, a, "/usr/local/sbin/runc"); err != nil {
+		t.Fatal(err)
+	}
+	src, _ := s.Copied("/usr/local/sbin/runc")
+	if data, _ := ioutil.ReadFile(src); string(data) != "runc" {
+		t.Errorf("unexpected cached file %s: %q", src, data)
+	}
+
+	cached := *requests
+	if err := r.Copy(ctx, fake.New("10.0.0.2"), a, "/usr/local/sbin/runc"); err != nil {
+		t.Fatal(err)
+	}
+	if *requests != cached {
+		t.Errorf("expect the cached artifact reused, got %d requests", *requests-cached)
+	}
+
+	if _, err := r.Lookup(ctx, "kubelet", "v1.25.0", "amd64"); err == nil {
+		t.Errorf("expect kubelet v1.25.0 not found")
+	}
+}
+
+func TestCheckArch(t *testing.T) {
+	r, err := New(t.TempDir(), "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &devopsv1.Cluster{Spec: devopsv1.ClusterSpec{Version: "v1.24.4"}}
+	if err := r.CheckArch(context.Background(), c, "amd64"); err != nil {
+		t.Errorf("expect the artifacts of amd64 in the image: %v", err)
+	}
+	err = r.CheckArch(context.Background(), c, "arm64")
+	if err == nil || !strings.Contains(err.Error(), Kubelet) {
+		t.Errorf("expect kubelet of arm64 missing, got %v", err)
+	}
+
+	s := fake.New("10.0.0.1", fake.Result{Match: "uname -m", Stdout: "aarch64\n"})
+	if arch, err := HostArch(s); err != nil || arch != "arm64" {
+		t.Errorf("expect arm64, got %q: %v", arch, err)
+	}
+	s = fake.New("10.0.0.2", fake.Result{Match: "uname -m", Stdout: "ppc64le\n"})
+	if _, err := HostArch(s); err == nil {
+		t.Errorf("expect ppc64le unsupported")
+	}
+}
@@ -0,0 +1,95 @@
+package artifact
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Source is where the artifacts are stored.
+type Source interface {
+	// Open returns the content of the file at path, the error is os.ErrNotExist if it's missing.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// NewSource returns the source of the url:
+//   - file:///k8s or /k8s: the local dir of the operator
+//   - http(s)://host/path: the http file server
+//   - oci://registry/repository:tag: the oci artifact pushed by oras, the files are the layers titled
+//     by the path, oci+http is the registry without tls
+func NewSource(rawURL string) (Source, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse artifact source %q", rawURL)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		if u.Path == "" {
+			return nil, errors.Errorf("artifact source %q has no dir", rawURL)
+		}
+		return dirSource(u.Path), nil
+	case "http", "https":
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+		return &httpSource{base: u, client: http.DefaultClient}, nil
+	case "oci", "oci+http":
+		return newOCISource(u)
+	default:
+		return nil, errors.Errorf("unsupported artifact source %q", rawURL)
+	}
+}
+
+// dirSource is the local dir of the operator.
+type dirSource string
+
+func (s dirSource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(s.LocalPath(path))
+}
+
+// LocalPath returns the local file of path.
+func (s dirSource) LocalPath(path string) string {
+	return filepath.Join(string(s), filepath.FromSlash(path))
+}
+
+// httpSource is the http file server, the path is relative to base.
+type httpSource struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func (s *httpSource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	u := s.base.ResolveReference(&url.URL{Path: strings.TrimPrefix(path, "/")})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// checkResponse closes the body and returns the error if the status isn't ok.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errors.Wrapf(os.ErrNotExist, "GET %s", resp.Request.URL)
+	}
+	return errors.Errorf("GET %s: %s", resp.Request.URL, resp.Status)
+}
@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/certs"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/join"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/kubeadm"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/kubemisc"
+)
+
+// EnsureRenewCerts re-issues the leaf certs and kubeconfigs of the credential, the CAs are rotated
+// first when the rotation in status is a step of CA rotation.
+func (p *Provider) EnsureRenewCerts(ctx *common.ClusterContext) error {
+	phase := devopsv1.CertRotationRenewing
+	if ctx.Cluster.Status.Certificates != nil && ctx.Cluster.Status.Certificates.Rotation != "" {
+		phase = ctx.Cluster.Status.Certificates.Rotation
+	}
+
+	ctx.Info("renew certs", "rotation", phase)
+	err := kubeadm.RenewCerts(ctx, kubeadm.GetKubeadmConfigByMaster0(ctx, p.Cfg), false, phase)
+	if err != nil {
+		return err
+	}
+
+	apiserver := certs.BuildApiserverEndpoint(ctx.Cluster.Spec.Machines[0].IP, 6443)
+	return kubemisc.BuildMasterMiscConfigToMap(ctx, apiserver)
+}
+
+// EnsureMasterCerts writes the certs and kubeconfigs to the masters one by one, and restarts
+// the control plane and kubelet to load them.
+func (p *Provider) EnsureMasterCerts(ctx *common.ClusterContext) error {
+	for _, machine := range ctx.Cluster.Spec.Machines {
+		sh, err := machine.SSH()
+		if err != nil {
+			return err
+		}
+
+		for pathFile, va := range ctx.Credential.CertsBinaryData {
+			ctx.Info("write certs binaryData", "node", sh.HostIP(), "file", pathFile)
+			err = sh.WriteFile(bytes.NewReader(va), pathFile)
+			if err != nil {
+				return errors.Wrapf(err, "node: %s write %s", sh.HostIP(), pathFile)
+			}
+		}
+
+		err = kubemisc.CovertMasterKubeConfig(sh, ctx)
+		if err != nil {
+			return err
+		}
+
+		if ctx.Cluster.Spec.Etcd == nil || ctx.Cluster.Spec.Etcd.External == nil {
+			err = kubeadm.RestartContainerByFilter(ctx, sh, kubeadm.LabelFilterForControlPlane("etcd"))
+			if err != nil {
+				return errors.Wrap(err, machine.IP)
+			}
+		}
+
+		err = kubeadm.RestartControlPlane(ctx, sh)
+		if err != nil {
+			return errors.Wrap(err, machine.IP)
+		}
+
+		_, err = sh.CombinedOutput("systemctl restart kubelet")
+		if err != nil {
+			return errors.Wrapf(err, "node: %s restart kubelet", machine.IP)
+		}
+	}
+
+	return nil
+}
+
+// EnsureWorkerCerts writes the CA and kubeconfigs to the running workers, and restarts kubelet.
+func (p *Provider) EnsureWorkerCerts(ctx *common.ClusterContext) error {
+	machines, err := listRunningWorkers(ctx)
+	if err != nil {
+		return err
+	}
+
+	apiserver := certs.BuildApiserverEndpoint(ctx.Cluster.Spec.PublicAlternativeNames[0], kubemisc.GetBindPort(ctx.Cluster))
+	for _, m := range machines {
+		sh, err := m.Spec.SSH()
+		if err != nil {
+			return err
+		}
+
+		fileMaps := map[string]string{
+			constants.CACertName: string(ctx.Credential.CACert),
+		}
+		err = join.BuildKubeletKubeconfig(sh.HostIP(), ctx, apiserver, fileMaps)
+		if err != nil {
+			return err
+		}
+
+		for pathName, va := range fileMaps {
+			ctx.Info("write certs", "node", sh.HostIP(), "file", pathName)
+			err = sh.WriteFile(strings.NewReader(va), pathName)
+			if err != nil {
+				return errors.Wrapf(err, "node: %s write %s", sh.HostIP(), pathName)
+			}
+		}
+
+		err = kubemisc.InstallNode(sh, &kubemisc.Option{
+			MasterEndpoint: apiserver,
+			ClusterName:    ctx.Cluster.Name,
+			CACert:         ctx.Credential.CACert,
+			Token:          *ctx.Credential.Token,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "node: %s write kubeconfig", sh.HostIP())
+		}
+
+		_, err = sh.CombinedOutput("systemctl restart kubelet")
+		if err != nil {
+			return errors.Wrapf(err, "node: %s restart kubelet", sh.HostIP())
+		}
+	}
+
+	return nil
+}
@@ -12,14 +12,20 @@ import (
 	"time"
 
 	"github.com/wtxue/kok-operator/pkg/addons/flannel"
+	"github.com/wtxue/kok-operator/pkg/addons/metallb"
 	"github.com/wtxue/kok-operator/pkg/addons/metricsserver"
 	"github.com/wtxue/kok-operator/pkg/addons/rawcni"
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	workloadv1 "github.com/wtxue/kok-operator/pkg/apis/workload/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/k8sutil"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/certs"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/clean"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/cri"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/etcd"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/gpu"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubeadm"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubebin"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubemisc"
@@ -34,45 +40,70 @@ import (
 	"github.com/segmentio/ksuid"
 	"github.com/thoas/go-funk"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
 	bootstraputil "k8s.io/cluster-bootstrap/token/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 func (p *Provider) EnsureCopyFiles(ctx *common.ClusterContext) error {
-	for _, file := range ctx.Cluster.Spec.Features.Files {
-		for _, machine := range ctx.Cluster.Spec.Machines {
-			machineSSH, err := machine.SSH()
-			if err != nil {
-				return err
-			}
+	if len(ctx.Cluster.Spec.Features.Files) == 0 {
+		return nil
+	}
 
-			err = system.CopyFile(machineSSH, &file)
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, s ssh.Interface) error {
+		for _, file := range ctx.Cluster.Spec.Features.Files {
+			err := system.CopyFile(s, &file)
 			if err != nil {
 				return err
 			}
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
-func (p *Provider) EnsurePreflight(ctx *common.ClusterContext) error {
+// EnsureMachineInfo detects the system info of masters into status, the cpu arch of each master
+// must have the artifacts of the cluster version.
+func (p *Provider) EnsureMachineInfo(ctx *common.ClusterContext) error {
+	var mu sync.Mutex
+	infos := make(map[string]*devopsv1.MachineSystemInfo)
+	err := p.forEachMaster(ctx, func(m *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		info, err := preflight.DetectMachineInfo(ctx, sh, m)
+		if info != nil {
+			mu.Lock()
+			infos[m.IP] = info
+			mu.Unlock()
+		}
+		return err
+	})
+
 	for _, m := range ctx.Cluster.Spec.Machines {
-		sh, err := m.SSH()
-		if err != nil {
-			return err
+		if info, ok := infos[m.IP]; ok {
+			ctx.Cluster.SetMachineInfo(m.IP, *info)
 		}
+	}
+	return err
+}
 
+func (p *Provider) EnsurePreflight(ctx *common.ClusterContext) error {
+	return p.forEachMaster(ctx, func(m *devopsv1.ClusterMachine, sh ssh.Interface) error {
 		ctx.Info("node preflight start ...", "node", m.IP)
-		err = preflight.RunMasterChecks(ctx, sh)
-		if err != nil {
-			ctx.Error(err, "node preflight", "node", m.IP)
-			return errors.Wrap(err, m.IP)
-		}
+		return preflight.RunMasterChecks(ctx, sh)
+	})
+}
+
+// EnsurePreflightReport runs the preflight checks of the masters and the machines into status,
+// the hosts are not changed.
+func (p *Provider) EnsurePreflightReport(ctx *common.ClusterContext) error {
+	hosts, err := preflight.ClusterHosts(ctx)
+	if err != nil {
+		return err
 	}
 
+	ctx.Cluster.Status.Preflight = preflight.Run(ctx, hosts, p.Cfg.Concurrency())
 	return nil
 }
 
@@ -172,19 +203,9 @@ func completeCredential(ctx *common.ClusterContext) error {
 }
 
 func (p *Provider) EnsureBuildLocalKubeconfig(ctx *common.ClusterContext) error {
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		sh, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
-		err = kubemisc.Install(ctx, sh)
-		if err != nil {
-			return errors.Wrap(err, machine.IP)
-		}
-	}
-
-	return nil
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		return kubemisc.Install(ctx, sh)
+	})
 }
 
 func (p *Provider) EnsureKubeadmInitKubeletStartPhase(ctx *common.ClusterContext) error {
@@ -197,18 +218,9 @@ func (p *Provider) EnsureKubeadmInitKubeletStartPhase(ctx *common.ClusterContext
 }
 
 func (p *Provider) EnsureImagesPull(ctx *common.ClusterContext) error {
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		sh, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
-		err = kubeadm.ImagesPull(ctx, sh, ctx.Cluster.Spec.Version, p.Cfg.CustomRegistry)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		return kubeadm.ImagesPull(ctx, sh, ctx.Cluster.Spec.Version, p.Cfg.CustomRegistry)
+	})
 }
 
 func (p *Provider) EnsureCerts(ctx *common.ClusterContext) error {
@@ -218,23 +230,16 @@ func (p *Provider) EnsureCerts(ctx *common.ClusterContext) error {
 		return err
 	}
 
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		sh, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
 		for pathFile, va := range ctx.Credential.CertsBinaryData {
 			ctx.Info("write certs binaryData", "node", sh.HostIP(), "file", pathFile)
-			err = sh.WriteFile(bytes.NewReader(va), pathFile)
+			err := sh.WriteFile(bytes.NewReader(va), pathFile)
 			if err != nil {
-				ctx.Error(err, "write certs binaryData", "node", sh.HostIP(), "file", pathFile)
-				return err
+				return errors.Wrapf(err, "write %s", pathFile)
 			}
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 func (p *Provider) EnsureKubeMiscPhase(ctx *common.ClusterContext) error {
@@ -340,6 +345,8 @@ func (p *Provider) EnsureJoinControlePlane(ctx *common.ClusterContext) error {
 		return nil
 	}
 
+	// it stays sequential, kubeadm adds the etcd member of a joining master and waits for it to be
+	// synced, adding several members at once may lose the quorum of etcd
 	for _, machine := range ctx.Cluster.Spec.Machines[1:] {
 		sh, err := machine.SSH()
 		if err != nil {
@@ -354,7 +361,7 @@ func (p *Provider) EnsureJoinControlePlane(ctx *common.ClusterContext) error {
 
 		_, err = clientset.CoreV1().Nodes().Get(context.TODO(), sh.HostIP(), metav1.GetOptions{})
 		if err == nil {
-			return nil
+			continue
 		}
 
 		// apiserver := certs.BuildApiserverEndpoint(c.Spec.Machines[0].IP, 6443)
@@ -382,53 +389,16 @@ func (p *Provider) EnsureJoinControlePlane(ctx *common.ClusterContext) error {
 }
 
 func (p *Provider) EnsureK8sComponent(ctx *common.ClusterContext) error {
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		sh, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
-		err = kubebin.Install(ctx, sh)
-		if err != nil {
-			return errors.Wrap(err, machine.IP)
-		}
-	}
-
-	return nil
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		return kubebin.Install(ctx, sh)
+	})
 }
 
 func (p *Provider) EnsureSystem(ctx *common.ClusterContext) error {
-	wg := sync.WaitGroup{}
-	quitErrors := make(chan error)
-	wgDone := make(chan struct{})
-	for _, mach := range ctx.Cluster.Spec.Machines {
-		sh, err := mach.SSH()
-		if err != nil {
-			return err
-		}
-
-		wg.Add(1)
-		go func(sh ssh.Interface) {
-			defer wg.Done()
-			err = system.Install(ctx, sh)
-			if err != nil {
-				quitErrors <- errors.Wrap(err, sh.HostIP())
-			}
-		}(sh)
-	}
-
-	go func() {
-		wg.Wait()
-		close(wgDone)
-	}()
-
-	// Wait until either WaitGroup is done or an error is received through the channel
-	select {
-	case <-wgDone:
-		break
-	case err := <-quitErrors:
-		close(quitErrors)
-		ctx.Error(err, "ensure system")
+	err := p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		return system.Install(ctx, sh)
+	})
+	if err != nil {
 		return err
 	}
 
@@ -437,19 +407,9 @@ func (p *Provider) EnsureSystem(ctx *common.ClusterContext) error {
 }
 
 func (p *Provider) EnsureCRI(ctx *common.ClusterContext) error {
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		sh, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
-		err = cri.InstallCRI(ctx, sh)
-		if err != nil {
-			return errors.Wrap(err, machine.IP)
-		}
-	}
-
-	return nil
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		return cri.InstallCRI(ctx, sh)
+	})
 }
 
 func (p *Provider) EnsureKubeadmInitWaitControlPlanePhase(ctx *common.ClusterContext) error {
@@ -483,25 +443,34 @@ func (p *Provider) EnsureMarkControlPlane(ctx *common.ClusterContext) error {
 	}
 
 	for _, machine := range ctx.Cluster.Spec.Machines {
-		if machine.Labels == nil {
-			machine.Labels = make(map[string]string)
+		err := markMaster(ctx, clientset, machine)
+		if err != nil {
+			return err
 		}
+	}
 
-		machine.Labels[constants.LabelNodeRoleMaster] = ""
-		if !ctx.Cluster.Spec.Features.EnableMasterSchedule {
-			taint := corev1.Taint{
-				Key:    constants.LabelNodeRoleMaster,
-				Effect: corev1.TaintEffectNoSchedule,
-			}
-			if !funk.Contains(machine.Taints, taint) {
-				machine.Taints = append(machine.Taints, taint)
-			}
+	return nil
+}
+
+func markMaster(ctx *common.ClusterContext, clientset kubernetes.Interface, machine *devopsv1.ClusterMachine) error {
+	if machine.Labels == nil {
+		machine.Labels = make(map[string]string)
+	}
+
+	machine.Labels[constants.LabelNodeRoleMaster] = ""
+	if !ctx.Cluster.Spec.Features.EnableMasterSchedule {
+		taint := corev1.Taint{
+			Key:    constants.LabelNodeRoleMaster,
+			Effect: corev1.TaintEffectNoSchedule,
 		}
-		err := apiclient.MarkNode(ctx.Ctx, clientset, machine.IP, machine.Labels, machine.Taints)
-		if err != nil {
-			return errors.Wrapf(err, "mark node: %s", machine.IP)
+		if !funk.Contains(machine.Taints, taint) {
+			machine.Taints = append(machine.Taints, taint)
 		}
 	}
+	err := apiclient.MarkNode(ctx.Ctx, clientset, machine.IP, machine.Labels, machine.Taints)
+	if err != nil {
+		return errors.Wrapf(err, "mark node: %s", machine.IP)
+	}
 
 	return nil
 }
@@ -511,76 +480,54 @@ func (p *Provider) EnsureRegistryHosts(ctx *common.ClusterContext) error {
 		return nil
 	}
 
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		return p.setRegistryHosts(ctx, sh)
+	})
+}
+
+func (p *Provider) setRegistryHosts(ctx *common.ClusterContext, s ssh.Interface) error {
 	domains := []string{
 		p.Cfg.Registry.Domain,
 		ctx.Cluster.Spec.TenantID + "." + p.Cfg.Registry.Domain,
 	}
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		sh, err := machine.SSH()
+	for _, one := range domains {
+		remoteHosts := &hosts.RemoteHosts{Host: one, SSH: s}
+		err := remoteHosts.Set(p.Cfg.Registry.IP)
 		if err != nil {
 			return err
 		}
-
-		for _, one := range domains {
-			remoteHosts := &hosts.RemoteHosts{Host: one, SSH: sh}
-			err := remoteHosts.Set(p.Cfg.Registry.IP)
-			if err != nil {
-				return errors.Wrap(err, machine.IP)
-			}
-		}
 	}
 
 	return nil
 }
 
 func (p *Provider) EnsurePreInstallHook(ctx *common.ClusterContext) error {
-	if ctx.Cluster.Spec.Features.Hooks == nil {
-		return nil
-	}
-
-	hook := ctx.Cluster.Spec.Features.Hooks[devopsv1.HookPreInstall]
-	if hook == "" {
-		return nil
-	}
-	cmd := strings.Split(hook, " ")[0]
-
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		s, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
-		s.Execf("chmod +x %s", cmd)
-		_, stderr, exit, err := s.Exec(hook)
-		if err != nil || exit != 0 {
-			return fmt.Errorf("exec %q failed:exit %d:stderr %s:error %s", hook, exit, stderr, err)
-		}
-	}
-	return nil
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, s ssh.Interface) error {
+		return execHook(ctx, s, devopsv1.HookPreInstall)
+	})
 }
 
 func (p *Provider) EnsurePostInstallHook(ctx *common.ClusterContext) error {
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, s ssh.Interface) error {
+		return execHook(ctx, s, devopsv1.HookPostInstall)
+	})
+}
+
+func execHook(ctx *common.ClusterContext, s ssh.Interface, hookType devopsv1.HookType) error {
 	if ctx.Cluster.Spec.Features.Hooks == nil {
 		return nil
 	}
 
-	hook := ctx.Cluster.Spec.Features.Hooks[devopsv1.HookPostInstall]
+	hook := ctx.Cluster.Spec.Features.Hooks[hookType]
 	if hook == "" {
 		return nil
 	}
 	cmd := strings.Split(hook, " ")[0]
 
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		s, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
-		s.Execf("chmod +x %s", cmd)
-		_, stderr, exit, err := s.Exec(hook)
-		if err != nil || exit != 0 {
-			return fmt.Errorf("exec %q failed:exit %d:stderr %s:error %s", hook, exit, stderr, err)
-		}
+	s.Execf("chmod +x %s", cmd)
+	_, stderr, exit, err := s.Exec(hook)
+	if err != nil || exit != 0 {
+		return fmt.Errorf("exec %q failed:exit %d:stderr %s:error %s", hook, exit, stderr, err)
 	}
 	return nil
 }
@@ -593,6 +540,8 @@ func (p *Provider) EnsureRebuildEtcd(ctx *common.ClusterContext) error {
 		etcdClusterEndpoints = append(etcdClusterEndpoints, fmt.Sprintf("https://%s:2379", machine.IP))
 	}
 
+	// it stays sequential, kubelet restarts the etcd member and apiserver of a master once their
+	// manifests are rewritten, rewriting all the masters at once restarts all the members together
 	for _, machine := range ctx.Cluster.Spec.Machines {
 		sh, err := machine.SSH()
 		if err != nil {
@@ -680,12 +629,7 @@ func (p *Provider) EnsureRebuildEtcd(ctx *common.ClusterContext) error {
 
 func (p *Provider) EnsureRebuildControlPlane(ctx *common.ClusterContext) error {
 	// staticPodMap := kubevip.BuildKubeVipStaticPod(ctx)
-	for idx, machine := range ctx.Cluster.Spec.Machines {
-		sh, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
 		// if staticPodMap != nil {
 		// 	for name, file := range staticPodMap {
 		// 		pathName := constants.KubeletPodManifestDir + name
@@ -697,22 +641,18 @@ func (p *Provider) EnsureRebuildControlPlane(ctx *common.ClusterContext) error {
 		// }
 
 		// skip first node
-		if idx < 1 {
-			continue
+		if machine.IP == ctx.Cluster.Spec.Machines[0].IP {
+			return nil
 		}
 
-		err = kubemisc.CovertMasterKubeConfig(sh, ctx)
+		err := kubemisc.CovertMasterKubeConfig(sh, ctx)
 		if err != nil {
 			return err
 		}
 
 		_, _, _, err = sh.Execf("systemctl enable kubelet && systemctl restart kubelet")
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+		return err
+	})
 }
 
 func (p *Provider) EnsureExtKubeconfig(ctx *common.ClusterContext) error {
@@ -721,7 +661,7 @@ func (p *Provider) EnsureExtKubeconfig(ctx *common.ClusterContext) error {
 	}
 
 	apiserver := certs.BuildExternalApiserverEndpoint(ctx)
-	cfgMaps, err := certs.CreateApiserverKubeConfigFile(ctx.Credential.CAKey, ctx.Credential.CACert, apiserver, ctx.Cluster.Name)
+	cfgMaps, err := certs.CreateApiserverKubeConfigFile(ctx.Credential.CAKey, ctx.Credential.CACert, certs.LeafNotAfter(ctx.Cluster), apiserver, ctx.Cluster.Name)
 	if err != nil {
 		ctx.Error(err, "build apiserver kubeconfg")
 		return err
@@ -763,31 +703,95 @@ func (p *Provider) EnsureMetricsServer(ctx *common.ClusterContext) error {
 	return nil
 }
 
-func (p *Provider) EnsureEth(ctx *common.ClusterContext) error {
-	var cniType string
-	var ok bool
+// EnsureLoadBalancer deploys metallb when publicLB or internalLB is enabled, and removes it
+// when both of them are disabled. The metallb addon of the cluster conflicts with them, since
+// both of them manage the namespace metallb-system.
+func (p *Provider) EnsureLoadBalancer(ctx *common.ClusterContext) error {
+	addon, err := metallbAddon(ctx)
+	if err != nil {
+		return err
+	}
+	if addon != "" {
+		if ctx.Cluster.Spec.Features.LoadBalancerEnabled() {
+			return errors.Errorf("publicLB and internalLB conflict with the metallb addon %s, remove one of them", addon)
+		}
+		return nil
+	}
 
-	if cniType, ok = ctx.Cluster.Spec.Features.Hooks[devopsv1.HookCniInstall]; !ok {
+	clusterCtx, err := ctx.ClusterManager.Get(ctx.Cluster.Name)
+	if err != nil {
+		return err
+	}
+	objs, err := metallb.BuildMetalLBAddon(p.Cfg, ctx)
+	if err != nil {
+		return errors.Wrap(err, "build metallb")
+	}
+
+	logger := ctx.WithValues("component", "metallb")
+	if ctx.Cluster.Spec.Features.LoadBalancerEnabled() {
+		logger.Info("start reconcile ...")
+		for _, obj := range objs {
+			err = k8sutil.Reconcile(logger, clusterCtx.GetClient(), obj, k8sutil.DesiredStatePresent)
+			if err != nil {
+				return errors.Wrap(err, "reconcile metallb")
+			}
+		}
 		return nil
 	}
 
-	if cniType != "raw-cni" {
+	ns := &corev1.Namespace{}
+	err = clusterCtx.GetClient().Get(ctx.Ctx, types.NamespacedName{Name: metallb.Namespace}, ns)
+	if apierrors.IsNotFound(err) {
 		return nil
 	}
+	if err != nil {
+		return errors.Wrapf(err, "get namespace %s", metallb.Namespace)
+	}
 
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		sh, err := machine.SSH()
+	// in reverse order, the namespace is the last one
+	logger.Info("start clean ...")
+	for i := len(objs) - 1; i >= 0; i-- {
+		err = k8sutil.Reconcile(logger, clusterCtx.GetClient(), objs[i], k8sutil.DesiredStateAbsent)
 		if err != nil {
-			return err
+			return errors.Wrap(err, "clean metallb")
 		}
+	}
 
-		err = rawcni.ApplyEth(sh, ctx)
-		if err != nil {
-			return err
+	return nil
+}
+
+// metallbAddon returns the name of the metallb addon of the cluster, empty if not found.
+func metallbAddon(ctx *common.ClusterContext) (string, error) {
+	addons := &workloadv1.AddonsList{}
+	err := ctx.Client.List(ctx.Ctx, addons, client.InNamespace(ctx.Cluster.Namespace))
+	if err != nil {
+		return "", errors.Wrap(err, "list addons")
+	}
+
+	for i := range addons.Items {
+		if addons.Items[i].Spec.ClusterName == ctx.Cluster.Name && addons.Items[i].Spec.Type == workloadv1.AddonMetalLB {
+			return addons.Items[i].Name, nil
 		}
 	}
 
-	return nil
+	return "", nil
+}
+
+func (p *Provider) EnsureEth(ctx *common.ClusterContext) error {
+	var cniType string
+	var ok bool
+
+	if cniType, ok = ctx.Cluster.Spec.Features.Hooks[devopsv1.HookCniInstall]; !ok {
+		return nil
+	}
+
+	if cniType != "raw-cni" {
+		return nil
+	}
+
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		return rawcni.ApplyEth(sh, ctx)
+	})
 }
 
 func (p *Provider) EnsureDeployCni(ctx *common.ClusterContext) error {
@@ -800,17 +804,9 @@ func (p *Provider) EnsureDeployCni(ctx *common.ClusterContext) error {
 
 	switch cniType {
 	case "raw-cni":
-		for _, machine := range ctx.Cluster.Spec.Machines {
-			sh, err := machine.SSH()
-			if err != nil {
-				return err
-			}
-
-			err = rawcni.ApplyCniCfg(sh, ctx)
-			if err != nil {
-				return err
-			}
-		}
+		return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+			return rawcni.ApplyCniCfg(sh, ctx)
+		})
 	case "flannel":
 		clusterCtx, err := ctx.ClusterManager.Get(ctx.Cluster.Name)
 		if err != nil {
@@ -836,44 +832,63 @@ func (p *Provider) EnsureDeployCni(ctx *common.ClusterContext) error {
 	return nil
 }
 
+// EnsureMasterNode removes the masters which are not in spec any more, and joins the masters
+// which are not registered or not ready.
 func (p *Provider) EnsureMasterNode(ctx *common.ClusterContext) error {
 	clusterCtx, err := ctx.ClusterManager.Get(ctx.Cluster.Name)
 	if err != nil {
-		return nil
+		return errors.Wrapf(err, "cluster %s is not in cluster manager", ctx.Cluster.Name)
 	}
 
-	node := &corev1.Node{}
-	var noReadNode *devopsv1.ClusterMachine
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		err := clusterCtx.GetClient().Get(ctx.Ctx, types.NamespacedName{Name: machine.IP}, node)
+	for _, node := range ctx.Cluster.RemovedMasters() {
+		err = removeMaster(ctx, clusterCtx.KubeCli, node)
 		if err != nil {
-			return errors.Wrapf(err, "failed get cluster: %s node: %s", ctx.Cluster.Name, machine.IP)
+			return errors.Wrap(err, node)
 		}
+	}
 
-		isNoReady := false
-		for j := range node.Status.Conditions {
-			if node.Status.Conditions[j].Type != corev1.NodeReady {
-				continue
-			}
+	var joinMachines []*devopsv1.ClusterMachine
+	for i, machine := range ctx.Cluster.Spec.Machines {
+		node := &corev1.Node{}
+		err := clusterCtx.GetClient().Get(ctx.Ctx, types.NamespacedName{Name: machine.IP}, node)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed get cluster: %s node: %s", ctx.Cluster.Name, machine.IP)
+		}
 
-			if node.Status.Conditions[j].Status != corev1.ConditionTrue {
-				isNoReady = true
-			}
-			break
+		if err == nil && apiclient.IsNodeReady(node) {
+			continue
 		}
 
-		if isNoReady {
-			noReadNode = machine
-			break
+		// the other masters join the control plane of the first one
+		if i == 0 && err != nil {
+			return fmt.Errorf("the first master %s is not registered, it can't be replaced", machine.IP)
 		}
+		joinMachines = append(joinMachines, machine)
 	}
 
-	if noReadNode == nil {
+	if len(joinMachines) == 0 {
 		return nil
 	}
 
-	ctx.Info("start reconcile master", "node", noReadNode.IP)
-	sh, err := noReadNode.SSH()
+	// the certs uploaded by kubeadm are deleted after two hours
+	err = p.EnsureKubeadmInitUploadCertsPhase(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, machine := range joinMachines {
+		err = p.joinMaster(ctx, clusterCtx.KubeCli, machine)
+		if err != nil {
+			return errors.Wrap(err, machine.IP)
+		}
+	}
+
+	return nil
+}
+
+func (p *Provider) joinMaster(ctx *common.ClusterContext, clientset kubernetes.Interface, machine *devopsv1.ClusterMachine) error {
+	ctx.Info("start reconcile master", "node", machine.IP)
+	sh, err := machine.SSH()
 	if err != nil {
 		return err
 	}
@@ -885,8 +900,21 @@ func (p *Provider) EnsureMasterNode(ctx *common.ClusterContext) error {
 		}
 	}
 
+	err = execHook(ctx, sh, devopsv1.HookPreInstall)
+	if err != nil {
+		return err
+	}
+
+	if p.Cfg.NeedSetHosts() {
+		err = p.setRegistryHosts(ctx, sh)
+		if err != nil {
+			return err
+		}
+	}
+
 	phases := []func(ctx *common.ClusterContext, s ssh.Interface) error{
 		system.Install,
+		cri.InstallCRI,
 		kubebin.Install,
 		preflight.RunMasterChecks,
 		kubemisc.Install,
@@ -900,14 +928,146 @@ func (p *Provider) EnsureMasterNode(ctx *common.ClusterContext) error {
 		}
 	}
 
+	if p.Cfg.EnableCustomImages {
+		err = kubeadm.RebuildMasterManifestFile(ctx, sh, p.Cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = kubemisc.CovertMasterKubeConfig(sh, ctx)
+	if err != nil {
+		return err
+	}
+
+	_, _, _, err = sh.Execf("systemctl enable kubelet && systemctl restart kubelet")
+	if err != nil {
+		return err
+	}
+
+	err = markMaster(ctx, clientset, machine)
+	if err != nil {
+		return err
+	}
+
+	return execHook(ctx, sh, devopsv1.HookPostInstall)
+}
+
+// removeMaster drains the node, removes its etcd member, resets the host and deletes the node.
+func removeMaster(ctx *common.ClusterContext, clientset kubernetes.Interface, node string) error {
+	ctx.Info("start remove master", "node", node)
+	err := apiclient.DrainNode(ctx.Ctx, clientset, node)
+	if err != nil && !apierrors.IsNotFound(errors.Cause(err)) {
+		return err
+	}
+
+	if ctx.Cluster.Spec.Etcd == nil || ctx.Cluster.Spec.Etcd.External == nil {
+		err = etcd.RemoveMember(ctx, node)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the host may be gone already, its member is removed so the cluster is not blocked by it
+	err = resetMaster(ctx, node)
+	if err != nil {
+		ctx.Error(err, "failed reset removed master, reset it manually", "node", node)
+		ctx.Eventf(ctx.Cluster, corev1.EventTypeWarning, "FailedResetMaster", "reset removed master %s: %v", node, err)
+	}
+
+	err = clientset.CoreV1().Nodes().Delete(ctx.Ctx, node, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "delete node: %s", node)
+	}
+
+	ctx.Info("remove master successfully", "node", node)
 	return nil
 }
 
+// resetMaster runs kubeadm reset and cleans the removed host, it's not in spec any more so
+// it's connected with the ssh options of the first master.
+func resetMaster(ctx *common.ClusterContext, node string) error {
+	machine := *ctx.Cluster.Spec.Machines[0]
+	machine.IP = node
+	machine.HostKeyFingerprint = ""
+	sh, err := machine.SSH()
+	if err != nil {
+		return err
+	}
+
+	return clean.CleanNode(sh, ctx.Cluster.Spec.CRIType)
+}
+
 func (p *Provider) EnsureNvidiaDriver(ctx *common.ClusterContext) error {
+	if !gpu.Enabled(ctx.Cluster) {
+		return nil
+	}
 
-	return nil
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		if !gpu.MachineIsSupport(sh) {
+			return nil
+		}
+
+		arch, err := artifact.HostArch(sh)
+		if err != nil {
+			return err
+		}
+
+		option, err := gpu.NewNvidiaDriverOption(ctx, arch)
+		if err != nil {
+			return err
+		}
+		return gpu.InstallNvidiaDriver(sh, option)
+	})
 }
 
 func (p *Provider) EnsureNvidiaContainerRuntime(ctx *common.ClusterContext) error {
-	return nil
+	if !gpu.Enabled(ctx.Cluster) {
+		return nil
+	}
+
+	return p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		if !gpu.MachineIsSupport(sh) {
+			return nil
+		}
+
+		arch, err := artifact.HostArch(sh)
+		if err != nil {
+			return err
+		}
+
+		option, err := gpu.NewNvidiaContainerRuntimeOption(ctx, arch)
+		if err != nil {
+			return err
+		}
+		return gpu.InstallNvidiaContainerRuntime(sh, option)
+	})
+}
+
+// EnsureNvidiaDevicePlugin labels the masters with nvidia gpus and deploys the device plugin on them.
+func (p *Provider) EnsureNvidiaDevicePlugin(ctx *common.ClusterContext) error {
+	if !gpu.Enabled(ctx.Cluster) {
+		return nil
+	}
+
+	clientset, err := ctx.ClientsetForBootstrap()
+	if err != nil {
+		return err
+	}
+
+	err = p.forEachMaster(ctx, func(machine *devopsv1.ClusterMachine, sh ssh.Interface) error {
+		if !gpu.MachineIsSupport(sh) {
+			return nil
+		}
+
+		labels := map[string]string{gpu.LabelNvidiaDevice: gpu.LabelNvidiaDeviceEnable}
+		return apiclient.MarkNode(ctx.Ctx, clientset, machine.IP, labels, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	return gpu.InstallNvidiaDevicePlugin(ctx.Ctx, clientset, &gpu.NvidiaDevicePluginOption{
+		Image: p.Cfg.KubeAllImageFullName("k8s-device-plugin", gpu.DefaultDevicePluginVersion),
+	})
 }
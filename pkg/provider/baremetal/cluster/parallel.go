@@ -0,0 +1,33 @@
+package cluster
+
+import (
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// forEachMaster runs f on all masters with at most Cfg.MaxConcurrency hosts at the same time,
+// it waits for all of them and aggregates the errors with the ip of each host.
+func (p *Provider) forEachMaster(ctx *common.ClusterContext, f func(machine *devopsv1.ClusterMachine, s ssh.Interface) error) error {
+	machines := ctx.Cluster.Spec.Machines
+	errs := make([]error, len(machines))
+	workqueue.ParallelizeUntil(ctx.Ctx, p.Cfg.Concurrency(), len(machines), func(i int) {
+		s, err := machines[i].SSH()
+		if err == nil {
+			err = f(machines[i], s)
+		}
+		if err != nil {
+			ctx.Error(err, "failed on master", "node", machines[i].IP)
+			errs[i] = errors.Wrap(err, machines[i].IP)
+		}
+	})
+
+	// the hosts not started are skipped when the context is done
+	if ctx.Ctx != nil && ctx.Ctx.Err() != nil {
+		errs = append(errs, ctx.Ctx.Err())
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -42,6 +42,7 @@ func NewProvider(mgr *clusterprovider.CpManager, cfg *config.Config) (*Provider,
 	p.DelegateProvider = &clusterprovider.DelegateProvider{
 		ProviderName: baremetal.ProviderName,
 		CreateHandlers: []clusterprovider.Handler{
+			p.EnsureMachineInfo,
 			p.EnsureCopyFiles,
 			p.EnsurePreInstallHook,
 			p.EnsureRegistryHosts,
@@ -72,6 +73,7 @@ func NewProvider(mgr *clusterprovider.CpManager, cfg *config.Config) (*Provider,
 			p.EnsureRebuildEtcd,
 
 			p.EnsureDeployCni,
+			p.EnsureNvidiaDevicePlugin,
 			p.EnsureRebuildControlPlane,
 			p.EnsureExtKubeconfig,
 			p.EnsurePostInstallHook,
@@ -82,10 +84,31 @@ func NewProvider(mgr *clusterprovider.CpManager, cfg *config.Config) (*Provider,
 			p.EnsureDeployCni,
 			p.EnsureRebuildEtcd,
 			p.EnsureRebuildControlPlane,
-			p.EnsureRenewCerts,
 			p.EnsureAPIServerCert,
 			p.EnsureMetricsServer,
+			p.EnsureLoadBalancer,
+			p.EnsureNvidiaDevicePlugin,
+		},
+		UpgradeHandlers: []clusterprovider.Handler{
+			p.EnsureUpgradeCheck,
+			p.EnsureMachineInfo,
+			p.EnsureUpgradeControlPlane,
+			p.EnsureUpgradeWorkers,
+		},
+		ScaleHandlers: []clusterprovider.Handler{
+			p.EnsureMachineInfo,
+			p.EnsureMasterNode,
+			p.EnsureRebuildEtcd,
+			p.EnsureAPIServerCert,
+			p.EnsureMasterAddresses,
+		},
+		CertHandlers: []clusterprovider.Handler{
+			p.EnsureRenewCerts,
+			p.EnsureExtKubeconfig,
+			p.EnsureMasterCerts,
+			p.EnsureWorkerCerts,
 		},
+		PreflightFunc: p.EnsurePreflightReport,
 	}
 
 	return p, nil
@@ -3,10 +3,11 @@ package cluster
 import (
 	"fmt"
 	"reflect"
-	"time"
 
 	"github.com/pkg/errors"
 	"github.com/thoas/go-funk"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	workloadv1 "github.com/wtxue/kok-operator/pkg/apis/workload/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/k8sutil"
@@ -14,40 +15,9 @@ import (
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubeadm"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubemisc"
 	certutil "k8s.io/client-go/util/cert"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func (p *Provider) EnsureRenewCerts(ctx *common.ClusterContext) error {
-	for _, machine := range ctx.Cluster.Spec.Machines {
-		s, err := machine.SSH()
-		if err != nil {
-			return err
-		}
-
-		data, err := s.ReadFile(constants.APIServerCertName)
-		if err != nil {
-			return err
-		}
-		cts, err := certutil.ParseCertsPEM(data)
-		if err != nil {
-			return err
-		}
-		expirationDuration := time.Until(cts[0].NotAfter)
-		if expirationDuration > constants.RenewCertsTimeThreshold {
-			ctx.Info("skip EnsureRenewCerts because expiration duration > threshold",
-				"duration", expirationDuration, "threshold", constants.RenewCertsTimeThreshold)
-			return nil
-		}
-
-		ctx.Info("EnsureRenewCerts", "node", s.Host)
-		err = kubeadm.RenewCerts(s)
-		if err != nil {
-			return errors.Wrapf(err, "renew certs node: %s", machine.IP)
-		}
-	}
-
-	return nil
-}
-
 func (p *Provider) EnsureAPIServerCert(ctx *common.ClusterContext) error {
 	apiserver := certs.BuildApiserverEndpoint(ctx.Cluster.Spec.PublicAlternativeNames[0], kubemisc.GetBindPort(ctx.Cluster))
 
@@ -75,7 +45,7 @@ func (p *Provider) EnsureAPIServerCert(ctx *common.ClusterContext) error {
 		}
 
 		if reflect.DeepEqual(funk.IntersectString(actualCertSANs, exptectCertSANs), exptectCertSANs) {
-			return nil
+			continue
 		}
 
 		ctx.Info("EnsureAPIServerCert", "node", sh.Host)
@@ -87,7 +57,7 @@ func (p *Provider) EnsureAPIServerCert(ctx *common.ClusterContext) error {
 		if err != nil {
 			return errors.Wrap(err, machine.IP)
 		}
-		err = kubeadm.RestartContainerByFilter(sh, kubeadm.DockerFilterForControlPlane("kube-apiserver"))
+		err = kubeadm.RestartContainerByFilter(ctx, sh, kubeadm.LabelFilterForControlPlane("kube-apiserver"))
 		if err != nil {
 			return err
 		}
@@ -102,5 +72,61 @@ func (p *Provider) EnsureAPIServerCert(ctx *common.ClusterContext) error {
 		}
 	}
 
+	return refreshKubeVip(ctx)
+}
+
+// refreshKubeVip re-applies the kube-vip addon of the cluster when some master has no kube-vip static pod.
+func refreshKubeVip(ctx *common.ClusterContext) error {
+	addons := &workloadv1.AddonsList{}
+	err := ctx.Client.List(ctx.Ctx, addons, client.InNamespace(ctx.Cluster.Namespace))
+	if err != nil {
+		return err
+	}
+
+	for i := range addons.Items {
+		addon := &addons.Items[i]
+		if addon.Spec.ClusterName != ctx.Cluster.Name || addon.Spec.Type != workloadv1.AddonKubeVip ||
+			addon.Status.Phase != workloadv1.AddonRunning {
+			continue
+		}
+
+		missing := false
+		for _, machine := range ctx.Cluster.Spec.Machines {
+			sh, err := machine.SSH()
+			if err != nil {
+				return err
+			}
+
+			exist, err := sh.Exist(constants.KubeVipPodManifestFile)
+			if err != nil {
+				return errors.Wrap(err, machine.IP)
+			}
+			if !exist {
+				missing = true
+				break
+			}
+		}
+		if !missing {
+			continue
+		}
+
+		ctx.Info("refresh kube-vip", "addons", addon.Name)
+		addon.Status.Phase = workloadv1.AddonUpgrading
+		err = ctx.Client.Status().Update(ctx.Ctx, addon)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnsureMasterAddresses syncs the real addresses with the masters of spec, it must be the last scale handler.
+func (p *Provider) EnsureMasterAddresses(ctx *common.ClusterContext) error {
+	ctx.Cluster.RemoveAddress(devopsv1.AddressReal)
+	for _, m := range ctx.Cluster.Spec.Machines {
+		ctx.Cluster.AddAddress(devopsv1.AddressReal, m.IP, 6443)
+	}
+
 	return nil
 }
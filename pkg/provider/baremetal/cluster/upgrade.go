@@ -0,0 +1,373 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	clusterprovider "github.com/wtxue/kok-operator/pkg/provider/cluster"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/kubeadm"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/kubebin"
+	"github.com/wtxue/kok-operator/pkg/util/apiclient"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// upgradeWaitInterval is the interval to check the workers labeled in manual mode.
+const upgradeWaitInterval = 30 * time.Second
+
+type upgradePhase func(ctx *common.ClusterContext, s ssh.Interface) error
+
+func (p *Provider) EnsureUpgradeCheck(ctx *common.ClusterContext) error {
+	current, err := semver.NewVersion(ctx.Cluster.Status.Version)
+	if err != nil {
+		return errors.Wrapf(err, "parse current version %q", ctx.Cluster.Status.Version)
+	}
+
+	target, err := semver.NewVersion(ctx.Cluster.Spec.Version)
+	if err != nil {
+		return errors.Wrapf(err, "parse target version %q", ctx.Cluster.Spec.Version)
+	}
+
+	if target.LessThan(current) {
+		return fmt.Errorf("downgrade from %s to %s is not supported", ctx.Cluster.Status.Version, ctx.Cluster.Spec.Version)
+	}
+
+	if target.Major() != current.Major() || target.Minor()-current.Minor() > 1 {
+		return fmt.Errorf("upgrade from %s to %s skips minor versions, only one minor version at a time is supported",
+			ctx.Cluster.Status.Version, ctx.Cluster.Spec.Version)
+	}
+
+	if _, err := ctx.ClusterManager.Get(ctx.Cluster.Name); err != nil {
+		return errors.Wrapf(err, "cluster: %s is not managed yet", ctx.Cluster.Name)
+	}
+
+	return nil
+}
+
+func (p *Provider) EnsureUpgradeControlPlane(ctx *common.ClusterContext) error {
+	clusterCtx, err := ctx.ClusterManager.Get(ctx.Cluster.Name)
+	if err != nil {
+		return err
+	}
+
+	// the first master not upgraded run kubeadm upgrade apply, the others run kubeadm upgrade node
+	applied := false
+	for _, machine := range ctx.Cluster.Spec.Machines {
+		upgradeCmd := kubeadm.UpgradeNode
+		if !applied {
+			upgradeCmd = kubeadm.UpgradeApply
+		}
+
+		phases := []upgradePhase{
+			kubebin.UpgradeKubeadm,
+			p.upgradeImagesPull,
+			upgradeCmd,
+			p.upgradeMasterManifest,
+			kubebin.UpgradeKubelet,
+		}
+
+		_, err := p.upgradeNode(ctx, clusterCtx.KubeCli, machine, phases)
+		if err != nil {
+			return err
+		}
+		applied = true
+	}
+
+	return nil
+}
+
+// EnsureUpgradeWorkers upgrades the running workers one by one, only the ones labeled with need-upgrade
+// are upgraded in manual mode, and it waits until the others are labeled and upgraded.
+func (p *Provider) EnsureUpgradeWorkers(ctx *common.ClusterContext) error {
+	clusterCtx, err := ctx.ClusterManager.Get(ctx.Cluster.Name)
+	if err != nil {
+		return err
+	}
+
+	// the machine not running will be installed with the version of cluster spec
+	machines, err := listRunningWorkers(ctx)
+	if err != nil {
+		return err
+	}
+
+	phases := []upgradePhase{
+		kubebin.UpgradeKubeadm,
+		kubeadm.UpgradeNode,
+		kubebin.UpgradeKubelet,
+	}
+
+	manual := ctx.Cluster.Spec.Upgrade.Mode == devopsv1.UpgradeModeManual
+	pending := 0
+	for _, m := range machines {
+		nodeName := m.Spec.Machine.IP
+		if manual {
+			node, err := clusterCtx.KubeCli.CoreV1().Nodes().Get(ctx.Ctx, nodeName, metav1.GetOptions{})
+			if err != nil {
+				return errors.Wrapf(err, "failed get node: %s", nodeName)
+			}
+
+			if _, ok := node.Labels[constants.LabelNodeNeedUpgrade]; !ok {
+				upgraded, err := isNodeUpgraded(node, ctx.Cluster.Spec.Version)
+				if err != nil {
+					return err
+				}
+				if !upgraded {
+					pending++
+				}
+				ctx.Info("skip upgrade node without label", "node", nodeName, "label", constants.LabelNodeNeedUpgrade)
+				continue
+			}
+		}
+
+		upgraded, err := p.upgradeNode(ctx, clusterCtx.KubeCli, m.Spec.Machine, phases)
+		if err != nil {
+			return err
+		}
+		if !upgraded {
+			continue
+		}
+
+		if manual {
+			err = apiclient.PatchNode(ctx.Ctx, clusterCtx.KubeCli, nodeName, func(n *corev1.Node) {
+				delete(n.Labels, constants.LabelNodeNeedUpgrade)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "remove label %s node: %s", constants.LabelNodeNeedUpgrade, nodeName)
+			}
+		}
+
+		err = waitPodsReady(ctx, clusterCtx.KubeCli, ctx.Cluster.Spec.Upgrade.Strategy.MaxUnready)
+		if err != nil {
+			return errors.Wrapf(err, "after upgrade node: %s", nodeName)
+		}
+	}
+
+	if pending > 0 {
+		return clusterprovider.Waitingf(upgradeWaitInterval, "waiting for %d workers labeled with %s to upgrade", pending, constants.LabelNodeNeedUpgrade)
+	}
+	return nil
+}
+
+// listRunningWorkers returns the running machines of the cluster sorted by name, they are
+// reached with the ssh options of cluster.
+func listRunningWorkers(ctx *common.ClusterContext) ([]*devopsv1.Machine, error) {
+	ms := &devopsv1.MachineList{}
+	err := ctx.Client.List(ctx.Ctx, ms, client.InNamespace(ctx.Cluster.Namespace))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed list machine")
+	}
+
+	secrets := common.SecretGetter(ctx.Ctx, ctx.Client, ctx.Cluster.Namespace)
+	machines := make([]*devopsv1.Machine, 0, len(ms.Items))
+	for i := range ms.Items {
+		m := &ms.Items[i]
+		if m.Spec.ClusterName != ctx.Cluster.Name || m.Spec.Machine == nil {
+			continue
+		}
+
+		if m.Status.Phase != devopsv1.MachineRunning {
+			continue
+		}
+		m.Spec.Machine.SetSSHOptions(ctx.Cluster.Spec.SSH, secrets)
+		machines = append(machines, m)
+	}
+	sort.Slice(machines, func(i, j int) bool {
+		return machines[i].Name < machines[j].Name
+	})
+
+	return machines, nil
+}
+
+func (p *Provider) upgradeImagesPull(ctx *common.ClusterContext, s ssh.Interface) error {
+	return kubeadm.ImagesPull(ctx, s, ctx.Cluster.Spec.Version, p.Cfg.CustomRegistry)
+}
+
+func (p *Provider) upgradeMasterManifest(ctx *common.ClusterContext, s ssh.Interface) error {
+	if !p.Cfg.EnableCustomImages {
+		return nil
+	}
+
+	return kubeadm.RebuildMasterManifestFile(ctx, s, p.Cfg)
+}
+
+// upgradeNode run the upgrade phases on the node if its kubelet is older than the cluster spec version,
+// it returns true if the node has been upgraded by this call.
+func (p *Provider) upgradeNode(ctx *common.ClusterContext, kubeCli kubernetes.Interface, machine *devopsv1.ClusterMachine, phases []upgradePhase) (bool, error) {
+	node, err := kubeCli.CoreV1().Nodes().Get(ctx.Ctx, machine.IP, metav1.GetOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed get node: %s", machine.IP)
+	}
+
+	upgraded, err := isNodeUpgraded(node, ctx.Cluster.Spec.Version)
+	if err != nil {
+		return false, err
+	}
+
+	if upgraded {
+		condition := ctx.Cluster.GetNodeCondition(machine.IP, devopsv1.NodeConditionUpgrade)
+		if condition == nil || condition.Status != devopsv1.ConditionTrue {
+			setNodeUpgradeCondition(ctx, machine.IP, devopsv1.ConditionTrue, clusterprovider.ReasonSuccessfulProcess, "")
+		}
+		return false, nil
+	}
+
+	ctx.Info("start upgrade node", "node", machine.IP, "from", node.Status.NodeInfo.KubeletVersion, "to", ctx.Cluster.Spec.Version)
+	setNodeUpgradeCondition(ctx, machine.IP, devopsv1.ConditionUnknown, clusterprovider.ReasonWaitingProcess, "")
+
+	err = p.runUpgradePhases(ctx, kubeCli, machine, phases)
+	if err != nil {
+		setNodeUpgradeCondition(ctx, machine.IP, devopsv1.ConditionFalse, clusterprovider.ReasonFailedProcess, err.Error())
+		return false, errors.Wrapf(err, "upgrade node: %s", machine.IP)
+	}
+
+	setNodeUpgradeCondition(ctx, machine.IP, devopsv1.ConditionTrue, clusterprovider.ReasonSuccessfulProcess, "")
+	ctx.Info("upgrade node success", "node", machine.IP, "version", ctx.Cluster.Spec.Version)
+	return true, nil
+}
+
+func (p *Provider) runUpgradePhases(ctx *common.ClusterContext, kubeCli kubernetes.Interface, machine *devopsv1.ClusterMachine, phases []upgradePhase) error {
+	drain := ctx.Cluster.Spec.Upgrade.Strategy.DrainNodeBeforeUpgrade != nil && *ctx.Cluster.Spec.Upgrade.Strategy.DrainNodeBeforeUpgrade
+	if drain {
+		ctx.Info("drain node", "node", machine.IP)
+		err := apiclient.DrainNode(ctx.Ctx, kubeCli, machine.IP)
+		if err != nil {
+			return err
+		}
+	}
+
+	sh, err := machine.SSH()
+	if err != nil {
+		return err
+	}
+
+	for _, phase := range phases {
+		err = phase(ctx, sh)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = waitNodeUpgraded(ctx, kubeCli, machine.IP)
+	if err != nil {
+		return err
+	}
+
+	if drain {
+		err = apiclient.CordonNode(ctx.Ctx, kubeCli, machine.IP, false)
+		if err != nil {
+			return errors.Wrap(err, "uncordon")
+		}
+	}
+
+	return nil
+}
+
+func setNodeUpgradeCondition(ctx *common.ClusterContext, node string, status devopsv1.ConditionStatus, reason, message string) {
+	msg := fmt.Sprintf("upgrade to %s", ctx.Cluster.Spec.Version)
+	if message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, message)
+	}
+
+	now := metav1.Now()
+	condition := devopsv1.NodeCondition{
+		Node:          node,
+		Type:          devopsv1.NodeConditionUpgrade,
+		Status:        status,
+		LastProbeTime: now,
+		Reason:        reason,
+		Message:       msg,
+	}
+
+	old := ctx.Cluster.GetNodeCondition(node, devopsv1.NodeConditionUpgrade)
+	if old == nil || old.Status != status {
+		condition.LastTransitionTime = now
+	}
+	ctx.Cluster.SetNodeCondition(condition)
+}
+
+// isNodeUpgraded returns true if the kubelet version of node is equal to the version,
+// and error if it is newer than the version.
+func isNodeUpgraded(node *corev1.Node, version string) (bool, error) {
+	target, err := semver.NewVersion(version)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse target version %q", version)
+	}
+
+	current, err := semver.NewVersion(node.Status.NodeInfo.KubeletVersion)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse node: %s kubelet version %q", node.Name, node.Status.NodeInfo.KubeletVersion)
+	}
+
+	if current.GreaterThan(target) {
+		return false, fmt.Errorf("node: %s kubelet version %s is newer than %s", node.Name, node.Status.NodeInfo.KubeletVersion, version)
+	}
+
+	return current.Equal(target), nil
+}
+
+func waitNodeUpgraded(ctx *common.ClusterContext, kubeCli kubernetes.Interface, nodeName string) error {
+	err := wait.PollImmediate(5*time.Second, 5*time.Minute, func() (bool, error) {
+		node, err := kubeCli.CoreV1().Nodes().Get(ctx.Ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+
+		if !apiclient.IsNodeReady(node) {
+			return false, nil
+		}
+
+		return isNodeUpgraded(node, ctx.Cluster.Spec.Version)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "wait node: %s ready with version %s", nodeName, ctx.Cluster.Spec.Version)
+	}
+
+	return nil
+}
+
+// waitPodsReady wait until the unready pods of the cluster is not more than maxUnready, default is 0%.
+func waitPodsReady(ctx *common.ClusterContext, kubeCli kubernetes.Interface, maxUnready *intstr.IntOrString) error {
+	if maxUnready == nil {
+		zero := intstr.FromString("0%")
+		maxUnready = &zero
+	}
+
+	return wait.PollImmediate(5*time.Second, 5*time.Minute, func() (bool, error) {
+		pods, err := kubeCli.CoreV1().Pods(metav1.NamespaceAll).List(ctx.Ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, nil
+		}
+
+		total, unready := 0, 0
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+
+			total++
+			if !apiclient.IsPodReady(pod) {
+				unready++
+			}
+		}
+
+		max, err := intstr.GetScaledValueFromIntOrPercent(maxUnready, total, false)
+		if err != nil {
+			return false, errors.Wrap(err, "invalid maxUnready")
+		}
+
+		ctx.Info("wait pods ready", "total", total, "unready", unready, "maxUnready", max)
+		return unready <= max, nil
+	})
+}
@@ -0,0 +1,66 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/k8sclient"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestListRunningWorkersSSHOptions(t *testing.T) {
+	cluster := &devopsv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "default"},
+		Spec: devopsv1.ClusterSpec{SSH: &devopsv1.SSHOptions{
+			CredentialsRef: &corev1.LocalObjectReference{Name: "cluster-ssh"},
+		}},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker-ssh", Namespace: "default"},
+		Data:       map[string][]byte{corev1.BasicAuthPasswordKey: []byte("123456")},
+	}
+	worker := &devopsv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default"},
+		Spec: devopsv1.MachineSpec{
+			ClusterName: "demo",
+			Machine: &devopsv1.ClusterMachine{
+				IP:             "10.0.0.10",
+				Port:           22,
+				Username:       "root",
+				CredentialsRef: &corev1.LocalObjectReference{Name: "worker-ssh"},
+			},
+		},
+		Status: devopsv1.MachineStatus{Phase: devopsv1.MachineRunning},
+	}
+	cli := fake.NewClientBuilder().WithScheme(k8sclient.GetScheme()).WithObjects(cluster, secret, worker).Build()
+	ctx := &common.ClusterContext{
+		Ctx:     context.Background(),
+		Client:  cli,
+		Logger:  logr.Discard(),
+		Cluster: cluster,
+	}
+
+	machines, err := listRunningWorkers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machines) != 1 {
+		t.Fatalf("expect 1 worker, got %d", len(machines))
+	}
+
+	m := machines[0].Spec.Machine
+	if m.SSHOptions != cluster.Spec.SSH {
+		t.Errorf("expect the ssh options of cluster")
+	}
+	if _, err := m.SSH(); err != nil {
+		t.Fatalf("expect credentialsRef resolved, got %v", err)
+	}
+	if s, err := m.Secrets.Get("worker-ssh"); err != nil || string(s.Data[corev1.BasicAuthPasswordKey]) != "123456" {
+		t.Errorf("expect the secret of worker, got %v", err)
+	}
+}
@@ -11,7 +11,10 @@ import (
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/certs"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/cri"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/gpu"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/join"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubebin"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubemisc"
@@ -103,13 +106,31 @@ func (p *Provider) EnsureClean(ctx *common.ClusterContext, machine *devopsv1.Mac
 	return nil
 }
 
+// EnsureMachineInfo detects the system info of machine into status, the cpu arch must have the
+// artifacts of the cluster version.
+func (p *Provider) EnsureMachineInfo(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
+	s, err := machine.Spec.SSH()
+	if err != nil {
+		return err
+	}
+
+	info, err := preflight.DetectMachineInfo(ctx, s, machine.Spec.Machine)
+	if info != nil {
+		machine.Status.MachineInfo.OperatingSystem = info.OperatingSystem
+		machine.Status.MachineInfo.KernelVersion = info.KernelVersion
+		machine.Status.MachineInfo.Architecture = info.Architecture
+		machine.Status.MachineInfo.OSImage = info.OSImage
+	}
+	return err
+}
+
 func (p *Provider) EnsurePreflight(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
 	s, err := machine.Spec.SSH()
 	if err != nil {
 		return err
 	}
 
-	err = preflight.RunNodeChecks(s)
+	err = preflight.RunNodeChecks(ctx, s, machine)
 	if err != nil {
 		return err
 	}
@@ -179,6 +200,85 @@ func (p *Provider) EnsureSystem(ctx *common.ClusterContext, machine *devopsv1.Ma
 	return nil
 }
 
+func (p *Provider) EnsureCRI(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
+	sh, err := machine.Spec.SSH()
+	if err != nil {
+		return err
+	}
+
+	err = cri.InstallCRI(ctx, sh)
+	if err != nil {
+		return errors.Wrap(err, sh.HostIP())
+	}
+
+	return nil
+}
+
+func (p *Provider) EnsureNvidiaDriver(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
+	if !gpu.Enabled(ctx.Cluster) {
+		return nil
+	}
+
+	sh, err := machine.Spec.SSH()
+	if err != nil {
+		return err
+	}
+
+	if !gpu.MachineIsSupport(sh) {
+		ctx.Info("no nvidia gpu, skip driver", "node", sh.HostIP())
+		return nil
+	}
+
+	arch, err := artifact.HostArch(sh)
+	if err != nil {
+		return err
+	}
+
+	option, err := gpu.NewNvidiaDriverOption(ctx, arch)
+	if err != nil {
+		return err
+	}
+
+	err = gpu.InstallNvidiaDriver(sh, option)
+	if err != nil {
+		return errors.Wrap(err, sh.HostIP())
+	}
+
+	return nil
+}
+
+func (p *Provider) EnsureNvidiaContainerRuntime(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
+	if !gpu.Enabled(ctx.Cluster) {
+		return nil
+	}
+
+	sh, err := machine.Spec.SSH()
+	if err != nil {
+		return err
+	}
+
+	if !gpu.MachineIsSupport(sh) {
+		return nil
+	}
+
+	arch, err := artifact.HostArch(sh)
+	if err != nil {
+		return err
+	}
+
+	option, err := gpu.NewNvidiaContainerRuntimeOption(ctx, arch)
+	if err != nil {
+		return err
+	}
+
+	err = gpu.InstallNvidiaContainerRuntime(sh, option)
+	if err != nil {
+		return errors.Wrap(err, sh.HostIP())
+	}
+
+	return nil
+}
+
 func (p *Provider) EnsureK8sComponent(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
 	sh, err := machine.Spec.SSH()
 	if err != nil {
@@ -238,7 +338,23 @@ func (p *Provider) EnsureMarkNode(ctx *common.ClusterContext, machine *devopsv1.
 		return nil
 	}
 
-	err = apiclient.MarkNode(ctx.Ctx, clusterCtx.KubeCli, machine.Spec.Machine.IP, machine.Spec.Machine.Labels, machine.Spec.Machine.Taints)
+	labels := machine.Spec.Machine.Labels
+	if gpu.Enabled(ctx.Cluster) {
+		sh, err := machine.Spec.SSH()
+		if err != nil {
+			return err
+		}
+
+		if gpu.MachineIsSupport(sh) {
+			labels = make(map[string]string, len(machine.Spec.Machine.Labels)+1)
+			for k, v := range machine.Spec.Machine.Labels {
+				labels[k] = v
+			}
+			labels[gpu.LabelNvidiaDevice] = gpu.LabelNvidiaDeviceEnable
+		}
+	}
+
+	err = apiclient.MarkNode(ctx.Ctx, clusterCtx.KubeCli, machine.Spec.Machine.IP, labels, machine.Spec.Machine.Taints)
 	if err != nil {
 		return err
 	}
@@ -6,6 +6,7 @@ import (
 	"github.com/wtxue/kok-operator/pkg/provider/baremetal/validation"
 	"github.com/wtxue/kok-operator/pkg/provider/config"
 	machineprovider "github.com/wtxue/kok-operator/pkg/provider/machine"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/node"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
@@ -33,13 +34,17 @@ func NewProvider(mgr *machineprovider.MpManager, cfg *config.Config) (*Provider,
 	p.DelegateProvider = &machineprovider.DelegateProvider{
 		ProviderName: baremetal.ProviderName,
 		CreateHandlers: []machineprovider.Handler{
+			p.EnsureMachineInfo,
 			p.EnsureCopyFiles,
 			p.EnsurePreInstallHook,
 			p.EnsureClean,
 			p.EnsureRegistryHosts,
+			p.EnsureNvidiaDriver,
+			p.EnsureNvidiaContainerRuntime,
 
 			p.EnsureEth,
 			p.EnsureSystem,
+			p.EnsureCRI,
 			p.EnsureK8sComponent,
 			p.EnsurePreflight, // wait basic setting done
 
@@ -56,6 +61,10 @@ func NewProvider(mgr *machineprovider.MpManager, cfg *config.Config) (*Provider,
 			p.EnsurePostInstallHook,
 			p.EnsureRegistryHosts,
 		},
+		DeleteHandlers: []machineprovider.Handler{
+			node.EnsureRemoveNode,
+			node.EnsureCleanNode,
+		},
 	}
 
 	return p, nil
@@ -1,15 +1,22 @@
 package validation
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"strings"
 
+	"github.com/robfig/cron/v3"
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/system"
+	"github.com/wtxue/kok-operator/pkg/provider/preflight"
 	"github.com/wtxue/kok-operator/pkg/util/ipallocator"
 	"github.com/wtxue/kok-operator/pkg/util/validation"
 	utilvalidation "github.com/wtxue/kok-operator/pkg/util/validation"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
@@ -18,9 +25,37 @@ var (
 	clusterServiceNumAvails = []int32{32, 64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
 )
 
-// ValidateCluster validates a given Cluster.
+// ValidateCluster validates a given Cluster statically, it's run in admission so nothing is fetched
+// from the network. The artifacts of masters are checked by EnsureMachineInfo.
 func ValidateCluster(ctx *common.ClusterContext) field.ErrorList {
-	allErrs := ValidatClusterSpec(&ctx.Cluster.Spec, field.NewPath("spec"), ctx.Cluster.Status.Phase)
+	return ValidatClusterSpec(&ctx.Cluster.Spec, field.NewPath("spec"), ctx.Cluster.Status.Phase)
+}
+
+// ClusterWarnings returns the warnings of deprecated fields in cluster.
+func ClusterWarnings(cluster *devopsv1.Cluster) []string {
+	var warnings []string
+	fldPath := field.NewPath("spec", "machines")
+	for i, m := range cluster.Spec.Machines {
+		warnings = append(warnings, ClusterMachineWarnings(m, fldPath.Index(i))...)
+	}
+
+	return warnings
+}
+
+// ValidateClusterUpdate validates the fields which can't be changed once the cluster is created.
+func ValidateClusterUpdate(cluster *devopsv1.Cluster, old *devopsv1.Cluster) field.ErrorList {
+	allErrs := field.ErrorList{}
+	switch old.Status.Phase {
+	case devopsv1.ClusterRunning, devopsv1.ClusterUpgrading, devopsv1.ClusterRestoring:
+	default:
+		return allErrs
+	}
+
+	fldPath := field.NewPath("spec")
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(cluster.Spec.ClusterType, old.Spec.ClusterType, fldPath.Child("clusterType"))...)
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(cluster.Spec.ClusterCIDR, old.Spec.ClusterCIDR, fldPath.Child("clusterCIDR"))...)
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(cluster.Spec.ServiceCIDR, old.Spec.ServiceCIDR, fldPath.Child("serviceCIDR"))...)
+	allErrs = append(allErrs, apivalidation.ValidateImmutableField(cluster.Spec.DNSDomain, old.Spec.DNSDomain, fldPath.Child("dnsDomain"))...)
 
 	return allErrs
 }
@@ -31,8 +66,16 @@ func ValidatClusterSpec(spec *devopsv1.ClusterSpec, fldPath *field.Path, phase d
 
 	allErrs = append(allErrs, ValidateClusterSpecVersion(spec.Version, fldPath.Child("version"), phase)...)
 	allErrs = append(allErrs, ValidateCIDRs(spec, fldPath)...)
+	allErrs = append(allErrs, ValidateCRIType(spec.CRIType, fldPath.Child("criType"))...)
+	allErrs = append(allErrs, ValidateOSType(spec.OSType, fldPath.Child("osType"))...)
+	allErrs = append(allErrs, ValidatePackageRepos(spec.PackageRepos, fldPath.Child("packageRepos"))...)
+	allErrs = append(allErrs, ValidateEtcd(spec.Etcd, fldPath.Child("etcd"))...)
 	allErrs = append(allErrs, ValidateClusterProperty(spec, fldPath.Child("properties"))...)
-	// allErrs = append(allErrs, ValidateClusterMachines(spec.Machines, fldPath.Child("machines"))...)
+	allErrs = append(allErrs, ValidateLoadBalancer(&spec.Features, fldPath.Child("features"))...)
+	allErrs = append(allErrs, ValidateGPU(spec, fldPath)...)
+	allErrs = append(allErrs, ValidateCertificates(spec.Certificates, fldPath.Child("certificates"))...)
+	allErrs = append(allErrs, ValidatePreflightPolicy(spec.Preflight, fldPath.Child("preflight"))...)
+	allErrs = append(allErrs, ValidateClusterMachines(spec.Machines, fldPath.Child("machines"))...)
 	// allErrs = append(allErrs, ValidateClusterFeature(&spec.Features, fldPath.Child("features"))...)
 
 	return allErrs
@@ -48,6 +91,298 @@ func ValidateClusterSpecVersion(version string, fldPath *field.Path, phase devop
 	return allErrs
 }
 
+// ValidateClusterMachines validates the declared cpu arch of machines.
+func ValidateClusterMachines(machines []*devopsv1.ClusterMachine, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, m := range machines {
+		allErrs = append(allErrs, ValidateArch(m.Arch, fldPath.Index(i).Child("arch"))...)
+	}
+
+	return allErrs
+}
+
+// ValidateArch validates the cpu arch is supported, empty means it's detected.
+func ValidateArch(arch string, fldPath *field.Path) field.ErrorList {
+	if arch == "" {
+		return field.ErrorList{}
+	}
+
+	return utilvalidation.ValidateEnum(arch, fldPath, artifact.SupportedArches)
+}
+
+// ValidateCRIType validates a given cri type, empty means containerd.
+func ValidateCRIType(criType devopsv1.CRIType, fldPath *field.Path) field.ErrorList {
+	if criType == "" {
+		return field.ErrorList{}
+	}
+
+	return utilvalidation.ValidateEnum(criType, fldPath, []devopsv1.CRIType{devopsv1.ContainerdCRI, devopsv1.DockerCRI})
+}
+
+// ValidateOSType validates the os has installer, empty means it's detected.
+func ValidateOSType(osType devopsv1.OSType, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if osType != "" && !system.IsSupportedOS(osType) {
+		allErrs = append(allErrs, field.NotSupported(fldPath, osType, []string{
+			string(devopsv1.CentosType), string(devopsv1.RHELType), string(devopsv1.RockyType), string(devopsv1.AlmaType),
+			string(devopsv1.OpenEulerType), string(devopsv1.KylinType), string(devopsv1.AnolisType),
+			string(devopsv1.DebianType), string(devopsv1.UbuntuType),
+		}))
+	}
+
+	return allErrs
+}
+
+// ValidatePackageRepos validates the repo names are unique file names of each os.
+func ValidatePackageRepos(repos *devopsv1.PackageRepos, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if repos == nil {
+		return allErrs
+	}
+
+	names := make(map[string]bool)
+	for i, repo := range repos.Repos {
+		repoPath := fldPath.Child("repos").Index(i)
+		allErrs = append(allErrs, ValidateOSType(repo.OSType, repoPath.Child("osType"))...)
+		if repo.Name == "" {
+			allErrs = append(allErrs, field.Required(repoPath.Child("name"), ""))
+		} else if strings.ContainsAny(repo.Name, "/ ") || repo.Name == "." || repo.Name == ".." {
+			allErrs = append(allErrs, field.Invalid(repoPath.Child("name"), repo.Name, "must be a file name"))
+		} else if key := string(repo.OSType) + "/" + repo.Name; names[key] {
+			allErrs = append(allErrs, field.Duplicate(repoPath.Child("name"), repo.Name))
+		} else {
+			names[key] = true
+		}
+		if repo.Content == "" {
+			allErrs = append(allErrs, field.Required(repoPath.Child("content"), ""))
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateEtcd validates the replicas and backup config of local etcd.
+func ValidateEtcd(etcd *devopsv1.Etcd, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if etcd == nil || etcd.Local == nil {
+		return allErrs
+	}
+
+	if replicas := etcd.Local.Replicas; replicas != nil && *replicas < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("local", "replicas"), *replicas, "must be greater than or equal to 1"))
+	}
+
+	if etcd.Local.Backup == nil {
+		return allErrs
+	}
+
+	backup := etcd.Local.Backup
+	backupPath := fldPath.Child("local", "backup")
+	if backup.Schedule != "" {
+		if _, err := cron.ParseStandard(backup.Schedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(backupPath.Child("schedule"), backup.Schedule, err.Error()))
+		}
+	}
+
+	if backup.Retention < 0 {
+		allErrs = append(allErrs, field.Invalid(backupPath.Child("retention"), backup.Retention, "must be greater than or equal to 0"))
+	}
+
+	dstPath := backupPath.Child("destination")
+	if backup.Destination.Local != nil && backup.Destination.S3 != nil {
+		allErrs = append(allErrs, field.Forbidden(dstPath, "local and s3 are mutually exclusive"))
+	}
+
+	if s3 := backup.Destination.S3; s3 != nil {
+		if s3.Endpoint == "" {
+			allErrs = append(allErrs, field.Required(dstPath.Child("s3", "endpoint"), ""))
+		}
+		if s3.Bucket == "" {
+			allErrs = append(allErrs, field.Required(dstPath.Child("s3", "bucket"), ""))
+		}
+		if s3.CredentialsSecret == "" {
+			allErrs = append(allErrs, field.Required(dstPath.Child("s3", "credentialsSecret"), ""))
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateCertificates validates the schedule and durations of certificates policy, the leaf lifetime
+// must be longer than renewBefore, or the certs are renewed at every check.
+func ValidateCertificates(policy *devopsv1.CertificatePolicy, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if policy == nil {
+		return allErrs
+	}
+
+	if policy.Schedule != "" {
+		if _, err := cron.ParseStandard(policy.Schedule); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("schedule"), policy.Schedule, err.Error()))
+		}
+	}
+
+	lifetime := constants.CertLeafLifetime
+	if policy.LeafLifetime != nil {
+		lifetime = policy.LeafLifetime.Duration
+		if lifetime <= 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("leafLifetime"), lifetime.String(), "must be greater than 0"))
+		}
+	}
+
+	renewBefore := constants.RenewCertsTimeThreshold
+	if policy.RenewBefore != nil {
+		renewBefore = policy.RenewBefore.Duration
+		if renewBefore <= 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("renewBefore"), renewBefore.String(), "must be greater than 0"))
+		}
+	}
+
+	if len(allErrs) == 0 && lifetime <= renewBefore {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("leafLifetime"), lifetime.String(),
+			fmt.Sprintf("must be greater than renewBefore %s", renewBefore)))
+	}
+
+	return allErrs
+}
+
+// ValidatePreflightPolicy validates the enabled checks are optional and the thresholds are valid.
+func ValidatePreflightPolicy(policy *devopsv1.PreflightPolicy, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if policy == nil {
+		return allErrs
+	}
+
+	known := preflight.KnownCheckNames()
+	allErrs = append(allErrs, validateCheckNames(policy.Enable, preflight.OptionalCheckNames(), fldPath.Child("enable"))...)
+	allErrs = append(allErrs, validateCheckNames(policy.Disable, known, fldPath.Child("disable"))...)
+	allErrs = append(allErrs, validateCheckNames(policy.IgnoreErrors, append(known, devopsv1.PreflightIgnoreAll), fldPath.Child("ignoreErrors"))...)
+	if policy.MinCPU != nil && *policy.MinCPU < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("minCPU"), *policy.MinCPU, "must be greater than or equal to 1"))
+	}
+	if policy.MinMemory != nil && policy.MinMemory.Sign() <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("minMemory"), policy.MinMemory.String(), "must be greater than 0"))
+	}
+	if policy.MinDisk != nil && policy.MinDisk.Value() < 1<<30 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("minDisk"), policy.MinDisk.String(), "must be greater than or equal to 1Gi"))
+	}
+	if policy.MinKernelVersion != "" {
+		if _, _, ok := preflight.ParseKernelVersion(policy.MinKernelVersion); !ok {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("minKernelVersion"), policy.MinKernelVersion, "must be major.minor"))
+		}
+	}
+	if policy.MaxClockSkew != nil && policy.MaxClockSkew.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxClockSkew"), policy.MaxClockSkew.Duration.String(), "must be greater than 0"))
+	}
+
+	return allErrs
+}
+
+// validateCheckNames validates the names are in the supported ones, the typo is rejected instead of
+// doing nothing.
+func validateCheckNames(names []string, supported []string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i, name := range names {
+		found := false
+		for _, n := range supported {
+			found = found || strings.EqualFold(n, name)
+		}
+		if !found {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Index(i), name, supported))
+		}
+	}
+	return allErrs
+}
+
+// ValidateGPU validates the physical gpu is only used with containerd, the nvidia runtime is
+// configured for containerd.
+func ValidateGPU(spec *devopsv1.ClusterSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	gpuType := spec.Features.GPUType
+	if gpuType == nil || *gpuType != devopsv1.GPUPhysical {
+		return allErrs
+	}
+
+	if spec.CRIType != "" && spec.CRIType != devopsv1.ContainerdCRI {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("criType"), spec.CRIType, "physical gpu requires containerd"))
+	}
+
+	return allErrs
+}
+
+// ValidateLoadBalancer validates the metallb config when publicLB or internalLB is enabled.
+func ValidateLoadBalancer(features *devopsv1.ClusterFeature, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if !features.LoadBalancerEnabled() {
+		return allErrs
+	}
+
+	lbPath := fldPath.Child("loadBalancer")
+	lb := features.LoadBalancer
+	if lb == nil || len(lb.AddressPools) == 0 {
+		return append(allErrs, field.Required(lbPath.Child("addressPools"), "required when publicLB or internalLB is enabled"))
+	}
+
+	names := make(map[string]bool)
+	for i, pool := range lb.AddressPools {
+		poolPath := lbPath.Child("addressPools").Index(i)
+		if pool.Name == "" {
+			allErrs = append(allErrs, field.Required(poolPath.Child("name"), ""))
+		} else if names[pool.Name] {
+			allErrs = append(allErrs, field.Duplicate(poolPath.Child("name"), pool.Name))
+		}
+		names[pool.Name] = true
+
+		if len(pool.Addresses) == 0 {
+			allErrs = append(allErrs, field.Required(poolPath.Child("addresses"), ""))
+		}
+		for j, addr := range pool.Addresses {
+			if err := validateAddressRange(addr); err != nil {
+				allErrs = append(allErrs, field.Invalid(poolPath.Child("addresses").Index(j), addr, err.Error()))
+			}
+		}
+	}
+
+	if lb.Mode != devopsv1.LoadBalancerBGP {
+		return allErrs
+	}
+	if len(lb.BGPPeers) == 0 {
+		allErrs = append(allErrs, field.Required(lbPath.Child("bgpPeers"), "required in bgp mode"))
+	}
+	for i, peer := range lb.BGPPeers {
+		peerPath := lbPath.Child("bgpPeers").Index(i)
+		if net.ParseIP(peer.PeerAddress) == nil {
+			allErrs = append(allErrs, field.Invalid(peerPath.Child("peerAddress"), peer.PeerAddress, "must be a valid ip"))
+		}
+		if peer.PeerASN == 0 {
+			allErrs = append(allErrs, field.Required(peerPath.Child("peerASN"), ""))
+		}
+		if peer.MyASN == 0 {
+			allErrs = append(allErrs, field.Required(peerPath.Child("myASN"), ""))
+		}
+	}
+
+	return allErrs
+}
+
+// validateAddressRange validates a cidr or an ip range like 192.168.1.100-192.168.1.200.
+func validateAddressRange(addr string) error {
+	if strings.Contains(addr, "-") {
+		ips := strings.SplitN(addr, "-", 2)
+		start, end := net.ParseIP(strings.TrimSpace(ips[0])), net.ParseIP(strings.TrimSpace(ips[1]))
+		if start == nil || end == nil {
+			return fmt.Errorf("invalid ip range")
+		}
+		if (start.To4() == nil) != (end.To4() == nil) || bytes.Compare(start.To16(), end.To16()) > 0 {
+			return fmt.Errorf("start must be less than or equal to end with the same family")
+		}
+		return nil
+	}
+
+	_, _, err := net.ParseCIDR(addr)
+	return err
+}
+
 // ValidateCIDRs validates clusterCIDR and serviceCIDR.
 func ValidateCIDRs(spec *devopsv1.ClusterSpec, specPath *field.Path) field.ErrorList {
 	allErrs := field.ErrorList{}
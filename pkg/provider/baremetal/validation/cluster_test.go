@@ -0,0 +1,63 @@
+package validation
+
+import (
+	"testing"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidatePreflightPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *devopsv1.PreflightPolicy
+		fields []string
+	}{
+		{
+			name: "known names",
+			policy: &devopsv1.PreflightPolicy{
+				Enable:       []string{"KernelModule-iptable_nat"},
+				Disable:      []string{"swap", "Reachability"},
+				IgnoreErrors: []string{"Memory", "ClockSkew"},
+			},
+		},
+		{
+			name:   "ignore all",
+			policy: &devopsv1.PreflightPolicy{IgnoreErrors: []string{devopsv1.PreflightIgnoreAll}},
+		},
+		{
+			name:   "enable required check",
+			policy: &devopsv1.PreflightPolicy{Enable: []string{"Swap"}},
+			fields: []string{"spec.preflight.enable[0]"},
+		},
+		{
+			name:   "disable typo",
+			policy: &devopsv1.PreflightPolicy{Disable: []string{"Swap", "Reachabilty"}},
+			fields: []string{"spec.preflight.disable[1]"},
+		},
+		{
+			name:   "ignore typo",
+			policy: &devopsv1.PreflightPolicy{IgnoreErrors: []string{"Memroy"}},
+			fields: []string{"spec.preflight.ignoreErrors[0]"},
+		},
+		{
+			name:   "all only ignores",
+			policy: &devopsv1.PreflightPolicy{Disable: []string{devopsv1.PreflightIgnoreAll}},
+			fields: []string{"spec.preflight.disable[0]"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidatePreflightPolicy(tt.policy, field.NewPath("spec", "preflight"))
+			if len(errs) != len(tt.fields) {
+				t.Fatalf("expect errors of %v, got %v", tt.fields, errs)
+			}
+			for i, err := range errs {
+				if err.Field != tt.fields[i] {
+					t.Errorf("expect error of %s, got %v", tt.fields[i], err)
+				}
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package validation
 
 import (
+	"fmt"
+
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
@@ -14,9 +17,58 @@ func ValidateMachine(machine *devopsv1.Machine) field.ErrorList {
 	return allErrs
 }
 
+// ValidateMachineUpdate validates the machine is not moved to another cluster.
+func ValidateMachineUpdate(machine *devopsv1.Machine, old *devopsv1.Machine) field.ErrorList {
+	return apivalidation.ValidateImmutableField(machine.Spec.ClusterName, old.Spec.ClusterName, field.NewPath("spec", "clusterName"))
+}
+
 // ValidateMachineSpec validates a given machine spec.
 func ValidateMachineSpec(spec *devopsv1.MachineSpec, fldPath *field.Path) field.ErrorList {
 	allErrs := field.ErrorList{}
+	if spec.Machine != nil {
+		allErrs = append(allErrs, ValidateArch(spec.Machine.Arch, fldPath.Child("machine", "arch"))...)
+	}
+	allErrs = append(allErrs, ValidatePreflightPolicy(spec.Preflight, fldPath.Child("preflight"))...)
 
 	return allErrs
 }
+
+// MachineWarnings returns the warnings of deprecated fields in machine.
+func MachineWarnings(machine *devopsv1.Machine) []string {
+	if machine.Spec.Machine == nil {
+		return nil
+	}
+
+	return ClusterMachineWarnings(machine.Spec.Machine, field.NewPath("spec", "machine"))
+}
+
+// ClusterMachineWarnings warns the inline ssh credentials, which can be read by anyone who can read the cr.
+func ClusterMachineWarnings(m *devopsv1.ClusterMachine, fldPath *field.Path) []string {
+	var warnings []string
+	warnings = append(warnings, credentialsWarnings(m.Password, m.PrivateKey, m.PassPhrase, fldPath)...)
+	if m.ProxyJump != nil {
+		p := m.ProxyJump
+		warnings = append(warnings, credentialsWarnings(p.Password, p.PrivateKey, p.PassPhrase, fldPath.Child("proxyJump"))...)
+	}
+
+	return warnings
+}
+
+func credentialsWarnings(password string, privateKey, passPhrase []byte, fldPath *field.Path) []string {
+	var warnings []string
+	if password != "" {
+		warnings = append(warnings, deprecatedWarning(fldPath.Child("password")))
+	}
+	if len(privateKey) != 0 {
+		warnings = append(warnings, deprecatedWarning(fldPath.Child("privateKey")))
+	}
+	if len(passPhrase) != 0 {
+		warnings = append(warnings, deprecatedWarning(fldPath.Child("passPhrase")))
+	}
+
+	return warnings
+}
+
+func deprecatedWarning(fldPath *field.Path) string {
+	return fmt.Sprintf("%s is deprecated, use credentialsRef instead", fldPath.String())
+}
@@ -6,11 +6,13 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/thoas/go-funk"
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/observe"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	"k8s.io/apiserver/pkg/server/mux"
@@ -23,8 +25,38 @@ const (
 	ReasonSkipProcess       = "SkipProcess"
 
 	ConditionTypeDone = "EnsureDone"
+	// ConditionTypeUpgrade records the failed attempts of the upgrade, it's removed when the upgrade is done.
+	ConditionTypeUpgrade = "EnsureUpgrade"
 )
 
+// ErrCertsRotationUnsupported is returned by OnRotateCerts of the provider without cert handlers.
+var ErrCertsRotationUnsupported = errors.New("certs rotation is unsupported")
+
+// WaitingError is returned by the handler which waits for something in progress, the step is
+// requeued after RequeueAfter without counting a failed attempt.
+type WaitingError struct {
+	Message      string
+	RequeueAfter time.Duration
+}
+
+func (e *WaitingError) Error() string {
+	return e.Message
+}
+
+// Waitingf returns the WaitingError requeued after the duration.
+func Waitingf(after time.Duration, format string, args ...interface{}) error {
+	return &WaitingError{Message: fmt.Sprintf(format, args...), RequeueAfter: after}
+}
+
+// IsWaiting returns the duration to requeue if err is a WaitingError.
+func IsWaiting(err error) (time.Duration, bool) {
+	var waiting *WaitingError
+	if errors.As(err, &waiting) {
+		return waiting.RequeueAfter, true
+	}
+	return 0, false
+}
+
 // Provider defines a set of response interfaces for specific cluster
 // types in cluster management.
 type Provider interface {
@@ -32,6 +64,8 @@ type Provider interface {
 
 	RegisterHandler(mux *mux.PathRecorderMux)
 
+	// Validate validates the cluster statically, it's called in admission and must not reach
+	// the network or the hosts.
 	Validate(ctx *common.ClusterContext) field.ErrorList
 
 	PreCreate(ctx *common.ClusterContext) error
@@ -39,6 +73,9 @@ type Provider interface {
 
 	OnCreate(ctx *common.ClusterContext) error
 	OnUpdate(ctx *common.ClusterContext) error
+	OnUpgrade(ctx *common.ClusterContext) error
+	OnRotateCerts(ctx *common.ClusterContext) error
+	OnPreflight(ctx *common.ClusterContext) error
 	OnDelete(ctx *common.ClusterContext) error
 }
 
@@ -53,9 +90,19 @@ type DelegateProvider struct {
 	PreCreateFunc   func(ctx *common.ClusterContext) error
 	AfterCreateFunc func(ctx *common.ClusterContext) error
 
-	CreateHandlers []Handler
-	DeleteHandlers []Handler
-	UpdateHandlers []Handler
+	CreateHandlers  []Handler
+	DeleteHandlers  []Handler
+	UpdateHandlers  []Handler
+	UpgradeHandlers []Handler
+	// ScaleHandlers run in order on update when the masters of spec are changed
+	ScaleHandlers []Handler
+	// NeedScale returns true if the scale handlers should run, default is the masters of spec are changed
+	NeedScale func(ctx *common.ClusterContext) bool
+	// CertHandlers run in order to renew the leaf certs, or to run the step of CA rotation
+	// in status.certificates.rotation
+	CertHandlers []Handler
+	// PreflightFunc checks the hosts of the cluster into status.preflight without changing them
+	PreflightFunc Handler
 }
 
 func (p *DelegateProvider) Name() string {
@@ -108,6 +155,7 @@ func (p *DelegateProvider) OnCreate(ctx *common.ClusterContext) error {
 			LastTransitionTime: now,
 			Reason:             ReasonSkipProcess,
 		})
+		ctx.HandlerSkipped(ctx.Cluster, "create", condition.Type)
 	} else {
 		f := p.getCreateHandler(condition.Type)
 		if f == nil {
@@ -116,7 +164,19 @@ func (p *DelegateProvider) OnCreate(ctx *common.ClusterContext) error {
 
 		handlerName := f.Name()
 		ctx.Info("onCreate", "handlerName", handlerName)
-		if err = f(ctx); err != nil {
+		err = p.call(ctx, "create", condition.Type, f)
+		if _, ok := IsWaiting(err); ok {
+			ctx.Info("onCreate waiting", "handlerName", handlerName, "message", err.Error())
+			ctx.Cluster.SetCondition(devopsv1.ClusterCondition{
+				Type:          condition.Type,
+				Status:        devopsv1.ConditionUnknown,
+				LastProbeTime: now,
+				Message:       err.Error(),
+				Reason:        ReasonWaitingProcess,
+			})
+			return err
+		}
+		if err != nil {
 			ctx.Error(err, "OnCreate err", "handlerName", handlerName)
 			ctx.Cluster.SetCondition(devopsv1.ClusterCondition{
 				Type:          condition.Type,
@@ -167,6 +227,10 @@ func tryFindHandler(handlerName string, handlers []string) bool {
 }
 
 func (p *DelegateProvider) OnUpdate(ctx *common.ClusterContext) error {
+	if len(p.ScaleHandlers) > 0 && p.needScale(ctx) {
+		return p.onScale(ctx)
+	}
+
 	if ctx.Cluster.Annotations == nil {
 		return nil
 	}
@@ -186,7 +250,7 @@ func (p *DelegateProvider) OnUpdate(ctx *common.ClusterContext) error {
 
 		ctx.Info("onUpdate", "handlerName", handlerName)
 		now := metav1.Now()
-		if err := f(ctx); err != nil {
+		if err := p.call(ctx, "update", handlerName, f); err != nil {
 			ctx.Error(err, "onUpdate err", "handlerName", handlerName)
 			ctx.Cluster.SetCondition(devopsv1.ClusterCondition{
 				Type:          handlerName,
@@ -212,11 +276,132 @@ func (p *DelegateProvider) OnUpdate(ctx *common.ClusterContext) error {
 	return nil
 }
 
+func (p *DelegateProvider) needScale(ctx *common.ClusterContext) bool {
+	if p.NeedScale != nil {
+		return p.NeedScale(ctx)
+	}
+
+	return ctx.Cluster.MastersChanged()
+}
+
+// onScale runs all scale handlers in order until one of them fails, the last handler
+// must sync the addresses of masters, or it will run again.
+func (p *DelegateProvider) onScale(ctx *common.ClusterContext) error {
+	for _, f := range p.ScaleHandlers {
+		handlerName := f.Name()
+		ctx.Info("onScale", "handlerName", handlerName)
+		now := metav1.Now()
+		err := p.call(ctx, "scale", handlerName, f)
+		if _, ok := IsWaiting(err); ok {
+			ctx.Info("onScale waiting", "handlerName", handlerName, "message", err.Error())
+			ctx.Cluster.SetCondition(devopsv1.ClusterCondition{
+				Type:          handlerName,
+				Status:        devopsv1.ConditionUnknown,
+				LastProbeTime: now,
+				Message:       err.Error(),
+				Reason:        ReasonWaitingProcess,
+			})
+			return err
+		}
+		if err != nil {
+			ctx.Error(err, "onScale err", "handlerName", handlerName)
+			ctx.Cluster.SetCondition(devopsv1.ClusterCondition{
+				Type:          handlerName,
+				Status:        devopsv1.ConditionFalse,
+				LastProbeTime: now,
+				Message:       err.Error(),
+				Reason:        ReasonFailedProcess,
+			})
+			ctx.Cluster.Status.Reason = ReasonFailedProcess
+			ctx.Cluster.Status.Message = err.Error()
+			return nil
+		}
+
+		ctx.Cluster.SetCondition(devopsv1.ClusterCondition{
+			Type:               handlerName,
+			Status:             devopsv1.ConditionTrue,
+			LastProbeTime:      now,
+			LastTransitionTime: now,
+			Reason:             ReasonSuccessfulProcess,
+		})
+	}
+
+	return nil
+}
+
+// OnUpgrade runs all upgrade handlers in order until one of them fails, the cluster
+// turns back to running with the spec version when all of them succeed. The failed run is
+// counted as an attempt of the upgrade condition, the waiting one is not, and the error is returned.
+func (p *DelegateProvider) OnUpgrade(ctx *common.ClusterContext) error {
+	start := time.Now()
+	for _, f := range p.UpgradeHandlers {
+		handlerName := f.Name()
+		ctx.Info("onUpgrade", "handlerName", handlerName)
+		err := p.call(ctx, "upgrade", "", f)
+		if _, ok := IsWaiting(err); ok {
+			ctx.Info("onUpgrade waiting", "handlerName", handlerName, "message", err.Error())
+			ctx.Cluster.Status.Reason = ReasonWaitingProcess
+			ctx.Cluster.Status.Message = err.Error()
+			return err
+		}
+		if err != nil {
+			ctx.Error(err, "onUpgrade err", "handlerName", handlerName)
+			ctx.Cluster.RecordAttempt(ConditionTypeUpgrade, time.Since(start))
+			ctx.Cluster.SetCondition(devopsv1.ClusterCondition{
+				Type:          ConditionTypeUpgrade,
+				Status:        devopsv1.ConditionFalse,
+				LastProbeTime: metav1.Now(),
+				Message:       fmt.Sprintf("%s: %v", handlerName, err),
+				Reason:        ReasonFailedProcess,
+			})
+			ctx.Cluster.Status.Reason = ReasonFailedProcess
+			ctx.Cluster.Status.Message = err.Error()
+			return err
+		}
+	}
+
+	ctx.Info("upgrade successfully", "version", ctx.Cluster.Spec.Version)
+	ctx.Cluster.RemoveCondition(ConditionTypeUpgrade)
+	ctx.Cluster.Status.Version = ctx.Cluster.Spec.Version
+	ctx.Cluster.Status.Phase = devopsv1.ClusterRunning
+	ctx.Cluster.Status.Reason = ""
+	ctx.Cluster.Status.Message = ""
+	return nil
+}
+
+// OnRotateCerts runs all cert handlers in order until one of them fails, the provider without
+// cert handlers doesn't support the rotation.
+func (p *DelegateProvider) OnRotateCerts(ctx *common.ClusterContext) error {
+	if len(p.CertHandlers) == 0 {
+		return fmt.Errorf("provider %s: %w", p.Name(), ErrCertsRotationUnsupported)
+	}
+
+	for _, f := range p.CertHandlers {
+		handlerName := f.Name()
+		ctx.Info("onRotateCerts", "handlerName", handlerName)
+		if err := p.call(ctx, "certs", "", f); err != nil {
+			ctx.Error(err, "onRotateCerts err", "handlerName", handlerName)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OnPreflight runs the preflight checks of the hosts, it's unsupported by the provider without PreflightFunc.
+func (p *DelegateProvider) OnPreflight(ctx *common.ClusterContext) error {
+	if p.PreflightFunc == nil {
+		return fmt.Errorf("provider %s doesn't support preflight", p.Name())
+	}
+
+	return p.call(ctx, "preflight", "", p.PreflightFunc)
+}
+
 func (p *DelegateProvider) OnDelete(ctx *common.ClusterContext) error {
 	for _, f := range p.DeleteHandlers {
 		handlerName := f.Name()
 		ctx.Info("OnDelete", "handlerName", handlerName)
-		err := f(ctx)
+		err := p.call(ctx, "delete", "", f)
 		if err != nil {
 			ctx.Error(err, "OnDelete err", "handlerName", handlerName)
 			return err
@@ -226,6 +411,23 @@ func (p *DelegateProvider) OnDelete(ctx *common.ClusterContext) error {
 	return nil
 }
 
+// call runs the handler, emits the events and records its duration and failure, the attempt
+// is counted on the condition of conditionType if it's not empty.
+func (p *DelegateProvider) call(ctx *common.ClusterContext, operation string, conditionType string, f Handler) error {
+	handlerName := f.Name()
+	ctx.HandlerStarted(ctx.Cluster, operation, handlerName)
+	start := time.Now()
+	err := f(ctx)
+	observe.ObserveHandler("cluster", p.Name(), operation, handlerName, start, err)
+	elapsed := time.Since(start)
+	// waiting is not a failed attempt
+	if _, ok := IsWaiting(err); conditionType != "" && !ok {
+		ctx.Cluster.RecordAttempt(conditionType, elapsed)
+	}
+	ctx.HandlerFinished(ctx.Cluster, operation, handlerName, elapsed, err)
+	return err
+}
+
 func (h Handler) Name() string {
 	name := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
 	i := strings.Index(name, "Ensure")
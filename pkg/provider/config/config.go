@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/wtxue/kok-operator/pkg/constants"
@@ -24,6 +25,21 @@ type Config struct {
 	EnableCustomImages bool
 	EnableOnKube       bool
 	EnableHostNetwork  bool
+	// MaxConcurrency is the max number of hosts provisioned at the same time
+	MaxConcurrency int
+	// MaxAttempts is the max attempts of a failed create step before the phase turns to failed, no limit if less than 1
+	MaxAttempts int
+	// RetryBaseInterval and RetryMaxInterval bound the exponential backoff of a failed create step
+	RetryBaseInterval time.Duration
+	RetryMaxInterval  time.Duration
+	// ArtifactSource is the url of the binaries installed on the hosts, the local dir, http file
+	// server or oci artifact, see artifact.NewSource
+	ArtifactSource string
+	// ArtifactCacheDir is where the artifacts of the remote source are downloaded
+	ArtifactCacheDir string
+	// ArtifactSkipVerify allows the local source without manifest.yaml, whose artifacts are
+	// copied to the hosts without sha256 verification
+	ArtifactSkipVerify bool
 }
 
 type Registry struct {
@@ -64,6 +80,12 @@ func NewDefaultConfig() *Config {
 		EnableOnKube:       true,
 		EnableCustomImages: false,
 		EnableHostNetwork:  false,
+		MaxConcurrency:     5,
+		MaxAttempts:        10,
+		RetryBaseInterval:  10 * time.Second,
+		RetryMaxInterval:   5 * time.Minute,
+		ArtifactSource:     "file:///",
+		ArtifactCacheDir:   "/var/cache/kok-operator/artifacts",
 	}
 }
 
@@ -71,6 +93,55 @@ func (r *Config) NeedSetHosts() bool {
 	return r.Registry.IP != ""
 }
 
+// Concurrency returns the max number of hosts provisioned at the same time, at least one.
+func (r *Config) Concurrency() int {
+	if r.MaxConcurrency < 1 {
+		return 1
+	}
+
+	return r.MaxConcurrency
+}
+
+// RetryInterval returns the backoff interval after the attempts of a failed step, it doubles
+// from RetryBaseInterval on each attempt and is capped by RetryMaxInterval.
+func (r *Config) RetryInterval(attempts int32) time.Duration {
+	interval := r.RetryBaseInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	max := r.RetryMaxInterval
+	if max < interval {
+		max = interval
+	}
+
+	for i := int32(1); i < attempts && interval < max; i++ {
+		interval *= 2
+	}
+	if interval > max {
+		return max
+	}
+	return interval
+}
+
+// RetryWait returns how long the failed step probed at last should still wait before the next
+// attempt, 0 means it can be retried now.
+func (r *Config) RetryWait(attempts int32, last, now time.Time) time.Duration {
+	if last.IsZero() {
+		return 0
+	}
+
+	next := last.Add(r.RetryInterval(attempts))
+	if now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// ExceedMaxAttempts returns true when the failed step should not be retried anymore.
+func (r *Config) ExceedMaxAttempts(attempts int32) bool {
+	return r.MaxAttempts > 0 && int(attempts) >= r.MaxAttempts
+}
+
 func (r *Config) ImageFullName(name, tag string) string {
 	b := new(bytes.Buffer)
 	b.WriteString(name)
@@ -119,5 +190,12 @@ func (r *Config) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&r.EnableOnKube, "enable-onkube", r.EnableOnKube, "if true, the cluster manager will use on kube apiserver")
 	fs.BoolVar(&r.EnableHostNetwork, "enable-host-network", r.EnableHostNetwork, "if true, the kube-apiserver pod use hostNetwork")
 	fs.BoolVar(&r.EnableCustomImages, "enable-custom-images", r.EnableCustomImages, "enable custom images")
+	fs.IntVar(&r.MaxConcurrency, "max-concurrency", r.MaxConcurrency, "the max number of hosts provisioned at the same time")
+	fs.IntVar(&r.MaxAttempts, "max-attempts", r.MaxAttempts, "the max attempts of a failed create step before the cluster or machine turns to failed, 0 means no limit")
+	fs.DurationVar(&r.RetryBaseInterval, "retry-base-interval", r.RetryBaseInterval, "the initial backoff interval to retry a failed create step")
+	fs.DurationVar(&r.RetryMaxInterval, "retry-max-interval", r.RetryMaxInterval, "the max backoff interval to retry a failed create step")
+	fs.StringVar(&r.ArtifactSource, "artifact-source", r.ArtifactSource, "the source of the binaries installed on the hosts: file:///dir, http(s)://host/path or oci://registry/repository:tag")
+	fs.StringVar(&r.ArtifactCacheDir, "artifact-cache-dir", r.ArtifactCacheDir, "the dir to cache the artifacts downloaded from the remote source")
+	fs.BoolVar(&r.ArtifactSkipVerify, "artifact-skip-verify", r.ArtifactSkipVerify, "allow the local artifact source without manifest.yaml, the artifacts are not verified by sha256")
 	fs.StringArrayVar(&r.SupportK8sVersion, "support-k8s-version", r.SupportK8sVersion, "the support k8s version")
 }
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryInterval(t *testing.T) {
+	cfg := NewDefaultConfig()
+	cases := map[int32]time.Duration{
+		0:   10 * time.Second,
+		1:   10 * time.Second,
+		2:   20 * time.Second,
+		4:   80 * time.Second,
+		6:   5 * time.Minute,
+		100: 5 * time.Minute,
+	}
+	for attempts, expect := range cases {
+		if got := cfg.RetryInterval(attempts); got != expect {
+			t.Errorf("attempts %d: expect %s, got %s", attempts, expect, got)
+		}
+	}
+
+	if cfg.ExceedMaxAttempts(9) || !cfg.ExceedMaxAttempts(10) {
+		t.Errorf("unexpected max attempts check of %d", cfg.MaxAttempts)
+	}
+
+	cfg.MaxAttempts = 0
+	if cfg.ExceedMaxAttempts(1000) {
+		t.Errorf("expect no limit")
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	cfg := NewDefaultConfig()
+	now := time.Now()
+
+	if got := cfg.RetryWait(2, now.Add(-5*time.Second), now); got != 15*time.Second {
+		t.Errorf("expect 15s, got %s", got)
+	}
+	if got := cfg.RetryWait(2, now.Add(-time.Minute), now); got != 0 {
+		t.Errorf("expect no wait, got %s", got)
+	}
+	if got := cfg.RetryWait(1, time.Time{}, now); got != 0 {
+		t.Errorf("expect no wait without probe time, got %s", got)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/thoas/go-funk"
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/observe"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
@@ -29,6 +31,8 @@ const (
 type Provider interface {
 	Name() string
 
+	// Validate validates the machine statically, it's called in admission and must not reach
+	// the network or the hosts.
 	Validate(machine *devopsv1.Machine) field.ErrorList
 
 	PreCreate(machine *devopsv1.Machine) error
@@ -112,6 +116,7 @@ func (p *DelegateProvider) OnCreate(ctx *common.ClusterContext, machine *devopsv
 			Reason:             ReasonSkip,
 			Message:            "Skip current condition",
 		})
+		ctx.HandlerSkipped(machine, "create", condition.Type)
 	} else {
 		f := p.getCreateHandler(condition.Type)
 		if f == nil {
@@ -119,7 +124,7 @@ func (p *DelegateProvider) OnCreate(ctx *common.ClusterContext, machine *devopsv
 		}
 		handlerName := f.Name()
 		ctx.Info("OnCreate", "handlerName", handlerName)
-		err = f(ctx, machine)
+		err = p.call(ctx, machine, "create", condition.Type, f)
 		if err != nil {
 			ctx.Error(err, " OnCreate", "handlerName", handlerName)
 			machine.SetCondition(devopsv1.MachineCondition{
@@ -160,7 +165,7 @@ func (p *DelegateProvider) OnCreate(ctx *common.ClusterContext, machine *devopsv
 func (p *DelegateProvider) OnUpdate(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
 	for _, f := range p.UpdateHandlers {
 		ctx.Info("OnUpdate", "handlerName", f.Name())
-		err := f(ctx, machine)
+		err := p.call(ctx, machine, "update", "", f)
 		if err != nil {
 			return err
 		}
@@ -174,7 +179,7 @@ func (p *DelegateProvider) OnUpdate(ctx *common.ClusterContext, machine *devopsv
 func (p *DelegateProvider) OnDelete(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
 	for _, f := range p.DeleteHandlers {
 		ctx.Info("OnDelete", "handlerName", f.Name())
-		err := f(ctx, machine)
+		err := p.call(ctx, machine, "delete", "", f)
 		if err != nil {
 			return err
 		}
@@ -183,6 +188,22 @@ func (p *DelegateProvider) OnDelete(ctx *common.ClusterContext, machine *devopsv
 	return nil
 }
 
+// call runs the handler, emits the events and records its duration and failure, the attempt
+// is counted on the condition of conditionType if it's not empty.
+func (p *DelegateProvider) call(ctx *common.ClusterContext, machine *devopsv1.Machine, operation string, conditionType string, f Handler) error {
+	handlerName := f.Name()
+	ctx.HandlerStarted(machine, operation, handlerName)
+	start := time.Now()
+	err := f(ctx, machine)
+	observe.ObserveHandler("machine", p.Name(), operation, handlerName, start, err)
+	elapsed := time.Since(start)
+	if conditionType != "" {
+		machine.RecordAttempt(conditionType, elapsed)
+	}
+	ctx.HandlerFinished(machine, operation, handlerName, elapsed, err)
+	return err
+}
+
 func (p *DelegateProvider) getNextConditionType(conditionType string) string {
 	var (
 		i int
@@ -0,0 +1,265 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	etcdaddon "github.com/wtxue/kok-operator/pkg/addons/etcd"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/k8sutil"
+	clusterprovider "github.com/wtxue/kok-operator/pkg/provider/cluster"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/certs"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/etcd"
+	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+)
+
+// etcdWaitInterval is the interval to check the etcd members again when they are not ready.
+const etcdWaitInterval = 10 * time.Second
+
+// ensureEtcdCredential generates the etcd ca and the client cert of kube-apiserver into
+// the cluster credential, the existing ones are kept.
+func ensureEtcdCredential(ctx *common.ClusterContext) error {
+	if len(ctx.Credential.ETCDCACert) != 0 && len(ctx.Credential.ETCDCAKey) != 0 &&
+		len(ctx.Credential.ETCDAPIClientCert) != 0 && len(ctx.Credential.ETCDAPIClientKey) != 0 {
+		return nil
+	}
+
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName: "etcd-ca",
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "create etcd ca")
+	}
+
+	clientCert, clientKey, err := pkiutil.NewCertAndKey(caCert, caKey, &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName:   pkiutil.APIServerEtcdClientCertCommonName,
+			Organization: []string{pkiutil.SystemPrivilegedGroup},
+			Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "create etcd client cert")
+	}
+
+	caKeyData, err := keyutil.MarshalPrivateKeyToPEM(caKey)
+	if err != nil {
+		return err
+	}
+	clientKeyData, err := keyutil.MarshalPrivateKeyToPEM(clientKey)
+	if err != nil {
+		return err
+	}
+
+	ctx.Credential.ETCDCACert = pkiutil.EncodeCertPEM(caCert)
+	ctx.Credential.ETCDCAKey = caKeyData
+	ctx.Credential.ETCDAPIClientCert = pkiutil.EncodeCertPEM(clientCert)
+	ctx.Credential.ETCDAPIClientKey = clientKeyData
+	ctx.Info("create etcd ca and client cert successfully")
+	return nil
+}
+
+// applyEtcdCertsSecret creates the secret mounted by etcd and kube-apiserver, the server cert
+// is signed again only when the etcd ca is changed.
+func applyEtcdCertsSecret(ctx *common.ClusterContext) error {
+	name := etcdaddon.CertsSecretName(ctx.Cluster)
+	secret := &corev1.Secret{}
+	err := ctx.Client.Get(ctx.Ctx, types.NamespacedName{Namespace: ctx.Cluster.Namespace, Name: name}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && bytes.Equal(secret.Data[etcdaddon.CACertKey], ctx.Credential.ETCDCACert) &&
+		bytes.Equal(secret.Data[etcdaddon.APIServerClientCertKey], ctx.Credential.ETCDAPIClientCert) {
+		return nil
+	}
+
+	caCert, caKey, err := certs.LoadCertAndKeyFromByte(ctx.Credential.ETCDCAKey, ctx.Credential.ETCDCACert)
+	if err != nil {
+		return errors.Wrap(err, "load etcd ca")
+	}
+
+	altNames := certutil.AltNames{
+		IPs: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	for _, san := range etcdaddon.ServerCertSANs(ctx.Cluster) {
+		if ip := net.ParseIP(san); ip != nil {
+			altNames.IPs = append(altNames.IPs, ip)
+		} else {
+			altNames.DNSNames = append(altNames.DNSNames, san)
+		}
+	}
+
+	// the server cert is used by peers too
+	serverCert, serverKey, err := pkiutil.NewCertAndKey(caCert, caKey, &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName: etcdaddon.Name(ctx.Cluster),
+			AltNames:   altNames,
+			Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "create etcd server cert")
+	}
+	serverKeyData, err := keyutil.MarshalPrivateKeyToPEM(serverKey)
+	if err != nil {
+		return err
+	}
+
+	secret = &corev1.Secret{
+		ObjectMeta: k8sutil.ObjectMeta(name, constants.ClusterLabels(ctx.Cluster.Name), ctx.Cluster),
+		Type:       corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			etcdaddon.CACertKey:              ctx.Credential.ETCDCACert,
+			etcdaddon.ServerCertKey:          pkiutil.EncodeCertPEM(serverCert),
+			etcdaddon.ServerKeyKey:           serverKeyData,
+			etcdaddon.APIServerClientCertKey: ctx.Credential.ETCDAPIClientCert,
+			etcdaddon.APIServerClientKeyKey:  ctx.Credential.ETCDAPIClientKey,
+		},
+	}
+
+	logger := ctx.WithValues("cluster", ctx.Cluster.Name)
+	err = k8sutil.Reconcile(logger, ctx.Client, secret, k8sutil.DesiredStatePresent)
+	if err != nil {
+		return errors.Wrapf(err, "apply etcd certs secret err: %v", err)
+	}
+	return nil
+}
+
+// applyEtcdClusterConfigmap writes the initial cluster read by the members started without data.
+func applyEtcdClusterConfigmap(ctx *common.ClusterContext, replicas int32, state string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: k8sutil.ObjectMeta(etcdaddon.ClusterConfigMapName(ctx.Cluster), constants.ClusterLabels(ctx.Cluster.Name), ctx.Cluster),
+		Data: map[string]string{
+			etcdaddon.InitialClusterKey:      etcdaddon.InitialCluster(ctx.Cluster, replicas),
+			etcdaddon.InitialClusterStateKey: state,
+		},
+	}
+
+	logger := ctx.WithValues("cluster", ctx.Cluster.Name)
+	err := k8sutil.Reconcile(logger, ctx.Client, cm, k8sutil.DesiredStatePresent)
+	if err != nil {
+		return errors.Wrapf(err, "apply etcd cluster configmap err: %v", err)
+	}
+	return nil
+}
+
+func (p *Provider) applyEtcdObjects(ctx *common.ClusterContext, replicas int32) error {
+	objs, err := etcdaddon.BuildEtcdAddon(p.Cfg, ctx, replicas)
+	if err != nil {
+		return errors.Wrapf(err, "build etcd")
+	}
+
+	logger := ctx.WithValues("cluster", ctx.Cluster.Name)
+	for _, obj := range objs {
+		err = k8sutil.Reconcile(logger, ctx.Client, obj, k8sutil.DesiredStatePresent)
+		if err != nil {
+			return errors.Wrapf(err, "apply object err: %v", err)
+		}
+	}
+	return nil
+}
+
+func getEtcdStatefulSet(ctx *common.ClusterContext) (*appsv1.StatefulSet, error) {
+	sts := &appsv1.StatefulSet{}
+	err := ctx.Client.Get(ctx.Ctx, types.NamespacedName{Namespace: ctx.Cluster.Namespace, Name: etcdaddon.Name(ctx.Cluster)}, sts)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return sts, nil
+}
+
+// etcdNeedScale returns true if the members of etcd StatefulSet are different from spec.
+func etcdNeedScale(ctx *common.ClusterContext) bool {
+	if !etcdaddon.IsManaged(ctx.Cluster) {
+		return false
+	}
+
+	sts, err := getEtcdStatefulSet(ctx)
+	if err != nil {
+		ctx.Error(err, "failed to get etcd statefulset")
+		return false
+	}
+
+	return sts != nil && sts.Spec.Replicas != nil && *sts.Spec.Replicas != etcdaddon.Replicas(ctx.Cluster)
+}
+
+// etcdReadyMembers returns the number of etcd members kube-apiserver connects to, they are the ready
+// members of the StatefulSet, or the replicas of spec before any member is ready.
+func etcdReadyMembers(ctx *common.ClusterContext) int32 {
+	sts, err := getEtcdStatefulSet(ctx)
+	if err != nil {
+		ctx.Error(err, "failed to get etcd statefulset")
+	}
+	if sts == nil || sts.Status.ReadyReplicas == 0 {
+		return etcdaddon.Replicas(ctx.Cluster)
+	}
+
+	ready := sts.Status.ReadyReplicas
+	if sts.Spec.Replicas != nil && ready > *sts.Spec.Replicas {
+		ready = *sts.Spec.Replicas
+	}
+	return ready
+}
+
+// scaleEtcd adds or removes one member at a time, it's called again on the next reconcile
+// until the replicas of StatefulSet is the same as spec.
+func (p *Provider) scaleEtcd(ctx *common.ClusterContext, sts *appsv1.StatefulSet, replicas, desired int32) error {
+	if sts.Status.ReadyReplicas < replicas {
+		return clusterprovider.Waitingf(etcdWaitInterval, "waiting for all etcd members ready before scaling, ready %d/%d", sts.Status.ReadyReplicas, replicas)
+	}
+
+	endpoints := etcdaddon.ClientEndpoints(ctx.Cluster, replicas)
+	if replicas < desired {
+		err := etcd.AddMember(ctx, endpoints, etcdaddon.PeerURL(ctx.Cluster, replicas))
+		if err != nil {
+			return err
+		}
+
+		err = applyEtcdClusterConfigmap(ctx, replicas+1, "existing")
+		if err != nil {
+			return err
+		}
+
+		ctx.Info("scale out etcd", "from", replicas, "to", replicas+1)
+		return p.applyEtcdObjects(ctx, replicas+1)
+	}
+
+	// the last member is removed through the others
+	last := replicas - 1
+	err := etcd.RemoveMemberByName(ctx, endpoints[:last], etcdaddon.MemberName(ctx.Cluster, last), etcdaddon.PeerURL(ctx.Cluster, last))
+	if err != nil {
+		return err
+	}
+
+	ctx.Info("scale in etcd", "from", replicas, "to", last)
+	err = p.applyEtcdObjects(ctx, last)
+	if err != nil {
+		return err
+	}
+
+	// the data is stale once the member is removed
+	pvc := &corev1.PersistentVolumeClaim{}
+	pvc.Namespace = ctx.Cluster.Namespace
+	pvc.Name = fmt.Sprintf("datadir-%s", etcdaddon.MemberName(ctx.Cluster, last))
+	err = ctx.Client.Delete(ctx.Ctx, pvc)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "delete pvc %s", pvc.Name)
+	}
+	return nil
+}
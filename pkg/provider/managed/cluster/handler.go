@@ -9,6 +9,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/segmentio/ksuid"
 	"github.com/wtxue/kok-operator/pkg/addons/coredns"
+	etcdaddon "github.com/wtxue/kok-operator/pkg/addons/etcd"
 	"github.com/wtxue/kok-operator/pkg/addons/flannel"
 	"github.com/wtxue/kok-operator/pkg/addons/kubeproxy"
 	"github.com/wtxue/kok-operator/pkg/addons/metricsserver"
@@ -17,9 +18,11 @@ import (
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/k8sutil"
+	clusterprovider "github.com/wtxue/kok-operator/pkg/provider/cluster"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/certs"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubeadm"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubemisc"
+	"github.com/wtxue/kok-operator/pkg/provider/preflight"
 	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
 	bootstraputil "k8s.io/cluster-bootstrap/token/util"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -117,6 +120,17 @@ func (p *Provider) ping(resp http.ResponseWriter, req *http.Request) {
 	fmt.Fprint(resp, "pong")
 }
 
+// EnsurePreflightReport runs the preflight checks of the machines into status, the hosts are not changed.
+func (p *Provider) EnsurePreflightReport(ctx *common.ClusterContext) error {
+	hosts, err := preflight.ClusterHosts(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx.Cluster.Status.Preflight = preflight.Run(ctx, hosts, p.Cfg.Concurrency())
+	return nil
+}
+
 func (p *Provider) EnsurePreInstallHook(ctx *common.ClusterContext) error {
 	ctx.Info("ingore pre install")
 	return nil
@@ -163,9 +177,57 @@ func (p *Provider) EnsureKubeMisc(ctx *common.ClusterContext) error {
 	return ApplyKubeMiscConfigmap(ctx, ctx.Credential.KubeData)
 }
 
+// EnsureEtcd deploys the etcd StatefulSet of the cluster and waits for the quorum, the members
+// are scaled one by one to the replicas of spec after that.
 func (p *Provider) EnsureEtcd(ctx *common.ClusterContext) error {
-	ctx.Info("use exists etcd cluster")
-	return nil
+	if !etcdaddon.IsManaged(ctx.Cluster) {
+		ctx.Info("use exists etcd cluster")
+		return nil
+	}
+
+	err := ensureEtcdCredential(ctx)
+	if err != nil {
+		return err
+	}
+	err = applyEtcdCertsSecret(ctx)
+	if err != nil {
+		return err
+	}
+
+	sts, err := getEtcdStatefulSet(ctx)
+	if err != nil {
+		return err
+	}
+
+	desired := etcdaddon.Replicas(ctx.Cluster)
+	replicas := desired
+	if sts == nil {
+		err = applyEtcdClusterConfigmap(ctx, replicas, "new")
+		if err != nil {
+			return err
+		}
+	} else if sts.Spec.Replicas != nil {
+		// the replicas is changed only by scaling
+		replicas = *sts.Spec.Replicas
+	}
+
+	err = p.applyEtcdObjects(ctx, replicas)
+	if err != nil {
+		return err
+	}
+
+	var ready int32
+	if sts != nil {
+		ready = sts.Status.ReadyReplicas
+	}
+	if ready < replicas/2+1 {
+		return clusterprovider.Waitingf(etcdWaitInterval, "waiting for etcd quorum, ready %d/%d", ready, replicas)
+	}
+
+	if replicas == desired {
+		return nil
+	}
+	return p.scaleEtcd(ctx, sts, replicas, desired)
 }
 
 func (p *Provider) EnsureKubeMaster(ctx *common.ClusterContext) error {
@@ -197,7 +259,7 @@ func (p *Provider) EnsureKubeconfig(ctx *common.ClusterContext) error {
 	}
 
 	onKubeApiserver := certs.BuildApiserverEndpoint(ctx.GetOnKubeAPIServerName(), kubemisc.GetBindPort(ctx.Cluster))
-	cfgMaps, err := certs.CreateApiserverKubeConfigFile(ctx.Credential.CAKey, ctx.Credential.CACert, onKubeApiserver, ctx.Cluster.Name)
+	cfgMaps, err := certs.CreateApiserverKubeConfigFile(ctx.Credential.CAKey, ctx.Credential.CACert, certs.LeafNotAfter(ctx.Cluster), onKubeApiserver, ctx.Cluster.Name)
 	if err != nil {
 		return err
 	}
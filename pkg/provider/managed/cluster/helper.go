@@ -6,6 +6,7 @@ import (
 	"sort"
 	"strings"
 
+	etcdaddon "github.com/wtxue/kok-operator/pkg/addons/etcd"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/k8sutil"
@@ -22,6 +23,11 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	etcdCertsVolume = "etcd-certs"
+	etcdCertsDir    = "/etc/kubernetes/etcd-pki/"
+)
+
 type Reconciler struct {
 	Ctx     *common.ClusterContext
 	dynamic dynamic.Interface
@@ -87,7 +93,7 @@ func ApplyCertsConfigmap(ctx *common.ClusterContext, pathCerts map[string][]byte
 	}
 
 	cm := &corev1.ConfigMap{
-		ObjectMeta: k8sutil.ObjectMeta(constants.GenComponentName(ctx.Cluster.GetName(), constants.KubeApiServerCerts), constants.CtrlLabels, ctx.Cluster),
+		ObjectMeta: k8sutil.ObjectMeta(constants.GenComponentName(ctx.Cluster.GetName(), constants.KubeApiServerCerts), constants.ClusterLabels(ctx.Cluster.Name), ctx.Cluster),
 		Data:       noPathCerts,
 	}
 
@@ -109,7 +115,7 @@ func ApplyKubeMiscConfigmap(ctx *common.ClusterContext, pathKubeMisc map[string]
 	}
 
 	cm := &corev1.ConfigMap{
-		ObjectMeta: k8sutil.ObjectMeta(constants.GenComponentName(ctx.Cluster.GetName(), constants.KubeApiServerConfig), constants.CtrlLabels, ctx.Cluster),
+		ObjectMeta: k8sutil.ObjectMeta(constants.GenComponentName(ctx.Cluster.GetName(), constants.KubeApiServerConfig), constants.ClusterLabels(ctx.Cluster.Name), ctx.Cluster),
 		Data:       noPathKubeMisc,
 	}
 
@@ -238,6 +244,32 @@ func (r *Reconciler) apiServerDeployment() client.Object {
 			cmds = append(cmds, fmt.Sprintf("--etcd-certfile=%s", r.Ctx.Cluster.Spec.Etcd.External.CertFile))
 			cmds = append(cmds, fmt.Sprintf("--etcd-keyfile=%s", r.Ctx.Cluster.Spec.Etcd.External.KeyFile))
 		}
+	} else if etcdaddon.IsManaged(r.Ctx.Cluster) {
+		endpoints := etcdaddon.ClientEndpoints(r.Ctx.Cluster, etcdReadyMembers(r.Ctx))
+		cmds = append(cmds, fmt.Sprintf("--etcd-servers=%s", strings.Join(endpoints, ",")))
+		cmds = append(cmds, fmt.Sprintf("--etcd-cafile=%s%s", etcdCertsDir, etcdaddon.CACertKey))
+		cmds = append(cmds, fmt.Sprintf("--etcd-certfile=%s%s", etcdCertsDir, etcdaddon.APIServerClientCertKey))
+		cmds = append(cmds, fmt.Sprintf("--etcd-keyfile=%s%s", etcdCertsDir, etcdaddon.APIServerClientKeyKey))
+
+		vms = append(vms, corev1.VolumeMount{
+			Name:      etcdCertsVolume,
+			MountPath: etcdCertsDir,
+			ReadOnly:  true,
+		})
+		volumes = append(volumes, corev1.Volume{
+			Name: etcdCertsVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: etcdaddon.CertsSecretName(r.Ctx.Cluster),
+					Items: []corev1.KeyToPath{
+						{Key: etcdaddon.CACertKey, Path: etcdaddon.CACertKey},
+						{Key: etcdaddon.APIServerClientCertKey, Path: etcdaddon.APIServerClientCertKey},
+						{Key: etcdaddon.APIServerClientKeyKey, Path: etcdaddon.APIServerClientKeyKey},
+					},
+					DefaultMode: k8sutil.IntPointer(420),
+				},
+			},
+		})
 	} else {
 		cmds = append(cmds, fmt.Sprintf("--etcd-servers=%s", "http://etcd-0.etcd:2379,http://etcd-1.etcd:2379,http://etcd-2.etcd:2379"))
 	}
@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/k8sclient"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func etcdArgs(d *appsv1.Deployment) []string {
+	var args []string
+	for _, arg := range d.Spec.Template.Spec.Containers[0].Command {
+		if strings.HasPrefix(arg, "--etcd-") {
+			args = append(args, arg)
+		}
+	}
+	return args
+}
+
+func TestAPIServerEtcdArgs(t *testing.T) {
+	p := &Provider{Cfg: &config.Config{}}
+	newCtx := func() *common.ClusterContext {
+		ctx := &common.ClusterContext{
+			Ctx:     context.Background(),
+			Logger:  logr.Discard(),
+			Client:  fake.NewClientBuilder().WithScheme(k8sclient.GetScheme()).Build(),
+			Cluster: &devopsv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "demo"}},
+		}
+		if err := p.PreCreate(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return ctx
+	}
+
+	// the existing clusters defaulted with local etcd keep the legacy endpoints
+	ctx := newCtx()
+	d := (&Reconciler{Ctx: ctx, Provider: p}).apiServerDeployment().(*appsv1.Deployment)
+	args := etcdArgs(d)
+	if len(args) != 1 || args[0] != "--etcd-servers=http://etcd-0.etcd:2379,http://etcd-1.etcd:2379,http://etcd-2.etcd:2379" {
+		t.Errorf("expect the legacy etcd args, got %v", args)
+	}
+	for _, v := range d.Spec.Template.Spec.Volumes {
+		if v.Name == etcdCertsVolume {
+			t.Errorf("expect no etcd certs volume")
+		}
+	}
+
+	ctx = newCtx()
+	ctx.Cluster.Spec.Etcd.Local.Managed = true
+	d = (&Reconciler{Ctx: ctx, Provider: p}).apiServerDeployment().(*appsv1.Deployment)
+	args = etcdArgs(d)
+	if len(args) != 4 || !strings.HasPrefix(args[0], "--etcd-servers=https://demo-etcd-0.demo-etcd.demo.svc:2379,") {
+		t.Errorf("expect the managed etcd args, got %v", args)
+	}
+}
@@ -59,6 +59,16 @@ func NewProvider(mgr *clusterprovider.CpManager, cfg *config.Config) (*Provider,
 			p.EnsureCni,
 			p.EnsureMetricsServer,
 		},
+		UpgradeHandlers: []clusterprovider.Handler{
+			p.EnsureKubeMaster,
+			p.EnsureAddons,
+		},
+		ScaleHandlers: []clusterprovider.Handler{
+			p.EnsureEtcd,
+			p.EnsureKubeMaster,
+		},
+		NeedScale:     etcdNeedScale,
+		PreflightFunc: p.EnsurePreflightReport,
 	}
 
 	return p, nil
@@ -71,7 +81,12 @@ func (p *Provider) RegisterHandler(mux *mux.PathRecorderMux) {
 }
 
 func (p *Provider) Validate(ctx *common.ClusterContext) field.ErrorList {
-	return validation.ValidateCluster(ctx)
+	allErrs := validation.ValidateCluster(ctx)
+	// the certs of managed cluster are not on hosts, they can't be rotated
+	if ctx.Cluster.Spec.Certificates != nil {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "certificates"), "certs rotation is not supported by managed clusters"))
+	}
+	return allErrs
 }
 
 func (p *Provider) PreCreate(ctx *common.ClusterContext) error {
@@ -99,13 +99,31 @@ func (p *Provider) EnsureClean(ctx *common.ClusterContext, machine *devopsv1.Mac
 	return nil
 }
 
+// EnsureMachineInfo detects the system info of machine into status, the cpu arch must have the
+// artifacts of the cluster version.
+func (p *Provider) EnsureMachineInfo(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
+	machineSSH, err := machine.Spec.SSH()
+	if err != nil {
+		return err
+	}
+
+	info, err := preflight.DetectMachineInfo(ctx, machineSSH, machine.Spec.Machine)
+	if info != nil {
+		machine.Status.MachineInfo.OperatingSystem = info.OperatingSystem
+		machine.Status.MachineInfo.KernelVersion = info.KernelVersion
+		machine.Status.MachineInfo.Architecture = info.Architecture
+		machine.Status.MachineInfo.OSImage = info.OSImage
+	}
+	return err
+}
+
 func (p *Provider) EnsurePreflight(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
 	machineSSH, err := machine.Spec.SSH()
 	if err != nil {
 		return err
 	}
 
-	err = preflight.RunNodeChecks(machineSSH)
+	err = preflight.RunNodeChecks(ctx, machineSSH, machine)
 	if err != nil {
 		return err
 	}
@@ -6,6 +6,7 @@ import (
 	"github.com/wtxue/kok-operator/pkg/provider/config"
 	machineprovider "github.com/wtxue/kok-operator/pkg/provider/machine"
 	"github.com/wtxue/kok-operator/pkg/provider/managed"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/node"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 )
 
@@ -33,6 +34,7 @@ func NewProvider(mgr *machineprovider.MpManager, cfg *config.Config) (*Provider,
 	p.DelegateProvider = &machineprovider.DelegateProvider{
 		ProviderName: managed.ProviderName,
 		CreateHandlers: []machineprovider.Handler{
+			p.EnsureMachineInfo,
 			p.EnsureCopyFiles,
 			p.EnsurePreInstallHook,
 			p.EnsureClean,
@@ -57,6 +59,10 @@ func NewProvider(mgr *machineprovider.MpManager, cfg *config.Config) (*Provider,
 			p.EnsurePostInstallHook,
 			p.EnsureRegistryHosts,
 		},
+		DeleteHandlers: []machineprovider.Handler{
+			node.EnsureRemoveNode,
+			node.EnsureCleanNode,
+		},
 	}
 
 	return p, nil
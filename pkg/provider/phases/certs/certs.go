@@ -62,6 +62,7 @@ func CreateCertAndKeyFilesWithCA(certSpec *KubeadmCert, ca *CaAll, cfg *apis.War
 		return errors.Wrapf(err, "couldn't create %q certificate", certSpec.Name)
 	}
 
+	certConfig.NotAfter = cfg.NotAfter
 	cert, key, err := pkiutil.NewCertAndKey(ca.CaCert, ca.CaKey, certConfig)
 	if err != nil {
 		return err
@@ -28,6 +28,8 @@ import (
 type clientCertAuth struct {
 	CAKey         crypto.Signer
 	Organizations []string
+	// NotAfter overrides the expiration of the client cert
+	NotAfter *time.Time
 }
 
 // tokenAuth struct holds info required to use a token to provide authentication info in a kubeconfig object
@@ -37,7 +39,9 @@ type tokenAuth struct {
 
 // kubeConfigSpec struct holds info required to build a KubeConfig object
 type kubeConfigSpec struct {
-	CACert         *x509.Certificate
+	CACert *x509.Certificate
+	// CAData is the trusted CAs, it holds both the old and new CAs during CA rotation
+	CAData         []byte
 	APIServer      string
 	ClientName     string
 	TokenAuth      *tokenAuth
@@ -83,7 +87,7 @@ func LoadCertAndKeyFromByte(CAKey, CACert []byte) (*x509.Certificate, crypto.Sig
 
 // getKubeConfigSpecs returns all KubeConfigSpecs actualized to the context of the current InitConfiguration
 // NB. this methods holds the information about how kubeadm creates kubeconfig files.
-func getKubeConfigSpecs(CAKey, CACert []byte, apiserver string, kubeletNodeAddr string) (map[string]*kubeConfigSpec, error) {
+func getKubeConfigSpecs(CAKey, CACert []byte, notAfter *time.Time, apiserver string, kubeletNodeAddr string) (map[string]*kubeConfigSpec, error) {
 
 	caCert, caKey, err := LoadCertAndKeyFromByte(CAKey, CACert)
 	if err != nil {
@@ -93,36 +97,44 @@ func getKubeConfigSpecs(CAKey, CACert []byte, apiserver string, kubeletNodeAddr
 	var kubeConfigSpec = map[string]*kubeConfigSpec{
 		pkiutil.AdminKubeConfigFileName: {
 			CACert:     caCert,
+			CAData:     CACert,
 			APIServer:  apiserver,
 			ClientName: "kubernetes-admin",
 			ClientCertAuth: &clientCertAuth{
 				CAKey:         caKey,
 				Organizations: []string{pkiutil.SystemPrivilegedGroup},
+				NotAfter:      notAfter,
 			},
 		},
 		pkiutil.KubeletKubeConfigFileName: {
 			CACert:     caCert,
+			CAData:     CACert,
 			APIServer:  apiserver,
 			ClientName: fmt.Sprintf("%s%s", pkiutil.NodesUserPrefix, kubeletNodeAddr),
 			ClientCertAuth: &clientCertAuth{
 				CAKey:         caKey,
 				Organizations: []string{pkiutil.NodesGroup},
+				NotAfter:      notAfter,
 			},
 		},
 		pkiutil.ControllerManagerKubeConfigFileName: {
 			CACert:     caCert,
+			CAData:     CACert,
 			APIServer:  apiserver,
 			ClientName: pkiutil.ControllerManagerUser,
 			ClientCertAuth: &clientCertAuth{
-				CAKey: caKey,
+				CAKey:    caKey,
+				NotAfter: notAfter,
 			},
 		},
 		pkiutil.SchedulerKubeConfigFileName: {
 			CACert:     caCert,
+			CAData:     CACert,
 			APIServer:  apiserver,
 			ClientName: pkiutil.SchedulerUser,
 			ClientCertAuth: &clientCertAuth{
-				CAKey: caKey,
+				CAKey:    caKey,
+				NotAfter: notAfter,
 			},
 		},
 	}
@@ -140,7 +152,7 @@ func buildKubeConfigFromSpec(spec *kubeConfigSpec, clustername string) (*clientc
 			spec.APIServer,
 			clustername,
 			spec.ClientName,
-			pkiutil.EncodeCertPEM(spec.CACert),
+			spec.CAData,
 			spec.TokenAuth.Token,
 		), nil
 	}
@@ -152,6 +164,7 @@ func buildKubeConfigFromSpec(spec *kubeConfigSpec, clustername string) (*clientc
 			Organization: spec.ClientCertAuth.Organizations,
 			Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 		},
+		NotAfter: spec.ClientCertAuth.NotAfter,
 	}
 	clientCert, clientKey, err := pkiutil.NewCertAndKey(spec.CACert, spec.ClientCertAuth.CAKey, &clientCertConfig)
 	if err != nil {
@@ -167,7 +180,7 @@ func buildKubeConfigFromSpec(spec *kubeConfigSpec, clustername string) (*clientc
 		spec.APIServer,
 		clustername,
 		spec.ClientName,
-		pkiutil.EncodeCertPEM(spec.CACert),
+		spec.CAData,
 		encodedClientKey,
 		pkiutil.EncodeCertPEM(clientCert),
 	), nil
@@ -183,10 +196,10 @@ func DecodeKubeConfigByte(data []byte, config *clientcmdapi.Config) error {
 
 // CreateKubeConfigFiles creates all the requested kubeconfig files.
 // If kubeconfig files already exists, they are used only if evaluated equal; otherwise an error is returned.
-func CreateKubeConfigFiles(CAKey, CACert []byte, apiserver string, kubeletNodeAddr string, clusterName string, kubeConfigFileNames ...string) (map[string]*clientcmdapi.Config, error) {
+func CreateKubeConfigFiles(CAKey, CACert []byte, notAfter *time.Time, apiserver string, kubeletNodeAddr string, clusterName string, kubeConfigFileNames ...string) (map[string]*clientcmdapi.Config, error) {
 	cfgMaps := make(map[string]*clientcmdapi.Config)
 	// gets the KubeConfigSpecs, actualized for the current InitConfiguration
-	specs, err := getKubeConfigSpecs(CAKey, CACert, apiserver, kubeletNodeAddr)
+	specs, err := getKubeConfigSpecs(CAKey, CACert, notAfter, apiserver, kubeletNodeAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -248,16 +261,16 @@ func BuildApiserverEndpoint(ipOrDns string, bindPort int) string {
 	return controlPlaneURL.String()
 }
 
-func CreateKubeletKubeConfigFile(CAKey, CACert []byte, apiserver string, kubeletNodeAddr string, clusterName string) (map[string]*clientcmdapi.Config, error) {
-	return CreateKubeConfigFiles(CAKey, CACert, apiserver, kubeletNodeAddr, clusterName, GetKubeletKubeconfigList()...)
+func CreateKubeletKubeConfigFile(CAKey, CACert []byte, notAfter *time.Time, apiserver string, kubeletNodeAddr string, clusterName string) (map[string]*clientcmdapi.Config, error) {
+	return CreateKubeConfigFiles(CAKey, CACert, notAfter, apiserver, kubeletNodeAddr, clusterName, GetKubeletKubeconfigList()...)
 }
 
-func CreateMasterKubeConfigFile(CAKey, CACert []byte, apiserver string, clusterName string) (map[string]*clientcmdapi.Config, error) {
-	return CreateKubeConfigFiles(CAKey, CACert, apiserver, "", clusterName, GetMasterKubeConfigList()...)
+func CreateMasterKubeConfigFile(CAKey, CACert []byte, notAfter *time.Time, apiserver string, clusterName string) (map[string]*clientcmdapi.Config, error) {
+	return CreateKubeConfigFiles(CAKey, CACert, notAfter, apiserver, "", clusterName, GetMasterKubeConfigList()...)
 }
 
-func CreateApiserverKubeConfigFile(CAKey, CACert []byte, apiserver string, clusterName string) (map[string]*clientcmdapi.Config, error) {
-	return CreateKubeConfigFiles(CAKey, CACert, apiserver, "", clusterName, GetApiserverKubeconfigList()...)
+func CreateApiserverKubeConfigFile(CAKey, CACert []byte, notAfter *time.Time, apiserver string, clusterName string) (map[string]*clientcmdapi.Config, error) {
+	return CreateKubeConfigFiles(CAKey, CACert, notAfter, apiserver, "", clusterName, GetApiserverKubeconfigList()...)
 }
 
 func GetKubeletKubeconfigList() []string {
@@ -279,3 +292,38 @@ func GetMasterKubeConfigList() []string {
 		pkiutil.SchedulerKubeConfigFileName,
 	}
 }
+
+// CreateShortLivedAdminKubeConfig creates an admin kubeconfig whose client cert expires after ttl.
+func CreateShortLivedAdminKubeConfig(CAKey, CACert []byte, apiserver, clusterName, userName string, ttl time.Duration) (*clientcmdapi.Config, error) {
+	caCert, caKey, err := LoadCertAndKeyFromByte(CAKey, CACert)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create a kubeconfig; the CA files couldn't be loaded")
+	}
+
+	notAfter := time.Now().Add(ttl)
+	clientCert, clientKey, err := pkiutil.NewCertAndKey(caCert, caKey, &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName:   userName,
+			Organization: []string{pkiutil.SystemPrivilegedGroup},
+			Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		},
+		NotAfter: &notAfter,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failure while creating %s client certificate", userName)
+	}
+
+	encodedClientKey, err := keyutil.MarshalPrivateKeyToPEM(clientKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal private key to PEM")
+	}
+
+	return kubeconfigutil.CreateWithCerts(
+		apiserver,
+		clusterName,
+		userName,
+		CACert,
+		encodedClientKey,
+		pkiutil.EncodeCertPEM(clientCert),
+	), nil
+}
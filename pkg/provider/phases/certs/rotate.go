@@ -0,0 +1,109 @@
+package certs
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wtxue/kok-operator/pkg/apis"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+// LeafNotAfter returns the expiry of the leaf certs issued now for the cluster, nil means the
+// default validity when the certificates policy is not set.
+func LeafNotAfter(c *devopsv1.Cluster) *time.Time {
+	policy := c.Spec.Certificates
+	if policy == nil {
+		return nil
+	}
+
+	lifetime := constants.CertLeafLifetime
+	if policy.LeafLifetime != nil {
+		lifetime = policy.LeafLifetime.Duration
+	}
+	notAfter := time.Now().Add(lifetime)
+	return &notAfter
+}
+
+// LoadCA loads the CA of certSpec from data, the key matches the first cert of the bundle.
+func LoadCA(certSpec *KubeadmCert, certsDir string, data map[string][]byte) (*CaAll, error) {
+	certPath, keyPath := pkiutil.PathsForCertAndKey(certsDir, certSpec.BaseName)
+	caCert, caKey, err := LoadCertAndKeyFromByte(data[keyPath], data[certPath])
+	if err != nil {
+		return nil, errors.Wrapf(err, "load %s", certSpec.Name)
+	}
+
+	return &CaAll{
+		CaCert: caCert,
+		CaKey:  caKey,
+		Cfg:    certSpec}, nil
+}
+
+// RotateCA updates the CA of certSpec in data for the step of CA rotation. The new CA is generated
+// into pending at the first step, and removed from pending at the last step:
+//   - DistributingCA: the cert is the bundle of the old and new CAs, the old key signs
+//   - Reissuing: the cert is the bundle of the new and old CAs, the new key signs
+//   - RetiringCA: the cert is the new CA only
+func RotateCA(certSpec *KubeadmCert, cfg *apis.WarpperConfiguration, phase devopsv1.CertRotationPhase, data, pending map[string][]byte) error {
+	certPath, keyPath := pkiutil.PathsForCertAndKey(cfg.CertificatesDir, certSpec.BaseName)
+	if _, ok := pending[certPath]; !ok {
+		switch phase {
+		case devopsv1.CertRotationDistributingCA:
+			if _, err := CreateCACertAndKeyFiles(certSpec, cfg, pending); err != nil {
+				return err
+			}
+		case devopsv1.CertRotationRetiringCA:
+			// retired by the last try
+			return nil
+		default:
+			return errors.Errorf("no new CA of %s to rotate", certSpec.Name)
+		}
+	}
+
+	newCert := pending[certPath]
+	oldCerts, err := removeCert(data[certPath], newCert)
+	if err != nil {
+		return errors.Wrapf(err, "parse %s", certPath)
+	}
+
+	switch phase {
+	case devopsv1.CertRotationDistributingCA:
+		data[certPath] = append(oldCerts, newCert...)
+	case devopsv1.CertRotationReissuing:
+		data[certPath] = append(append([]byte{}, newCert...), oldCerts...)
+		data[keyPath] = pending[keyPath]
+	case devopsv1.CertRotationRetiringCA:
+		data[certPath] = newCert
+		data[keyPath] = pending[keyPath]
+		delete(pending, certPath)
+		delete(pending, keyPath)
+	default:
+		return errors.Errorf("unknown CA rotation step %q", phase)
+	}
+
+	return nil
+}
+
+// removeCert returns the PEM of the certs in bundle except cert.
+func removeCert(bundle, cert []byte) ([]byte, error) {
+	removed, err := certutil.ParseCertsPEM(cert)
+	if err != nil {
+		return nil, err
+	}
+	all, err := certutil.ParseCertsPEM(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, c := range all {
+		if c.Equal(removed[0]) {
+			continue
+		}
+		buf.Write(pkiutil.EncodeCertPEM(c))
+	}
+	return buf.Bytes(), nil
+}
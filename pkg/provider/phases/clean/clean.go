@@ -1,16 +1,26 @@
 package clean
 
 import (
+	"fmt"
 	"os"
 
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/cri"
 	"github.com/wtxue/kok-operator/pkg/util/ssh"
 
 	"github.com/pkg/errors"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-func CleanNode(s ssh.Interface) error {
-	cmd := "kubeadm reset -f && rm -rf /var/lib/etcd /var/lib/kubelet /var/lib/dockershim /var/run/kubernetes /var/lib/cni /etc/kubernetes /etc/cni /root/.kube /opt/k8s && ipvsadm --clear"
+func CleanNode(s ssh.Interface, criType devopsv1.CRIType) error {
+	// kubeadm reset clean up the containers by the cri socket of the runtime
+	cmd := fmt.Sprintf("kubeadm reset -f --cri-socket=%s && rm -rf /var/lib/etcd /var/lib/kubelet /var/lib/dockershim /var/run/kubernetes /var/lib/cni /etc/kubernetes /etc/cni /root/.kube /opt/k8s && ipvsadm --clear",
+		cri.GetCRISocket(criType))
+	if criType == devopsv1.DockerCRI {
+		// remove the containers left by the docker engine, e.g. cri-dockerd is not running
+		cmd = `ids=$(docker ps -aq -f "label=io.kubernetes.pod.namespace"); [ -z "$ids" ] || docker rm -f $ids; ` + cmd
+	}
+
 	logf.Log.V(4).Info("start exec", "node", s.HostIP(), "cmd", cmd)
 	exit, err := s.ExecStream(cmd, os.Stdout, os.Stderr)
 	if err != nil {
@@ -2,18 +2,17 @@ package cri
 
 import (
 	"bytes"
-	"fmt"
 	"strings"
 
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
-	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
 	"github.com/wtxue/kok-operator/pkg/util/ssh"
 	"github.com/wtxue/kok-operator/pkg/util/template"
 )
 
 const ContainerdConfigTemplate = `disabled_plugins = []
-imports = []
+imports = ["/etc/containerd/conf.d/*.toml"]
 oom_score = 0
 plugin_dir = ""
 required_plugins = []
@@ -72,7 +71,7 @@ version = 2
     max_container_log_line_size = 16384
     netns_mounts_under_state_dir = false
     restrict_oom_score_adj = false
-    sandbox_image = "{{ default "docker.io/wtxue/pause:3.7" .PauseImage }}"
+    sandbox_image = "{{ .PauseImage }}"
     selinux_category_range = 1024
     stats_collect_period = 10
     stream_idle_timeout = "4h0m0s"
@@ -277,24 +276,20 @@ type ContainerdConfig struct {
 	PauseImage            string
 }
 
-func InstallCRI(ctx *common.ClusterContext, s ssh.Interface) error {
-	// dir := "bin/linux/" // local debug config dir
-	otherDir := "/k8s/bin/"
-	if dir := constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterDebugLocalDir); len(dir) > 0 {
-		otherDir = dir + otherDir
-	}
-
+// InstallContainerd install containerd, runc and crictl, then write the containerd config with registry mirrors
+func InstallContainerd(ctx *common.ClusterContext, s ssh.Interface) error {
+	// Src is the name of artifact
 	var CopyList = []devopsv1.File{
 		{
-			Src: otherDir + "crictl",
+			Src: artifact.Crictl,
 			Dst: "/usr/local/bin/crictl",
 		},
 		{
-			Src: otherDir + "containerd.tar.gz",
+			Src: artifact.Containerd,
 			Dst: "/opt/k8s/containerd.tar.gz",
 		},
 		{
-			Src: otherDir + "runc",
+			Src: artifact.Runc,
 			Dst: "/usr/local/sbin/runc",
 		},
 	}
@@ -305,9 +300,9 @@ func InstallCRI(ctx *common.ClusterContext, s ssh.Interface) error {
 		// 	continue
 		// }
 
-		err := s.CopyFile(ls.Src, ls.Dst)
+		err := artifact.Install(ctx, s, ls.Src, ls.Dst)
 		if err != nil {
-			ctx.Error(err, "CopyFile", "node", s.HostIP(), "src", ls.Src)
+			ctx.Error(err, "CopyFile", "node", s.HostIP(), "artifact", ls.Src)
 			return err
 		}
 
@@ -341,9 +336,14 @@ func InstallCRI(ctx *common.ClusterContext, s ssh.Interface) error {
 		ctx.Info("copy successfully", "node", s.HostIP(), "path", ls.Dst)
 	}
 
+	arch, err := artifact.HostArch(s)
+	if err != nil {
+		return err
+	}
+
 	config := &ContainerdConfig{
 		PrivateRegistryConfig: ctx.Cluster.Spec.Registry,
-		PauseImage:            "",
+		PauseImage:            PauseImage(arch),
 	}
 
 	configData, err := template.ParseString(ContainerdConfigTemplate, config)
@@ -363,19 +363,5 @@ func InstallCRI(ctx *common.ClusterContext, s ssh.Interface) error {
 		return err
 	}
 
-	// systemctl enable containerd && systemctl daemon-reload && systemctl restart containerd
-	cmd := "systemctl enable containerd && systemctl daemon-reload && systemctl restart containerd"
-	if _, stderr, exit, err := s.Execf(cmd); err != nil || exit != 0 {
-		cmd = "journalctl --unit containerd -n10 --no-pager"
-		jStdout, _, jExit, jErr := s.Execf(cmd)
-		if jErr != nil || jExit != 0 {
-			return fmt.Errorf("exec %q:error %s", cmd, err)
-		}
-		ctx.Info("log", "cmd", cmd, "stdout", jStdout)
-
-		return fmt.Errorf("Exec %s failed:exit %d:stderr %s:error %s:log:\n%s", cmd, exit, stderr, err, jStdout)
-	}
-
-	ctx.Info("exec successfully", "node", s.HostIP(), "cmd", cmd)
-	return nil
+	return restartService(ctx, s, "containerd")
 }
@@ -0,0 +1,87 @@
+package cri
+
+import (
+	"fmt"
+	"strings"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+)
+
+const (
+	// DefaultPauseImage is the sandbox image used by the container runtime
+	DefaultPauseImage = "docker.io/wtxue/pause:3.7"
+	// MultiArchPauseImage is the sandbox image backed by manifest list, used on the hosts of
+	// other archs than the default one
+	MultiArchPauseImage = "registry.aliyuncs.com/google_containers/pause:3.7"
+)
+
+// PauseImage returns the sandbox image of the host arch.
+func PauseImage(arch string) string {
+	if arch == artifact.DefaultArch {
+		return DefaultPauseImage
+	}
+
+	return MultiArchPauseImage
+}
+
+// InstallCRI install the container runtime specified by cluster spec criType
+func InstallCRI(ctx *common.ClusterContext, s ssh.Interface) error {
+	switch ctx.Cluster.Spec.CRIType {
+	case devopsv1.DockerCRI:
+		return InstallDocker(ctx, s)
+	case devopsv1.ContainerdCRI, "":
+		return InstallContainerd(ctx, s)
+	default:
+		return fmt.Errorf("unsupported cri type: %s", ctx.Cluster.Spec.CRIType)
+	}
+}
+
+// GetCRISocket returns the cri socket of kubelet for the cri type
+func GetCRISocket(criType devopsv1.CRIType) string {
+	if criType == devopsv1.DockerCRI {
+		return constants.CRIDockerdSocket
+	}
+
+	return constants.ContainerdSocket
+}
+
+// RegistryMirrors returns the mirror endpoints of the registry host
+func RegistryMirrors(registry *devopsv1.Registry, host string) []string {
+	if registry == nil {
+		return nil
+	}
+
+	mirror, ok := registry.Mirrors[host]
+	if !ok {
+		return nil
+	}
+
+	return mirror.Endpoints
+}
+
+func restartService(ctx *common.ClusterContext, s ssh.Interface, units ...string) error {
+	// systemctl enable containerd && systemctl daemon-reload && systemctl restart containerd
+	unitList := strings.Join(units, " ")
+	cmd := fmt.Sprintf("systemctl enable %s && systemctl daemon-reload && systemctl restart %s", unitList, unitList)
+	if _, stderr, exit, err := s.Execf(cmd); err != nil || exit != 0 {
+		logCmd := "journalctl"
+		for _, unit := range units {
+			logCmd += " --unit " + unit
+		}
+		logCmd += " -n10 --no-pager"
+		jStdout, _, jExit, jErr := s.Execf(logCmd)
+		if jErr != nil || jExit != 0 {
+			return fmt.Errorf("exec %q:error %s", logCmd, err)
+		}
+		ctx.Info("log", "cmd", logCmd, "stdout", jStdout)
+
+		return fmt.Errorf("Exec %s failed:exit %d:stderr %s:error %s:log:\n%s", cmd, exit, stderr, err, jStdout)
+	}
+
+	ctx.Info("exec successfully", "node", s.HostIP(), "cmd", cmd)
+	return nil
+}
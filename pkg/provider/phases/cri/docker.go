@@ -0,0 +1,214 @@
+package cri
+
+import (
+	"bytes"
+	"encoding/json"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	"github.com/wtxue/kok-operator/pkg/util/template"
+)
+
+const (
+	// dockerHubRegistry is the only registry docker engine supports mirrors for
+	dockerHubRegistry = "docker.io"
+
+	DockerDaemonConfigFile = "/etc/docker/daemon.json"
+	DockerServiceFile      = "/etc/systemd/system/docker.service"
+	CRIDockerdServiceFile  = "/etc/systemd/system/cri-dockerd.service"
+	CrictlConfigFile       = "/etc/crictl.yaml"
+)
+
+const DockerServiceTemplate = `[Unit]
+Description=Docker Application Container Engine
+Documentation=https://docs.docker.com
+After=network-online.target firewalld.service
+Wants=network-online.target
+
+[Service]
+Type=notify
+ExecStart=/usr/local/bin/dockerd
+ExecReload=/bin/kill -s HUP $MAINPID
+LimitNOFILE=infinity
+LimitNPROC=infinity
+LimitCORE=infinity
+TasksMax=infinity
+TimeoutStartSec=0
+Delegate=yes
+KillMode=process
+Restart=on-failure
+StartLimitBurst=3
+StartLimitInterval=60s
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const CRIDockerdServiceTemplate = `[Unit]
+Description=CRI Interface for Docker Application Container Engine
+Documentation=https://docs.mirantis.com
+After=network-online.target firewalld.service docker.service
+Wants=network-online.target
+Requires=docker.service
+
+[Service]
+Type=simple
+ExecStart=/usr/local/bin/cri-dockerd \
+  --container-runtime-endpoint {{ .Socket }} \
+  --network-plugin=cni \
+  --cni-bin-dir=/opt/cni/bin \
+  --cni-conf-dir=/etc/cni/net.d \
+  --pod-infra-container-image={{ .PauseImage }}
+ExecReload=/bin/kill -s HUP $MAINPID
+TimeoutSec=0
+RestartSec=2
+Restart=always
+StartLimitBurst=3
+StartLimitInterval=60s
+LimitNOFILE=infinity
+LimitNPROC=infinity
+LimitCORE=infinity
+TasksMax=infinity
+Delegate=yes
+KillMode=process
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const CrictlConfigTemplate = `runtime-endpoint: {{ .Socket }}
+image-endpoint: {{ .Socket }}
+timeout: 10
+debug: false
+`
+
+type CRIDockerdConfig struct {
+	Socket     string
+	PauseImage string
+}
+
+// DockerDaemonConfig is the subset of /etc/docker/daemon.json managed by kok-operator
+type DockerDaemonConfig struct {
+	ExecOpts        []string          `json:"exec-opts"`
+	LogDriver       string            `json:"log-driver"`
+	LogOpts         map[string]string `json:"log-opts"`
+	StorageDriver   string            `json:"storage-driver"`
+	RegistryMirrors []string          `json:"registry-mirrors,omitempty"`
+}
+
+// BuildDockerDaemonConfig build docker daemon config, docker engine only supports mirrors of docker.io
+func BuildDockerDaemonConfig(ctx *common.ClusterContext) ([]byte, error) {
+	config := &DockerDaemonConfig{
+		ExecOpts:      []string{"native.cgroupdriver=systemd"},
+		LogDriver:     "json-file",
+		LogOpts:       map[string]string{"max-size": "100m", "max-file": "5"},
+		StorageDriver: "overlay2",
+	}
+
+	if ctx.Cluster.Spec.Registry != nil {
+		for host := range ctx.Cluster.Spec.Registry.Mirrors {
+			if host != dockerHubRegistry {
+				ctx.Info("docker engine only supports mirrors of docker.io, ignoring", "registry", host)
+			}
+		}
+	}
+	config.RegistryMirrors = RegistryMirrors(ctx.Cluster.Spec.Registry, dockerHubRegistry)
+
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// InstallDocker install docker engine and cri-dockerd, kubelet talks to docker engine by cri-dockerd
+func InstallDocker(ctx *common.ClusterContext, s ssh.Interface) error {
+	// Src is the name of artifact
+	var CopyList = []devopsv1.File{
+		{
+			Src: artifact.Crictl,
+			Dst: "/usr/local/bin/crictl",
+		},
+		{
+			Src: artifact.Docker,
+			Dst: "/opt/k8s/docker.tgz",
+		},
+		{
+			Src: artifact.CRIDockerd,
+			Dst: "/usr/local/bin/cri-dockerd",
+		},
+	}
+
+	for _, ls := range CopyList {
+		err := artifact.Install(ctx, s, ls.Src, ls.Dst)
+		if err != nil {
+			ctx.Error(err, "CopyFile", "node", s.HostIP(), "artifact", ls.Src)
+			return err
+		}
+
+		switch ls.Dst {
+		case "/opt/k8s/docker.tgz":
+			// docker static binaries: docker, dockerd, containerd, runc ...
+			cmd := "mkdir -p /usr/local/bin /opt/k8s/docker && " +
+				"tar -C /opt/k8s -xzf /opt/k8s/docker.tgz && " +
+				"cp -rf /opt/k8s/docker/* /usr/local/bin/ && " +
+				"rm -rf /opt/k8s/docker"
+			_, err := s.CombinedOutput(cmd)
+			if err != nil {
+				return err
+			}
+		default:
+			_, err := s.CombinedOutput("chmod a+x " + ls.Dst)
+			if err != nil {
+				return err
+			}
+		}
+		ctx.Info("copy successfully", "node", s.HostIP(), "path", ls.Dst)
+	}
+
+	daemonData, err := BuildDockerDaemonConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	arch, err := artifact.HostArch(s)
+	if err != nil {
+		return err
+	}
+
+	criDockerdCfg := &CRIDockerdConfig{
+		Socket:     constants.CRIDockerdSocket,
+		PauseImage: PauseImage(arch),
+	}
+
+	criDockerdData, err := template.ParseString(CRIDockerdServiceTemplate, criDockerdCfg)
+	if err != nil {
+		return err
+	}
+
+	crictlData, err := template.ParseString(CrictlConfigTemplate, criDockerdCfg)
+	if err != nil {
+		return err
+	}
+
+	_, _, _, err = s.Execf("mkdir -p /etc/docker")
+	if err != nil {
+		return err
+	}
+
+	fileMaps := map[string][]byte{
+		DockerDaemonConfigFile: daemonData,
+		DockerServiceFile:      []byte(DockerServiceTemplate),
+		CRIDockerdServiceFile:  criDockerdData,
+		CrictlConfigFile:       crictlData,
+	}
+
+	for pathName, data := range fileMaps {
+		ctx.Info("start write docker config", "node", s.HostIP(), "path", pathName)
+		err = s.WriteFile(bytes.NewReader(data), pathName)
+		if err != nil {
+			return err
+		}
+	}
+
+	return restartService(ctx, s, "docker", "cri-dockerd")
+}
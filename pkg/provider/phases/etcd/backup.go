@@ -0,0 +1,278 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/kubebin"
+	"github.com/wtxue/kok-operator/pkg/util/s3"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DefaultRetention is the number of snapshots kept by default
+	DefaultRetention = 7
+
+	// clientCertsDir is a temporary directory for the etcd client certs of ClusterCredential
+	clientCertsDir = constants.CertificatesDir + "etcd-backup/"
+
+	s3Scheme               = "s3://"
+	s3AccessKeyIDKey       = "accessKeyID"
+	s3SecretAccessKeyKey   = "secretAccessKey"
+	snapshotUploadTimeout  = 30 * time.Minute
+	snapshotNameTimeFormat = "20060102150405"
+)
+
+type snapshotStatus struct {
+	Hash      int64 `json:"hash"`
+	Revision  int64 `json:"revision"`
+	TotalKey  int64 `json:"totalKey"`
+	TotalSize int64 `json:"totalSize"`
+}
+
+// GetBackup returns the backup config of local etcd, nil means the cluster has no local etcd.
+func GetBackup(c *devopsv1.Cluster) *devopsv1.EtcdBackup {
+	if c.Spec.Etcd == nil || c.Spec.Etcd.Local == nil || len(c.Spec.Machines) == 0 {
+		return nil
+	}
+
+	if c.Spec.Etcd.Local.Backup == nil {
+		return &devopsv1.EtcdBackup{}
+	}
+
+	return c.Spec.Etcd.Local.Backup
+}
+
+// GetRetention returns the number of snapshots to keep.
+func GetRetention(backup *devopsv1.EtcdBackup) int {
+	if backup.Retention <= 0 {
+		return DefaultRetention
+	}
+
+	return int(backup.Retention)
+}
+
+func localDir(backup *devopsv1.EtcdBackup) string {
+	if backup.Destination.Local != nil && backup.Destination.Local.Path != "" {
+		return backup.Destination.Local.Path
+	}
+
+	return constants.EtcdBackupDir
+}
+
+// Backup takes a snapshot on the first available master and stores it to the destination.
+func Backup(ctx *common.ClusterContext, backup *devopsv1.EtcdBackup) (*devopsv1.EtcdBackupRecord, error) {
+	if len(ctx.Credential.ETCDCACert) == 0 || len(ctx.Credential.ETCDAPIClientCert) == 0 || len(ctx.Credential.ETCDAPIClientKey) == 0 {
+		return nil, fmt.Errorf("etcd client certs not found in cluster credential")
+	}
+
+	name := fmt.Sprintf("etcd-snapshot-%s-%s.db", ctx.Cluster.Name, time.Now().Format(snapshotNameTimeFormat))
+	var errs []string
+	for _, machine := range ctx.Cluster.Spec.Machines {
+		s, err := machine.SSH()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		record, err := snapshot(ctx, s, localDir(backup), name)
+		if err != nil {
+			ctx.Error(err, "failed to take etcd snapshot", "node", machine.IP)
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		if backup.Destination.S3 != nil {
+			err = upload(ctx, s, backup.Destination.S3, record)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ctx.Info("etcd backup successfully", "node", record.Node, "location", record.Location,
+			"size", record.Size, "revision", record.Revision)
+		return record, nil
+	}
+
+	return nil, fmt.Errorf("no master can take etcd snapshot: %s", strings.Join(errs, "; "))
+}
+
+// snapshot runs etcdctl snapshot save on the master with the client certs of ClusterCredential.
+func snapshot(ctx *common.ClusterContext, s ssh.Interface, dir, name string) (*devopsv1.EtcdBackupRecord, error) {
+	err := kubebin.InstallEtcdctl(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.CombinedOutput(fmt.Sprintf("mkdir -p %s %s && chmod 700 %s", dir, clientCertsDir, clientCertsDir))
+	if err != nil {
+		return nil, err
+	}
+	defer s.Execf("rm -rf %s", clientCertsDir)
+
+	certs := map[string][]byte{
+		clientCertsDir + "ca.crt":     ctx.Credential.ETCDCACert,
+		clientCertsDir + "client.crt": ctx.Credential.ETCDAPIClientCert,
+		clientCertsDir + "client.key": ctx.Credential.ETCDAPIClientKey,
+	}
+	for pathName, data := range certs {
+		err = s.WriteFile(bytes.NewReader(data), pathName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "node: %s write %s", s.HostIP(), pathName)
+		}
+	}
+
+	file := path.Join(dir, name)
+	cmd := fmt.Sprintf("ETCDCTL_API=3 etcdctl --endpoints=https://127.0.0.1:%d --cacert=%s --cert=%s --key=%s snapshot save %s",
+		constants.EtcdListenClientPort, clientCertsDir+"ca.crt", clientCertsDir+"client.crt", clientCertsDir+"client.key", file)
+	if _, stderr, exit, err := s.Exec(cmd); err != nil || exit != 0 {
+		return nil, fmt.Errorf("node: %s exec %q failed:exit %d:stderr %s:error %v", s.HostIP(), cmd, exit, stderr, err)
+	}
+
+	cmd = fmt.Sprintf("ETCDCTL_API=3 etcdctl snapshot status %s -w json", file)
+	stdout, stderr, exit, err := s.Exec(cmd)
+	if err != nil || exit != 0 {
+		return nil, fmt.Errorf("node: %s exec %q failed:exit %d:stderr %s:error %v", s.HostIP(), cmd, exit, stderr, err)
+	}
+
+	status := &snapshotStatus{}
+	err = json.Unmarshal([]byte(strings.TrimSpace(stdout)), status)
+	if err != nil {
+		return nil, errors.Wrapf(err, "node: %s unmarshal snapshot status %q", s.HostIP(), stdout)
+	}
+
+	out, err := s.CombinedOutput(fmt.Sprintf("stat -c %%s %s", file))
+	if err != nil {
+		return nil, err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "node: %s parse size of %s", s.HostIP(), file)
+	}
+
+	return &devopsv1.EtcdBackupRecord{
+		Name:     name,
+		Node:     s.HostIP(),
+		Location: file,
+		Size:     size,
+		Revision: status.Revision,
+		Time:     metav1.Now(),
+	}, nil
+}
+
+// upload streams the snapshot on the master to the object storage, and removes the local file.
+func upload(ctx *common.ClusterContext, s ssh.Interface, dst *devopsv1.S3BackupDestination, record *devopsv1.EtcdBackupRecord) error {
+	client, err := newS3Client(ctx, dst)
+	if err != nil {
+		return err
+	}
+
+	key := path.Join(dst.Prefix, record.Name)
+	pr, pw := io.Pipe()
+	go func() {
+		stderr := new(bytes.Buffer)
+		exit, err := s.ExecStream("cat "+record.Location, pw, stderr)
+		if err == nil && exit != 0 {
+			err = fmt.Errorf("cat %s exit %d: %s", record.Location, exit, stderr.String())
+		}
+		pw.CloseWithError(err)
+	}()
+
+	uploadCtx, cancel := context.WithTimeout(ctx.Ctx, snapshotUploadTimeout)
+	defer cancel()
+	err = client.PutObject(uploadCtx, dst.Bucket, key, pr)
+	pr.Close()
+	if err != nil {
+		return errors.Wrapf(err, "upload etcd snapshot %s", record.Name)
+	}
+
+	s.Execf("rm -f %s", record.Location)
+	record.Location = s3Scheme + path.Join(dst.Bucket, key)
+	return nil
+}
+
+// Prune removes the oldest snapshots beyond the retention and returns the records kept.
+func Prune(ctx *common.ClusterContext, backup *devopsv1.EtcdBackup, records []devopsv1.EtcdBackupRecord) []devopsv1.EtcdBackupRecord {
+	retention := GetRetention(backup)
+	if len(records) <= retention {
+		return records
+	}
+
+	expired := records[:len(records)-retention]
+	for i := range expired {
+		err := remove(ctx, backup, &expired[i])
+		if err != nil {
+			ctx.Error(err, "failed to remove expired etcd snapshot, ignoring", "location", expired[i].Location)
+			continue
+		}
+		ctx.Info("remove expired etcd snapshot", "node", expired[i].Node, "location", expired[i].Location)
+	}
+
+	return append([]devopsv1.EtcdBackupRecord{}, records[len(records)-retention:]...)
+}
+
+func remove(ctx *common.ClusterContext, backup *devopsv1.EtcdBackup, record *devopsv1.EtcdBackupRecord) error {
+	if strings.HasPrefix(record.Location, s3Scheme) {
+		if backup.Destination.S3 == nil {
+			return fmt.Errorf("no s3 destination to remove it")
+		}
+
+		client, err := newS3Client(ctx, backup.Destination.S3)
+		if err != nil {
+			return err
+		}
+
+		bucketAndKey := strings.SplitN(strings.TrimPrefix(record.Location, s3Scheme), "/", 2)
+		if len(bucketAndKey) != 2 {
+			return fmt.Errorf("invalid location %q", record.Location)
+		}
+		return client.DeleteObject(ctx.Ctx, bucketAndKey[0], bucketAndKey[1])
+	}
+
+	for _, machine := range ctx.Cluster.Spec.Machines {
+		if machine.IP != record.Node {
+			continue
+		}
+
+		s, err := machine.SSH()
+		if err != nil {
+			return err
+		}
+
+		_, err = s.CombinedOutput(fmt.Sprintf("rm -f %s", record.Location))
+		return err
+	}
+
+	return fmt.Errorf("node %s is not a master any more", record.Node)
+}
+
+func newS3Client(ctx *common.ClusterContext, dst *devopsv1.S3BackupDestination) (*s3.Client, error) {
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: ctx.Cluster.Namespace, Name: dst.CredentialsSecret}
+	err := ctx.Client.Get(ctx.Ctx, key, secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get s3 credentials secret %s", key.String())
+	}
+
+	return s3.New(&s3.Config{
+		Endpoint:           dst.Endpoint,
+		Region:             dst.Region,
+		AccessKeyID:        string(secret.Data[s3AccessKeyIDKey]),
+		SecretAccessKey:    string(secret.Data[s3SecretAccessKeyKey]),
+		InsecureSkipVerify: dst.InsecureSkipVerify,
+	})
+}
@@ -0,0 +1,198 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+)
+
+const memberRequestTimeout = 10 * time.Second
+
+type member struct {
+	// ID is uint64 which is encoded as string by the grpc gateway
+	ID       json.RawMessage `json:"ID"`
+	Name     string          `json:"name"`
+	PeerURLs []string        `json:"peerURLs"`
+}
+
+type memberListResponse struct {
+	Members []member `json:"members"`
+}
+
+// RemoveMember removes the etcd member of the node through the grpc gateway of the other masters,
+// the member is matched by name or peer url, it's not an error if the member does not exist.
+func RemoveMember(ctx *common.ClusterContext, node string) error {
+	var endpoints []string
+	for _, machine := range ctx.Cluster.Spec.Machines {
+		if machine.IP == node {
+			continue
+		}
+		endpoints = append(endpoints, fmt.Sprintf("https://%s:%d", machine.IP, constants.EtcdListenClientPort))
+	}
+
+	peerURL := fmt.Sprintf("https://%s:%d", node, constants.EtcdListenPeerPort)
+	return RemoveMemberByName(ctx, endpoints, node, peerURL)
+}
+
+// RemoveMemberByName removes the etcd member matched by name or peer url through the first available
+// endpoint, it's not an error if the member does not exist.
+func RemoveMemberByName(ctx *common.ClusterContext, endpoints []string, name string, peerURL string) error {
+	return tryEndpoints(ctx, endpoints, "remove etcd member "+name, func(client *http.Client, endpoint string) error {
+		return removeMember(ctx, client, endpoint, name, peerURL)
+	})
+}
+
+// AddMember adds the etcd member with the peer url through the first available endpoint,
+// it's not an error if the peer url is already a member.
+func AddMember(ctx *common.ClusterContext, endpoints []string, peerURL string) error {
+	return tryEndpoints(ctx, endpoints, "add etcd member "+peerURL, func(client *http.Client, endpoint string) error {
+		return addMember(ctx, client, endpoint, peerURL)
+	})
+}
+
+func tryEndpoints(ctx *common.ClusterContext, endpoints []string, action string, f func(client *http.Client, endpoint string) error) error {
+	client, err := newMemberClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, endpoint := range endpoints {
+		err = f(client, endpoint)
+		if err == nil {
+			return nil
+		}
+
+		ctx.Error(err, "failed to "+action, "endpoint", endpoint)
+		errs = append(errs, err.Error())
+	}
+
+	return fmt.Errorf("%s: %s", action, strings.Join(errs, "; "))
+}
+
+func removeMember(ctx *common.ClusterContext, client *http.Client, endpoint, name, peerURL string) error {
+	resp := &memberListResponse{}
+	err := postMember(ctx, client, endpoint+"/v3/cluster/member/list", []byte("{}"), resp)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range resp.Members {
+		isMember := m.Name == name
+		for _, u := range m.PeerURLs {
+			if u == peerURL {
+				isMember = true
+			}
+		}
+		if !isMember {
+			continue
+		}
+
+		body := fmt.Sprintf(`{"ID":"%s"}`, strings.Trim(string(m.ID), `"`))
+		err = postMember(ctx, client, endpoint+"/v3/cluster/member/remove", []byte(body), nil)
+		if err != nil {
+			return err
+		}
+
+		ctx.Info("remove etcd member successfully", "name", name, "id", strings.Trim(string(m.ID), `"`))
+		return nil
+	}
+
+	ctx.Info("etcd member not found, skip remove", "name", name)
+	return nil
+}
+
+func addMember(ctx *common.ClusterContext, client *http.Client, endpoint, peerURL string) error {
+	resp := &memberListResponse{}
+	err := postMember(ctx, client, endpoint+"/v3/cluster/member/list", []byte("{}"), resp)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range resp.Members {
+		for _, u := range m.PeerURLs {
+			if u == peerURL {
+				ctx.Info("etcd member already exists, skip add", "peerURL", peerURL)
+				return nil
+			}
+		}
+	}
+
+	body, err := json.Marshal(map[string][]string{"peerURLs": {peerURL}})
+	if err != nil {
+		return err
+	}
+	err = postMember(ctx, client, endpoint+"/v3/cluster/member/add", body, nil)
+	if err != nil {
+		return err
+	}
+
+	ctx.Info("add etcd member successfully", "peerURL", peerURL)
+	return nil
+}
+
+func postMember(ctx *common.ClusterContext, client *http.Client, url string, body []byte, out interface{}) error {
+	reqCtx, cancel := context.WithTimeout(ctx.Ctx, memberRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("post %s failed: %s: %s", url, resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(data, out), "unmarshal response of %s", url)
+}
+
+// newMemberClient builds the https client with the etcd client certs of ClusterCredential.
+func newMemberClient(ctx *common.ClusterContext) (*http.Client, error) {
+	if len(ctx.Credential.ETCDCACert) == 0 || len(ctx.Credential.ETCDAPIClientCert) == 0 || len(ctx.Credential.ETCDAPIClientKey) == 0 {
+		return nil, fmt.Errorf("etcd client certs not found in cluster credential")
+	}
+
+	cert, err := tls.X509KeyPair(ctx.Credential.ETCDAPIClientCert, ctx.Credential.ETCDAPIClientKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "load etcd client cert")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ctx.Credential.ETCDCACert) {
+		return nil, fmt.Errorf("invalid etcd ca cert")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}
+	return &http.Client{Transport: transport}, nil
+}
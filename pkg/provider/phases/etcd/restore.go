@@ -0,0 +1,398 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/kubeadm"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/kubebin"
+	"github.com/wtxue/kok-operator/pkg/util/apiclient"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// restoreDir is where the snapshot is placed on every master before restore
+	restoreDir = "/var/lib/etcd-restore/"
+	// manifestsStashDir keeps the control plane manifests while the static pods are stopped
+	manifestsStashDir = constants.KubernetesDir + "manifests-restore/"
+	// rollbackFile records the old data dir of the member until the restore is done
+	rollbackFile = restoreDir + "rollback"
+)
+
+// controlPlaneComponents are the static pods stopped during restore, etcd must be the first one.
+var controlPlaneComponents = []string{"etcd", "kube-apiserver", "kube-controller-manager", "kube-scheduler"}
+
+// Restore restores the etcd members on all masters from the snapshot reference, the reference is
+// the name or location of a record in status.etcdBackups, "s3://bucket/key" or "<node>:<path>".
+// The old data dir is kept as "<dataDir>.bak-<time>" on each master, all masters are rolled back
+// to it if the restore failed.
+func Restore(ctx *common.ClusterContext, backup *devopsv1.EtcdBackup, ref string) error {
+	source, err := resolveSnapshot(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	masters, err := masterSSH(ctx)
+	if err != nil {
+		return err
+	}
+
+	// download the snapshot to all masters first, nothing is changed if it fails
+	file := restoreDir + source.Name
+	for _, s := range masters {
+		err = fetchSnapshot(ctx, backup, source, s, file)
+		if err != nil {
+			return err
+		}
+		ctx.Info("fetch etcd snapshot successfully", "node", s.HostIP(), "path", file)
+	}
+
+	err = restoreMembers(ctx, masters, file)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range masters {
+		s.Execf("rm -f %s %s", file, rollbackFile)
+	}
+
+	ctx.Info("etcd restore successfully", "snapshot", source.Location, "revision", source.Revision)
+	return nil
+}
+
+// AbortRestore rolls back the masters left by an unfinished restore and waits for the apiserver.
+func AbortRestore(ctx *common.ClusterContext) error {
+	masters, err := masterSSH(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = rollback(ctx, masters)
+	if err != nil {
+		return err
+	}
+
+	return waitAPIHealthz(ctx)
+}
+
+func masterSSH(ctx *common.ClusterContext) ([]ssh.Interface, error) {
+	masters := make([]ssh.Interface, 0, len(ctx.Cluster.Spec.Machines))
+	for _, machine := range ctx.Cluster.Spec.Machines {
+		s, err := machine.SSH()
+		if err != nil {
+			return nil, err
+		}
+		masters = append(masters, s)
+	}
+	return masters, nil
+}
+
+// restoreMembers replaces the data of all members with the snapshot file, the masters are rolled
+// back if any step failed.
+func restoreMembers(ctx *common.ClusterContext, masters []ssh.Interface, file string) error {
+	err := replaceMembers(ctx, masters, file)
+	if err == nil {
+		err = waitAPIHealthz(ctx)
+	}
+	if err == nil {
+		return nil
+	}
+
+	ctx.Error(err, "failed to restore etcd, start rollback")
+	if rbErr := rollback(ctx, masters); rbErr != nil {
+		return errors.Wrapf(err, "rollback failed: %v", rbErr)
+	}
+	return err
+}
+
+func replaceMembers(ctx *common.ClusterContext, masters []ssh.Interface, file string) error {
+	for _, s := range masters {
+		err := stopControlPlane(ctx, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	peerCluster := kubeadm.BuildMasterEtcdPeerCluster(ctx)
+	for _, s := range masters {
+		err := restoreMember(ctx, s, file, peerCluster)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, s := range masters {
+		err := startControlPlane(ctx, s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rollback moves the old data dir back and starts the control plane on all masters.
+func rollback(ctx *common.ClusterContext, masters []ssh.Interface) error {
+	var errs []error
+	for _, s := range masters {
+		err := rollbackMember(ctx, s)
+		if err == nil {
+			err = startControlPlane(ctx, s)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+func rollbackMember(ctx *common.ClusterContext, s ssh.Interface) error {
+	ok, err := s.Exist(rollbackFile)
+	if err != nil || !ok {
+		return err
+	}
+	data, err := s.ReadFile(rollbackFile)
+	if err != nil {
+		return err
+	}
+
+	// the member may be running with the restored data
+	err = stopControlPlane(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	dataDir, bak := etcdDataDir(ctx), strings.TrimSpace(string(data))
+	cmd := fmt.Sprintf("if [ -d %s ]; then rm -rf %s && mv %s %s; fi && rm -f %s", bak, dataDir, bak, dataDir, rollbackFile)
+	_, err = s.CombinedOutput(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "node: %s rollback etcd data", s.HostIP())
+	}
+
+	ctx.Info("rollback etcd member successfully", "node", s.HostIP(), "dataDir", dataDir, "backup", bak)
+	return nil
+}
+
+func resolveSnapshot(ctx *common.ClusterContext, ref string) (*devopsv1.EtcdBackupRecord, error) {
+	for i := range ctx.Cluster.Status.EtcdBackups {
+		record := ctx.Cluster.Status.EtcdBackups[i]
+		if record.Name == ref || record.Location == ref {
+			return &record, nil
+		}
+	}
+
+	if strings.HasPrefix(ref, s3Scheme) {
+		return &devopsv1.EtcdBackupRecord{Name: path.Base(ref), Location: ref}, nil
+	}
+
+	if i := strings.Index(ref, ":/"); i > 0 {
+		return &devopsv1.EtcdBackupRecord{Name: path.Base(ref[i+1:]), Node: ref[:i], Location: ref[i+1:]}, nil
+	}
+
+	return nil, fmt.Errorf("unknown etcd snapshot %q, it must be a record of status, s3://bucket/key or <node>:<path>", ref)
+}
+
+// fetchSnapshot places the snapshot to the file on the master.
+func fetchSnapshot(ctx *common.ClusterContext, backup *devopsv1.EtcdBackup, source *devopsv1.EtcdBackupRecord, s ssh.Interface, file string) error {
+	err := kubebin.InstallEtcdctl(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.CombinedOutput(fmt.Sprintf("mkdir -p %s", restoreDir))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(source.Location, s3Scheme):
+		if backup.Destination.S3 == nil {
+			return fmt.Errorf("no s3 destination to download %s", source.Location)
+		}
+
+		client, err := newS3Client(ctx, backup.Destination.S3)
+		if err != nil {
+			return err
+		}
+
+		bucketAndKey := strings.SplitN(strings.TrimPrefix(source.Location, s3Scheme), "/", 2)
+		if len(bucketAndKey) != 2 {
+			return fmt.Errorf("invalid location %q", source.Location)
+		}
+
+		downloadCtx, cancel := context.WithTimeout(ctx.Ctx, snapshotUploadTimeout)
+		defer cancel()
+		body, _, err := client.GetObject(downloadCtx, bucketAndKey[0], bucketAndKey[1])
+		if err != nil {
+			return errors.Wrapf(err, "download etcd snapshot %s", source.Location)
+		}
+		defer body.Close()
+
+		err = s.WriteFile(body, file)
+		if err != nil {
+			return errors.Wrapf(err, "node: %s write %s", s.HostIP(), file)
+		}
+	case source.Node == s.HostIP():
+		_, err = s.CombinedOutput(fmt.Sprintf("cp -f %s %s", source.Location, file))
+		if err != nil {
+			return err
+		}
+	default:
+		var src ssh.Interface
+		for _, machine := range ctx.Cluster.Spec.Machines {
+			if machine.IP == source.Node {
+				src, err = machine.SSH()
+				if err != nil {
+					return err
+				}
+				break
+			}
+		}
+		if src == nil {
+			return fmt.Errorf("node %s of snapshot %s is not a master", source.Node, source.Location)
+		}
+
+		pr, pw := io.Pipe()
+		go func() {
+			stderr := new(bytes.Buffer)
+			exit, err := src.ExecStream("cat "+source.Location, pw, stderr)
+			if err == nil && exit != 0 {
+				err = fmt.Errorf("cat %s exit %d: %s", source.Location, exit, stderr.String())
+			}
+			pw.CloseWithError(err)
+		}()
+
+		err = s.WriteFile(pr, file)
+		pr.Close()
+		if err != nil {
+			return errors.Wrapf(err, "node: %s copy %s from %s", s.HostIP(), source.Location, source.Node)
+		}
+	}
+
+	if source.Size == 0 {
+		return nil
+	}
+
+	out, err := s.CombinedOutput(fmt.Sprintf("stat -c %%s %s", file))
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil || size != source.Size {
+		return fmt.Errorf("node: %s size of %s is %s, expected %d", s.HostIP(), file, strings.TrimSpace(string(out)), source.Size)
+	}
+
+	return nil
+}
+
+// stopControlPlane moves the static pod manifests away and waits for the containers to exit.
+func stopControlPlane(ctx *common.ClusterContext, s ssh.Interface) error {
+	cmd := fmt.Sprintf("mkdir -p %s && for c in %s; do if [ -f %s$c.yaml ]; then mv -f %s$c.yaml %s; fi; done",
+		manifestsStashDir, strings.Join(controlPlaneComponents, " "), constants.KubeletPodManifestDir,
+		constants.KubeletPodManifestDir, manifestsStashDir)
+	_, err := s.CombinedOutput(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "node: %s stop control plane", s.HostIP())
+	}
+
+	for _, component := range controlPlaneComponents {
+		err = kubeadm.WaitContainerStopped(ctx, s, kubeadm.LabelFilterForControlPlane(component))
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx.Info("stop control plane successfully", "node", s.HostIP())
+	return nil
+}
+
+// startControlPlane moves the static pod manifests back, kubelet starts them.
+func startControlPlane(ctx *common.ClusterContext, s ssh.Interface) error {
+	cmd := fmt.Sprintf("if ls %s*.yaml >/dev/null 2>&1; then mv -f %s*.yaml %s; fi",
+		manifestsStashDir, manifestsStashDir, constants.KubeletPodManifestDir)
+	_, err := s.CombinedOutput(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "node: %s start control plane", s.HostIP())
+	}
+
+	ctx.Info("start control plane successfully", "node", s.HostIP())
+	return nil
+}
+
+func etcdDataDir(ctx *common.ClusterContext) string {
+	if ctx.Cluster.Spec.Etcd != nil && ctx.Cluster.Spec.Etcd.Local != nil && ctx.Cluster.Spec.Etcd.Local.DataDir != "" {
+		return ctx.Cluster.Spec.Etcd.Local.DataDir
+	}
+	return constants.EtcdDataDir
+}
+
+// restoreMember moves the data dir away and restores the snapshot file, the old data dir is recorded
+// in rollbackFile first. The one of an unfinished restore is kept, the data dir is dropped instead.
+func restoreMember(ctx *common.ClusterContext, s ssh.Interface, file, peerCluster string) error {
+	dataDir := etcdDataDir(ctx)
+	pending, err := s.Exist(rollbackFile)
+	if err != nil {
+		return err
+	}
+
+	var bak, move string
+	if pending {
+		data, err := s.ReadFile(rollbackFile)
+		if err != nil {
+			return err
+		}
+		bak = strings.TrimSpace(string(data))
+		move = fmt.Sprintf("rm -rf %s", dataDir)
+	} else {
+		bak = fmt.Sprintf("%s.bak-%s", dataDir, time.Now().Format(snapshotNameTimeFormat))
+		err = s.WriteFile(strings.NewReader(bak), rollbackFile)
+		if err != nil {
+			return errors.Wrapf(err, "node: %s write %s", s.HostIP(), rollbackFile)
+		}
+		move = fmt.Sprintf("if [ -d %s ]; then mv %s %s; fi", dataDir, dataDir, bak)
+	}
+
+	cmd := fmt.Sprintf("%s && "+
+		"ETCDCTL_API=3 etcdctl snapshot restore %s --name %s --initial-cluster %s --initial-advertise-peer-urls https://%s:%d --data-dir %s",
+		move, file, s.HostIP(), peerCluster, s.HostIP(), constants.EtcdListenPeerPort, dataDir)
+	if _, stderr, exit, err := s.Exec(cmd); err != nil || exit != 0 {
+		return fmt.Errorf("node: %s exec %q failed:exit %d:stderr %s:error %v", s.HostIP(), cmd, exit, stderr, err)
+	}
+
+	ctx.Info("restore etcd member successfully", "node", s.HostIP(), "dataDir", dataDir, "backup", bak)
+	return nil
+}
+
+func waitAPIHealthz(ctx *common.ClusterContext) error {
+	start := time.Now()
+	err := wait.PollImmediate(5*time.Second, 5*time.Minute, func() (bool, error) {
+		clientset, err := ctx.Clientset()
+		if err != nil {
+			ctx.Error(err, "Clientset")
+			return false, nil
+		}
+
+		reqCtx, reqCancel := context.WithTimeout(ctx.Ctx, 10*time.Second)
+		defer reqCancel()
+		return apiclient.CheckAPIHealthz(reqCtx, clientset.Discovery().RESTClient()), nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "wait apiserver healthz after restore")
+	}
+
+	ctx.Info("apiserver is healthy after restore", "after seconds", fmt.Sprintf("%f", time.Since(start).Seconds()))
+	return nil
+}
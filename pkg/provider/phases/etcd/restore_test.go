@@ -0,0 +1,45 @@
+package etcd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	"github.com/wtxue/kok-operator/pkg/util/ssh/fake"
+)
+
+func TestRestoreMembersRollback(t *testing.T) {
+	ctx := &common.ClusterContext{
+		Ctx:    context.Background(),
+		Logger: logr.Discard(),
+		Cluster: &devopsv1.Cluster{Spec: devopsv1.ClusterSpec{Machines: []*devopsv1.ClusterMachine{
+			{IP: "10.0.0.1"}, {IP: "10.0.0.2"},
+		}}},
+	}
+
+	m1 := fake.New("10.0.0.1")
+	m2 := fake.New("10.0.0.2", fake.Result{Match: "etcdctl snapshot restore", Exit: 1, Stderr: "corrupted snapshot"})
+	err := restoreMembers(ctx, []ssh.Interface{m1, m2}, restoreDir+"snapshot.db")
+	if err == nil || !strings.Contains(err.Error(), "corrupted snapshot") {
+		t.Fatalf("expect the restore error, got %v", err)
+	}
+
+	for _, m := range []*fake.SSH{m1, m2} {
+		bak, ok := m.File(rollbackFile)
+		if !ok || !strings.HasPrefix(string(bak), constants.EtcdDataDir+".bak-") {
+			t.Fatalf("expect the old data dir recorded on %s, got %q", m.HostIP(), bak)
+		}
+		if !m.Executed("mv " + string(bak) + " " + constants.EtcdDataDir) {
+			t.Errorf("expect the old data dir moved back on %s, got %v", m.HostIP(), m.Commands())
+		}
+		cmds := m.Commands()
+		if last := cmds[len(cmds)-1]; !strings.Contains(last, "mv -f "+manifestsStashDir+"*.yaml") {
+			t.Errorf("expect the control plane started at last on %s, got %q", m.HostIP(), last)
+		}
+	}
+}
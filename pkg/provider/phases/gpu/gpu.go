@@ -1,40 +1,260 @@
 package gpu
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
+	"github.com/wtxue/kok-operator/pkg/util/apiclient"
 	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientset "k8s.io/client-go/kubernetes"
 )
 
+const (
+	// LabelNvidiaDevice marks the nodes with nvidia gpus, the device plugin only runs on them
+	LabelNvidiaDevice = "nvidia-device-enable"
+	// LabelNvidiaDeviceEnable is the value of LabelNvidiaDevice on gpu nodes
+	LabelNvidiaDeviceEnable = "enable"
+
+	// RuntimeClassName is the runtime class and the containerd runtime of nvidia container runtime
+	RuntimeClassName = "nvidia"
+
+	// DefaultDriverVersion is the version of the nvidia driver installer
+	DefaultDriverVersion = "515.65.01"
+	// DefaultDevicePluginVersion is the version of the nvidia device plugin image
+	DefaultDevicePluginVersion = "v0.12.3"
+
+	// ContainerdNvidiaConfig is imported by the containerd config
+	ContainerdNvidiaConfig = "/etc/containerd/conf.d/nvidia.toml"
+
+	devicePluginName = "nvidia-device-plugin"
+	nvidiaVendorID   = "0x10de"
+
+	containerdNvidiaRuntime = `version = 2
+
+[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.nvidia]
+  privileged_without_host_devices = false
+  runtime_type = "io.containerd.runc.v2"
+
+  [plugins."io.containerd.grpc.v1.cri".containerd.runtimes.nvidia.options]
+    BinaryName = "/usr/bin/nvidia-container-runtime"
+    SystemdCgroup = true
+`
+)
+
+// Enabled returns true if the cluster uses physical nvidia gpus.
+func Enabled(cluster *devopsv1.Cluster) bool {
+	gpuType := cluster.Spec.Features.GPUType
+	return gpuType != nil && *gpuType == devopsv1.GPUPhysical
+}
+
+// fetchArtifact returns the local file and the sha256 of the artifact name for arch.
+func fetchArtifact(ctx *common.ClusterContext, name, arch string) (string, string, error) {
+	r := artifact.ForCluster(ctx.Cluster)
+	a, err := r.Lookup(ctx.Ctx, name, ctx.Cluster.Spec.Version, arch)
+	if err != nil {
+		return "", "", err
+	}
+
+	src, err := r.Fetch(ctx.Ctx, a)
+	return src, a.Sha256, err
+}
+
 type NvidiaDriverOption struct {
+	// InstallerSrc is the local path of the NVIDIA-Linux-<arch>-<version>.run installer
+	InstallerSrc string
+	// Sha256 of the installer verified after copying, skipped if empty
+	Sha256 string
 }
 
+// NewNvidiaDriverOption returns the driver installer of arch in the artifact repository.
+func NewNvidiaDriverOption(ctx *common.ClusterContext, arch string) (*NvidiaDriverOption, error) {
+	src, sum, err := fetchArtifact(ctx, artifact.NvidiaDriver, arch)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NvidiaDriverOption{InstallerSrc: src, Sha256: sum}, nil
+}
+
+// InstallNvidiaDriver installs the nvidia driver with the .run installer, it's skipped
+// if nvidia-smi works already.
 func InstallNvidiaDriver(s ssh.Interface, option *NvidiaDriverOption) error {
+	if _, err := s.CombinedOutput("nvidia-smi -L"); err == nil {
+		return nil
+	}
+
+	dst := path.Join("/opt/k8s", path.Base(option.InstallerSrc))
+	err := s.CopyFile(option.InstallerSrc, dst)
+	if err != nil {
+		return errors.Wrapf(err, "copy %s", option.InstallerSrc)
+	}
+	if option.Sha256 != "" {
+		if err := artifact.VerifyRemote(s, dst, option.Sha256); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.CombinedOutput(fmt.Sprintf("sh %s --silent", dst))
+	if err != nil {
+		return errors.Wrap(err, "install nvidia driver")
+	}
 
 	return nil
 }
 
 type NvidiaContainerRuntimeOption struct {
+	// PackageSrc is the local path of the nvidia container toolkit tarball, extracted to /
+	PackageSrc string
+	// Sha256 of the tarball verified after copying, skipped if empty
+	Sha256 string
 }
 
+// NewNvidiaContainerRuntimeOption returns the container toolkit tarball of arch in the artifact repository.
+func NewNvidiaContainerRuntimeOption(ctx *common.ClusterContext, arch string) (*NvidiaContainerRuntimeOption, error) {
+	src, sum, err := fetchArtifact(ctx, artifact.NvidiaContainerToolkit, arch)
+	if err != nil {
+		return nil, err
+	}
+
+	return &NvidiaContainerRuntimeOption{PackageSrc: src, Sha256: sum}, nil
+}
+
+// InstallNvidiaContainerRuntime installs the nvidia container toolkit, and adds the nvidia
+// runtime to containerd, which is restarted if it's running.
 func InstallNvidiaContainerRuntime(s ssh.Interface, option *NvidiaContainerRuntimeOption) error {
+	dst := path.Join("/opt/k8s", path.Base(option.PackageSrc))
+	err := s.CopyFile(option.PackageSrc, dst)
+	if err != nil {
+		return errors.Wrapf(err, "copy %s", option.PackageSrc)
+	}
+	if option.Sha256 != "" {
+		if err := artifact.VerifyRemote(s, dst, option.Sha256); err != nil {
+			return err
+		}
+	}
 
-	return nil
+	_, err = s.CombinedOutput(fmt.Sprintf("tar -C / -xzf %s && ldconfig && nvidia-container-cli info", dst))
+	if err != nil {
+		return errors.Wrap(err, "install nvidia container toolkit")
+	}
+
+	_, err = s.CombinedOutput("mkdir -p " + path.Dir(ContainerdNvidiaConfig))
+	if err != nil {
+		return err
+	}
+	err = s.WriteFile(strings.NewReader(containerdNvidiaRuntime), ContainerdNvidiaConfig)
+	if err != nil {
+		return errors.Wrap(err, "write containerd nvidia runtime")
+	}
+
+	_, err = s.CombinedOutput("if systemctl is-active -q containerd; then systemctl restart containerd; fi")
+	return err
 }
 
 type NvidiaDevicePluginOption struct {
 	Image string
 }
 
+// InstallNvidiaDevicePlugin creates the nvidia runtime class and the device plugin daemonset
+// running on the nodes labeled with LabelNvidiaDevice.
 func InstallNvidiaDevicePlugin(ctx context.Context, clientset clientset.Interface, option *NvidiaDevicePluginOption) error {
+	err := apiclient.CreateOrUpdateRuntimeClass(ctx, clientset, &nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: RuntimeClassName},
+		Handler:    RuntimeClassName,
+		Scheduling: &nodev1.Scheduling{
+			NodeSelector: map[string]string{LabelNvidiaDevice: LabelNvidiaDeviceEnable},
+		},
+	})
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return apiclient.CreateOrUpdateDaemonSet(ctx, clientset, devicePluginDaemonSet(option))
+}
+
+func devicePluginDaemonSet(option *NvidiaDevicePluginOption) *appsv1.DaemonSet {
+	labels := map[string]string{"app": devicePluginName}
+	runtimeClassName := RuntimeClassName
+	allowPrivilegeEscalation := false
+	hostPathType := corev1.HostPathDirectory
+
+	return &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      devicePluginName,
+			Namespace: metav1.NamespaceSystem,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+				Type: appsv1.RollingUpdateDaemonSetStrategyType,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RuntimeClassName:  &runtimeClassName,
+					PriorityClassName: "system-node-critical",
+					NodeSelector:      map[string]string{LabelNvidiaDevice: LabelNvidiaDeviceEnable},
+					Tolerations: []corev1.Toleration{
+						{Key: "nvidia.com/gpu", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+						{Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  devicePluginName,
+							Image: option.Image,
+							Env: []corev1.EnvVar{
+								{Name: "FAIL_ON_INIT_ERROR", Value: "false"},
+							},
+							SecurityContext: &corev1.SecurityContext{
+								AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+								Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{Name: "device-plugin", MountPath: "/var/lib/kubelet/device-plugins"},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							Name: "device-plugin",
+							VolumeSource: corev1.VolumeSource{
+								HostPath: &corev1.HostPathVolumeSource{
+									Path: "/var/lib/kubelet/device-plugins",
+									Type: &hostPathType,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
 }
 
 func IsEnable(labels map[string]string) bool {
-	return labels["nvidia-device-enable"] == "enable"
+	return labels[LabelNvidiaDevice] == LabelNvidiaDeviceEnable
 }
 
+// MachineIsSupport returns true if the machine has nvidia display or 3d controllers.
 func MachineIsSupport(s ssh.Interface) bool {
-	return true
+	cmd := fmt.Sprintf(`for d in /sys/bus/pci/devices/*; do `+
+		`if [ "$(cat $d/vendor)" = "%s" ] && grep -q '^0x030' $d/class; then echo $d; fi; done`, nvidiaVendorID)
+	stdout, err := s.CombinedOutput(cmd)
+	if err != nil {
+		return false
+	}
+
+	return len(bytes.TrimSpace(stdout)) > 0
 }
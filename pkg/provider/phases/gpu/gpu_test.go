@@ -0,0 +1,100 @@
+package gpu
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/wtxue/kok-operator/pkg/util/ssh/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestMachineIsSupport(t *testing.T) {
+	gpuHost := fake.New("10.0.0.1", fake.Result{Match: "/sys/bus/pci/devices", Stdout: "/sys/bus/pci/devices/0000:3b:00.0\n"})
+	if !MachineIsSupport(gpuHost) {
+		t.Errorf("expect %s supported", gpuHost.HostIP())
+	}
+
+	if host := fake.New("10.0.0.2"); MachineIsSupport(host) {
+		t.Errorf("expect %s not supported", host.HostIP())
+	}
+}
+
+func TestInstallNvidiaDriver(t *testing.T) {
+	s := fake.New("10.0.0.1", fake.Result{Match: "nvidia-smi -L", Exit: 127, Stderr: "command not found"})
+	opt := &NvidiaDriverOption{InstallerSrc: "/k8s/bin/NVIDIA-Linux-x86_64-" + DefaultDriverVersion + ".run"}
+
+	if err := InstallNvidiaDriver(s, opt); err != nil {
+		t.Fatal(err)
+	}
+	if src, ok := s.Copied("/opt/k8s/NVIDIA-Linux-x86_64-" + DefaultDriverVersion + ".run"); !ok || src != opt.InstallerSrc {
+		t.Errorf("expect installer copied, got %q", src)
+	}
+	if !s.Executed("--silent") {
+		t.Errorf("expect installer executed, got %v", s.Commands())
+	}
+
+	installed := fake.New("10.0.0.2")
+	if err := InstallNvidiaDriver(installed, opt); err != nil {
+		t.Fatal(err)
+	}
+	if installed.Executed("--silent") {
+		t.Errorf("expect installed driver skipped, got %v", installed.Commands())
+	}
+}
+
+func TestInstallNvidiaContainerRuntime(t *testing.T) {
+	s := fake.New("10.0.0.1")
+	err := InstallNvidiaContainerRuntime(s, &NvidiaContainerRuntimeOption{PackageSrc: "/k8s/bin/nvidia-container-toolkit.tar.gz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok := s.File(ContainerdNvidiaConfig)
+	if !ok {
+		t.Fatalf("expect %s written", ContainerdNvidiaConfig)
+	}
+	if !strings.Contains(string(data), "runtimes.nvidia") || !strings.Contains(string(data), "nvidia-container-runtime") {
+		t.Errorf("unexpected containerd config:\n%s", data)
+	}
+	if !s.Executed("systemctl restart containerd") {
+		t.Errorf("expect containerd restarted, got %v", s.Commands())
+	}
+}
+
+func TestInstallNvidiaDevicePlugin(t *testing.T) {
+	ctx := context.Background()
+	client := k8sfake.NewSimpleClientset()
+	opt := &NvidiaDevicePluginOption{Image: "docker.io/wtxue/k8s-device-plugin:" + DefaultDevicePluginVersion}
+
+	// twice for the update of existing objects
+	for i := 0; i < 2; i++ {
+		if err := InstallNvidiaDevicePlugin(ctx, client, opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rc, err := client.NodeV1().RuntimeClasses().Get(ctx, RuntimeClassName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc.Handler != RuntimeClassName {
+		t.Errorf("expect handler %s, got %s", RuntimeClassName, rc.Handler)
+	}
+
+	ds, err := client.AppsV1().DaemonSets(metav1.NamespaceSystem).Get(ctx, devicePluginName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := ds.Spec.Template.Spec
+	if spec.RuntimeClassName == nil || *spec.RuntimeClassName != RuntimeClassName {
+		t.Errorf("expect runtime class %s, got %v", RuntimeClassName, spec.RuntimeClassName)
+	}
+	if !IsEnable(spec.NodeSelector) {
+		t.Errorf("expect gpu node selector, got %v", spec.NodeSelector)
+	}
+	if spec.Containers[0].Image != opt.Image {
+		t.Errorf("expect image %s, got %s", opt.Image, spec.Containers[0].Image)
+	}
+}
@@ -100,7 +100,10 @@ func BuildKubeletDynamicEnvFile(imageRepository string, nodeReg *kubeadmv1beta2.
 
 	kubeletFlags["network-plugin"] = "cni"
 	kubeletFlags["container-runtime"] = "remote"
-	kubeletFlags["container-runtime-endpoint"] = "unix:///var/run/containerd/containerd.sock"
+	kubeletFlags["container-runtime-endpoint"] = constants.ContainerdSocket
+	if nodeReg.CRISocket != "" {
+		kubeletFlags["container-runtime-endpoint"] = nodeReg.CRISocket
+	}
 
 	// Pass the "--hostname-override" flag to the kubelet only if it's different from the hostname
 	nodeName, hostname, err := GetNodeNameAndHostname(nodeReg)
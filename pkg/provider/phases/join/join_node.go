@@ -10,6 +10,7 @@ import (
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/provider/config"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/certs"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/cri"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/kubeadm"
 	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
 	"github.com/wtxue/kok-operator/pkg/util/ssh"
@@ -35,7 +36,7 @@ func ApplyPodManifest(hostIP string, ctx *common.ClusterContext, cfg *config.Con
 }
 
 func BuildKubeletKubeconfig(hostIP string, ctx *common.ClusterContext, apiserver string, fileMaps map[string]string) error {
-	cfgMaps, err := certs.CreateKubeConfigFiles(ctx.Credential.CAKey, ctx.Credential.CACert,
+	cfgMaps, err := certs.CreateKubeConfigFiles(ctx.Credential.CAKey, ctx.Credential.CACert, certs.LeafNotAfter(ctx.Cluster),
 		apiserver, hostIP, ctx.Cluster.Name, pkiutil.KubeletKubeConfigFileName)
 	if err != nil {
 		return errors.Wrapf(err, "create node: %s kubelet kubeconfg", hostIP)
@@ -95,7 +96,8 @@ func JoinNodePhase(s ssh.Interface, cfg *config.Config, ctx *common.ClusterConte
 	}
 
 	nodeOpt := &kubeadmv1beta2.NodeRegistrationOptions{
-		Name: hostIP,
+		Name:      hostIP,
+		CRISocket: cri.GetCRISocket(ctx.Cluster.Spec.CRIType),
 	}
 	flagsEnv := BuildKubeletDynamicEnvFile(cfg.CustomRegistry, nodeOpt)
 	fileMaps[constants.KubeletEnvFileName] = flagsEnv
@@ -6,26 +6,29 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/wtxue/kok-operator/pkg/apis"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/k8sutil"
 	"github.com/wtxue/kok-operator/pkg/provider/config"
 	"github.com/wtxue/kok-operator/pkg/provider/phases/certs"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/cri"
+	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
 	"github.com/wtxue/kok-operator/pkg/util/ssh"
 	"github.com/wtxue/kok-operator/pkg/util/template"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/tools/clientcmd"
-	clientcmdlatest "k8s.io/client-go/tools/clientcmd/api/latest"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
 	joinControlPlaneCmd = `kubeadm join {{.ControlPlaneEndpoint}} \
 --apiserver-advertise-address={{.AdvertiseAddress }} \
 --node-name={{.NodeName}} \
+--cri-socket={{.CRISocket}} \
 --token={{.BootstrapToken}} \
 --control-plane --certificate-key={{.CertificateKey}} \
 --skip-phases=control-plane-join/mark-control-plane \
@@ -40,6 +43,7 @@ const (
 `
 	joinNodeCmd = `kubeadm join {{.ControlPlaneEndpoint}} \
 --node-name={{.NodeName}} \
+--cri-socket={{.CRISocket}} \
 --token={{.BootstrapToken}} \
 --discovery-token-unsafe-skip-ca-verification \
 --ignore-preflight-errors=ImagePull \
@@ -91,49 +95,122 @@ func Init(ctx *common.ClusterContext, s ssh.Interface, kubeadmConfig *Config, ex
 
 // InitCerts ...
 func InitCerts(ctx *common.ClusterContext, cfg *Config, isManaged bool) error {
-	var lastCACert *certs.CaAll
 	cfgMaps := make(map[string][]byte)
+	warp := newWarpperConfiguration(ctx, cfg)
+	cas := make(map[string]*certs.CaAll)
+	for _, cert := range getCertList(isManaged) {
+		if cert.CAName != "" {
+			continue
+		}
 
-	warp := &apis.WarpperConfiguration{
-		InitConfiguration:    cfg.InitConfiguration,
-		ClusterConfiguration: cfg.ClusterConfiguration,
-		IPs:                  ctx.IPs(),
+		ret, err := certs.CreateCACertAndKeyFiles(cert, warp, cfgMaps)
+		if err != nil {
+			return err
+		}
+		cas[cert.Name] = ret
 	}
 
-	var certList certs.Certificates
-	if !isManaged {
-		certList = certs.GetDefaultCertList()
-	} else {
-		certList = certs.GetCertsWithoutEtcd()
+	err := createLeafCerts(warp, isManaged, cas, cfgMaps)
+	if err != nil {
+		return err
 	}
 
-	for _, cert := range certList {
-		if cert.CAName == "" {
-			ret, err := certs.CreateCACertAndKeyFiles(cert, warp, cfgMaps)
-			if err != nil {
-				return err
-			}
-			lastCACert = ret
-		} else {
-			if lastCACert == nil {
-				return fmt.Errorf("not hold CertificateAuthority by create cert: %s", cert.Name)
+	err = certs.CreateServiceAccountKeyAndPublicKeyFiles(cfg.ClusterConfiguration.CertificatesDir, x509.RSA, cfgMaps)
+	if err != nil {
+		return errors.Wrapf(err, "create sa public key")
+	}
+
+	if len(cfgMaps) == 0 {
+		return fmt.Errorf("no cert build")
+	}
+
+	storeCerts(ctx, cfgMaps)
+	return nil
+}
+
+// RenewCerts re-issues the leaf certs of the credential with its CAs, the CAs are updated first
+// for the step of CA rotation. The service account keys are kept.
+func RenewCerts(ctx *common.ClusterContext, cfg *Config, isManaged bool, phase devopsv1.CertRotationPhase) error {
+	cfgMaps := make(map[string][]byte)
+	warp := newWarpperConfiguration(ctx, cfg)
+	cas := make(map[string]*certs.CaAll)
+	for _, cert := range getCertList(isManaged) {
+		if cert.CAName != "" {
+			continue
+		}
+
+		certPath, keyPath := pkiutil.PathsForCertAndKey(warp.CertificatesDir, cert.BaseName)
+		cfgMaps[certPath] = ctx.Credential.CertsBinaryData[certPath]
+		cfgMaps[keyPath] = ctx.Credential.CertsBinaryData[keyPath]
+		if phase != "" && phase != devopsv1.CertRotationRenewing {
+			if ctx.Credential.PendingCAData == nil {
+				ctx.Credential.PendingCAData = make(map[string][]byte)
 			}
-			err := certs.CreateCertAndKeyFilesWithCA(cert, lastCACert, warp, cfgMaps)
+			err := certs.RotateCA(cert, warp, phase, cfgMaps, ctx.Credential.PendingCAData)
 			if err != nil {
-				return errors.Wrapf(err, "create cert: %s", cert.Name)
+				return err
 			}
 		}
+
+		ca, err := certs.LoadCA(cert, warp.CertificatesDir, cfgMaps)
+		if err != nil {
+			return err
+		}
+		cas[cert.Name] = ca
 	}
 
-	err := certs.CreateServiceAccountKeyAndPublicKeyFiles(cfg.ClusterConfiguration.CertificatesDir, x509.RSA, cfgMaps)
+	if len(ctx.Credential.PendingCAData) == 0 {
+		ctx.Credential.PendingCAData = nil
+	}
+
+	err := createLeafCerts(warp, isManaged, cas, cfgMaps)
 	if err != nil {
-		return errors.Wrapf(err, "create sa public key")
+		return err
 	}
 
-	if len(cfgMaps) == 0 {
-		return fmt.Errorf("no cert build")
+	storeCerts(ctx, cfgMaps)
+	return nil
+}
+
+func newWarpperConfiguration(ctx *common.ClusterContext, cfg *Config) *apis.WarpperConfiguration {
+	return &apis.WarpperConfiguration{
+		InitConfiguration:    cfg.InitConfiguration,
+		ClusterConfiguration: cfg.ClusterConfiguration,
+		IPs:                  ctx.IPs(),
+		NotAfter:             certs.LeafNotAfter(ctx.Cluster),
 	}
+}
 
+func getCertList(isManaged bool) certs.Certificates {
+	if isManaged {
+		return certs.GetCertsWithoutEtcd()
+	}
+
+	return certs.GetDefaultCertList()
+}
+
+func createLeafCerts(warp *apis.WarpperConfiguration, isManaged bool, cas map[string]*certs.CaAll, cfgMaps map[string][]byte) error {
+	for _, cert := range getCertList(isManaged) {
+		if cert.CAName == "" {
+			continue
+		}
+
+		ca, ok := cas[cert.CAName]
+		if !ok {
+			return fmt.Errorf("not hold CertificateAuthority by create cert: %s", cert.Name)
+		}
+		err := certs.CreateCertAndKeyFilesWithCA(cert, ca, warp, cfgMaps)
+		if err != nil {
+			return errors.Wrapf(err, "create cert: %s", cert.Name)
+		}
+	}
+
+	return nil
+}
+
+// storeCerts saves the certs into credential, the CAs and etcd client certs are saved into
+// their own fields as well.
+func storeCerts(ctx *common.ClusterContext, cfgMaps map[string][]byte) {
 	if ctx.Credential.CertsBinaryData == nil {
 		ctx.Credential.CertsBinaryData = make(map[string][]byte)
 	}
@@ -165,8 +242,6 @@ func InitCerts(ctx *common.ClusterContext, cfg *Config, isManaged bool) error {
 
 		ctx.Credential.CertsBinaryData[pathFile] = v
 	}
-
-	return nil
 }
 
 // JoinControlPlaneOption ...
@@ -176,6 +251,7 @@ type JoinControlPlaneOption struct {
 	BootstrapToken       string
 	CertificateKey       string
 	ControlPlaneEndpoint string
+	CRISocket            string
 }
 
 // JoinControlPlane ...
@@ -186,6 +262,7 @@ func JoinControlPlane(ctx *common.ClusterContext, s ssh.Interface) error {
 		ControlPlaneEndpoint: fmt.Sprintf("%s:6443", ctx.Cluster.Spec.Machines[0].IP),
 		NodeName:             s.HostIP(),
 		AdvertiseAddress:     s.HostIP(),
+		CRISocket:            cri.GetCRISocket(ctx.Cluster.Spec.CRIType),
 	}
 
 	cmd, err := template.ParseString(joinControlPlaneCmd, option)
@@ -205,6 +282,7 @@ type JoinNodeOption struct {
 	NodeName             string
 	BootstrapToken       string
 	ControlPlaneEndpoint string
+	CRISocket            string
 }
 
 func JoinNode(s ssh.Interface, option *JoinNodeOption) error {
@@ -221,104 +299,91 @@ func JoinNode(s ssh.Interface, option *JoinNodeOption) error {
 	return nil
 }
 
-func RenewCerts(s ssh.Interface) error {
-	err := fixKubeadmBug1753(s)
-	if err != nil {
-		return fmt.Errorf("fixKubeadmBug1753(https://github.com/kubernetes/kubeadm/issues/1753) error: %w", err)
-	}
-
-	cmd := fmt.Sprintf("kubeadm alpha certs renew all --config=%s", constants.KubeadmConfigFileName)
-	_, err = s.CombinedOutput(cmd)
-	if err != nil {
-		return err
-	}
-
-	err = RestartControlPlane(s)
-	if err != nil {
-		return err
+func RestartControlPlane(ctx *common.ClusterContext, s ssh.Interface) error {
+	targets := []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler"}
+	for _, one := range targets {
+		err := RestartContainerByFilter(ctx, s, LabelFilterForControlPlane(one))
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// https://github.com/kubernetes/kubeadm/issues/1753
-func fixKubeadmBug1753(s ssh.Interface) error {
-	needUpdate := false
+// LabelFilterForControlPlane returns the container label of the control plane static pod
+func LabelFilterForControlPlane(name string) string {
+	return fmt.Sprintf("io.kubernetes.container.name=%s", name)
+}
+
+// RestartContainerByFilter remove the containers matched the label filter, and wait for kubelet to restart them.
+// docker cluster use docker cli, others use crictl.
+func RestartContainerByFilter(ctx *common.ClusterContext, s ssh.Interface, filter string) error {
+	listCmd := listContainerCmd(ctx, filter)
 
-	data, err := s.ReadFile(constants.KubeletKubeConfigFileName)
+	output, err := s.CombinedOutput(listCmd)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "node: %s exec: %q", s.HostIP(), listCmd)
 	}
-	kubeletKubeconfig, err := clientcmd.Load(data)
-	if err != nil {
-		return err
+
+	ids := strings.Fields(string(output))
+	if len(ids) == 0 {
+		ctx.Info("no container matched, skip restart", "node", s.HostIP(), "filter", filter)
+		return nil
 	}
-	for _, info := range kubeletKubeconfig.AuthInfos {
-		if info.ClientKeyData == nil && info.ClientCertificateData == nil {
-			continue
-		}
 
-		info.ClientKeyData = []byte{}
-		info.ClientCertificateData = []byte{}
-		info.ClientKey = constants.KubeletClientCurrent
-		info.ClientCertificate = constants.KubeletClientCurrent
+	rmCmd := fmt.Sprintf("crictl rm -f %s", strings.Join(ids, " "))
+	if ctx.Cluster.Spec.CRIType == devopsv1.DockerCRI {
+		rmCmd = fmt.Sprintf("docker rm -f %s", strings.Join(ids, " "))
+	}
 
-		needUpdate = true
+	ctx.Info("restart container", "node", s.HostIP(), "cmd", rmCmd)
+	_, err = s.CombinedOutput(rmCmd)
+	if err != nil {
+		return errors.Wrapf(err, "node: %s exec: %q", s.HostIP(), rmCmd)
 	}
 
-	if needUpdate {
-		data, err := runtime.Encode(clientcmdlatest.Codec, kubeletKubeconfig)
+	err = wait.PollImmediate(5*time.Second, 5*time.Minute, func() (bool, error) {
+		output, err := s.CombinedOutput(listCmd)
 		if err != nil {
-			return err
+			return false, nil
 		}
-		err = s.WriteFile(bytes.NewReader(data), constants.KubeletKubeConfigFileName)
-		if err != nil {
-			return err
+		if len(strings.TrimSpace(string(output))) == 0 {
+			return false, nil
 		}
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("restart container(%s) error: %w", filter, err)
 	}
 
 	return nil
 }
 
-func RestartControlPlane(s ssh.Interface) error {
-	targets := []string{"kube-apiserver", "kube-controller-manager", "kube-scheduler"}
-	for _, one := range targets {
-		err := RestartContainerByFilter(s, DockerFilterForControlPlane(one))
+// WaitContainerStopped wait for the containers matched the label filter to exit,
+// e.g. the static pod manifest is removed.
+func WaitContainerStopped(ctx *common.ClusterContext, s ssh.Interface, filter string) error {
+	listCmd := listContainerCmd(ctx, filter)
+	err := wait.PollImmediate(5*time.Second, 3*time.Minute, func() (bool, error) {
+		output, err := s.CombinedOutput(listCmd)
 		if err != nil {
-			return err
+			return false, nil
 		}
+		return len(strings.TrimSpace(string(output))) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("node: %s wait container(%s) stopped error: %w", s.HostIP(), filter, err)
 	}
 
 	return nil
 }
 
-func DockerFilterForControlPlane(name string) string {
-	return fmt.Sprintf("label=io.kubernetes.container.name=%s", name)
-}
-
-func RestartContainerByFilter(s ssh.Interface, filter string) error {
-	// cmd := fmt.Sprintf("docker rm -f $(docker ps -q -f '%s')", filter)
-	// _, err := s.CombinedOutput(cmd)
-	// if err != nil {
-	// 	return err
-	// }
-	//
-	// err = wait.PollImmediate(5*time.Second, 5*time.Minute, func() (bool, error) {
-	// 	cmd = fmt.Sprintf("docker ps -q -f '%s'", filter)
-	// 	output, err := s.CombinedOutput(cmd)
-	// 	if err != nil {
-	// 		return false, nil
-	// 	}
-	// 	if len(output) == 0 {
-	// 		return false, nil
-	// 	}
-	// 	return true, nil
-	// })
-	// if err != nil {
-	// 	return fmt.Errorf("restart container(%s) error: %w", filter, err)
-	// }
+func listContainerCmd(ctx *common.ClusterContext, filter string) string {
+	if ctx.Cluster.Spec.CRIType == devopsv1.DockerCRI {
+		return fmt.Sprintf("docker ps -q -f 'label=%s'", filter)
+	}
 
-	return nil
+	return fmt.Sprintf("crictl ps -q --label '%s'", filter)
 }
 
 type Option struct {
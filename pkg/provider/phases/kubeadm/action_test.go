@@ -0,0 +1,135 @@
+package kubeadm
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	"github.com/wtxue/kok-operator/pkg/util/pointer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func newTestClusterContext() *common.ClusterContext {
+	return &common.ClusterContext{
+		Logger: logr.Discard(),
+		Cluster: &devopsv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"},
+			Spec: devopsv1.ClusterSpec{
+				Version:    "v1.24.4",
+				DNSDomain:  "cluster.local",
+				Properties: devopsv1.ClusterProperty{MaxNodePodNum: pointer.ToInt32(110)},
+				Machines:   []*devopsv1.ClusterMachine{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+				Certificates: &devopsv1.CertificatePolicy{
+					LeafLifetime: &metav1.Duration{Duration: 48 * time.Hour},
+				},
+			},
+			Status: devopsv1.ClusterStatus{ServiceCIDR: "10.96.0.0/16"},
+		},
+		Credential: &devopsv1.ClusterCredential{
+			CredentialInfo: devopsv1.CredentialInfo{
+				BootstrapToken: pointer.ToString("abcdef.0123456789abcdef"),
+				CertificateKey: pointer.ToString("0123456789abcdef0123456789abcdef"),
+			},
+		},
+	}
+}
+
+func parseCerts(t *testing.T, data []byte) []*x509.Certificate {
+	t.Helper()
+
+	certs, err := certutil.ParseCertsPEM(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+func verifyCert(t *testing.T, data []byte, ca *x509.Certificate) error {
+	t.Helper()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	_, err := parseCerts(t, data)[0].Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+func TestRotateCA(t *testing.T) {
+	ctx := newTestClusterContext()
+	cfg := GetKubeadmConfigByMaster0(ctx, config.NewDefaultConfig())
+	if err := InitCerts(ctx, cfg, false); err != nil {
+		t.Fatal(err)
+	}
+
+	data := ctx.Credential.CertsBinaryData
+	oldCA := parseCerts(t, ctx.Credential.CACert)[0]
+	oldSA := string(data["/etc/kubernetes/pki/sa.key"])
+	leaf := parseCerts(t, data[constants.APIServerCertName])[0]
+	if d := time.Until(leaf.NotAfter); d > 48*time.Hour || d < 47*time.Hour {
+		t.Errorf("expect leaf lifetime 48h, got %s", d)
+	}
+
+	if err := RenewCerts(ctx, cfg, false, devopsv1.CertRotationRenewing); err != nil {
+		t.Fatal(err)
+	}
+	if !parseCerts(t, ctx.Credential.CACert)[0].Equal(oldCA) {
+		t.Errorf("expect CA kept by renewal")
+	}
+	if parseCerts(t, data[constants.APIServerCertName])[0].Equal(leaf) {
+		t.Errorf("expect apiserver cert renewed")
+	}
+	if string(data["/etc/kubernetes/pki/sa.key"]) != oldSA {
+		t.Errorf("expect sa key kept")
+	}
+
+	if err := RenewCerts(ctx, cfg, false, devopsv1.CertRotationDistributingCA); err != nil {
+		t.Fatal(err)
+	}
+	bundle := parseCerts(t, ctx.Credential.CACert)
+	if len(bundle) != 2 || !bundle[0].Equal(oldCA) {
+		t.Fatalf("expect the old CA bundled with the new one, got %d certs", len(bundle))
+	}
+	newCA := bundle[1]
+	if err := verifyCert(t, data[constants.APIServerCertName], oldCA); err != nil {
+		t.Errorf("expect leaf signed by the old CA when distributing: %v", err)
+	}
+	if len(ctx.Credential.PendingCAData) == 0 {
+		t.Errorf("expect the new CA pending")
+	}
+
+	if err := RenewCerts(ctx, cfg, false, devopsv1.CertRotationReissuing); err != nil {
+		t.Fatal(err)
+	}
+	bundle = parseCerts(t, ctx.Credential.CACert)
+	if len(bundle) != 2 || !bundle[0].Equal(newCA) || !bundle[1].Equal(oldCA) {
+		t.Fatalf("expect the new CA bundled with the old one")
+	}
+	if err := verifyCert(t, data[constants.APIServerCertName], newCA); err != nil {
+		t.Errorf("expect leaf signed by the new CA when reissuing: %v", err)
+	}
+	if err := verifyCert(t, data[constants.APIServerEtcdClientCertName], parseCerts(t, ctx.Credential.ETCDCACert)[0]); err != nil {
+		t.Errorf("expect etcd cert signed by the new etcd CA: %v", err)
+	}
+
+	// the retry of the last step is a no-op for the CAs
+	for i := 0; i < 2; i++ {
+		if err := RenewCerts(ctx, cfg, false, devopsv1.CertRotationRetiringCA); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bundle = parseCerts(t, ctx.Credential.CACert)
+	if len(bundle) != 1 || !bundle[0].Equal(newCA) {
+		t.Errorf("expect only the new CA after retiring")
+	}
+	if ctx.Credential.PendingCAData != nil {
+		t.Errorf("expect no pending CA after retiring")
+	}
+}
@@ -7,12 +7,11 @@ import (
 
 	"github.com/imdario/mergo"
 	"github.com/wtxue/kok-operator/pkg/apis"
-	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
-
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
 	"github.com/wtxue/kok-operator/pkg/k8sutil"
 	"github.com/wtxue/kok-operator/pkg/provider/config"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/cri"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -102,9 +101,7 @@ func GetInitConfiguration(ctx *common.ClusterContext, cfg *config.Config) *kubea
 		}
 	}
 
-	if ctx.Cluster.Spec.CRIType != devopsv1.DockerCRI {
-		initCfg.NodeRegistration.CRISocket = "unix:///run/containerd/containerd.sock"
-	}
+	initCfg.NodeRegistration.CRISocket = cri.GetCRISocket(ctx.Cluster.Spec.CRIType)
 
 	return initCfg
 }
@@ -0,0 +1,46 @@
+package kubeadm
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+)
+
+const (
+	upgradeApplyCmd = `kubeadm upgrade apply %s --yes \
+--certificate-renewal=false \
+--ignore-preflight-errors=CoreDNSUnsupportedPlugins \
+--ignore-preflight-errors=CoreDNSMigration \
+-v 4
+`
+	upgradeNodeCmd = `kubeadm upgrade node \
+--certificate-renewal=false \
+-v 4
+`
+)
+
+// UpgradeApply upgrade the control plane on the first master to the version of cluster spec.
+func UpgradeApply(ctx *common.ClusterContext, s ssh.Interface) error {
+	cmd := fmt.Sprintf(upgradeApplyCmd, ctx.Cluster.Spec.Version)
+	ctx.Info("kubeadm upgrade apply", "node", s.HostIP(), "cmd", cmd)
+	exit, err := s.ExecStream(cmd, os.Stdout, os.Stderr)
+	if err != nil || exit != 0 {
+		return fmt.Errorf("node: %s exec %q failed:exit %d error:%v", s.HostIP(), cmd, exit, err)
+	}
+
+	return nil
+}
+
+// UpgradeNode upgrade the local control plane on the other masters after UpgradeApply, and the kubelet
+// config on the workers.
+func UpgradeNode(ctx *common.ClusterContext, s ssh.Interface) error {
+	ctx.Info("kubeadm upgrade node", "node", s.HostIP(), "cmd", upgradeNodeCmd)
+	exit, err := s.ExecStream(upgradeNodeCmd, os.Stdout, os.Stderr)
+	if err != nil || exit != 0 {
+		return fmt.Errorf("node: %s exec %q failed:exit %d error:%v", s.HostIP(), upgradeNodeCmd, exit, err)
+	}
+
+	return nil
+}
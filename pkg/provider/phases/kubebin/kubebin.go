@@ -7,37 +7,31 @@ import (
 	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
 	"github.com/wtxue/kok-operator/pkg/util/ssh"
 )
 
 func Install(ctx *common.ClusterContext, s ssh.Interface) error {
-	// dir := "bin/linux/" // local debug config dir
-	k8sDir := fmt.Sprintf("/k8s-%s/bin/", ctx.Cluster.Spec.Version)
-	otherDir := "/k8s/bin/"
-	if dir := constants.GetMapKey(ctx.Cluster.Annotations, constants.ClusterDebugLocalDir); len(dir) > 0 {
-		k8sDir = dir + k8sDir
-		otherDir = dir + otherDir
-	}
-
+	// Src is the name of artifact
 	var CopyList = []devopsv1.File{
 		{
-			Src: k8sDir + "kubectl",
+			Src: artifact.Kubectl,
 			Dst: "/usr/local/bin/kubectl",
 		},
 		{
-			Src: k8sDir + "kubeadm",
+			Src: artifact.Kubeadm,
 			Dst: "/usr/local/bin/kubeadm",
 		},
 		{
-			Src: otherDir + "k9s",
+			Src: artifact.K9s,
 			Dst: "/usr/local/bin/k9s",
 		},
 		{
-			Src: k8sDir + "kubelet",
+			Src: artifact.Kubelet,
 			Dst: "/usr/bin/kubelet",
 		},
 		{
-			Src: otherDir + "cni.tgz",
+			Src: artifact.CNI,
 			Dst: "/opt/k8s/cni.tgz",
 		},
 	}
@@ -48,9 +42,9 @@ func Install(ctx *common.ClusterContext, s ssh.Interface) error {
 			continue
 		}
 
-		err := s.CopyFile(ls.Src, ls.Dst)
+		err := artifact.Install(ctx, s, ls.Src, ls.Dst)
 		if err != nil {
-			ctx.Error(err, "CopyFile", "node", s.HostIP(), "src", ls.Src)
+			ctx.Error(err, "CopyFile", "node", s.HostIP(), "artifact", ls.Src)
 			return err
 		}
 
@@ -106,3 +100,83 @@ func Install(ctx *common.ClusterContext, s ssh.Interface) error {
 	ctx.Info("exec successfully", "node", s.HostIP(), "cmd", cmd)
 	return nil
 }
+
+// UpgradeKubeadm replace the kubeadm binary with the one of cluster spec version,
+// it must be done before run kubeadm upgrade on the node.
+func UpgradeKubeadm(ctx *common.ClusterContext, s ssh.Interface) error {
+	err := artifact.Install(ctx, s, artifact.Kubeadm, "/usr/local/bin/kubeadm")
+	if err != nil {
+		return err
+	}
+
+	_, _, _, err = s.Execf("chmod a+x /usr/local/bin/kubeadm")
+	if err != nil {
+		return err
+	}
+
+	ctx.Info("upgrade kubeadm success", "node", s.HostIP(), "version", ctx.Cluster.Spec.Version)
+	return nil
+}
+
+// UpgradeKubelet replace the kubelet and kubectl binary with the one of cluster spec version
+// and restart kubelet.
+func UpgradeKubelet(ctx *common.ClusterContext, s ssh.Interface) error {
+	// kubelet binary is busy when it running
+	cmd := "systemctl stop kubelet"
+	if _, stderr, exit, err := s.Execf(cmd); err != nil || exit != 0 {
+		return fmt.Errorf("node: %s exec %q failed:exit %d:stderr %s:error %s", s.HostIP(), cmd, exit, stderr, err)
+	}
+
+	var copyList = []devopsv1.File{
+		{
+			Src: artifact.Kubectl,
+			Dst: "/usr/local/bin/kubectl",
+		},
+		{
+			Src: artifact.Kubelet,
+			Dst: "/usr/bin/kubelet",
+		},
+	}
+
+	for _, ls := range copyList {
+		err := artifact.Install(ctx, s, ls.Src, ls.Dst)
+		if err != nil {
+			ctx.Error(err, "CopyFile", "node", s.HostIP(), "artifact", ls.Src)
+			return err
+		}
+
+		_, _, _, err = s.Execf("chmod a+x %s", ls.Dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	cmd = "systemctl daemon-reload && systemctl restart kubelet"
+	if _, stderr, exit, err := s.Execf(cmd); err != nil || exit != 0 {
+		return fmt.Errorf("node: %s exec %q failed:exit %d:stderr %s:error %s", s.HostIP(), cmd, exit, stderr, err)
+	}
+
+	ctx.Info("upgrade kubelet success", "node", s.HostIP(), "version", ctx.Cluster.Spec.Version)
+	return nil
+}
+
+// InstallEtcdctl copy the etcdctl binary of cluster spec version to the master if it is missing.
+func InstallEtcdctl(ctx *common.ClusterContext, s ssh.Interface) error {
+	dst := "/usr/local/bin/etcdctl"
+	if ok, err := s.Exist(dst); err == nil && ok {
+		return nil
+	}
+
+	err := artifact.Install(ctx, s, artifact.Etcdctl, dst)
+	if err != nil {
+		return err
+	}
+
+	_, _, _, err = s.Execf("chmod a+x %s", dst)
+	if err != nil {
+		return err
+	}
+
+	ctx.Info("copy success", "node", s.HostIP(), "dst", dst)
+	return nil
+}
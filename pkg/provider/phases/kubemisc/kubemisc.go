@@ -83,7 +83,7 @@ func ApplyKubeletKubeconfig(ctx *common.ClusterContext, apiserver string, kubele
 		return fmt.Errorf("ca is nil")
 	}
 
-	cfgMaps, err := certs.CreateKubeletKubeConfigFile(ctx.Credential.CAKey, ctx.Credential.CACert,
+	cfgMaps, err := certs.CreateKubeletKubeConfigFile(ctx.Credential.CAKey, ctx.Credential.CACert, certs.LeafNotAfter(ctx.Cluster),
 		apiserver, kubeletNodeAddr, ctx.Cluster.Name)
 	if err != nil {
 		return errors.Wrap(err, "create kubeconfg")
@@ -107,7 +107,7 @@ func BuildMasterMiscConfigToMap(ctx *common.ClusterContext, apiserver string) er
 		return fmt.Errorf("ca is nil")
 	}
 
-	cfgMaps, err := certs.CreateMasterKubeConfigFile(ctx.Credential.CAKey, ctx.Credential.CACert,
+	cfgMaps, err := certs.CreateMasterKubeConfigFile(ctx.Credential.CAKey, ctx.Credential.CACert, certs.LeafNotAfter(ctx.Cluster),
 		apiserver, ctx.Cluster.Name)
 	if err != nil {
 		return errors.Wrap(err, "create kubeconfg")
@@ -0,0 +1,48 @@
+package node
+
+import (
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/clean"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EnsureRemoveNode delete the node object from the cluster, it's skipped when the cluster is unreachable.
+func EnsureRemoveNode(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
+	if machine.Spec.Machine == nil {
+		ctx.Info("machine has no host, skip remove node")
+		return nil
+	}
+
+	clusterCtx, err := ctx.ClusterManager.Get(ctx.Cluster.Name)
+	if err != nil {
+		ctx.Info("cluster is not in cluster manager, skip remove node", "node", machine.Spec.Machine.IP)
+		return nil
+	}
+
+	err = clusterCtx.KubeCli.CoreV1().Nodes().Delete(ctx.Ctx, machine.Spec.Machine.IP, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "delete node %s", machine.Spec.Machine.IP)
+	}
+
+	ctx.Info("remove node successfully", "node", machine.Spec.Machine.IP)
+	return nil
+}
+
+// EnsureCleanNode reset kubeadm and remove the kubernetes files on the node.
+func EnsureCleanNode(ctx *common.ClusterContext, machine *devopsv1.Machine) error {
+	if machine.Spec.Machine == nil {
+		ctx.Info("machine has no host, skip clean node")
+		return nil
+	}
+
+	s, err := machine.Spec.SSH()
+	if err != nil {
+		return err
+	}
+
+	return clean.CleanNode(s, ctx.Cluster.Spec.CRIType)
+}
@@ -0,0 +1,144 @@
+package system
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+)
+
+// OSReleaseFile identifies the operating system of the host.
+const OSReleaseFile = "/etc/os-release"
+
+// the package managers of the supported operating systems
+const (
+	Yum = "yum"
+	Apt = "apt"
+)
+
+// rhelTypes are the rpm based distributions, their scripts only differ in the packages.
+var rhelTypes = map[devopsv1.OSType]bool{
+	devopsv1.CentosType:    true,
+	devopsv1.RHELType:      true,
+	devopsv1.RockyType:     true,
+	devopsv1.AlmaType:      true,
+	devopsv1.OpenEulerType: true,
+	devopsv1.KylinType:     true,
+	devopsv1.AnolisType:    true,
+}
+
+// OSInfo is the operating system of the host.
+type OSInfo struct {
+	Type      devopsv1.OSType
+	IDLike    []string
+	VersionID string
+	// PrettyName is the os image reported by the node, e.g. "Rocky Linux 8.6 (Green Obsidian)".
+	PrettyName string
+}
+
+// PackageManager returns the package manager of the os.
+func (o *OSInfo) PackageManager() string {
+	if rhelTypes[o.Type] {
+		return Yum
+	}
+	return Apt
+}
+
+// EL7 returns true if the os is CentOS or RHEL 7, which has the legacy packages.
+func (o *OSInfo) EL7() bool {
+	if o.Type != devopsv1.CentosType && o.Type != devopsv1.RHELType {
+		return false
+	}
+	return strings.Split(o.VersionID, ".")[0] == "7"
+}
+
+// ParseOSRelease returns the os of the content of /etc/os-release, the unknown distributions are
+// identified by ID_LIKE.
+func ParseOSRelease(data string) (*OSInfo, error) {
+	fields := map[string]string{}
+	for _, line := range strings.Split(data, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 || strings.HasPrefix(kv[0], "#") {
+			continue
+		}
+		v := kv[1]
+		if uv, err := strconv.Unquote(v); err == nil {
+			v = uv
+		} else {
+			v = strings.Trim(v, `'"`)
+		}
+		fields[kv[0]] = v
+	}
+
+	info := &OSInfo{
+		Type:       devopsv1.OSType(strings.ToLower(fields["ID"])),
+		IDLike:     strings.Fields(strings.ToLower(fields["ID_LIKE"])),
+		VersionID:  fields["VERSION_ID"],
+		PrettyName: fields["PRETTY_NAME"],
+	}
+	if info.Type == "" {
+		return nil, errors.Errorf("no ID in %s", OSReleaseFile)
+	}
+
+	if !IsSupportedOS(info.Type) {
+		like, ok := likeOSType(info.IDLike)
+		if !ok {
+			return nil, errors.Errorf("unsupported os %s %s", info.Type, info.VersionID)
+		}
+		info.Type = like
+	}
+	if info.PackageManager() == Yum && !info.EL7() {
+		if major, err := strconv.Atoi(strings.Split(info.VersionID, ".")[0]); err == nil && major < 7 {
+			return nil, errors.Errorf("unsupported os %s %s", info.Type, info.VersionID)
+		}
+	}
+
+	return info, nil
+}
+
+// likeOSType returns the supported os of ID_LIKE.
+func likeOSType(idLike []string) (devopsv1.OSType, bool) {
+	for _, id := range idLike {
+		switch id {
+		case "rhel", "centos", "fedora":
+			return devopsv1.RHELType, true
+		case "debian":
+			return devopsv1.DebianType, true
+		case "ubuntu":
+			return devopsv1.UbuntuType, true
+		}
+	}
+
+	return "", false
+}
+
+// IsSupportedOS returns true if the os has installer.
+func IsSupportedOS(t devopsv1.OSType) bool {
+	return rhelTypes[t] || t == devopsv1.DebianType || t == devopsv1.UbuntuType
+}
+
+// DetectOS returns the os of the host by /etc/os-release, osType is used if it's missing.
+func DetectOS(s ssh.Interface, osType devopsv1.OSType) (*OSInfo, error) {
+	ok, err := s.Exist(OSReleaseFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "node: %s stat %s", s.HostIP(), OSReleaseFile)
+	}
+	if !ok {
+		if !IsSupportedOS(osType) {
+			return nil, errors.Errorf("node: %s no %s, and unsupported osType %q", s.HostIP(), OSReleaseFile, osType)
+		}
+		return &OSInfo{Type: osType, PrettyName: string(osType)}, nil
+	}
+
+	data, err := s.ReadFile(OSReleaseFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "node: %s read %s", s.HostIP(), OSReleaseFile)
+	}
+	info, err := ParseOSRelease(string(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "node: %s", s.HostIP())
+	}
+	return info, nil
+}
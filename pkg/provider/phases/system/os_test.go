@@ -0,0 +1,146 @@
+package system
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/util/ssh/fake"
+	"github.com/wtxue/kok-operator/pkg/util/template"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		osType  devopsv1.OSType
+		manager string
+		el7     bool
+		wantErr bool
+	}{
+		{
+			name:    "centos7",
+			data:    "NAME=\"CentOS Linux\"\nVERSION=\"7 (Core)\"\nID=\"centos\"\nID_LIKE=\"rhel fedora\"\nVERSION_ID=\"7\"\n",
+			osType:  devopsv1.CentosType,
+			manager: Yum,
+			el7:     true,
+		},
+		{
+			name:    "rocky",
+			data:    "NAME=\"Rocky Linux\"\nID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nVERSION_ID=\"8.6\"\n",
+			osType:  devopsv1.RockyType,
+			manager: Yum,
+		},
+		{
+			name:    "openEuler",
+			data:    "NAME=\"openEuler\"\nVERSION=\"22.03 LTS\"\nID=\"openEuler\"\nVERSION_ID=\"22.03\"\n",
+			osType:  devopsv1.OpenEulerType,
+			manager: Yum,
+		},
+		{
+			name:    "kylin",
+			data:    "NAME=\"Kylin Linux Advanced Server\"\nVERSION=\"V10 (Sword)\"\nID=\"kylin\"\nVERSION_ID=\"V10\"\n",
+			osType:  devopsv1.KylinType,
+			manager: Yum,
+		},
+		{
+			name:    "ubuntu",
+			data:    "NAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"22.04\"\n",
+			osType:  devopsv1.UbuntuType,
+			manager: Apt,
+		},
+		{
+			name:    "oracle like rhel",
+			data:    "NAME=\"Oracle Linux Server\"\nID=\"ol\"\nID_LIKE=\"fedora\"\nVERSION_ID=\"8.6\"\n",
+			osType:  devopsv1.RHELType,
+			manager: Yum,
+		},
+		{
+			name:    "centos6",
+			data:    "ID=\"centos\"\nVERSION_ID=\"6\"\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			data:    "ID=\"arch\"\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := ParseOSRelease(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expect error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if info.Type != tt.osType || info.PackageManager() != tt.manager || info.EL7() != tt.el7 {
+				t.Errorf("unexpected os %+v", info)
+			}
+		})
+	}
+}
+
+func TestInstallRepos(t *testing.T) {
+	ctx := &common.ClusterContext{
+		Logger: logr.Discard(),
+		Cluster: &devopsv1.Cluster{Spec: devopsv1.ClusterSpec{PackageRepos: &devopsv1.PackageRepos{
+			Replace: true,
+			Repos: []devopsv1.PackageRepo{
+				{OSType: devopsv1.RockyType, Name: "rocky-mirror", Content: "[baseos]\n"},
+				{OSType: devopsv1.UbuntuType, Name: "ubuntu-mirror", Content: "deb http://mirror/ubuntu jammy main\n"},
+			},
+		}}},
+	}
+
+	rocky := fake.New("10.0.0.1")
+	rocky.WriteFile(strings.NewReader("ID=\"rocky\"\nVERSION_ID=\"9.0\"\n"), OSReleaseFile)
+	info, err := DetectOS(rocky, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallRepos(ctx, rocky, info); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := rocky.File("/etc/yum.repos.d/rocky-mirror.repo"); !ok || string(data) != "[baseos]\n" {
+		t.Errorf("expect the rocky repo written, got %q", data)
+	}
+	if _, ok := rocky.File("/etc/apt/sources.list.d/ubuntu-mirror.list"); ok {
+		t.Errorf("expect the ubuntu repo skipped")
+	}
+	if !rocky.Executed("repoBakDir") {
+		t.Errorf("expect the existing repos backed up")
+	}
+
+	// the hosts without repos of their os are untouched
+	debian := fake.New("10.0.0.2")
+	if err := InstallRepos(ctx, debian, &OSInfo{Type: devopsv1.DebianType}); err != nil {
+		t.Fatal(err)
+	}
+	if len(debian.Commands()) != 0 {
+		t.Errorf("expect no commands, got %v", debian.Commands())
+	}
+
+	if _, err := DetectOS(fake.New("10.0.0.3"), ""); err == nil {
+		t.Errorf("expect error without os-release and osType")
+	}
+	if info, err := DetectOS(fake.New("10.0.0.3"), devopsv1.CentosType); err != nil || info.Type != devopsv1.CentosType {
+		t.Errorf("expect the osType used, got %+v: %v", info, err)
+	}
+}
+
+func TestRHELShellTemplate(t *testing.T) {
+	for _, el7 := range []bool{true, false} {
+		data, err := template.ParseString(rhelShellTemplate, &Option{HostIP: "10.0.0.1", EL7: el7})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "fs.may_detach_mounts") != el7 || strings.Contains(string(data), "conntrack-tools") == el7 {
+			t.Errorf("unexpected script of el7 %v:\n%s", el7, data)
+		}
+	}
+}
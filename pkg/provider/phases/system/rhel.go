@@ -1,27 +1,21 @@
 package system
 
 const (
-	centosShellTemplate = `
+	rhelShellTemplate = `
 #!/usr/bin/env bash
 
 set -xeuo pipefail
 
-function Update_yumrepo() {
-    mkdir -p /etc/yum.repos.d/repoBakDir
-    mv /etc/yum.repos.d/*.repo /etc/yum.repos.d/repoBakDir/
-	rm -rvf /etc/yum.repos.d/*.repo
-    curl https://mirrors.aliyun.com/repo/epel-7.repo -o /etc/yum.repos.d/epel-7.repo
-    curl https://mirrors.aliyun.com/repo/Centos-{{ default "7" .CentosVersion }}.repo -o /etc/yum.repos.d/Centos-Base.repo
-}
-
 function Firewalld_process() {
     grep SELINUX=disabled /etc/selinux/config && echo -e "\033[32;32m 已关闭防火墙，退出防火墙设置 \033[0m \n" && return
 
     echo -e "\033[32;32m 关闭防火墙 \033[0m \n"
-    systemctl stop firewalld && systemctl disable firewalld
+    if systemctl list-unit-files firewalld.service | grep -q firewalld; then
+      systemctl stop firewalld && systemctl disable firewalld
+    fi
 
     echo -e "\033[32;32m 关闭selinux \033[0m \n"
-    setenforce 0
+    setenforce 0 || true
     sed -i 's/^SELINUX=.*/SELINUX=disabled/' /etc/selinux/config
     echo -e "\033[32;32m 关闭swap \033[0m \n"
     swapoff -a && sed -i '/ swap / s/^\(.*\)$/#\1/g' /etc/fstab
@@ -29,10 +23,16 @@ function Firewalld_process() {
 
 function Install_depend_software(){
     echo -e "\033[32;32m 开始安装依赖环境包 \033[0m \n"
+{{- if .EL7 }}
     yum -y --nogpgcheck install  yum-utils device-mapper-persistent-data lvm2 \
            curl wget vim telnet ipvsadm tc ipset tree telnet wget net-tools  \
            tcpdump bash-completion sysstat chrony jq psmisc socat \
            sysstat conntrack iproute dstat lsof perl 
+{{- else }}
+    yum -y --nogpgcheck install  curl wget vim ipvsadm ipset tree net-tools  \
+           tcpdump bash-completion sysstat chrony jq psmisc socat tar \
+           conntrack-tools iproute iproute-tc lsof perl 
+{{- end }}
 }
 
 function Install_ipvs(){
@@ -42,6 +42,7 @@ function Install_ipvs(){
     fi
 
     echo -e "\033[32;32m 开始配置系统ipvs \033[0m \n"
+    mkdir -p /etc/sysconfig/modules
     cat <<EOF |tee /etc/sysconfig/modules/ipvs.modules
 #!/bin/bash
 ipvs_modules="ip_vs ip_vs_lc ip_vs_wlc ip_vs_rr ip_vs_wrr ip_vs_lblc ip_vs_lblcr ip_vs_dh ip_vs_sh ip_vs_fo ip_vs_nq ip_vs_sed ip_vs_ftp nf_conntrack"
@@ -112,7 +113,9 @@ net.ipv4.tcp_syncookies = 1
 net.netfilter.nf_conntrack_max = 2310720
 fs.inotify.max_user_watches = 89100
 fs.inotify.max_user_instances = 8192
+{{- if .EL7 }}
 fs.may_detach_mounts = 1
+{{- end }}
 fs.file-max = 52706963
 fs.nr_open = 52706963
 vm.swappiness = 0
@@ -131,11 +134,9 @@ EOF
 
 # 初始化顺序
 echo -e "\033[32;32m 开始初始化结点 @{{ .HostIP }}@ \033[0m \n"
-Update_yumrepo && \
 Firewalld_process && \
 Install_depend_software && \
 Install_ipvs && \
-Install_depend_environment && \
-Update_kernel 
+Install_depend_environment
 `
 )
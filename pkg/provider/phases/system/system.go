@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -22,28 +23,88 @@ type Option struct {
 	K8sVersion string
 	// ContainerdVersion  string
 	// Cgroupdriver       string // cgroupfs or systemd
-	HostIP        string
-	KernelRepo    string
-	ResolvConf    string
-	CentosVersion string
-	ExtraArgs     map[string]string
+	HostIP     string
+	KernelRepo string
+	ResolvConf string
+	OSType     devopsv1.OSType
+	EL7        bool
+	ExtraArgs  map[string]string
 }
 
-func shellTemplate(ctx *common.ClusterContext) string {
-	switch ctx.Cluster.Spec.OSType {
-	case devopsv1.DebianType:
-		return debianShellTemplate
-	case devopsv1.UbuntuType:
+func shellTemplate(info *OSInfo) string {
+	switch {
+	case info.PackageManager() == Yum:
+		return rhelShellTemplate
+	case info.Type == devopsv1.UbuntuType:
 		return ubuntuShellTemplate
 	default:
-		return centosShellTemplate
+		return debianShellTemplate
+	}
+}
+
+// repoDir returns the dir and suffix of the repo files of the package manager.
+func repoDir(info *OSInfo) (string, string) {
+	if info.PackageManager() == Yum {
+		return "/etc/yum.repos.d", ".repo"
+	}
+	return "/etc/apt/sources.list.d", ".list"
+}
+
+// InstallRepos writes the package repos of the cluster for the os of the host, the existing
+// repos are backed up into repoBakDir if they're replaced.
+func InstallRepos(ctx *common.ClusterContext, s ssh.Interface, info *OSInfo) error {
+	if ctx.Cluster.Spec.PackageRepos == nil {
+		return nil
+	}
+
+	var repos []devopsv1.PackageRepo
+	for _, repo := range ctx.Cluster.Spec.PackageRepos.Repos {
+		if repo.OSType == "" || repo.OSType == info.Type {
+			repos = append(repos, repo)
+		}
+	}
+	if len(repos) == 0 {
+		return nil
+	}
+
+	dir, suffix := repoDir(info)
+	if ctx.Cluster.Spec.PackageRepos.Replace {
+		cmd := fmt.Sprintf("mkdir -p %s/repoBakDir && find %s -maxdepth 1 -name '*%s' -exec mv -f {} %s/repoBakDir/ \\;", dir, dir, suffix, dir)
+		if info.PackageManager() == Apt {
+			cmd += " && if [ -f /etc/apt/sources.list ]; then mv -f /etc/apt/sources.list /etc/apt/sources.list.d/repoBakDir/; fi"
+		}
+		if _, err := s.CombinedOutput(cmd); err != nil {
+			return errors.Wrapf(err, "node: %s back up repos", s.HostIP())
+		}
+	}
+
+	for _, repo := range repos {
+		err := s.WriteFile(strings.NewReader(repo.Content), path.Join(dir, repo.Name+suffix))
+		if err != nil {
+			return errors.Wrapf(err, "node: %s write repo %s", s.HostIP(), repo.Name)
+		}
 	}
+
+	ctx.Info("install package repos", "node", s.HostIP(), "os", info.Type, "replace", ctx.Cluster.Spec.PackageRepos.Replace)
+	return nil
 }
 
 func Install(ctx *common.ClusterContext, s ssh.Interface) error {
+	info, err := DetectOS(s, ctx.Cluster.Spec.OSType)
+	if err != nil {
+		return err
+	}
+
+	err = InstallRepos(ctx, s, info)
+	if err != nil {
+		return err
+	}
+
 	option := &Option{
 		K8sVersion: ctx.Cluster.Spec.Version,
 		HostIP:     s.HostIP(),
+		OSType:     info.Type,
+		EL7:        info.EL7(),
 	}
 
 	// _, _, _, err := s.Execf("hostnamectl set-hostname %s", s.HostIP())
@@ -51,7 +112,7 @@ func Install(ctx *common.ClusterContext, s ssh.Interface) error {
 	// 	return err
 	// }
 
-	initData, err := template.ParseString(shellTemplate(ctx), option)
+	initData, err := template.ParseString(shellTemplate(info), option)
 	if err != nil {
 		return err
 	}
@@ -61,7 +122,7 @@ func Install(ctx *common.ClusterContext, s ssh.Interface) error {
 		return err
 	}
 
-	ctx.Info("start exec init system ... ", "node", option.HostIP)
+	ctx.Info("start exec init system ... ", "node", option.HostIP, "os", info.PrettyName)
 	cmd := fmt.Sprintf("chmod a+x %s && %s", constants.SystemInitFile, constants.SystemInitFile)
 	exit, err := s.ExecStream(cmd, os.Stdout, os.Stderr)
 	if err != nil {
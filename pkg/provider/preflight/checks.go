@@ -7,9 +7,12 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
 	"github.com/wtxue/kok-operator/pkg/constants"
 	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
 	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
@@ -19,74 +22,120 @@ const (
 
 var tools = []string{"ipvsadm", "modprobe", "modinfo", "ip", "awk", "iptables"}
 
-func newCommonChecks(s ssh.Interface) []Checker {
+func newCommonChecks(s ssh.Interface, policy *devopsv1.PreflightPolicy) []Checker {
+	major, minor := minKernelVersion(policy)
 	return []Checker{
 		IsPrivilegedUserCheck{Interface: s},
-		CPUArchCeck{Interface: s, Arch: 64},
-		KernelCheck{Interface: s, MinKernelVersion: 4, MinMajorVersion: 10},
-		// KernelModuleCheck{Interface: s, Module: "iptable_nat"},
+		CPUArchCeck{Interface: s},
+		KernelCheck{Interface: s, MinKernelVersion: major, MinMajorVersion: minor},
 		FileContentCheck{Interface: s, Path: ipv4Forward, Content: []byte{'1'}},
-		// FileAvailableCheck{Interface: s, Path: constants.KubectlConfigFile},
-		// DirAvailableCheck{Interface: s, Path: constants.CNIConfDIr},
 		DirAvailableCheck{Interface: s, Path: constants.CNIDataDir},
-		// PortOpenCheck{Interface: s, port: constants.ProxyHealthzPort},
-		// PortOpenCheck{Interface: s, port: constants.ProxyStatusPort},
 		PortOpenCheck{Interface: s, port: constants.KubeletPort},
+		DiskCheck{Interface: s, Path: diskPath, MinGiB: minDiskGiB(policy)},
+		SwapCheck{Interface: s},
 	}
 }
 
-// RunMasterChecks checks for master
-func RunMasterChecks(ctx *common.ClusterContext, s ssh.Interface) error {
-	checks := newCommonChecks(s)
+// optionalChecks are the checks run only when they're enabled by the policy.
+func optionalChecks(s ssh.Interface) []Checker {
+	return []Checker{
+		KernelModuleCheck{Interface: s, Module: "iptable_nat"},
+		FileAvailableCheck{Interface: s, Path: constants.KubectlConfigFile},
+		DirAvailableCheck{Interface: s, Path: constants.CNIConfDIr},
+		PortOpenCheck{Interface: s, port: constants.ProxyHealthzPort},
+		PortOpenCheck{Interface: s, port: constants.ProxyStatusPort},
+		PortOpenCheck{Interface: s, port: constants.EtcdListenPeerPort},
+	}
+}
+
+// the minimums of the hosts, the memory of master is the one required by kubeadm
+const (
+	MinMasterMemory = 1700 << 20
+	MinNodeMemory   = 1 << 30
+	MinDiskGiB      = 10
+	diskPath        = "/var/lib"
+)
+
+// NewMasterChecks returns the checks of master tuned by policy
+func NewMasterChecks(s ssh.Interface, policy *devopsv1.PreflightPolicy) []Checker {
+	checks := newCommonChecks(s, policy)
 	checks = append(checks, []Checker{
-		NumCPUCheck{Interface: s, NumCPU: 1},
+		NumCPUCheck{Interface: s, NumCPU: minCPU(policy)},
+		MemoryCheck{Interface: s, MinBytes: minMemory(policy, MinMasterMemory)},
 		DirAvailableCheck{Interface: s, Path: constants.EtcdDataDir},
 		PortOpenCheck{Interface: s, port: 6443}, // kube-apiserver
 		PortOpenCheck{Interface: s, port: constants.ProxyHealthzPort},
-		// PortOpenCheck{Interface: s, port: constants.ProxyStatusPort},
 		PortOpenCheck{Interface: s, port: constants.EtcdListenClientPort},
-		// PortOpenCheck{Interface: s, port: constants.EtcdListenPeerPort},
 	}...)
 
 	for _, tool := range tools {
 		checks = append(checks, InPathCheck{Interface: s, executable: tool})
 	}
 
-	return RunChecks(checks)
+	return applyPolicy(checks, optionalChecks(s), policy)
 }
 
-// RunNodeChecks checks for node
-func RunNodeChecks(s ssh.Interface) error {
-	checks := newCommonChecks(s)
-	checks = append(checks, []Checker{}...)
+// NewNodeChecks returns the checks of node tuned by policy
+func NewNodeChecks(s ssh.Interface, policy *devopsv1.PreflightPolicy) []Checker {
+	checks := newCommonChecks(s, policy)
+	checks = append(checks, []Checker{
+		MemoryCheck{Interface: s, MinBytes: minMemory(policy, MinNodeMemory)},
+	}...)
 
 	for _, tool := range tools {
 		checks = append(checks, InPathCheck{Interface: s, executable: tool})
 	}
 
-	return RunChecks(checks)
+	return applyPolicy(checks, optionalChecks(s), policy)
+}
+
+// RunMasterChecks checks for master by the policy of cluster
+func RunMasterChecks(ctx *common.ClusterContext, s ssh.Interface) error {
+	policy := ctx.Cluster.Spec.Preflight
+	warnings, err := RunChecks(NewMasterChecks(s, policy), policy)
+	for _, w := range warnings {
+		ctx.Info("preflight warning", "node", s.HostIP(), "warning", w)
+		ctx.Eventf(ctx.Cluster, corev1.EventTypeWarning, reasonPreflightWarning, "node %s %s", s.HostIP(), w)
+	}
+	return err
+}
+
+// RunNodeChecks checks for node by the policy of machine, or the one of cluster if it's not set
+func RunNodeChecks(ctx *common.ClusterContext, s ssh.Interface, machine *devopsv1.Machine) error {
+	policy := MachinePolicy(ctx.Cluster, machine)
+	warnings, err := RunChecks(NewNodeChecks(s, policy), policy)
+	for _, w := range warnings {
+		ctx.Info("preflight warning", "node", s.HostIP(), "warning", w)
+		ctx.Eventf(machine, corev1.EventTypeWarning, reasonPreflightWarning, "node %s %s", s.HostIP(), w)
+	}
+	return err
 }
 
-// RunChecks runs each check, displays it's warnings/errors, and once all
-// are processed will exit if any errors occurred.
-func RunChecks(checks []Checker) error {
+// RunChecks runs each check, and once all are processed will exit if any errors occurred.
+// The warnings and the errors ignored by policy are returned as warnings.
+func RunChecks(checks []Checker, policy *devopsv1.PreflightPolicy) ([]string, error) {
 	var errsBuffer bytes.Buffer
+	var warnings []string
 
 	for _, c := range checks {
 		name := c.Name()
-		warnings, errs := c.Check()
+		ws, errs := c.Check()
 
-		for _, w := range warnings {
-			errsBuffer.WriteString(fmt.Sprintf("\t[WARNING %s]: %v\n", name, w))
+		for _, w := range ws {
+			warnings = append(warnings, fmt.Sprintf("[WARNING %s]: %v", name, w))
 		}
 		for _, i := range errs {
+			if IsIgnored(policy, name) {
+				warnings = append(warnings, fmt.Sprintf("[WARNING %s]: %v (ignored)", name, i.Error()))
+				continue
+			}
 			errsBuffer.WriteString(fmt.Sprintf("\t[ERROR %s]: %v\n", name, i.Error()))
 		}
 	}
 	if errsBuffer.Len() > 0 {
-		return &Error{Msg: errsBuffer.String()}
+		return warnings, &Error{Msg: errsBuffer.String()}
 	}
-	return nil
+	return warnings, nil
 }
 
 // Error defines struct for communicating error messages generated by preflight checks
@@ -141,32 +190,94 @@ func (ncc NumCPUCheck) Check() (warnings, errorList []error) {
 	return warnings, errorList
 }
 
-// CPUArchCeck checks cpu arch
+// MemoryCheck checks the total memory is not less than required
+type MemoryCheck struct {
+	ssh.Interface
+	MinBytes uint64
+}
+
+// Name returns the label for MemoryCheck
+func (MemoryCheck) Name() string {
+	return "Memory"
+}
+
+// Check validates the total memory
+func (mc MemoryCheck) Check() (warnings, errorList []error) {
+	capacity, err := ssh.MemoryCapacity(mc.Interface)
+	if err != nil {
+		return nil, []error{errors.Wrap(err, "get memory capacity")}
+	}
+
+	if capacity < mc.MinBytes {
+		errorList = append(errorList, errors.Errorf("the memory %dMB is less than the required %dMB", capacity>>20, mc.MinBytes>>20))
+	}
+	return nil, errorList
+}
+
+// DiskCheck checks the available disk of Path is not less than required
+type DiskCheck struct {
+	ssh.Interface
+	Path   string
+	MinGiB int
+}
+
+// Name returns the label for DiskCheck
+func (DiskCheck) Name() string {
+	return "Disk"
+}
+
+// Check validates the available disk
+func (dc DiskCheck) Check() (warnings, errorList []error) {
+	avail, err := ssh.DiskAvail(dc.Interface, dc.Path)
+	if err != nil {
+		return nil, []error{errors.Wrapf(err, "get available disk of %s", dc.Path)}
+	}
+
+	if avail < dc.MinGiB {
+		errorList = append(errorList, errors.Errorf("the available disk %dGiB of %s is less than the required %dGiB", avail, dc.Path, dc.MinGiB))
+	}
+	return nil, errorList
+}
+
+// SwapCheck checks the swap is off
+type SwapCheck struct {
+	ssh.Interface
+}
+
+// Name returns the label for SwapCheck
+func (SwapCheck) Name() string {
+	return "Swap"
+}
+
+// Check validates no swap is in use, /proc/swaps has the header only
+func (sc SwapCheck) Check() (warnings, errorList []error) {
+	data, err := sc.CombinedOutput("cat /proc/swaps")
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) > 1 {
+		errorList = append(errorList, errors.Errorf("swap is on: %s", strings.Join(lines[1:], "; ")))
+	}
+	return nil, errorList
+}
+
+// CPUArchCeck checks the cpu arch is supported
 type CPUArchCeck struct {
 	ssh.Interface
-	Arch int
 }
 
 // Name returns the label for CPUArchCeck
 func (CPUArchCeck) Name() string {
-	return "NumCPU"
+	return "CPUArch"
 }
 
 // Check checks cpu arch
 func (cac CPUArchCeck) Check() (warnings, errorList []error) {
-	result, err := cac.CombinedOutput("getconf LONG_BIT")
+	_, err := artifact.HostArch(cac.Interface)
 	if err != nil {
 		errorList = append(errorList, err)
-		return
-	}
-	arch, err := strconv.Atoi(strings.TrimSpace(string(result)))
-	if err != nil {
-		errorList = append(errorList, err)
-		return
-	}
-
-	if arch != cac.Arch {
-		errorList = append(errorList, errors.Errorf("only support CPU arch %d, but current is %d", cac.Arch, arch))
 	}
 	return warnings, errorList
 }
@@ -358,8 +469,8 @@ type KernelCheck struct {
 }
 
 // Name returns label for KernelCheck
-func (kc KernelCheck) Name() string {
-	return fmt.Sprintf("KernelCheck-%d-%d", kc.MinKernelVersion, kc.MinMajorVersion)
+func (KernelCheck) Name() string {
+	return "KernelVersion"
 }
 
 // Check validates kernel version
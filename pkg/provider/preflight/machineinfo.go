@@ -0,0 +1,52 @@
+package preflight
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/system"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+)
+
+// DetectMachineInfo returns the system info of the host. The cpu arch must be supported and match
+// the declared one of machine, the os must have installer, and the artifacts of the cluster version
+// must exist for the arch, the info is returned with the error of os and artifacts.
+func DetectMachineInfo(ctx *common.ClusterContext, s ssh.Interface, m *devopsv1.ClusterMachine) (*devopsv1.MachineSystemInfo, error) {
+	out, err := s.CombinedOutput("uname -s -r -m")
+	if err != nil {
+		return nil, errors.Wrapf(err, "node: %s uname", s.HostIP())
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 3 {
+		return nil, errors.Errorf("node: %s unexpected uname: %q", s.HostIP(), out)
+	}
+
+	arch, err := artifact.ParseArch(fields[2])
+	if err != nil {
+		return nil, errors.Wrapf(err, "node: %s", s.HostIP())
+	}
+	if m != nil && m.Arch != "" && m.Arch != arch {
+		return nil, errors.Errorf("node: %s declared arch %s, but detected %s", s.HostIP(), m.Arch, arch)
+	}
+
+	info := &devopsv1.MachineSystemInfo{
+		OperatingSystem: strings.ToLower(fields[0]),
+		KernelVersion:   fields[1],
+		Architecture:    arch,
+	}
+	osInfo, err := system.DetectOS(s, ctx.Cluster.Spec.OSType)
+	if err != nil {
+		return info, err
+	}
+	info.OSImage = osInfo.PrettyName
+	ctx.Info("detect machine info", "node", s.HostIP(), "arch", arch, "kernel", info.KernelVersion, "os", info.OSImage)
+
+	err = artifact.ForCluster(ctx.Cluster).CheckArch(ctx.Ctx, ctx.Cluster, arch)
+	if err != nil {
+		return info, errors.Wrapf(err, "node: %s", s.HostIP())
+	}
+	return info, nil
+}
@@ -0,0 +1,68 @@
+package preflight
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
+	"github.com/wtxue/kok-operator/pkg/util/ssh/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDetectMachineInfo(t *testing.T) {
+	dir := t.TempDir()
+	ctx := &common.ClusterContext{
+		Logger: logr.Discard(),
+		Cluster: &devopsv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{constants.ClusterDebugLocalDir: dir}},
+			Spec:       devopsv1.ClusterSpec{Version: "v1.24.4"},
+		},
+	}
+
+	// only the artifacts of amd64 like the image
+	manifest := "artifacts:\n"
+	for _, name := range artifact.Required(ctx.Cluster) {
+		manifest += fmt.Sprintf("- name: %s\n  arch: amd64\n  path: %s\n  sha256: %s\n", name, name, strings.Repeat("ab", 32))
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, artifact.ManifestFile), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	x86 := fake.New("10.0.0.1", fake.Result{Match: "uname", Stdout: "Linux 5.10.0-18-amd64 x86_64\n"})
+	x86.WriteFile(strings.NewReader("PRETTY_NAME=\"Debian GNU/Linux 11 (bullseye)\"\nID=debian\nVERSION_ID=\"11\"\n"), "/etc/os-release")
+	info, err := DetectMachineInfo(ctx, x86, &devopsv1.ClusterMachine{IP: "10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Architecture != "amd64" || info.OperatingSystem != "linux" || info.KernelVersion != "5.10.0-18-amd64" ||
+		info.OSImage != "Debian GNU/Linux 11 (bullseye)" {
+		t.Errorf("unexpected info %+v", info)
+	}
+
+	if _, err := DetectMachineInfo(ctx, x86, &devopsv1.ClusterMachine{IP: "10.0.0.1", Arch: "arm64"}); err == nil {
+		t.Errorf("expect the declared arch mismatched")
+	}
+
+	arm := fake.New("10.0.0.2", fake.Result{Match: "uname", Stdout: "Linux 5.10.0-18-arm64 aarch64\n"})
+	arm.WriteFile(strings.NewReader("ID=\"kylin\"\nVERSION_ID=\"V10\"\n"), "/etc/os-release")
+	info, err = DetectMachineInfo(ctx, arm, &devopsv1.ClusterMachine{IP: "10.0.0.2"})
+	if err == nil {
+		t.Errorf("expect no artifacts of arm64 in the image")
+	}
+	if info == nil || info.Architecture != "arm64" {
+		t.Errorf("expect arm64 detected, got %+v", info)
+	}
+
+	unknown := fake.New("10.0.0.3", fake.Result{Match: "uname", Stdout: "Linux 6.0.2-arch1-1 x86_64\n"})
+	unknown.WriteFile(strings.NewReader("ID=arch\n"), "/etc/os-release")
+	if _, err := DetectMachineInfo(ctx, unknown, nil); err == nil {
+		t.Errorf("expect arch linux unsupported")
+	}
+}
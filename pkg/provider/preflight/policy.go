@@ -0,0 +1,148 @@
+package preflight
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+)
+
+const reasonPreflightWarning = "PreflightWarning"
+
+// the defaults of the policy
+const (
+	defaultMinKernelMajor = 4
+	defaultMinKernelMinor = 10
+)
+
+// MachinePolicy returns the policy of machine, or the one of cluster if it's not set.
+func MachinePolicy(c *devopsv1.Cluster, machine *devopsv1.Machine) *devopsv1.PreflightPolicy {
+	if machine != nil && machine.Spec.Preflight != nil {
+		return machine.Spec.Preflight
+	}
+
+	return c.Spec.Preflight
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisabled returns true if the check of name isn't run.
+func IsDisabled(policy *devopsv1.PreflightPolicy, name string) bool {
+	return policy != nil && containsName(policy.Disable, name)
+}
+
+// IsIgnored returns true if the errors of the check of name are warnings.
+func IsIgnored(policy *devopsv1.PreflightPolicy, name string) bool {
+	return policy != nil && (containsName(policy.IgnoreErrors, name) || containsName(policy.IgnoreErrors, devopsv1.PreflightIgnoreAll))
+}
+
+// applyPolicy returns the checks without the disabled ones, and with the enabled optional ones.
+func applyPolicy(checks, optional []Checker, policy *devopsv1.PreflightPolicy) []Checker {
+	names := make(map[string]bool, len(checks))
+	result := make([]Checker, 0, len(checks))
+	for _, c := range checks {
+		names[c.Name()] = true
+		if !IsDisabled(policy, c.Name()) {
+			result = append(result, c)
+		}
+	}
+
+	if policy == nil {
+		return result
+	}
+	for _, c := range optional {
+		if !names[c.Name()] && containsName(policy.Enable, c.Name()) && !IsDisabled(policy, c.Name()) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// OptionalCheckNames returns the names of the checks can be enabled.
+func OptionalCheckNames() []string {
+	var names []string
+	for _, c := range optionalChecks(nil) {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+// hostsCheckNames are the names of the checks run by Run across the hosts.
+var hostsCheckNames = []string{"SSH", "Reachability", "CIDR", "ClockSkew", "Hostname", "MAC"}
+
+// KnownCheckNames returns the names of all checks, which can be disabled or ignored.
+func KnownCheckNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, checks := range [][]Checker{NewMasterChecks(nil, nil), NewNodeChecks(nil, nil), optionalChecks(nil)} {
+		for _, c := range checks {
+			if !seen[c.Name()] {
+				seen[c.Name()] = true
+				names = append(names, c.Name())
+			}
+		}
+	}
+	return append(names, hostsCheckNames...)
+}
+
+// ParseKernelVersion parses the kernel version as major.minor.
+func ParseKernelVersion(version string) (int, int, bool) {
+	parts := strings.Split(version, ".")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil || major < 0 {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+func minKernelVersion(policy *devopsv1.PreflightPolicy) (int, int) {
+	if policy != nil && policy.MinKernelVersion != "" {
+		if major, minor, ok := ParseKernelVersion(policy.MinKernelVersion); ok {
+			return major, minor
+		}
+	}
+	return defaultMinKernelMajor, defaultMinKernelMinor
+}
+
+func minCPU(policy *devopsv1.PreflightPolicy) int {
+	if policy != nil && policy.MinCPU != nil {
+		return int(*policy.MinCPU)
+	}
+	return 1
+}
+
+func minMemory(policy *devopsv1.PreflightPolicy, def uint64) uint64 {
+	if policy != nil && policy.MinMemory != nil {
+		return uint64(policy.MinMemory.Value())
+	}
+	return def
+}
+
+func minDiskGiB(policy *devopsv1.PreflightPolicy) int {
+	if policy != nil && policy.MinDisk != nil {
+		return int(policy.MinDisk.Value() >> 30)
+	}
+	return MinDiskGiB
+}
+
+func maxClockSkew(policy *devopsv1.PreflightPolicy) time.Duration {
+	if policy != nil && policy.MaxClockSkew != nil {
+		return policy.MaxClockSkew.Duration
+	}
+	return MaxClockSkew
+}
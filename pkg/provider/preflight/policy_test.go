@@ -0,0 +1,98 @@
+package preflight
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/util/pointer"
+	"github.com/wtxue/kok-operator/pkg/util/ssh/fake"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func checkNames(checks []Checker) []string {
+	var names []string
+	for _, c := range checks {
+		names = append(names, c.Name())
+	}
+	return names
+}
+
+func TestRunMasterChecksPolicy(t *testing.T) {
+	s := newHost("10.0.0.1", "master", "00:00:00:00:00:01", time.Now(),
+		fake.Result{Match: "getconf", Stdout: "2\n"},
+		fake.Result{Match: "which ipvsadm", Exit: 1})
+	ctx := &common.ClusterContext{Logger: logr.Discard(), Cluster: &devopsv1.Cluster{}}
+	if err := RunMasterChecks(ctx, s); err != nil {
+		t.Fatalf("expect the warning of ipvsadm not failed: %v", err)
+	}
+
+	mem := resource.MustParse("16Gi")
+	ctx.Cluster.Spec.Preflight = &devopsv1.PreflightPolicy{
+		Disable:          []string{"swap"},
+		Enable:           []string{"Port-2380"},
+		MinCPU:           pointer.ToInt32(4),
+		MinMemory:        &mem,
+		MinKernelVersion: "5.4",
+	}
+	checks := NewMasterChecks(s, ctx.Cluster.Spec.Preflight)
+	names := strings.Join(checkNames(checks), ",")
+	if strings.Contains(names, "Swap") || !strings.Contains(names, "Port-2380") || strings.Count(names, "Port-10256") > 1 {
+		t.Errorf("unexpected checks %s", names)
+	}
+
+	err := RunMasterChecks(ctx, s)
+	if err == nil || !strings.Contains(err.Error(), "NumCPU") || !strings.Contains(err.Error(), "Memory") {
+		t.Fatalf("expect NumCPU and Memory failed, got %v", err)
+	}
+
+	ctx.Cluster.Spec.Preflight.IgnoreErrors = []string{"NumCPU", "memory"}
+	if err := RunMasterChecks(ctx, s); err != nil {
+		t.Errorf("expect the errors ignored, got %v", err)
+	}
+	ctx.Cluster.Spec.Preflight.IgnoreErrors = []string{devopsv1.PreflightIgnoreAll}
+	ctx.Cluster.Spec.Preflight.MinKernelVersion = "6.0"
+	if err := RunMasterChecks(ctx, s); err != nil {
+		t.Errorf("expect all errors ignored, got %v", err)
+	}
+}
+
+func TestRunPolicy(t *testing.T) {
+	ctx := &common.ClusterContext{
+		Ctx:    context.Background(),
+		Logger: logr.Discard(),
+		Cluster: &devopsv1.Cluster{Spec: devopsv1.ClusterSpec{
+			Preflight: &devopsv1.PreflightPolicy{MaxClockSkew: &metav1.Duration{Duration: 2 * time.Minute}},
+		}},
+	}
+
+	now := time.Now()
+	hosts := []Host{
+		{Interface: newHost("10.0.0.1", "master1", "00:00:00:00:00:01", now), Role: RoleMaster, Policy: ctx.Cluster.Spec.Preflight},
+		{Interface: newHost("10.0.0.2", "master2", "00:00:00:00:00:02", now.Add(-time.Minute)), Role: RoleMaster, Policy: ctx.Cluster.Spec.Preflight},
+		{Interface: newHost("10.0.0.3", "node1", "00:00:00:00:00:02", now, fake.Result{Match: "MemTotal", Stdout: "512000\n"}), Role: RoleNode,
+			Policy: &devopsv1.PreflightPolicy{IgnoreErrors: []string{"Memory", "MAC"}, Disable: []string{"Reachability"}}},
+	}
+	report := Run(ctx, hosts, 2)
+	if c := findCheck(report.Hosts[1], "ClockSkew"); c.Status != devopsv1.PreflightPassed {
+		t.Errorf("expect the skew within 2m, got %+v", c)
+	}
+	if c := findCheck(report.Hosts[1], "MAC"); c.Status != devopsv1.PreflightFailed {
+		t.Errorf("expect the duplicated mac of master failed, got %+v", c)
+	}
+	node := report.Hosts[2]
+	if findCheck(node, "Memory").Status != devopsv1.PreflightWarning || findCheck(node, "MAC").Status != devopsv1.PreflightWarning {
+		t.Errorf("expect the errors of node ignored, got %+v", node)
+	}
+	if findCheck(node, "Reachability").Name != "" {
+		t.Errorf("expect reachability of node disabled")
+	}
+	if node.Status != devopsv1.PreflightWarning {
+		t.Errorf("expect node warning, got %s", node.Status)
+	}
+}
@@ -0,0 +1,389 @@
+package preflight
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/constants"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// the roles of the hosts
+const (
+	RoleMaster = "master"
+	RoleNode   = "node"
+)
+
+// MaxClockSkew is the default max clock offset of a host to the others.
+const MaxClockSkew = 5 * time.Second
+
+// probeTimeout is the seconds to connect the ports of the other hosts.
+const probeTimeout = 3
+
+// fixedByInstall are the checks of the state set by system init, they're warnings before install.
+var fixedByInstall = map[string]bool{
+	SwapCheck{}.Name():                         true,
+	FileContentCheck{Path: ipv4Forward}.Name(): true,
+}
+
+// the interfaces created by kubernetes and cni, their addresses are in the cluster cidrs
+var k8sInterfacePrefixes = []string{"cni", "flannel", "cali", "tunl", "vxlan", "cilium", "kube-ipvs", "nodelocaldns", "docker", "veth"}
+
+// Host is a host checked by Run.
+type Host struct {
+	ssh.Interface
+	Role   string
+	Policy *devopsv1.PreflightPolicy
+}
+
+// hostFacts are the facts of the host compared with the others.
+type hostFacts struct {
+	ip       string
+	offset   time.Duration
+	hostname string
+	mac      string
+	networks []*net.IPNet
+	errs     map[string]error
+}
+
+// Run runs the checks of each host and the checks between the hosts, nothing is changed on the
+// hosts. The checks fixed by system init and the ones ignored by the policy of host are warnings.
+func Run(ctx *common.ClusterContext, hosts []Host, concurrency int) *devopsv1.PreflightReport {
+	results := make([]devopsv1.HostPreflightResult, len(hosts))
+	facts := make([]*hostFacts, len(hosts))
+	workqueue.ParallelizeUntil(ctx.Ctx, concurrency, len(hosts), func(i int) {
+		h := hosts[i]
+		results[i] = devopsv1.HostPreflightResult{IP: h.HostIP(), Role: h.Role}
+		if err := h.Ping(); err != nil {
+			results[i].Checks = append(results[i].Checks, errorResult("SSH", err))
+			return
+		}
+
+		checks := NewNodeChecks(h.Interface, h.Policy)
+		if h.Role == RoleMaster {
+			checks = NewMasterChecks(h.Interface, h.Policy)
+		}
+		for _, c := range checks {
+			r := checkResult(c)
+			if fixedByInstall[c.Name()] && r.Status == devopsv1.PreflightFailed {
+				r.Status = devopsv1.PreflightWarning
+			}
+			results[i].Checks = append(results[i].Checks, r)
+		}
+
+		facts[i] = detectFacts(h)
+		if !IsDisabled(h.Policy, "Reachability") {
+			results[i].Checks = append(results[i].Checks, reachabilityResult(h, hosts))
+		}
+		results[i].Checks = append(results[i].Checks, cidrResult(ctx.Cluster, facts[i]))
+	})
+
+	for i, r := range clockSkewResults(facts, maxClockSkew(ctx.Cluster.Spec.Preflight)) {
+		results[i].Checks = append(results[i].Checks, r)
+	}
+	for i, r := range duplicateResults("Hostname", facts, func(f *hostFacts) string { return f.hostname }) {
+		results[i].Checks = append(results[i].Checks, r)
+	}
+	for i, r := range duplicateResults("MAC", facts, func(f *hostFacts) string { return f.mac }) {
+		results[i].Checks = append(results[i].Checks, r)
+	}
+
+	report := &devopsv1.PreflightReport{Time: metav1.Now(), Status: devopsv1.PreflightPassed, Hosts: results}
+	for i := range report.Hosts {
+		h := &report.Hosts[i]
+		h.Status = devopsv1.PreflightPassed
+		checks := h.Checks[:0]
+		for _, c := range h.Checks {
+			if IsDisabled(hosts[i].Policy, c.Name) {
+				continue
+			}
+			if c.Status == devopsv1.PreflightFailed && IsIgnored(hosts[i].Policy, c.Name) {
+				c.Status = devopsv1.PreflightWarning
+			}
+			checks = append(checks, c)
+			h.Status = worse(h.Status, c.Status)
+		}
+		h.Checks = checks
+		report.Status = worse(report.Status, h.Status)
+	}
+
+	ctx.Info("preflight finished", "hosts", len(hosts), "status", report.Status)
+	return report
+}
+
+func worse(a, b devopsv1.PreflightCheckStatus) devopsv1.PreflightCheckStatus {
+	rank := map[devopsv1.PreflightCheckStatus]int{
+		devopsv1.PreflightPassed:  0,
+		devopsv1.PreflightWarning: 1,
+		devopsv1.PreflightFailed:  2,
+	}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+// checkResult runs the check.
+func checkResult(c Checker) devopsv1.PreflightCheckResult {
+	warnings, errs := c.Check()
+	r := devopsv1.PreflightCheckResult{Name: c.Name(), Status: devopsv1.PreflightPassed}
+	for _, w := range warnings {
+		r.Status = devopsv1.PreflightWarning
+		r.Messages = append(r.Messages, w.Error())
+	}
+	for _, err := range errs {
+		r.Status = devopsv1.PreflightFailed
+		r.Messages = append(r.Messages, err.Error())
+	}
+
+	return r
+}
+
+func errorResult(name string, errs ...error) devopsv1.PreflightCheckResult {
+	r := devopsv1.PreflightCheckResult{Name: name, Status: devopsv1.PreflightPassed}
+	for _, err := range errs {
+		r.Status = devopsv1.PreflightFailed
+		r.Messages = append(r.Messages, err.Error())
+	}
+
+	return r
+}
+
+// detectFacts detects the clock, hostname, mac and networks of the host.
+func detectFacts(h Host) *hostFacts {
+	f := &hostFacts{ip: h.HostIP(), errs: make(map[string]error)}
+
+	before := time.Now()
+	ts, err := ssh.Timestamp(h)
+	if err != nil {
+		f.errs["ClockSkew"] = errors.Wrap(err, "get timestamp")
+	} else {
+		mid := before.Add(time.Since(before) / 2)
+		f.offset = time.Unix(int64(ts), 0).Sub(mid)
+	}
+
+	out, err := h.CombinedOutput("hostname")
+	if err != nil {
+		f.errs["Hostname"] = errors.Wrap(err, "get hostname")
+	}
+	f.hostname = strings.ToLower(strings.TrimSpace(string(out)))
+
+	out, err = h.CombinedOutput("ip -o -4 addr show scope global")
+	if err != nil {
+		f.errs["MAC"] = errors.Wrap(err, "get addresses")
+		f.errs["CIDR"] = f.errs["MAC"]
+		return f
+	}
+	var iface string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[2] != "inet" || isK8sInterface(fields[1]) {
+			continue
+		}
+		ip, network, err := net.ParseCIDR(fields[3])
+		if err != nil {
+			continue
+		}
+		f.networks = append(f.networks, network)
+		if ip.String() == h.HostIP() {
+			iface = fields[1]
+		}
+	}
+	if iface == "" {
+		f.errs["MAC"] = errors.Errorf("no interface of %s", h.HostIP())
+		return f
+	}
+	out, err = h.CombinedOutput(fmt.Sprintf("cat /sys/class/net/%s/address", iface))
+	if err != nil {
+		f.errs["MAC"] = errors.Wrapf(err, "get mac of %s", iface)
+	}
+	f.mac = strings.ToLower(strings.TrimSpace(string(out)))
+
+	return f
+}
+
+func isK8sInterface(name string) bool {
+	for _, prefix := range k8sInterfacePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// reachabilityResult checks the ports of the other hosts used by the host can be connected, the
+// refused connection is reachable since nothing listens before install.
+func reachabilityResult(h Host, hosts []Host) devopsv1.PreflightCheckResult {
+	var errs []error
+	for _, other := range hosts {
+		if other.HostIP() == h.HostIP() {
+			continue
+		}
+
+		var ports []int
+		if other.Role == RoleMaster {
+			ports = append(ports, 6443)
+			if h.Role == RoleMaster {
+				ports = append(ports, constants.EtcdListenClientPort)
+			}
+		}
+		if h.Role == RoleMaster {
+			ports = append(ports, constants.KubeletPort)
+		}
+
+		for _, port := range ports {
+			cmd := fmt.Sprintf("timeout %d bash -c '</dev/tcp/%s/%d'", probeTimeout, other.HostIP(), port)
+			stdout, stderr, exit, err := h.Exec(cmd)
+			if err != nil {
+				errs = append(errs, errors.Wrapf(err, "connect %s:%d", other.HostIP(), port))
+			} else if exit != 0 && !strings.Contains(stdout+stderr, "refused") {
+				errs = append(errs, errors.Errorf("%s:%d is unreachable: exit %d %s", other.HostIP(), port, exit, strings.TrimSpace(stdout+stderr)))
+			}
+		}
+	}
+
+	return errorResult("Reachability", errs...)
+}
+
+// cidrResult checks the cluster and service cidr don't overlap the networks of the host.
+func cidrResult(c *devopsv1.Cluster, f *hostFacts) devopsv1.PreflightCheckResult {
+	if err := f.errs["CIDR"]; err != nil {
+		return errorResult("CIDR", err)
+	}
+
+	cidrs := map[string]string{"clusterCIDR": c.Spec.ClusterCIDR}
+	if c.Spec.ServiceCIDR != nil {
+		cidrs["serviceCIDR"] = *c.Spec.ServiceCIDR
+	}
+
+	var errs []error
+	for _, name := range []string{"clusterCIDR", "serviceCIDR"} {
+		if cidrs[name] == "" {
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(cidrs[name])
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "parse %s", name))
+			continue
+		}
+		for _, network := range f.networks {
+			if cidr.Contains(network.IP) || network.Contains(cidr.IP) {
+				errs = append(errs, errors.Errorf("%s %s overlaps the host network %s", name, cidr, network))
+			}
+		}
+	}
+
+	return errorResult("CIDR", errs...)
+}
+
+// clockSkewResults checks the clock offset of each host to the median of the hosts.
+func clockSkewResults(facts []*hostFacts, maxSkew time.Duration) map[int]devopsv1.PreflightCheckResult {
+	var offsets []time.Duration
+	for _, f := range facts {
+		if f != nil && f.errs["ClockSkew"] == nil {
+			offsets = append(offsets, f.offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+
+	results := make(map[int]devopsv1.PreflightCheckResult)
+	for i, f := range facts {
+		if f == nil {
+			continue
+		}
+		if err := f.errs["ClockSkew"]; err != nil {
+			results[i] = errorResult("ClockSkew", err)
+			continue
+		}
+
+		skew := f.offset - offsets[len(offsets)/2]
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > maxSkew {
+			results[i] = errorResult("ClockSkew", errors.Errorf("the clock is %s off the other hosts, max %s", skew, maxSkew))
+		} else {
+			results[i] = errorResult("ClockSkew")
+		}
+	}
+
+	return results
+}
+
+// duplicateResults checks the key of each host is unique.
+func duplicateResults(name string, facts []*hostFacts, key func(f *hostFacts) string) map[int]devopsv1.PreflightCheckResult {
+	indexes := make(map[string][]int)
+	for i, f := range facts {
+		if f != nil && f.errs[name] == nil && key(f) != "" {
+			indexes[key(f)] = append(indexes[key(f)], i)
+		}
+	}
+
+	results := make(map[int]devopsv1.PreflightCheckResult)
+	for i, f := range facts {
+		if f == nil {
+			continue
+		}
+		if err := f.errs[name]; err != nil {
+			results[i] = errorResult(name, err)
+			continue
+		}
+
+		if same := indexes[key(f)]; len(same) > 1 {
+			var others []string
+			for _, j := range same {
+				if j != i {
+					others = append(others, facts[j].ip)
+				}
+			}
+			results[i] = errorResult(name, errors.Errorf("%s %s is duplicated with %v", strings.ToLower(name), key(f), others))
+		} else {
+			results[i] = errorResult(name)
+		}
+	}
+
+	return results
+}
+
+// ClusterHosts returns the masters and the machines of the cluster.
+func ClusterHosts(ctx *common.ClusterContext) ([]Host, error) {
+	var hosts []Host
+	for _, m := range ctx.Cluster.Spec.Machines {
+		s, err := m.SSH()
+		if err != nil {
+			return nil, errors.Wrapf(err, "master %s", m.IP)
+		}
+		hosts = append(hosts, Host{Interface: s, Role: RoleMaster, Policy: ctx.Cluster.Spec.Preflight})
+	}
+
+	ms := &devopsv1.MachineList{}
+	err := ctx.Client.List(ctx.Ctx, ms, client.InNamespace(ctx.Cluster.Namespace))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed list machine")
+	}
+	secrets := common.SecretGetter(ctx.Ctx, ctx.Client, ctx.Cluster.Namespace)
+	for i := range ms.Items {
+		m := &ms.Items[i]
+		if m.Spec.ClusterName != ctx.Cluster.Name || m.Spec.Machine == nil {
+			continue
+		}
+
+		m.Spec.Machine.SetSSHOptions(ctx.Cluster.Spec.SSH, secrets)
+		s, err := m.Spec.SSH()
+		if err != nil {
+			return nil, errors.Wrapf(err, "machine %s", m.Name)
+		}
+		hosts = append(hosts, Host{Interface: s, Role: RoleNode, Policy: MachinePolicy(ctx.Cluster, m)})
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,107 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/util/ssh/fake"
+)
+
+// newHost returns a fake host passing the checks of itself, results are matched first.
+func newHost(ip, hostname, mac string, clock time.Time, results ...fake.Result) *fake.SSH {
+	results = append(results,
+		fake.Result{Match: "id -u", Stdout: "0\n"},
+		fake.Result{Match: "uname -m", Stdout: "x86_64\n"},
+		fake.Result{Match: "uname -r", Stdout: "5.10.0-18-amd64\n"},
+		fake.Result{Match: "getconf", Stdout: "4\n"},
+		fake.Result{Match: "MemTotal", Stdout: "8000000\n"},
+		fake.Result{Match: "df -BG", Stdout: "50\n"},
+		fake.Result{Match: "/proc/swaps", Stdout: "Filename\tType\tSize\tUsed\tPriority\n"},
+		fake.Result{Match: "ss -tl", Exit: 1},
+		fake.Result{Match: "date +%s", Stdout: fmt.Sprintf("%d\n", clock.Unix())},
+		fake.Result{Match: "hostname", Stdout: hostname + "\n"},
+		fake.Result{Match: "ip -o -4 addr", Stdout: fmt.Sprintf("2: eth0    inet %s/24 brd 10.0.0.255 scope global eth0\\       valid_lft forever\n", ip)},
+		fake.Result{Match: "/sys/class/net/eth0/address", Stdout: mac + "\n"},
+	)
+	s := fake.New(ip, results...)
+	s.WriteFile(strings.NewReader("1\n"), ipv4Forward)
+	return s
+}
+
+func findCheck(r devopsv1.HostPreflightResult, name string) devopsv1.PreflightCheckResult {
+	for _, c := range r.Checks {
+		if c.Name == name {
+			return c
+		}
+	}
+	return devopsv1.PreflightCheckResult{}
+}
+
+func TestRun(t *testing.T) {
+	ctx := &common.ClusterContext{
+		Ctx:     context.Background(),
+		Logger:  logr.Discard(),
+		Cluster: &devopsv1.Cluster{Spec: devopsv1.ClusterSpec{ClusterCIDR: "10.244.0.0/16"}},
+	}
+
+	now := time.Now()
+	hosts := []Host{
+		{Interface: newHost("10.0.0.1", "master1", "00:00:00:00:00:01", now), Role: RoleMaster},
+		{Interface: newHost("10.0.0.2", "master2", "00:00:00:00:00:02", now), Role: RoleMaster},
+		{Interface: newHost("10.0.0.3", "node1", "00:00:00:00:00:03", now), Role: RoleNode},
+	}
+	report := Run(ctx, hosts, 2)
+	if report.Status != devopsv1.PreflightPassed {
+		t.Fatalf("expect passed, got %+v", report)
+	}
+	if len(report.Hosts) != 3 || report.Hosts[2].Role != RoleNode {
+		t.Fatalf("unexpected hosts %+v", report.Hosts)
+	}
+	for _, name := range []string{"Memory", "Disk", "Swap", "Reachability", "CIDR", "ClockSkew", "Hostname", "MAC"} {
+		if c := findCheck(report.Hosts[0], name); c.Status != devopsv1.PreflightPassed {
+			t.Errorf("expect %s passed, got %+v", name, c)
+		}
+	}
+
+	hosts = []Host{
+		{Interface: newHost("10.0.0.1", "master", "00:00:00:00:00:01", now,
+			fake.Result{Match: "/dev/tcp/10.0.0.2/6443", Exit: 124},
+			fake.Result{Match: "/proc/swaps", Stdout: "Filename\n/dev/dm-1 partition 8388604 0 -2\n"}), Role: RoleMaster},
+		{Interface: newHost("10.0.0.2", "master", "00:00:00:00:00:01", now.Add(-time.Minute),
+			fake.Result{Match: "/dev/tcp/10.0.0.1/6443", Exit: 1, Stderr: "bash: connect: Connection refused"}), Role: RoleMaster},
+		{Interface: newHost("10.244.1.10", "node", "00:00:00:00:00:03", now,
+			fake.Result{Match: "MemTotal", Stdout: "512000\n"}), Role: RoleNode},
+	}
+	report = Run(ctx, hosts, 2)
+	if report.Status != devopsv1.PreflightFailed {
+		t.Fatalf("expect failed, got %+v", report)
+	}
+
+	expects := []struct {
+		host   int
+		name   string
+		status devopsv1.PreflightCheckStatus
+	}{
+		{0, "Reachability", devopsv1.PreflightFailed},
+		{0, "Swap", devopsv1.PreflightWarning},
+		{0, "Hostname", devopsv1.PreflightFailed},
+		{0, "MAC", devopsv1.PreflightFailed},
+		{0, "ClockSkew", devopsv1.PreflightPassed},
+		{1, "Reachability", devopsv1.PreflightPassed},
+		{1, "ClockSkew", devopsv1.PreflightFailed},
+		{2, "Memory", devopsv1.PreflightFailed},
+		{2, "CIDR", devopsv1.PreflightFailed},
+		{2, "Hostname", devopsv1.PreflightPassed},
+	}
+	for _, e := range expects {
+		if c := findCheck(report.Hosts[e.host], e.name); c.Status != e.status {
+			t.Errorf("expect %s of %s %s, got %+v", e.name, report.Hosts[e.host].IP, e.status, c)
+		}
+	}
+}
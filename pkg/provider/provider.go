@@ -1,6 +1,7 @@
 package provider
 
 import (
+	"github.com/wtxue/kok-operator/pkg/provider/artifact"
 	baremetalcluster "github.com/wtxue/kok-operator/pkg/provider/baremetal/cluster"
 	baremetalmachine "github.com/wtxue/kok-operator/pkg/provider/baremetal/machine"
 	"github.com/wtxue/kok-operator/pkg/provider/cluster"
@@ -22,6 +23,12 @@ var AddToCpManagerFuncs []func(*clusterprovider.CpManager, *config.Config) error
 var AddToMpManagerFuncs []func(*machineprovider.MpManager, *config.Config) error
 
 func NewProvider(config *config.Config) (*ProviderManager, error) {
+	repo, err := artifact.New(config.ArtifactSource, config.ArtifactCacheDir, config.ArtifactSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	artifact.SetDefault(repo)
+
 	AddToCpManagerFuncs = append(AddToCpManagerFuncs, baremetalcluster.Add)
 	AddToCpManagerFuncs = append(AddToCpManagerFuncs, managedcluster.Add)
 
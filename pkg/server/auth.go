@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// scopeAll grants the access to the clusters of all namespaces.
+const scopeAll = "*"
+
+type identityKey struct{}
+
+// identity is the authenticated user and the scopes it can access, a scope is a namespace or
+// namespace/cluster, and * means all the clusters.
+type identity struct {
+	user   string
+	scopes []string
+}
+
+// allowNamespace returns true if any cluster of the namespace can be accessed.
+func (i *identity) allowNamespace(namespace string) bool {
+	for _, scope := range i.scopes {
+		if scope == scopeAll || scope == namespace || strings.HasPrefix(scope, namespace+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// allowCluster returns true if the cluster can be accessed.
+func (i *identity) allowCluster(namespace, name string) bool {
+	for _, scope := range i.scopes {
+		if scope == scopeAll || scope == namespace || scope == namespace+"/"+name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadTokenFile reads the csv of token,user[,scope...], the empty lines and the lines start with #
+// are ignored. The token without scope can access nothing.
+func loadTokenFile(file string) (map[string]*identity, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "open token file")
+	}
+	defer f.Close()
+
+	tokens := make(map[string]*identity)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, ",")
+		if len(fields) < 2 || strings.TrimSpace(fields[0]) == "" || strings.TrimSpace(fields[1]) == "" {
+			return nil, errors.Errorf("token file %s line %d: expect token,user[,scope...]", file, line)
+		}
+
+		id := &identity{user: strings.TrimSpace(fields[1])}
+		for _, scope := range fields[2:] {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				id.scopes = append(id.scopes, scope)
+			}
+		}
+		if len(id.scopes) == 0 {
+			logger.Info("the token has no scope and can access nothing", "user", id.user, "line", line)
+		}
+		tokens[strings.TrimSpace(fields[0])] = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read token file")
+	}
+	if len(tokens) == 0 {
+		return nil, errors.Errorf("no token found in %s", file)
+	}
+
+	return tokens, nil
+}
+
+// authenticate returns the identity of the verified client cert or the bearer token, the scopes of
+// the client cert are its organizations.
+func (s *Server) authenticate(req *http.Request) (*identity, bool) {
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.PeerCertificates) > 0 {
+		subject := req.TLS.PeerCertificates[0].Subject
+		return &identity{user: subject.CommonName, scopes: subject.Organization}, true
+	}
+
+	auth := strings.TrimSpace(req.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return nil, false
+	}
+
+	token := strings.TrimSpace(parts[1])
+	if token == "" {
+		return nil, false
+	}
+
+	var found *identity
+	for t, id := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = id
+		}
+	}
+	return found, found != nil
+}
+
+func (s *Server) withAuthentication(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		id, ok := s.authenticate(req)
+		if !ok {
+			resp.Header().Set("WWW-Authenticate", `Bearer realm="kok-operator"`)
+			http.Error(resp, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		logger.V(4).Info("request", "user", id.user, "method", req.Method, "path", req.URL.Path)
+		handler.ServeHTTP(resp, req.WithContext(context.WithValue(req.Context(), identityKey{}, id)))
+	})
+}
+
+// identityFrom returns the identity of the request, the unauthenticated one can access nothing.
+func identityFrom(req *http.Request) *identity {
+	if id, ok := req.Context().Value(identityKey{}).(*identity); ok {
+		return id
+	}
+
+	return &identity{}
+}
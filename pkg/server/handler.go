@@ -0,0 +1,288 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/provider/phases/certs"
+	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	apiPrefix           = "/apis/v1"
+	namespacesPrefix    = apiPrefix + "/namespaces/"
+	onKubeAdminKubeconf = "/etc/kubernetes/admin.conf"
+
+	roleMaster = "master"
+	roleWorker = "worker"
+)
+
+// ClusterInfo is the summary of a cluster.
+type ClusterInfo struct {
+	Name              string                `json:"name"`
+	Namespace         string                `json:"namespace"`
+	ClusterType       string                `json:"clusterType,omitempty"`
+	Version           string                `json:"version,omitempty"`
+	Phase             devopsv1.ClusterPhase `json:"phase,omitempty"`
+	CreationTimestamp metav1.Time           `json:"creationTimestamp"`
+}
+
+// ClusterStatusInfo is the summary and the status of a cluster.
+type ClusterStatusInfo struct {
+	ClusterInfo `json:",inline"`
+	Status      devopsv1.ClusterStatus `json:"status"`
+}
+
+// NodeInfo is a node of the cluster inventory, the ssh credentials are never returned.
+type NodeInfo struct {
+	Name        string                      `json:"name"`
+	Role        string                      `json:"role"`
+	IP          string                      `json:"ip"`
+	Labels      map[string]string           `json:"labels,omitempty"`
+	Phase       string                      `json:"phase,omitempty"`
+	Addresses   []devopsv1.MachineAddress   `json:"addresses,omitempty"`
+	MachineInfo *devopsv1.MachineSystemInfo `json:"machineInfo,omitempty"`
+}
+
+func (s *Server) registerHandler() {
+	s.mux.HandleFunc(apiPrefix+"/clusters", s.listClusters)
+	s.mux.HandlePrefix(namespacesPrefix, http.HandlerFunc(s.clusterResource))
+}
+
+func writeJSON(resp http.ResponseWriter, code int, obj interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(code)
+	if err := json.NewEncoder(resp).Encode(obj); err != nil {
+		logger.Error(err, "failed to write response")
+	}
+}
+
+func writeForbidden(resp http.ResponseWriter, id *identity, target string) {
+	writeJSON(resp, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("user %q can't access %s", id.user, target)})
+}
+
+func writeError(resp http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if apierrors.IsNotFound(err) {
+		code = http.StatusNotFound
+	}
+	writeJSON(resp, code, map[string]string{"error": err.Error()})
+}
+
+func clusterInfo(c *devopsv1.Cluster) ClusterInfo {
+	return ClusterInfo{
+		Name:              c.Name,
+		Namespace:         c.Namespace,
+		ClusterType:       c.Spec.ClusterType,
+		Version:           c.Status.Version,
+		Phase:             c.Status.Phase,
+		CreationTimestamp: c.CreationTimestamp,
+	}
+}
+
+// listClusters serves GET /apis/v1/clusters?namespace=xxx, only the clusters in the scopes of user
+// are listed.
+func (s *Server) listClusters(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(resp, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := identityFrom(req)
+	namespace := req.URL.Query().Get("namespace")
+	if namespace != "" && !id.allowNamespace(namespace) {
+		writeForbidden(resp, id, "namespace "+namespace)
+		return
+	}
+
+	clusters := &devopsv1.ClusterList{}
+	err := s.cli.List(req.Context(), clusters, client.InNamespace(namespace))
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+
+	list := make([]ClusterInfo, 0, len(clusters.Items))
+	for i := range clusters.Items {
+		if id.allowCluster(clusters.Items[i].Namespace, clusters.Items[i].Name) {
+			list = append(list, clusterInfo(&clusters.Items[i]))
+		}
+	}
+	writeJSON(resp, http.StatusOK, list)
+}
+
+// clusterResource serves GET /apis/v1/namespaces/{namespace}/clusters/{name}[/kubeconfig|/nodes]
+func (s *Server) clusterResource(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(resp, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, namespacesPrefix), "/"), "/")
+	if len(parts) < 3 || len(parts) > 4 || parts[1] != "clusters" || parts[0] == "" || parts[2] == "" {
+		http.NotFound(resp, req)
+		return
+	}
+
+	// checked before get, so the clusters out of scopes aren't disclosed by 404
+	if id := identityFrom(req); !id.allowCluster(parts[0], parts[2]) {
+		writeForbidden(resp, id, fmt.Sprintf("cluster %s/%s", parts[0], parts[2]))
+		return
+	}
+
+	cluster := &devopsv1.Cluster{}
+	err := s.cli.Get(req.Context(), types.NamespacedName{Namespace: parts[0], Name: parts[2]}, cluster)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+
+	if len(parts) == 3 {
+		writeJSON(resp, http.StatusOK, ClusterStatusInfo{
+			ClusterInfo: clusterInfo(cluster),
+			Status:      cluster.Status,
+		})
+		return
+	}
+
+	switch parts[3] {
+	case "kubeconfig":
+		s.kubeconfig(resp, req, cluster)
+	case "nodes":
+		s.nodes(resp, req, cluster)
+	default:
+		http.NotFound(resp, req)
+	}
+}
+
+// kubeconfig returns an admin kubeconfig which expires after the ttl query, default and max is --api-kubeconfig-ttl.
+func (s *Server) kubeconfig(resp http.ResponseWriter, req *http.Request, cluster *devopsv1.Cluster) {
+	ttl := s.opt.KubeconfigTTL
+	if v := req.URL.Query().Get("ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			http.Error(resp, fmt.Sprintf("invalid ttl %q", v), http.StatusBadRequest)
+			return
+		}
+		if d < ttl {
+			ttl = d
+		}
+	}
+
+	if cluster.Status.Phase != devopsv1.ClusterRunning && cluster.Status.Phase != devopsv1.ClusterUpgrading {
+		writeJSON(resp, http.StatusConflict, map[string]string{"error": fmt.Sprintf("cluster is %s", cluster.Status.Phase)})
+		return
+	}
+
+	credential := &devopsv1.ClusterCredential{}
+	err := s.cli.Get(req.Context(), types.NamespacedName{Namespace: cluster.Namespace, Name: cluster.Name}, credential)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+
+	server, err := s.apiserverEndpoint(credential)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+
+	user := fmt.Sprintf("kok-operator:%s", identityFrom(req).user)
+	cfg, err := certs.CreateShortLivedAdminKubeConfig(credential.CAKey, credential.CACert, server, cluster.Name, user, ttl)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+
+	data, err := clientcmd.Write(*cfg)
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+
+	logger.Info("issue admin kubeconfig", "cluster", cluster.Name, "namespace", cluster.Namespace, "user", user, "ttl", ttl.String())
+	resp.Header().Set("Content-Type", "application/yaml")
+	resp.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.kubeconfig", cluster.Name))
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Write(data)
+}
+
+// apiserverEndpoint returns the server of the admin kubeconfig which is used by the operator too.
+func (s *Server) apiserverEndpoint(credential *devopsv1.ClusterCredential) (string, error) {
+	adminKey := onKubeAdminKubeconf
+	if !s.gMgr.EnableOnKube {
+		adminKey = pkiutil.ExternalAdminKubeConfigFileName
+	}
+
+	data, ok := credential.KubeData[adminKey]
+	if !ok {
+		return "", errors.Errorf("can't find kubeconfig %s", adminKey)
+	}
+
+	cfg, err := clientcmd.Load([]byte(data))
+	if err != nil {
+		return "", errors.Wrapf(err, "load kubeconfig %s", adminKey)
+	}
+
+	if kubeCtx, ok := cfg.Contexts[cfg.CurrentContext]; ok {
+		if c, ok := cfg.Clusters[kubeCtx.Cluster]; ok {
+			return c.Server, nil
+		}
+	}
+	for _, c := range cfg.Clusters {
+		return c.Server, nil
+	}
+	return "", errors.Errorf("no server found in kubeconfig %s", adminKey)
+}
+
+// nodes returns the masters of spec and the machines of the cluster.
+func (s *Server) nodes(resp http.ResponseWriter, req *http.Request, cluster *devopsv1.Cluster) {
+	nodes := make([]NodeInfo, 0, len(cluster.Spec.Machines))
+	for _, m := range cluster.Spec.Machines {
+		nodes = append(nodes, NodeInfo{
+			Name:   m.IP,
+			Role:   roleMaster,
+			IP:     m.IP,
+			Labels: m.Labels,
+		})
+	}
+
+	machines := &devopsv1.MachineList{}
+	err := s.cli.List(req.Context(), machines, client.InNamespace(cluster.Namespace))
+	if err != nil {
+		writeError(resp, err)
+		return
+	}
+
+	for i := range machines.Items {
+		m := &machines.Items[i]
+		if m.Spec.ClusterName != cluster.Name {
+			continue
+		}
+
+		node := NodeInfo{
+			Name:        m.Name,
+			Role:        roleWorker,
+			Phase:       string(m.Status.Phase),
+			Addresses:   m.Status.Addresses,
+			MachineInfo: &m.Status.MachineInfo,
+		}
+		if m.Spec.Machine != nil {
+			node.IP = m.Spec.Machine.IP
+			node.Labels = m.Spec.Machine.Labels
+		}
+		nodes = append(nodes, node)
+	}
+
+	writeJSON(resp, http.StatusOK, nodes)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wtxue/kok-operator/pkg/gmanager"
+	"github.com/wtxue/kok-operator/pkg/option"
+	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
+	"k8s.io/apiserver/pkg/server/mux"
+	certutil "k8s.io/client-go/util/cert"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	logger = logf.Log.WithName("server")
+)
+
+const (
+	shutdownTimeout = 10 * time.Second
+)
+
+// Server is the https api of operator, it serves the provider handlers and
+// the read-only views of clusters for the clients without access to the crds.
+type Server struct {
+	opt    *option.ServerOption
+	cli    client.Client
+	gMgr   *gmanager.GManager
+	mux    *mux.PathRecorderMux
+	tokens map[string]*identity
+}
+
+// New returns the api server, the tokens or the client ca is required.
+func New(opt *option.ServerOption, cli client.Client, gMgr *gmanager.GManager) (*Server, error) {
+	if opt.TokenFile == "" && opt.ClientCAFile == "" {
+		return nil, errors.New("the operator api requires --api-token-file or --api-client-ca-file")
+	}
+	if (opt.TLSCertFile == "") != (opt.TLSKeyFile == "") {
+		return nil, errors.New("--api-tls-cert-file and --api-tls-key-file must be set together")
+	}
+
+	s := &Server{
+		opt:  opt,
+		cli:  cli,
+		gMgr: gMgr,
+		mux:  mux.NewPathRecorderMux("kok-operator"),
+	}
+
+	if opt.TokenFile != "" {
+		tokens, err := loadTokenFile(opt.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		s.tokens = tokens
+	}
+
+	gMgr.CpManager.RegisterHandler(s.mux)
+	s.registerHandler()
+	return s, nil
+}
+
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if s.opt.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.opt.TLSCertFile, s.opt.TLSKeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load serving cert")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	} else {
+		cert, err := selfSignedCert()
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("no serving cert is specified, use a self-signed cert")
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+
+	if s.opt.ClientCAFile != "" {
+		data, err := os.ReadFile(s.opt.ClientCAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read client ca")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no cert found in client ca file %s", s.opt.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return cfg, nil
+}
+
+func selfSignedCert() (*tls.Certificate, error) {
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName: "kok-operator-ca",
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create self-signed ca")
+	}
+
+	altNames := certutil.AltNames{
+		DNSNames: []string{"localhost"},
+		IPs:      []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		altNames.DNSNames = append(altNames.DNSNames, hostname)
+	}
+
+	cert, key, err := pkiutil.NewCertAndKey(caCert, caKey, &pkiutil.CertConfig{
+		Config: certutil.Config{
+			CommonName: "kok-operator",
+			AltNames:   altNames,
+			Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create self-signed cert")
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw, caCert.Raw},
+		PrivateKey:  key,
+	}, nil
+}
+
+// Start serves until the context is done.
+func (s *Server) Start(ctx context.Context) error {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              s.opt.BindAddress,
+		Handler:           s.withAuthentication(s.mux),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error(err, "failed to shutdown operator api")
+		}
+	}()
+
+	logger.Info("operator api start", "addr", s.opt.BindAddress)
+	err = srv.ListenAndServeTLS("", "")
+	if err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "serve operator api")
+	}
+
+	logger.Info("operator api stopped")
+	return nil
+}
+
+// NeedLeaderElection returns false, the api is read-only and served by every replica.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
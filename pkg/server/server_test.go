@@ -0,0 +1,254 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/gmanager"
+	"github.com/wtxue/kok-operator/pkg/k8sclient"
+	"github.com/wtxue/kok-operator/pkg/option"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	"github.com/wtxue/kok-operator/pkg/util/pkiutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apiserver/pkg/server/mux"
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "tokens.csv")
+	err := os.WriteFile(file, []byte("# token,user[,scope...]\nabc,portal,*\ndef,tenant,ns2,ns1/c2\n\nghi,nobody\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := loadTokenFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(&pkiutil.CertConfig{
+		Config: certutil.Config{CommonName: "kubernetes"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKeyData, err := keyutil.MarshalPrivateKeyToPEM(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cli := fake.NewClientBuilder().WithScheme(k8sclient.GetScheme()).WithObjects(
+		&devopsv1.ClusterCredential{
+			ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"},
+			CredentialInfo: devopsv1.CredentialInfo{
+				CACert: pkiutil.EncodeCertPEM(caCert),
+				CAKey:  caKeyData,
+				KubeData: map[string]string{
+					"/etc/kubernetes/admin.conf": "apiVersion: v1\nkind: Config\nclusters:\n- name: c1\n  cluster:\n    server: https://10.0.0.1:6443\n",
+				},
+			},
+		},
+		&devopsv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"},
+			Spec: devopsv1.ClusterSpec{
+				ClusterType: "Baremetal",
+				Machines:    []*devopsv1.ClusterMachine{{IP: "10.0.0.1", Password: "secret"}},
+			},
+			Status: devopsv1.ClusterStatus{Phase: devopsv1.ClusterRunning, Version: "v1.24.4"},
+		},
+		&devopsv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "ns1"},
+			Spec: devopsv1.MachineSpec{
+				ClusterName: "c1",
+				Machine:     &devopsv1.ClusterMachine{IP: "10.0.0.2", Password: "secret"},
+			},
+		},
+	).Build()
+
+	s := &Server{
+		opt:    option.DefaultServerOption(),
+		cli:    cli,
+		gMgr:   &gmanager.GManager{Config: config.NewDefaultConfig()},
+		mux:    mux.NewPathRecorderMux("test"),
+		tokens: tokens,
+	}
+	s.registerHandler()
+	return s
+}
+
+// serve sends the GET request of the bearer token to the authenticated handler.
+func serve(s *Server, token, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	s.withAuthentication(s.mux).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAuthentication(t *testing.T) {
+	s := newTestServer(t)
+	handler := s.withAuthentication(s.mux)
+
+	tests := []struct {
+		name   string
+		header string
+		code   int
+	}{
+		{name: "no token", code: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer abd", code: http.StatusUnauthorized},
+		{name: "basic auth", header: "Basic abc", code: http.StatusUnauthorized},
+		{name: "valid token", header: "Bearer abc", code: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/apis/v1/clusters", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("%s: expect code %d, got %d", tt.name, tt.code, rec.Code)
+		}
+	}
+}
+
+func TestClusterResource(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{path: "/apis/v1/clusters", code: http.StatusOK},
+		{path: "/apis/v1/namespaces/ns1/clusters/c1", code: http.StatusOK},
+		{path: "/apis/v1/namespaces/ns1/clusters/c1/nodes", code: http.StatusOK},
+		{path: "/apis/v1/namespaces/ns1/clusters/c2", code: http.StatusNotFound},
+		{path: "/apis/v1/namespaces/ns1/clusters/c1/unknown", code: http.StatusNotFound},
+		{path: "/apis/v1/namespaces/ns1/machines/m1", code: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := serve(s, "abc", tt.path)
+		if rec.Code != tt.code {
+			t.Errorf("%s: expect code %d, got %d", tt.path, tt.code, rec.Code)
+		}
+	}
+
+	rec := serve(s, "abc", "/apis/v1/namespaces/ns1/clusters/c1/nodes")
+	var nodes []map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &nodes); err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expect 2 nodes, got %d", len(nodes))
+	}
+	for _, n := range nodes {
+		if _, ok := n["password"]; ok {
+			t.Errorf("node %v should not contain ssh credentials", n["name"])
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/apis/v1/clusters", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	s.withAuthentication(s.mux).ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expect code %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestKubeconfig(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, "abc", "/apis/v1/namespaces/ns1/clusters/c1/kubeconfig?ttl=10m")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expect code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	cfg, err := clientcmd.Load(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Clusters["c1"] == nil || cfg.Clusters["c1"].Server != "https://10.0.0.1:6443" {
+		t.Fatalf("unexpected clusters %v", cfg.Clusters)
+	}
+
+	for _, auth := range cfg.AuthInfos {
+		certs, err := certutil.ParseCertsPEM(auth.ClientCertificateData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if certs[0].NotAfter.After(time.Now().Add(11 * time.Minute)) {
+			t.Errorf("expect the client cert expires in 10m, got %s", certs[0].NotAfter)
+		}
+	}
+
+	rec = serve(s, "abc", "/apis/v1/namespaces/ns1/clusters/c1/kubeconfig?ttl=abc")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expect code %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	s := newTestServer(t)
+
+	tests := []struct {
+		token string
+		path  string
+		code  int
+	}{
+		{token: "def", path: "/apis/v1/clusters?namespace=ns2", code: http.StatusOK},
+		{token: "def", path: "/apis/v1/clusters?namespace=ns1", code: http.StatusOK},
+		{token: "def", path: "/apis/v1/clusters?namespace=ns3", code: http.StatusForbidden},
+		{token: "def", path: "/apis/v1/namespaces/ns1/clusters/c1", code: http.StatusForbidden},
+		{token: "def", path: "/apis/v1/namespaces/ns1/clusters/c1/nodes", code: http.StatusForbidden},
+		{token: "def", path: "/apis/v1/namespaces/ns1/clusters/c1/kubeconfig", code: http.StatusForbidden},
+		{token: "def", path: "/apis/v1/namespaces/ns1/clusters/c2", code: http.StatusNotFound},
+		{token: "ghi", path: "/apis/v1/clusters?namespace=ns1", code: http.StatusForbidden},
+		{token: "ghi", path: "/apis/v1/namespaces/ns1/clusters/c1/kubeconfig", code: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		rec := serve(s, tt.token, tt.path)
+		if rec.Code != tt.code {
+			t.Errorf("%s %s: expect code %d, got %d", tt.token, tt.path, tt.code, rec.Code)
+		}
+	}
+
+	var list []ClusterInfo
+	if err := json.Unmarshal(serve(s, "def", "/apis/v1/clusters").Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Errorf("expect the clusters out of scopes filtered, got %v", list)
+	}
+
+	// the scopes of client cert are its organizations
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "portal", Organization: []string{"ns1/c1"}}}
+	for path, code := range map[string]int{
+		"/apis/v1/namespaces/ns1/clusters/c1/nodes": http.StatusOK,
+		"/apis/v1/namespaces/ns2/clusters/c1/nodes": http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}}
+		rec := httptest.NewRecorder()
+		s.withAuthentication(s.mux).ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Errorf("cert %s: expect code %d, got %d", path, code, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package apiclient
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/kubectl/pkg/drain"
+)
+
+const (
+	// DrainNodeTimeout specifies how long should wait for evicting all pods on the node before timing out
+	DrainNodeTimeout = 5 * time.Minute
+)
+
+func newDrainHelper(ctx context.Context, client clientset.Interface) *drain.Helper {
+	return &drain.Helper{
+		Ctx:                 ctx,
+		Client:              client,
+		Force:               true,
+		GracePeriodSeconds:  -1,
+		IgnoreAllDaemonSets: true,
+		DeleteEmptyDirData:  true,
+		Timeout:             DrainNodeTimeout,
+		Out:                 os.Stdout,
+		ErrOut:              os.Stderr,
+	}
+}
+
+// CordonNode mark node as unschedulable or schedulable
+func CordonNode(ctx context.Context, client clientset.Interface, nodeName string, desired bool) error {
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to get node %q", nodeName)
+	}
+
+	return drain.RunCordonOrUncordon(newDrainHelper(ctx, client), node, desired)
+}
+
+// DrainNode cordon the node and evict all pods except daemonSet pods on it
+func DrainNode(ctx context.Context, client clientset.Interface, nodeName string) error {
+	err := CordonNode(ctx, client, nodeName, true)
+	if err != nil {
+		return errors.Wrapf(err, "failed to cordon node %q", nodeName)
+	}
+
+	err = drain.RunNodeDrain(newDrainHelper(ctx, client), nodeName)
+	if err != nil {
+		return errors.Wrapf(err, "failed to drain node %q", nodeName)
+	}
+
+	return nil
+}
+
+// IsNodeReady returns true if the node ready condition is true
+func IsNodeReady(node *corev1.Node) bool {
+	for i := range node.Status.Conditions {
+		if node.Status.Conditions[i].Type == corev1.NodeReady {
+			return node.Status.Conditions[i].Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
@@ -16,6 +16,7 @@ import (
 	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	nodev1 "k8s.io/api/node/v1"
 	rbac "k8s.io/api/rbac/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -193,6 +194,20 @@ func CreateOrUpdateClusterRoleBinding(ctx context.Context, client clientset.Inte
 	return nil
 }
 
+// CreateOrUpdateRuntimeClass creates a RuntimeClass if the target resource doesn't exist. If the resource exists already, this function will update the resource instead.
+func CreateOrUpdateRuntimeClass(ctx context.Context, client clientset.Interface, rc *nodev1.RuntimeClass) error {
+	if _, err := client.NodeV1().RuntimeClasses().Create(ctx, rc, metav1.CreateOptions{}); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return errors.Wrap(err, "unable to create runtimeclass")
+		}
+
+		if _, err := client.NodeV1().RuntimeClasses().Update(ctx, rc, metav1.UpdateOptions{}); err != nil {
+			return errors.Wrap(err, "unable to update runtimeclass")
+		}
+	}
+	return nil
+}
+
 // PatchNodeOnce executes patchFn on the node object found by the node name.
 // This is a condition function meant to be used with wait.Poll. false, nil
 // implies it is safe to try again, an error indicates no more tries should be
@@ -4,8 +4,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"hash"
-	"io/ioutil"
+	"io"
 	"os"
+	"strings"
+
+	"github.com/pkg/errors"
 )
 
 func Sha256WithFile(filename string) (string, error) {
@@ -20,14 +23,27 @@ func SumWithFile(h hash.Hash, filename string) (string, error) {
 	}
 	defer f.Close()
 
-	data, err := ioutil.ReadAll(f)
-	if err != nil {
+	if _, err := io.Copy(h, f); err != nil {
 		return "", err
 	}
 
-	return Sum(h, data), nil
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 func Sum(h hash.Hash, data []byte) string {
-	return hex.EncodeToString(h.Sum(data))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// VerifySha256 returns error if the sha256 of the file isn't expected.
+func VerifySha256(filename, expected string) error {
+	sum, err := Sha256WithFile(filename)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(sum, expected) {
+		return errors.Errorf("%s sha256 mismatch: expected %s, got %s", filename, expected, sum)
+	}
+
+	return nil
 }
@@ -0,0 +1,122 @@
+package s3
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const defaultRegion = "us-east-1"
+
+// Client is the S3 compatible client with path-style url, only supports the object operations
+// needed by kok-operator.
+type Client struct {
+	s3       *s3.Client
+	uploader *manager.Uploader
+}
+
+// Config ...
+type Config struct {
+	Endpoint           string
+	Region             string
+	AccessKeyID        string
+	SecretAccessKey    string
+	InsecureSkipVerify bool
+}
+
+// New ...
+func New(c *Config) (*Client, error) {
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, fmt.Errorf("accessKeyID and secretAccessKey are required")
+	}
+
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %v", c.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: scheme must be http or https", c.Endpoint)
+	}
+
+	region := c.Region
+	if region == "" {
+		region = defaultRegion
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	client := s3.New(s3.Options{
+		Region:           region,
+		Credentials:      credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, ""),
+		EndpointResolver: s3.EndpointResolverFromURL(endpoint.String()),
+		UsePathStyle:     true,
+		HTTPClient:       &http.Client{Transport: transport},
+	})
+	return &Client{
+		s3:       client,
+		uploader: manager.NewUploader(client),
+	}, nil
+}
+
+// PutObject uploads the object, the body is streamed in multipart if it's larger than a part.
+func (c *Client) PutObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	if bucket == "" || key == "" {
+		return fmt.Errorf("bucket and key are required")
+	}
+
+	_, err := c.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	})
+	if err != nil {
+		return fmt.Errorf("put object %s/%s: %v", bucket, key, err)
+	}
+
+	return nil
+}
+
+// GetObject returns the body and size of the object, the caller must close the body.
+func (c *Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, int64, error) {
+	if bucket == "" || key == "" {
+		return nil, 0, fmt.Errorf("bucket and key are required")
+	}
+
+	out, err := c.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("get object %s/%s: %v", bucket, key, err)
+	}
+
+	return out.Body, out.ContentLength, nil
+}
+
+// DeleteObject deletes the object, it's not an error if the object does not exist.
+func (c *Client) DeleteObject(ctx context.Context, bucket, key string) error {
+	if bucket == "" || key == "" {
+		return fmt.Errorf("bucket and key are required")
+	}
+
+	_, err := c.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("delete object %s/%s: %v", bucket, key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package s3
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 serves the objects of path-style url and checks the requests are signed.
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if auth := req.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=ak/") {
+		f.t.Errorf("unexpected authorization %q", auth)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(req.Body)
+		f.objects[req.URL.Path] = data
+	case http.MethodGet:
+		data, ok := f.objects[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`<Error><Code>NoSuchKey</Code></Error>`))
+			return
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, req.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{t: t, objects: map[string][]byte{}})
+	defer server.Close()
+
+	if _, err := New(&Config{Endpoint: server.URL}); err == nil {
+		t.Errorf("expect the credentials required")
+	}
+	if _, err := New(&Config{Endpoint: "s3.example.com", AccessKeyID: "ak", SecretAccessKey: "sk"}); err == nil {
+		t.Errorf("expect the endpoint without scheme invalid")
+	}
+
+	c, err := New(&Config{Endpoint: server.URL, AccessKeyID: "ak", SecretAccessKey: "sk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := c.PutObject(ctx, "backup", "c1/snapshot.db", strings.NewReader("snapshot")); err != nil {
+		t.Fatal(err)
+	}
+
+	body, size, err := c.GetObject(ctx, "backup", "c1/snapshot.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(body)
+	body.Close()
+	if string(data) != "snapshot" || size != int64(len(data)) {
+		t.Errorf("unexpected object %q size %d", data, size)
+	}
+
+	if err := c.DeleteObject(ctx, "backup", "c1/snapshot.db"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.GetObject(ctx, "backup", "c1/snapshot.db"); err == nil {
+		t.Errorf("expect the deleted object not found")
+	}
+}
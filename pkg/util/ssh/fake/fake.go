@@ -0,0 +1,184 @@
+// Package fake provides a fake ssh.Interface which records the commands and files instead
+// of touching a host, the handlers are verified with it in tests.
+package fake
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/wtxue/kok-operator/pkg/util/hash"
+	"github.com/wtxue/kok-operator/pkg/util/ssh"
+)
+
+// Result is the result of the commands containing Match.
+type Result struct {
+	Match  string
+	Stdout string
+	Stderr string
+	Exit   int
+	Err    error
+}
+
+// SSH is a fake ssh.Interface, the commands without matched result succeed with empty output,
+// except sha256sum of the copied files.
+type SSH struct {
+	Host    string
+	Results []Result
+
+	mu       sync.Mutex
+	commands []string
+	files    map[string][]byte
+	copied   map[string]string
+}
+
+var _ ssh.Interface = &SSH{}
+
+// New returns a fake ssh of host, results are matched in order.
+func New(host string, results ...Result) *SSH {
+	return &SSH{
+		Host:    host,
+		Results: results,
+		files:   make(map[string][]byte),
+		copied:  make(map[string]string),
+	}
+}
+
+// Commands returns the commands executed in order.
+func (s *SSH) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+// Executed returns true if any executed command contains sub.
+func (s *SSH) Executed(sub string) bool {
+	for _, cmd := range s.Commands() {
+		if strings.Contains(cmd, sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// File returns the content written to dst.
+func (s *SSH) File(dst string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.files[dst]
+	return data, ok
+}
+
+// Copied returns the local source copied to dst.
+func (s *SSH) Copied(dst string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.copied[dst]
+	return src, ok
+}
+
+func (s *SSH) HostIP() string {
+	return s.Host
+}
+
+func (s *SSH) Ping() error {
+	_, _, _, err := s.Exec("date")
+	return err
+}
+
+func (s *SSH) Exec(cmd string) (stdout string, stderr string, exit int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commands = append(s.commands, cmd)
+	for _, r := range s.Results {
+		if strings.Contains(cmd, r.Match) {
+			return r.Stdout, r.Stderr, r.Exit, r.Err
+		}
+	}
+
+	// the sha256 of the copied file is the one of local source
+	if dst := strings.TrimPrefix(cmd, "sha256sum "); dst != cmd {
+		if sum, err := hash.Sha256WithFile(s.copied[dst]); err == nil {
+			return fmt.Sprintf("%s  %s\n", sum, dst), "", 0, nil
+		}
+	}
+
+	return "", "", 0, nil
+}
+
+func (s *SSH) ExecStream(cmd string, stdout, stderr io.Writer) (exit int, err error) {
+	bout, berr, exit, err := s.Exec(cmd)
+	io.WriteString(stdout, bout)
+	io.WriteString(stderr, berr)
+	return exit, err
+}
+
+func (s *SSH) Execf(format string, a ...interface{}) (stdout string, stderr string, exit int, err error) {
+	return s.Exec(fmt.Sprintf(format, a...))
+}
+
+func (s *SSH) CombinedOutput(cmd string) ([]byte, error) {
+	stdout, stderr, exit, err := s.Exec(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if exit != 0 {
+		return nil, &ssh.CommandError{Host: s.Host, Cmd: cmd, Exit: exit, Stderr: stderr}
+	}
+	return []byte(stdout), nil
+}
+
+func (s *SSH) CopyFile(src, dst string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.copied[dst] = src
+	return nil
+}
+
+func (s *SSH) WriteFile(src io.Reader, dst string) error {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.files[dst] = data
+	return nil
+}
+
+func (s *SSH) ReadFile(filename string) ([]byte, error) {
+	data, ok := s.File(filename)
+	if !ok {
+		return nil, fmt.Errorf("read file %s error: %w", filename, os.ErrNotExist)
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+func (s *SSH) Exist(filename string) (bool, error) {
+	if _, ok := s.File(filename); ok {
+		return true, nil
+	}
+	_, ok := s.Copied(filename)
+	return ok, nil
+}
+
+func (s *SSH) Stat(p string) (os.FileInfo, error) {
+	return nil, fmt.Errorf("stat %s error: %w", p, os.ErrNotExist)
+}
+
+func (s *SSH) LookPath(file string) (string, error) {
+	data, err := s.CombinedOutput(fmt.Sprintf("which %s", file))
+	return string(data), err
+}
@@ -0,0 +1,77 @@
+package ssh
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// HostKeyCallback returns the host key check of config, the pinned fingerprint takes precedence
+// over known hosts, and the host key is not checked when both of them are empty.
+func HostKeyCallback(c *Config) (ssh.HostKeyCallback, error) {
+	switch {
+	case c.HostKeyFingerprint != "":
+		return FingerprintCallback(c.HostKeyFingerprint), nil
+	case len(c.KnownHosts) != 0:
+		return KnownHostsCallback(c.KnownHosts)
+	default:
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+}
+
+// FingerprintCallback accepts only the host key with the SHA256 fingerprint, such as
+// "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8" printed by ssh-keygen -lf.
+func FingerprintCallback(fingerprint string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		actual := ssh.FingerprintSHA256(key)
+		if actual != fingerprint {
+			return fmt.Errorf("host key mismatch for %s: got %s, expected %s", hostname, actual, fingerprint)
+		}
+		return nil
+	}
+}
+
+// knownHostsCallbacks caches the callbacks by the digest of known_hosts content, the content is
+// parsed once instead of on every dial.
+var knownHostsCallbacks sync.Map
+
+// KnownHostsCallback accepts only the host keys in the content of known_hosts file,
+// the unknown hosts are rejected too.
+func KnownHostsCallback(data []byte) (ssh.HostKeyCallback, error) {
+	sum := sha256.Sum256(data)
+	if cb, ok := knownHostsCallbacks.Load(sum); ok {
+		return cb.(ssh.HostKeyCallback), nil
+	}
+
+	cb, err := parseKnownHosts(data)
+	if err != nil {
+		return nil, err
+	}
+	knownHostsCallbacks.Store(sum, cb)
+	return cb, nil
+}
+
+// parseKnownHosts parses the content by knownhosts, which reads files only, the temp file is
+// removed after it's read.
+func parseKnownHosts(data []byte) (ssh.HostKeyCallback, error) {
+	f, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// the file is read at once
+	return knownhosts.New(f.Name())
+}
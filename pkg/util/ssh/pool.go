@@ -0,0 +1,125 @@
+package ssh
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultIdleTimeout is how long an unused client is kept in the pool.
+const DefaultIdleTimeout = 5 * time.Minute
+
+// DefaultPool is shared by all SSH without a pool in config.
+var DefaultPool = NewPool(DefaultIdleTimeout)
+
+// Pool keeps the ssh clients keyed by host, credentials and host key config, a client is shared
+// by all sessions to the host with the same config and closed when it has not been used for the
+// idle timeout.
+type Pool struct {
+	mu          sync.Mutex
+	clients     map[string]*pooledClient
+	idleTimeout time.Duration
+}
+
+type pooledClient struct {
+	client   *ssh.Client
+	refs     int
+	lastUsed time.Time
+}
+
+// NewPool ...
+func NewPool(idleTimeout time.Duration) *Pool {
+	return &Pool{
+		clients:     make(map[string]*pooledClient),
+		idleTimeout: idleTimeout,
+	}
+}
+
+// Get returns the client of key and dials a new one if there is none, the caller must call
+// release when it's done with the client.
+func (p *Pool) Get(key string, dial func() (*ssh.Client, error)) (client *ssh.Client, release func(), err error) {
+	p.mu.Lock()
+	p.closeIdleLocked()
+	if c, ok := p.clients[key]; ok {
+		c.refs++
+		p.mu.Unlock()
+		return c.client, p.releaseFunc(c), nil
+	}
+	p.mu.Unlock()
+
+	client, err = dial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c, ok := p.clients[key]
+	if ok {
+		// dialed by another caller at the same time
+		client.Close()
+	} else {
+		c = &pooledClient{client: client}
+		p.clients[key] = c
+	}
+	c.refs++
+	return c.client, p.releaseFunc(c), nil
+}
+
+func (p *Pool) releaseFunc(c *pooledClient) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			c.refs--
+			c.lastUsed = time.Now()
+		})
+	}
+}
+
+// Remove closes the client and removes it from the pool if it's still the client of key,
+// it's called when the client is broken.
+func (p *Pool) Remove(key string, client *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c, ok := p.clients[key]; ok && c.client == client {
+		delete(p.clients, key)
+	}
+	client.Close()
+}
+
+// Len returns the number of clients in the pool.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.clients)
+}
+
+// Close closes all clients in the pool.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, c := range p.clients {
+		c.client.Close()
+		delete(p.clients, key)
+	}
+}
+
+func (p *Pool) closeIdleLocked() {
+	if p.idleTimeout <= 0 {
+		return
+	}
+
+	now := time.Now()
+	for key, c := range p.clients {
+		if c.refs == 0 && now.Sub(c.lastUsed) > p.idleTimeout {
+			c.client.Close()
+			delete(p.clients, key)
+		}
+	}
+}
@@ -3,7 +3,10 @@ package ssh
 import (
 	"bytes"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -12,9 +15,11 @@ import (
 	"net"
 	"os"
 	"path"
+	"strconv"
 	"time"
 
 	"github.com/pkg/sftp"
+	"github.com/wtxue/kok-operator/pkg/observe"
 	"github.com/wtxue/kok-operator/pkg/util/hash"
 	"golang.org/x/crypto/ssh"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -27,8 +32,23 @@ type SSH struct {
 	Port        int
 	addr        string
 	authMethods []ssh.AuthMethod
+	credentials *Credentials
 	dialer      sshDialer
 	Retry       int
+
+	retryInterval      time.Duration
+	hostKeyFingerprint string
+	knownHosts         []byte
+	proxy              *SSH
+	pool               *Pool
+	credentialsFunc    func() (*Credentials, error)
+}
+
+// Credentials is the secret part of config to login the host.
+type Credentials struct {
+	Password   string
+	PrivateKey []byte
+	PassPhrase []byte
 }
 
 type Config struct {
@@ -42,6 +62,20 @@ type Config struct {
 	// seconds). This timeout is only intended to catch otherwise uncaught hangs.
 	DialTimeOut time.Duration
 	Retry       int
+	// RetryInterval is the interval between dial retries, default 5 seconds
+	RetryInterval time.Duration
+
+	// HostKeyFingerprint pins the SHA256 fingerprint of the host key
+	HostKeyFingerprint string
+	// KnownHosts is the content of known_hosts file to check the host key
+	KnownHosts []byte
+	// Proxy is the jump host to reach the host, like ssh -J
+	Proxy *Config
+	// Pool keeps the clients of hosts, DefaultPool is used if it's nil
+	Pool *Pool
+	// CredentialsFunc returns the credentials when dialing, it takes precedence over
+	// Password and PrivateKey
+	CredentialsFunc func() (*Credentials, error)
 }
 
 type Interface interface {
@@ -62,7 +96,58 @@ type Interface interface {
 }
 
 func New(c *Config) (*SSH, error) {
-	if c.Password == "" && c.PrivateKey == nil {
+	var authMethods []ssh.AuthMethod
+	credentials := &Credentials{Password: c.Password, PrivateKey: c.PrivateKey, PassPhrase: c.PassPhrase}
+	if c.CredentialsFunc == nil {
+		var err error
+		authMethods, err = makeAuthMethods(credentials)
+		if err != nil {
+			return nil, err
+		}
+	}
+	addr := net.JoinHostPort(c.Host, strconv.Itoa(c.Port))
+
+	if c.DialTimeOut == 0 {
+		c.DialTimeOut = 5 * time.Second
+	}
+	if c.RetryInterval == 0 {
+		c.RetryInterval = 5 * time.Second
+	}
+
+	pool := c.Pool
+	if pool == nil {
+		pool = DefaultPool
+	}
+
+	var proxy *SSH
+	if c.Proxy != nil {
+		var err error
+		proxy, err = New(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %v", c.Proxy.Host, err)
+		}
+	}
+
+	return &SSH{
+		User:               c.User,
+		Host:               c.Host,
+		Port:               c.Port,
+		addr:               addr,
+		authMethods:        authMethods,
+		credentials:        credentials,
+		dialer:             &timeoutDialer{&realSSHDialer{}, c.DialTimeOut},
+		Retry:              c.Retry,
+		retryInterval:      c.RetryInterval,
+		hostKeyFingerprint: c.HostKeyFingerprint,
+		knownHosts:         c.KnownHosts,
+		proxy:              proxy,
+		pool:               pool,
+		credentialsFunc:    c.CredentialsFunc,
+	}, nil
+}
+
+func makeAuthMethods(c *Credentials) ([]ssh.AuthMethod, error) {
+	if c.Password == "" && len(c.PrivateKey) == 0 {
 		return nil, errors.New("password or privateKey at least one")
 	}
 
@@ -77,21 +162,8 @@ func New(c *Config) (*SSH, error) {
 		}
 		authMethods = append(authMethods, ssh.PublicKeys(signer))
 	}
-	addr := fmt.Sprintf("%s:%d", c.Host, c.Port)
 
-	if c.DialTimeOut == 0 {
-		c.DialTimeOut = 5 * time.Second
-	}
-
-	return &SSH{
-		User:        c.User,
-		Host:        c.Host,
-		Port:        c.Port,
-		addr:        addr,
-		authMethods: authMethods,
-		dialer:      &timeoutDialer{&realSSHDialer{}, c.DialTimeOut},
-		Retry:       c.Retry,
-	}, nil
+	return authMethods, nil
 }
 
 func (s *SSH) Ping() error {
@@ -104,96 +176,238 @@ func (s *SSH) HostIP() string {
 	return s.Host
 }
 
-func (s *SSH) CombinedOutput(cmd string) ([]byte, error) {
-	stdout, stderr, exit, err := s.Exec(cmd)
+// key identifies the client of the host in pool, the clients via different proxies are different.
+// The digest of credentials and host key config is a part of key, so a client is never shared by
+// the configs which login the host differently or check its host key differently.
+func (s *SSH) key() (string, error) {
+	credentials, err := s.getCredentials()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	if exit != 0 {
-		return nil, fmt.Errorf("cmd: %s exit error %d:%s", cmd, exit, stderr)
+
+	h := sha256.New()
+	for _, b := range [][]byte{[]byte(credentials.Password), credentials.PrivateKey, credentials.PassPhrase,
+		[]byte(s.hostKeyFingerprint), s.knownHosts} {
+		binary.Write(h, binary.BigEndian, uint64(len(b)))
+		h.Write(b)
 	}
-	return []byte(stdout), nil
+	key := s.User + "@" + s.addr + "/" + hex.EncodeToString(h.Sum(nil))
+	if s.proxy != nil {
+		proxyKey, err := s.proxy.key()
+		if err != nil {
+			return "", err
+		}
+		key += "," + proxyKey
+	}
+
+	return key, nil
 }
 
-func (s *SSH) Execf(format string, a ...interface{}) (stdout string, stderr string, exit int, err error) {
-	return s.Exec(fmt.Sprintf(format, a...))
+// getCredentials returns the credentials of config, the ones of CredentialsFunc are read every time
+// so the changed secret takes effect on the next session.
+func (s *SSH) getCredentials() (*Credentials, error) {
+	if s.credentialsFunc == nil {
+		return s.credentials, nil
+	}
+
+	credentials, err := s.credentialsFunc()
+	if err != nil {
+		return nil, fmt.Errorf("get credentials of %s@%s: %v", s.User, s.addr, err)
+	}
+	return credentials, nil
 }
 
-func (s *SSH) Exec(cmd string) (stdout string, stderr string, exit int, err error) {
-	// Setup the config, dial the server, and open a session.
+// dial connects to the host with retries.
+func (s *SSH) dial() (*ssh.Client, error) {
+	hostKeyCallback, err := HostKeyCallback(&Config{HostKeyFingerprint: s.hostKeyFingerprint, KnownHosts: s.knownHosts})
+	if err != nil {
+		return nil, fmt.Errorf("host key callback of %s: %v", s.addr, err)
+	}
+
+	authMethods := s.authMethods
+	if s.credentialsFunc != nil {
+		credentials, err := s.getCredentials()
+		if err != nil {
+			return nil, err
+		}
+		authMethods, err = makeAuthMethods(credentials)
+		if err != nil {
+			return nil, fmt.Errorf("credentials of %s@%s: %v", s.User, s.addr, err)
+		}
+	}
+
 	config := &ssh.ClientConfig{
 		User:            s.User,
-		Auth:            s.authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Auth:            authMethods,
+		HostKeyCallback: hostKeyCallback,
 	}
-	client, err := s.dialer.Dial("tcp", s.addr, config)
+	client, err := s.dialOnce(config)
 	if err != nil && s.Retry > 0 {
-		err = wait.Poll(5*time.Second, time.Duration(s.Retry)*5*time.Second, func() (bool, error) {
-			if client, err = s.dialer.Dial("tcp", s.addr, config); err != nil {
-				return false, err
+		err = wait.Poll(s.retryInterval, time.Duration(s.Retry)*s.retryInterval, func() (bool, error) {
+			if client, err = s.dialOnce(config); err != nil {
+				klog.V(4).Infof("retry dial %s@%s: %v", s.User, s.addr, err)
+				return false, nil
 			}
 			return true, nil
 		})
+		if err == wait.ErrWaitTimeout {
+			err = fmt.Errorf("dial %s timeout after %d retries", s.addr, s.Retry)
+		}
 	}
 	if err != nil {
-		return "", "", 0, fmt.Errorf("error getting SSH client to %s@%s: '%v'", s.User, s.addr, err)
+		return nil, fmt.Errorf("error getting SSH client to %s@%s: '%v'", s.User, s.addr, err)
 	}
-	defer client.Close()
 
-	session, err := client.NewSession()
+	return client, nil
+}
+
+func (s *SSH) dialOnce(config *ssh.ClientConfig) (*ssh.Client, error) {
+	if s.proxy == nil {
+		return s.dialer.Dial("tcp", s.addr, config)
+	}
+
+	// the proxy client is owned by the client of host, it's closed with the host client
+	proxyClient, err := s.proxy.dial()
 	if err != nil {
-		return "", "", 0, fmt.Errorf("error creating session to %s@%s: '%v'", s.User, s.addr, err)
+		return nil, err
 	}
-	defer session.Close()
 
-	// Run the command.
-	code := 0
-	var bout, berr bytes.Buffer
-	session.Stdout, session.Stderr = &bout, &berr
-	if err = session.Run(cmd); err != nil {
-		// Check whether the command failed to run or didn't complete.
-		if exiterr, ok := err.(*ssh.ExitError); ok {
-			// If we got an ExitError and the exit code is nonzero, we'll
-			// consider the SSH itself successful (just that the command run
-			// errored on the Host).
-			if code = exiterr.ExitStatus(); code != 0 {
-				err = nil
-			}
-		} else {
-			// Some other kind of error happened (e.g. an IOError); consider the
-			// SSH unsuccessful.
-			err = fmt.Errorf("failed running `%s` on %s@%s: '%v'", cmd, s.User, s.addr, err)
+	client, err := dialVia(proxyClient, s.addr, config)
+	if err != nil {
+		proxyClient.Close()
+		return nil, fmt.Errorf("via proxy %s: %v", s.proxy.addr, err)
+	}
+
+	go func() {
+		client.Wait()
+		proxyClient.Close()
+	}()
+	return client, nil
+}
+
+func dialVia(proxyClient *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := proxyClient.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// session opens a session on the pooled client, the client is dialed again if it's broken.
+func (s *SSH) session() (*ssh.Session, func(), error) {
+	key, err := s.key()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := 0; ; i++ {
+		client, release, err := s.pool.Get(key, s.dial)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		session, err := client.NewSession()
+		if err == nil {
+			return session, func() {
+				session.Close()
+				release()
+			}, nil
+		}
+
+		release()
+		s.pool.Remove(key, client)
+		if i > 0 {
+			return nil, nil, fmt.Errorf("error creating session to %s@%s: '%v'", s.User, s.addr, err)
 		}
 	}
-	return bout.String(), berr.String(), code, err
 }
 
-func (s *SSH) ExecStream(cmd string, stdout, stderr io.Writer) (exit int, err error) {
-	// Setup the config, dial the server, and open a session.
-	config := &ssh.ClientConfig{
-		User:            s.User,
-		Auth:            s.authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+// sftp opens a sftp client on the pooled client, the client is dialed again if it's broken.
+func (s *SSH) sftp() (*sftp.Client, func(), error) {
+	key, err := s.key()
+	if err != nil {
+		return nil, nil, err
 	}
-	client, err := s.dialer.Dial("tcp", s.addr, config)
-	if err != nil && s.Retry > 0 {
-		err = wait.Poll(5*time.Second, time.Duration(s.Retry)*5*time.Second, func() (bool, error) {
-			if client, err = s.dialer.Dial("tcp", s.addr, config); err != nil {
-				return false, err
-			}
-			return true, nil
-		})
+
+	for i := 0; ; i++ {
+		client, release, err := s.pool.Get(key, s.dial)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sftpClient, err := sftp.NewClient(client)
+		if err == nil {
+			return sftpClient, func() {
+				sftpClient.Close()
+				release()
+			}, nil
+		}
+
+		release()
+		s.pool.Remove(key, client)
+		if i > 0 {
+			return nil, nil, fmt.Errorf("error creating sftp to %s@%s: '%v'", s.User, s.addr, err)
+		}
 	}
+}
+
+// CommandError is returned when the command fails to run or exits with non-zero code.
+type CommandError struct {
+	Host   string
+	Cmd    string
+	Exit   int
+	Stderr string
+	// Err is the error of ssh when the command fails to run
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("failed running `%s` on %s: '%v'", e.Cmd, e.Host, e.Err)
+	}
+	return fmt.Sprintf("cmd: %s exit error %d:%s", e.Cmd, e.Exit, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (s *SSH) CombinedOutput(cmd string) ([]byte, error) {
+	stdout, stderr, exit, err := s.Exec(cmd)
 	if err != nil {
-		return 0, fmt.Errorf("error getting SSH client to %s@%s: '%v'", s.User, s.addr, err)
+		return nil, err
+	}
+	if exit != 0 {
+		return nil, &CommandError{Host: s.Host, Cmd: cmd, Exit: exit, Stderr: stderr}
 	}
-	defer client.Close()
+	return []byte(stdout), nil
+}
 
-	session, err := client.NewSession()
+func (s *SSH) Execf(format string, a ...interface{}) (stdout string, stderr string, exit int, err error) {
+	return s.Exec(fmt.Sprintf(format, a...))
+}
+
+func (s *SSH) Exec(cmd string) (stdout string, stderr string, exit int, err error) {
+	var bout, berr bytes.Buffer
+	exit, err = s.ExecStream(cmd, &bout, &berr)
+	return bout.String(), berr.String(), exit, err
+}
+
+func (s *SSH) ExecStream(cmd string, stdout, stderr io.Writer) (exit int, err error) {
+	start := time.Now()
+	session, closeSession, err := s.session()
 	if err != nil {
-		return 0, fmt.Errorf("error creating session to %s@%s: '%v'", s.User, s.addr, err)
+		observe.ObserveSSHCommand(s.Host, start, "session")
+		return 0, err
 	}
-	defer session.Close()
+	defer closeSession()
 
 	// Run the command.
 	code := 0
@@ -210,9 +424,17 @@ func (s *SSH) ExecStream(cmd string, stdout, stderr io.Writer) (exit int, err er
 		} else {
 			// Some other kind of error happened (e.g. an IOError); consider the
 			// SSH unsuccessful.
-			err = fmt.Errorf("failed running `%s` on %s@%s: '%v'", cmd, s.User, s.addr, err)
+			err = &CommandError{Host: s.Host, Cmd: cmd, Err: err}
 		}
 	}
+
+	reason := ""
+	if err != nil {
+		reason = "run"
+	} else if code != 0 {
+		reason = "exit"
+	}
+	observe.ObserveSSHCommand(s.Host, start, reason)
 	return code, err
 }
 
@@ -232,30 +454,11 @@ func (s *SSH) CopyFile(src, dst string) error {
 	}
 	klog.Infof("[%s] copy `%s` to %q", s.addr, src, dst)
 
-	config := &ssh.ClientConfig{
-		User:            s.User,
-		Auth:            s.authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-	client, err := s.dialer.Dial("tcp", s.addr, config)
-	if err != nil {
-		err = wait.Poll(5*time.Second, time.Duration(s.Retry)*5*time.Second, func() (bool, error) {
-			if client, err = s.dialer.Dial("tcp", s.addr, config); err != nil {
-				return false, err
-			}
-			return true, nil
-		})
-	}
-	if err != nil {
-		return err
-	}
-	defer client.Close()
-
-	sftpClient, err := sftp.NewClient(client)
+	sftpClient, closeSftp, err := s.sftp()
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
+	defer closeSftp()
 
 	srcFile, err := os.Open(src)
 
@@ -278,30 +481,11 @@ func (s *SSH) CopyFile(src, dst string) error {
 func (s *SSH) WriteFile(src io.Reader, dst string) error {
 	klog.Infof("[%s] Write data to %q", s.addr, dst)
 
-	config := &ssh.ClientConfig{
-		User:            s.User,
-		Auth:            s.authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-	client, err := s.dialer.Dial("tcp", s.addr, config)
-	if err != nil {
-		err = wait.Poll(5*time.Second, time.Duration(s.Retry)*5*time.Second, func() (bool, error) {
-			if client, err = s.dialer.Dial("tcp", s.addr, config); err != nil {
-				return false, err
-			}
-			return true, nil
-		})
-	}
-	if err != nil {
-		return err
-	}
-	defer client.Close()
-
-	sftpClient, err := sftp.NewClient(client)
+	sftpClient, closeSftp, err := s.sftp()
 	if err != nil {
 		return err
 	}
-	defer sftpClient.Close()
+	defer closeSftp()
 
 	err = sftpClient.MkdirAll(path.Dir(dst))
 	if err != nil {
@@ -318,30 +502,11 @@ func (s *SSH) WriteFile(src io.Reader, dst string) error {
 }
 
 func (s *SSH) Stat(p string) (os.FileInfo, error) {
-	config := &ssh.ClientConfig{
-		User:            s.User,
-		Auth:            s.authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-	client, err := s.dialer.Dial("tcp", s.addr, config)
-	if err != nil {
-		err = wait.Poll(5*time.Second, time.Duration(s.Retry)*5*time.Second, func() (bool, error) {
-			if client, err = s.dialer.Dial("tcp", s.addr, config); err != nil {
-				return false, err
-			}
-			return true, nil
-		})
-	}
-	if err != nil {
-		return nil, err
-	}
-	defer client.Close()
-
-	sftpClient, err := sftp.NewClient(client)
+	sftpClient, closeSftp, err := s.sftp()
 	if err != nil {
 		return nil, err
 	}
-	defer sftpClient.Close()
+	defer closeSftp()
 
 	return sftpClient.Stat(p)
 }
@@ -356,35 +521,18 @@ func (s *SSH) Exist(filename string) (bool, error) {
 }
 
 func (s *SSH) ReadFile(filename string) ([]byte, error) {
-	config := &ssh.ClientConfig{
-		User:            s.User,
-		Auth:            s.authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-	client, err := s.dialer.Dial("tcp", s.addr, config)
-	if err != nil {
-		err = wait.Poll(5*time.Second, time.Duration(s.Retry)*5*time.Second, func() (bool, error) {
-			if client, err = s.dialer.Dial("tcp", s.addr, config); err != nil {
-				return false, fmt.Errorf("read file %s error: %w", filename, err)
-			}
-			return true, nil
-		})
-	}
-	if err != nil {
-		return nil, fmt.Errorf("read file %s error: %w", filename, err)
-	}
-	defer client.Close()
-
-	sftpClient, err := sftp.NewClient(client)
+	sftpClient, closeSftp, err := s.sftp()
 	if err != nil {
 		return nil, fmt.Errorf("read file %s error: %w", filename, err)
 	}
-	defer sftpClient.Close()
+	defer closeSftp()
 
 	f, err := sftpClient.Open(filename)
 	if err != nil {
 		return nil, fmt.Errorf("read file %s error: %w", filename, err)
 	}
+	defer f.Close()
+
 	data := new(bytes.Buffer)
 	_, err = f.WriteTo(data)
 	if err != nil {
@@ -0,0 +1,490 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const testPassword = "123456"
+
+// testServer is an in-process ssh server which runs "exit N" and echoes other commands,
+// it also forwards direct-tcpip channels to be used as a jump host.
+type testServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+	hostKey  ssh.Signer
+	dials    int32
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTestServer(t *testing.T, addr string) *testServer {
+	s, err := startTestServer(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.close)
+	return s
+}
+
+func startTestServer(addr string) (*testServer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) != testPassword {
+				return nil, fmt.Errorf("password rejected for %s", c.User())
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &testServer{listener: l, config: config, hostKey: signer}
+	go s.serve()
+	return s, nil
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	atomic.AddInt32(&s.dials, 1)
+
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		switch newCh.ChannelType() {
+		case "session":
+			go handleSession(newCh)
+		case "direct-tcpip":
+			go handleDirectTCPIP(newCh)
+		default:
+			newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+		}
+	}
+}
+
+func handleSession(newCh ssh.NewChannel) {
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+
+	for req := range reqs {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct{ Command string }
+		ssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+
+		code := 0
+		if strings.HasPrefix(payload.Command, "exit ") {
+			code, _ = strconv.Atoi(strings.TrimPrefix(payload.Command, "exit "))
+		} else {
+			io.WriteString(ch, payload.Command)
+		}
+
+		status := make([]byte, 4)
+		binary.BigEndian.PutUint32(status, uint32(code))
+		ch.SendRequest("exit-status", false, status)
+		return
+	}
+}
+
+func handleDirectTCPIP(newCh ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newCh.ExtraData(), &payload); err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		newCh.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	ch, reqs, err := newCh.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		io.Copy(ch, conn)
+		ch.Close()
+	}()
+	io.Copy(conn, ch)
+	conn.Close()
+}
+
+// closeConns breaks all the connections to the server.
+func (s *testServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testServer) close() {
+	s.listener.Close()
+	s.closeConns()
+}
+
+func (s *testServer) clientConfig(pool *Pool) *Config {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &Config{
+		User:     "root",
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		Password: testPassword,
+		Pool:     pool,
+	}
+}
+
+func (s *testServer) dialCount() int {
+	return int(atomic.LoadInt32(&s.dials))
+}
+
+func mustExec(t *testing.T, c *Config, cmd string) string {
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _, exit, err := s.Exec(cmd)
+	if err != nil {
+		t.Fatalf("exec %q: %v", cmd, err)
+	}
+	if exit != 0 {
+		t.Fatalf("exec %q: exit %d", cmd, exit)
+	}
+	return stdout
+}
+
+func TestExecReuseClient(t *testing.T) {
+	srv := newTestServer(t, "127.0.0.1:0")
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+
+	for i := 0; i < 3; i++ {
+		if out := mustExec(t, srv.clientConfig(pool), "hello"); out != "hello" {
+			t.Errorf("expected stdout hello, got %q", out)
+		}
+	}
+
+	s, _ := New(srv.clientConfig(pool))
+	_, _, exit, err := s.Exec("exit 3")
+	if err != nil || exit != 3 {
+		t.Errorf("expected exit 3, got %d, %v", exit, err)
+	}
+
+	if n := srv.dialCount(); n != 1 {
+		t.Errorf("expected 1 connection, got %d", n)
+	}
+	if n := pool.Len(); n != 1 {
+		t.Errorf("expected 1 client in pool, got %d", n)
+	}
+}
+
+func TestExecRedialBrokenClient(t *testing.T) {
+	srv := newTestServer(t, "127.0.0.1:0")
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+
+	mustExec(t, srv.clientConfig(pool), "hello")
+	srv.closeConns()
+	mustExec(t, srv.clientConfig(pool), "hello")
+
+	if n := srv.dialCount(); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+}
+
+func TestPoolCloseIdle(t *testing.T) {
+	srv := newTestServer(t, "127.0.0.1:0")
+	pool := NewPool(time.Millisecond)
+	defer pool.Close()
+
+	mustExec(t, srv.clientConfig(pool), "hello")
+	time.Sleep(10 * time.Millisecond)
+	mustExec(t, srv.clientConfig(pool), "hello")
+
+	if n := srv.dialCount(); n != 2 {
+		t.Errorf("expected 2 connections, got %d", n)
+	}
+}
+
+func TestHostKeyFingerprint(t *testing.T) {
+	srv := newTestServer(t, "127.0.0.1:0")
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+
+	c := srv.clientConfig(pool)
+	c.HostKeyFingerprint = ssh.FingerprintSHA256(srv.hostKey.PublicKey())
+	mustExec(t, c, "hello")
+
+	other := newTestServer(t, "127.0.0.1:0")
+	c = srv.clientConfig(NewPool(time.Minute))
+	c.HostKeyFingerprint = ssh.FingerprintSHA256(other.hostKey.PublicKey())
+	s, _ := New(c)
+	if _, _, _, err := s.Exec("hello"); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("expected host key mismatch, got %v", err)
+	}
+}
+
+func TestHostKeyKnownHosts(t *testing.T) {
+	srv := newTestServer(t, "127.0.0.1:0")
+	other := newTestServer(t, "127.0.0.1:0")
+	addr := srv.listener.Addr().String()
+
+	c := srv.clientConfig(NewPool(time.Minute))
+	c.KnownHosts = []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, srv.hostKey.PublicKey()) + "\n")
+	mustExec(t, c, "hello")
+
+	c = srv.clientConfig(NewPool(time.Minute))
+	c.KnownHosts = []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, other.hostKey.PublicKey()) + "\n")
+	s, _ := New(c)
+	if _, _, _, err := s.Exec("hello"); err == nil {
+		t.Error("expected error of changed host key")
+	}
+
+	// the host not in known hosts is rejected too
+	c = other.clientConfig(NewPool(time.Minute))
+	c.KnownHosts = []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, srv.hostKey.PublicKey()) + "\n")
+	s, _ = New(c)
+	if _, _, _, err := s.Exec("hello"); err == nil {
+		t.Error("expected error of unknown host")
+	}
+}
+
+func TestKnownHostsCallbackNoTempFile(t *testing.T) {
+	srv := newTestServer(t, "127.0.0.1:0")
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+
+	data := []byte(knownhosts.Line([]string{knownhosts.Normalize(srv.listener.Addr().String())}, srv.hostKey.PublicKey()) + "\n")
+	for i := 0; i < 3; i++ {
+		if _, err := KnownHostsCallback(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected no temp file left, got %d", len(files))
+	}
+}
+
+func TestProxyJump(t *testing.T) {
+	bastion := newTestServer(t, "127.0.0.1:0")
+	srv := newTestServer(t, "127.0.0.1:0")
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+
+	c := srv.clientConfig(pool)
+	c.Proxy = bastion.clientConfig(pool)
+	c.Proxy.HostKeyFingerprint = ssh.FingerprintSHA256(bastion.hostKey.PublicKey())
+	c.HostKeyFingerprint = ssh.FingerprintSHA256(srv.hostKey.PublicKey())
+	for i := 0; i < 2; i++ {
+		if out := mustExec(t, c, "hello"); out != "hello" {
+			t.Errorf("expected stdout hello, got %q", out)
+		}
+	}
+
+	if n := bastion.dialCount(); n != 1 {
+		t.Errorf("expected 1 connection to bastion, got %d", n)
+	}
+	if n := srv.dialCount(); n != 1 {
+		t.Errorf("expected 1 connection to host, got %d", n)
+	}
+
+	c = srv.clientConfig(NewPool(time.Minute))
+	c.Proxy = bastion.clientConfig(nil)
+	c.Proxy.HostKeyFingerprint = ssh.FingerprintSHA256(srv.hostKey.PublicKey())
+	s, _ := New(c)
+	if _, _, _, err := s.Exec("hello"); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("expected host key mismatch of bastion, got %v", err)
+	}
+}
+
+func TestDialRetry(t *testing.T) {
+	// reserve a free port and start the server on it later
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+	c := &Config{
+		User:          "root",
+		Host:          addr.IP.String(),
+		Port:          addr.Port,
+		Password:      testPassword,
+		Retry:         20,
+		RetryInterval: 50 * time.Millisecond,
+		Pool:          pool,
+	}
+
+	started := make(chan *testServer, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		srv, err := startTestServer(addr.String())
+		if err != nil {
+			t.Error(err)
+		}
+		started <- srv
+	}()
+
+	mustExec(t, c, "hello")
+	if srv := <-started; srv != nil {
+		srv.close()
+	}
+
+	c.Port = addr.Port + 1
+	c.Retry = 2
+	s, _ := New(c)
+	if _, _, _, err := s.Exec("hello"); err == nil || !strings.Contains(err.Error(), "timeout after 2 retries") {
+		t.Errorf("expected retry timeout, got %v", err)
+	}
+}
+
+func TestCredentialsFunc(t *testing.T) {
+	srv := newTestServer(t, "127.0.0.1:0")
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+
+	calls := 0
+	c := srv.clientConfig(pool)
+	c.Password = ""
+	c.CredentialsFunc = func() (*Credentials, error) {
+		calls++
+		return &Credentials{Password: testPassword}, nil
+	}
+	mustExec(t, c, "hello")
+	mustExec(t, c, "hello")
+
+	if n := srv.dialCount(); n != 1 {
+		t.Errorf("expected 1 connection, got %d", n)
+	}
+	if calls == 0 {
+		t.Error("expected credentials read")
+	}
+
+	c = srv.clientConfig(NewPool(time.Minute))
+	c.CredentialsFunc = func() (*Credentials, error) {
+		return &Credentials{}, nil
+	}
+	s, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := s.Exec("hello"); err == nil {
+		t.Error("expected error of empty credentials")
+	}
+}
+
+func TestPoolKeyIsolation(t *testing.T) {
+	srv := newTestServer(t, "127.0.0.1:0")
+	other := newTestServer(t, "127.0.0.1:0")
+	pool := NewPool(time.Minute)
+	defer pool.Close()
+
+	c := srv.clientConfig(pool)
+	c.HostKeyFingerprint = ssh.FingerprintSHA256(srv.hostKey.PublicKey())
+	mustExec(t, c, "hello")
+
+	// the same host with wrong password doesn't get the authenticated client
+	c = srv.clientConfig(pool)
+	c.Password = "wrong"
+	c.HostKeyFingerprint = ssh.FingerprintSHA256(srv.hostKey.PublicKey())
+	s, _ := New(c)
+	if _, _, _, err := s.Exec("hello"); err == nil {
+		t.Error("expected error of wrong password")
+	}
+
+	// the same host with other fingerprint checks the host key again
+	c = srv.clientConfig(pool)
+	c.HostKeyFingerprint = ssh.FingerprintSHA256(other.hostKey.PublicKey())
+	s, _ = New(c)
+	if _, _, _, err := s.Exec("hello"); err == nil || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("expected host key mismatch, got %v", err)
+	}
+
+	// the credentials of secret are a part of key too
+	c = srv.clientConfig(pool)
+	c.Password = ""
+	c.CredentialsFunc = func() (*Credentials, error) {
+		return &Credentials{Password: "wrong"}, nil
+	}
+	s, _ = New(c)
+	if _, _, _, err := s.Exec("hello"); err == nil {
+		t.Error("expected error of wrong credentials")
+	}
+
+	if n := pool.Len(); n != 1 {
+		t.Errorf("expected 1 client in pool, got %d", n)
+	}
+}
@@ -0,0 +1,123 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/controllers/common"
+	"github.com/wtxue/kok-operator/pkg/gmanager"
+	"github.com/wtxue/kok-operator/pkg/provider/baremetal/validation"
+	"github.com/wtxue/kok-operator/pkg/provider/cluster"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// clusterDefaulter fills the defaults of provider into the new clusters.
+type clusterDefaulter struct {
+	gMgr    *gmanager.GManager
+	decoder *admission.Decoder
+}
+
+func (h *clusterDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("")
+	}
+
+	c := &devopsv1.Cluster{}
+	if err := h.decoder.Decode(req, c); err != nil {
+		return badRequest(err)
+	}
+
+	p, err := h.gMgr.CpManager.GetProvider(c.Spec.ClusterType)
+	if err != nil {
+		return denied("Cluster", c.Name, field.ErrorList{
+			field.NotSupported(field.NewPath("spec", "clusterType"), c.Spec.ClusterType, h.gMgr.CpManager.Providers()),
+		}, nil)
+	}
+
+	err = p.PreCreate(newClusterContext(ctx, c))
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, data)
+}
+
+// clusterValidator validates the clusters by provider, and the immutable fields once they are created.
+type clusterValidator struct {
+	gMgr    *gmanager.GManager
+	decoder *admission.Decoder
+}
+
+func (h *clusterValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	c := &devopsv1.Cluster{}
+	if err := h.decoder.Decode(req, c); err != nil {
+		return badRequest(err)
+	}
+
+	// the finalizers must be removable whatever the spec is
+	if !c.DeletionTimestamp.IsZero() {
+		return admission.Allowed("")
+	}
+
+	warnings := validation.ClusterWarnings(c)
+	var old *devopsv1.Cluster
+	allErrs := field.ErrorList{}
+	if req.Operation == admissionv1.Update {
+		old = &devopsv1.Cluster{}
+		if err := h.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return badRequest(err)
+		}
+		// the metadata of existing clusters, such as finalizers and annotations, are always updatable
+		if equality.Semantic.DeepEqual(c.Spec, old.Spec) {
+			return admission.Allowed("").WithWarnings(warnings...)
+		}
+		allErrs = append(allErrs, validation.ValidateClusterUpdate(c, old)...)
+	}
+
+	p, err := h.gMgr.CpManager.GetProvider(c.Spec.ClusterType)
+	if err != nil {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("spec", "clusterType"), c.Spec.ClusterType, h.gMgr.CpManager.Providers()))
+		return denied("Cluster", c.Name, allErrs, warnings)
+	}
+
+	errs := validateCluster(ctx, p, c)
+	if old != nil {
+		errs = newErrors(errs, validateCluster(ctx, p, old))
+	}
+	allErrs = append(allErrs, errs...)
+	if len(allErrs) > 0 {
+		return denied("Cluster", c.Name, allErrs, warnings)
+	}
+
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+func validateCluster(ctx context.Context, p cluster.Provider, c *devopsv1.Cluster) field.ErrorList {
+	// a new cluster is validated as initializing, which is its first phase
+	if c.Status.Phase == "" {
+		c = c.DeepCopy()
+		c.Status.Phase = devopsv1.ClusterInitializing
+	}
+
+	return p.Validate(newClusterContext(ctx, c))
+}
+
+func newClusterContext(ctx context.Context, c *devopsv1.Cluster) *common.ClusterContext {
+	return &common.ClusterContext{
+		Ctx:     ctx,
+		Key:     types.NamespacedName{Namespace: c.Namespace, Name: c.Name},
+		Logger:  logger.WithValues("cluster", c.Name),
+		Cluster: c,
+	}
+}
@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/gmanager"
+	"github.com/wtxue/kok-operator/pkg/provider/baremetal/validation"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// machineDefaulter fills the defaults of the provider of its cluster into the new machines.
+type machineDefaulter struct {
+	gMgr    *gmanager.GManager
+	cli     client.Reader
+	decoder *admission.Decoder
+}
+
+func (h *machineDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("")
+	}
+
+	m := &devopsv1.Machine{}
+	if err := h.decoder.Decode(req, m); err != nil {
+		return badRequest(err)
+	}
+
+	// the missing cluster is denied by the validator
+	cluster, err := getCluster(ctx, h.cli, m)
+	if err != nil {
+		return admission.Allowed("")
+	}
+
+	p, err := h.gMgr.MpManager.GetProvider(cluster.Spec.ClusterType)
+	if err != nil {
+		return admission.Allowed("")
+	}
+
+	err = p.PreCreate(m)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, data)
+}
+
+// machineValidator validates the machines by the provider of its cluster, which must exist
+// when the machine is created.
+type machineValidator struct {
+	gMgr    *gmanager.GManager
+	cli     client.Reader
+	decoder *admission.Decoder
+}
+
+func (h *machineValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	m := &devopsv1.Machine{}
+	if err := h.decoder.Decode(req, m); err != nil {
+		return badRequest(err)
+	}
+
+	if !m.DeletionTimestamp.IsZero() {
+		return admission.Allowed("")
+	}
+
+	warnings := validation.MachineWarnings(m)
+	var old *devopsv1.Machine
+	allErrs := field.ErrorList{}
+	if req.Operation == admissionv1.Update {
+		old = &devopsv1.Machine{}
+		if err := h.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return badRequest(err)
+		}
+		if equality.Semantic.DeepEqual(m.Spec, old.Spec) {
+			return admission.Allowed("").WithWarnings(warnings...)
+		}
+		allErrs = append(allErrs, validation.ValidateMachineUpdate(m, old)...)
+	}
+
+	clusterPath := field.NewPath("spec", "clusterName")
+	cluster, err := getCluster(ctx, h.cli, m)
+	switch {
+	case apierrors.IsNotFound(err):
+		// the machines of a deleted cluster are updated when they are cleaned
+		if req.Operation == admissionv1.Create {
+			allErrs = append(allErrs, field.NotFound(clusterPath, m.Spec.ClusterName))
+		}
+	case err != nil:
+		return admission.Errored(http.StatusInternalServerError, err)
+	default:
+		p, err := h.gMgr.MpManager.GetProvider(cluster.Spec.ClusterType)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(clusterPath, m.Spec.ClusterName, err.Error()))
+			break
+		}
+		errs := p.Validate(m)
+		if old != nil {
+			errs = newErrors(errs, p.Validate(old))
+		}
+		allErrs = append(allErrs, errs...)
+	}
+
+	if len(allErrs) > 0 {
+		return denied("Machine", m.Name, allErrs, warnings)
+	}
+
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+func getCluster(ctx context.Context, cli client.Reader, m *devopsv1.Machine) (*devopsv1.Cluster, error) {
+	cluster := &devopsv1.Cluster{}
+	err := cli.Get(ctx, types.NamespacedName{Name: m.Spec.ClusterName, Namespace: m.Namespace}, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return cluster, nil
+}
@@ -0,0 +1,87 @@
+package webhook
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/gmanager"
+	admissionv1 "k8s.io/api/admission/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlwebhook "sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	MutateClusterPath   = "/mutate-devops-fake-io-v1-cluster"
+	ValidateClusterPath = "/validate-devops-fake-io-v1-cluster"
+	MutateMachinePath   = "/mutate-devops-fake-io-v1-machine"
+	ValidateMachinePath = "/validate-devops-fake-io-v1-machine"
+)
+
+var (
+	logger = logf.Log.WithName("webhook")
+)
+
+// +kubebuilder:webhook:path=/mutate-devops-fake-io-v1-cluster,mutating=true,failurePolicy=fail,sideEffects=None,groups=devops.fake.io,resources=clusters,verbs=create,versions=v1,name=mcluster.devops.fake.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-devops-fake-io-v1-cluster,mutating=false,failurePolicy=fail,sideEffects=None,groups=devops.fake.io,resources=clusters,verbs=create;update,versions=v1,name=vcluster.devops.fake.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/mutate-devops-fake-io-v1-machine,mutating=true,failurePolicy=fail,sideEffects=None,groups=devops.fake.io,resources=machines,verbs=create,versions=v1,name=mmachine.devops.fake.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-devops-fake-io-v1-machine,mutating=false,failurePolicy=fail,sideEffects=None,groups=devops.fake.io,resources=machines,verbs=create;update,versions=v1,name=vmachine.devops.fake.io,admissionReviewVersions=v1
+
+// Add registers the defaulting and validating webhooks of clusters and machines, they run the
+// same PreCreate and Validate of providers as the reconcilers.
+func Add(mgr manager.Manager, gMgr *gmanager.GManager) error {
+	decoder, err := admission.NewDecoder(mgr.GetScheme())
+	if err != nil {
+		return errors.Wrap(err, "new admission decoder")
+	}
+
+	// the missing cluster of machine is checked without the cache, which may be stale
+	// right after the cluster is created
+	cli := mgr.GetAPIReader()
+	srv := mgr.GetWebhookServer()
+	srv.Register(MutateClusterPath, &ctrlwebhook.Admission{Handler: &clusterDefaulter{gMgr: gMgr, decoder: decoder}})
+	srv.Register(ValidateClusterPath, &ctrlwebhook.Admission{Handler: &clusterValidator{gMgr: gMgr, decoder: decoder}})
+	srv.Register(MutateMachinePath, &ctrlwebhook.Admission{Handler: &machineDefaulter{gMgr: gMgr, cli: cli, decoder: decoder}})
+	srv.Register(ValidateMachinePath, &ctrlwebhook.Admission{Handler: &machineValidator{gMgr: gMgr, cli: cli, decoder: decoder}})
+	return nil
+}
+
+// denied returns the invalid status of obj, which is shown to the users like the validation of api server.
+func denied(kind string, name string, allErrs field.ErrorList, warnings []string) admission.Response {
+	statusErr := apierrors.NewInvalid(devopsv1.GroupVersion.WithKind(kind).GroupKind(), name, allErrs)
+	return admission.Response{
+		AdmissionResponse: admissionv1.AdmissionResponse{
+			Allowed:  false,
+			Result:   &statusErr.ErrStatus,
+			Warnings: warnings,
+		},
+	}
+}
+
+func badRequest(err error) admission.Response {
+	return admission.Errored(http.StatusBadRequest, err)
+}
+
+// newErrors returns the errors not in oldErrs, the existing objects created before the webhooks
+// or with older rules are still updatable if the update doesn't make them worse.
+func newErrors(errs field.ErrorList, oldErrs field.ErrorList) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for _, err := range errs {
+		found := false
+		for _, oldErr := range oldErrs {
+			if err.Type == oldErr.Type && err.Field == oldErr.Field {
+				found = true
+				break
+			}
+		}
+		if !found {
+			allErrs = append(allErrs, err)
+		}
+	}
+
+	return allErrs
+}
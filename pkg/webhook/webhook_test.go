@@ -0,0 +1,205 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	devopsv1 "github.com/wtxue/kok-operator/pkg/apis/devops/v1"
+	"github.com/wtxue/kok-operator/pkg/gmanager"
+	"github.com/wtxue/kok-operator/pkg/k8sclient"
+	"github.com/wtxue/kok-operator/pkg/provider"
+	baremetalcluster "github.com/wtxue/kok-operator/pkg/provider/baremetal/cluster"
+	baremetalmachine "github.com/wtxue/kok-operator/pkg/provider/baremetal/machine"
+	clusterprovider "github.com/wtxue/kok-operator/pkg/provider/cluster"
+	"github.com/wtxue/kok-operator/pkg/provider/config"
+	machineprovider "github.com/wtxue/kok-operator/pkg/provider/machine"
+	managedcluster "github.com/wtxue/kok-operator/pkg/provider/managed/cluster"
+	"github.com/wtxue/kok-operator/pkg/util/pointer"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newTestManager(t *testing.T) *gmanager.GManager {
+	t.Helper()
+
+	pMgr := &provider.ProviderManager{
+		CpManager: clusterprovider.New(),
+		MpManager: machineprovider.New(),
+		Cfg:       config.NewDefaultConfig(),
+	}
+	if err := baremetalcluster.Add(pMgr.CpManager, pMgr.Cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := baremetalmachine.Add(pMgr.MpManager, pMgr.Cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := managedcluster.Add(pMgr.CpManager, pMgr.Cfg); err != nil {
+		t.Fatal(err)
+	}
+	return &gmanager.GManager{ProviderManager: pMgr, Config: pMgr.Cfg}
+}
+
+func newTestDecoder(t *testing.T) *admission.Decoder {
+	t.Helper()
+
+	decoder, err := admission.NewDecoder(k8sclient.GetScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoder
+}
+
+func newRequest(t *testing.T, op admissionv1.Operation, obj, old runtime.Object) admission.Request {
+	t.Helper()
+
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: op}}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Object.Raw = data
+	if old != nil {
+		data, err = json.Marshal(old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.OldObject.Raw = data
+	}
+	return req
+}
+
+func newCluster() *devopsv1.Cluster {
+	return &devopsv1.Cluster{
+		TypeMeta:   metav1.TypeMeta{APIVersion: devopsv1.GroupVersion.String(), Kind: "Cluster"},
+		ObjectMeta: metav1.ObjectMeta{Name: "c1", Namespace: "ns1"},
+		Spec: devopsv1.ClusterSpec{
+			ClusterType: "baremetal",
+			Version:     "v1.24.4",
+			ClusterCIDR: "10.244.0.0/16",
+			Properties: devopsv1.ClusterProperty{
+				MaxClusterServiceNum: pointer.ToInt32(256),
+				MaxNodePodNum:        pointer.ToInt32(256),
+			},
+		},
+	}
+}
+
+func TestClusterDefaulter(t *testing.T) {
+	h := &clusterDefaulter{gMgr: newTestManager(t), decoder: newTestDecoder(t)}
+	c := newCluster()
+	c.Spec.ClusterCIDR = ""
+
+	resp := h.Handle(context.Background(), newRequest(t, admissionv1.Create, c, nil))
+	if !resp.Allowed {
+		t.Fatalf("expect allowed, got %v", resp.Result)
+	}
+
+	patched := map[string]bool{}
+	for _, p := range resp.Patches {
+		patched[p.Path] = true
+	}
+	for _, path := range []string{"/spec/clusterCIDR", "/spec/criType", "/spec/etcd"} {
+		if !patched[path] {
+			t.Errorf("expect %s defaulted, got %v", path, resp.Patches)
+		}
+	}
+
+	c.Spec.ClusterType = "unknown"
+	if resp := h.Handle(context.Background(), newRequest(t, admissionv1.Create, c, nil)); resp.Allowed {
+		t.Errorf("expect unknown cluster type denied")
+	}
+}
+
+func TestClusterValidator(t *testing.T) {
+	h := &clusterValidator{gMgr: newTestManager(t), decoder: newTestDecoder(t)}
+	ctx := context.Background()
+
+	c := newCluster()
+	c.Spec.Machines = []*devopsv1.ClusterMachine{{IP: "10.0.0.1", Password: "secret"}}
+	resp := h.Handle(ctx, newRequest(t, admissionv1.Create, c, nil))
+	if !resp.Allowed {
+		t.Fatalf("expect allowed, got %v", resp.Result)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "spec.machines[0].password") {
+		t.Errorf("expect password warning, got %v", resp.Warnings)
+	}
+
+	c.Spec.Version = "v1.0.0"
+	if resp := h.Handle(ctx, newRequest(t, admissionv1.Create, c, nil)); resp.Allowed {
+		t.Errorf("expect unsupported version denied")
+	}
+
+	managed := newCluster()
+	managed.Spec.ClusterType = "managed"
+	managed.Spec.Certificates = &devopsv1.CertificatePolicy{}
+	resp = h.Handle(ctx, newRequest(t, admissionv1.Create, managed, nil))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "spec.certificates") {
+		t.Errorf("expect certificates of managed cluster denied, got %v", resp.Result)
+	}
+
+	old := newCluster()
+	old.Status.Phase = devopsv1.ClusterRunning
+	updated := old.DeepCopy()
+	updated.Spec.ClusterCIDR = "10.245.0.0/16"
+	resp = h.Handle(ctx, newRequest(t, admissionv1.Update, updated, old))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "spec.clusterCIDR") {
+		t.Errorf("expect immutable clusterCIDR denied, got %v", resp.Result)
+	}
+
+	old.Status.Phase = devopsv1.ClusterInitializing
+	if resp := h.Handle(ctx, newRequest(t, admissionv1.Update, updated, old)); !resp.Allowed {
+		t.Errorf("expect clusterCIDR updatable before running, got %v", resp.Result)
+	}
+
+	// the existing invalid fields don't block the other updates
+	old.Status.Phase = devopsv1.ClusterRunning
+	old.Spec.Properties = devopsv1.ClusterProperty{}
+	updated = old.DeepCopy()
+	updated.Spec.Machines = []*devopsv1.ClusterMachine{{IP: "10.0.0.1"}}
+	if resp := h.Handle(ctx, newRequest(t, admissionv1.Update, updated, old)); !resp.Allowed {
+		t.Errorf("expect update allowed, got %v", resp.Result)
+	}
+
+	// the metadata only update is always allowed
+	old.Spec.ClusterType = "unknown"
+	updated = old.DeepCopy()
+	updated.Finalizers = nil
+	if resp := h.Handle(ctx, newRequest(t, admissionv1.Update, updated, old)); !resp.Allowed {
+		t.Errorf("expect metadata update allowed, got %v", resp.Result)
+	}
+}
+
+func TestMachineValidator(t *testing.T) {
+	cli := fake.NewClientBuilder().WithScheme(k8sclient.GetScheme()).WithObjects(newCluster()).Build()
+	h := &machineValidator{gMgr: newTestManager(t), cli: cli, decoder: newTestDecoder(t)}
+	ctx := context.Background()
+
+	m := &devopsv1.Machine{
+		TypeMeta:   metav1.TypeMeta{APIVersion: devopsv1.GroupVersion.String(), Kind: "Machine"},
+		ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "ns1"},
+		Spec: devopsv1.MachineSpec{
+			ClusterName: "c1",
+			Machine:     &devopsv1.ClusterMachine{IP: "10.0.0.2"},
+		},
+	}
+	if resp := h.Handle(ctx, newRequest(t, admissionv1.Create, m, nil)); !resp.Allowed {
+		t.Fatalf("expect allowed, got %v", resp.Result)
+	}
+
+	missing := m.DeepCopy()
+	missing.Spec.ClusterName = "c2"
+	resp := h.Handle(ctx, newRequest(t, admissionv1.Create, missing, nil))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "spec.clusterName") {
+		t.Errorf("expect missing cluster denied, got %v", resp.Result)
+	}
+
+	resp = h.Handle(ctx, newRequest(t, admissionv1.Update, missing, m))
+	if resp.Allowed || !strings.Contains(resp.Result.Message, "field is immutable") {
+		t.Errorf("expect immutable clusterName denied, got %v", resp.Result)
+	}
+}